@@ -0,0 +1,31 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTransferCanceled is returned by a pull or push that was stopped with CancelTransfer
+var ErrTransferCanceled = errors.New("transfer canceled")
+
+// CancelTransfer stops the pull or push in progress by closing the connection, since the protocol has no way to
+// abandon a transfer. The transfer fails with ErrTransferCanceled, and the connection has to be reconnected before
+// it's used again.
+func (ctx *ObsidianSocketContext) CancelTransfer() {
+	ctx.canceled.Store(true)
+	_ = ctx.closeConnection()
+}
+
+// transferError replaces the error of a transfer that was stopped with CancelTransfer, and wraps ErrLostSync if the
+// connection was closed because frames went unread
+func (ctx *ObsidianSocketContext) transferError(err error) error {
+	if err != nil && ctx.canceled.Swap(false) {
+		return ErrTransferCanceled
+	}
+	if err != nil {
+		if lost := ctx.lostSync(); lost != nil && !errors.Is(err, ErrLostSync) {
+			return fmt.Errorf("%w: %v", lost, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// textExtensions are the file types Obsidian treats as text
+var textExtensions = map[string]bool{
+	"md":     true,
+	"canvas": true,
+	"json":   true,
+	"txt":    true,
+	"css":    true,
+	"js":     true,
+	"csv":    true,
+}
+
+// Extension returns the extension sent in push metadata for a path: lowercase and without the leading dot
+func Extension(path string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// IsText returns true if a file should be handled as text, based on its extension or, for unknown extensions,
+// by sniffing its content
+func IsText(path string, content []byte) bool {
+	ext := Extension(path)
+	if textExtensions[ext] {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(content), "text/")
+}
+
+// hasValidText returns false if a file with a known text extension has content that isn't valid UTF-8
+func hasValidText(path string, content []byte) bool {
+	return !textExtensions[Extension(path)] || utf8.Valid(content)
+}
@@ -0,0 +1,32 @@
+package api
+
+import "context"
+
+// withContext runs op, closing the connection if c is done first, since a blocking websocket read can't be interrupted
+// any other way. Returns c's error in that case, and the connection has to be reconnected before it's used again.
+func (ctx *ObsidianSocketContext) withContext(c context.Context, op func() error) error {
+	if c.Done() == nil {
+		return op()
+	}
+	if err := c.Err(); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	watched := make(chan bool)
+	go func() {
+		select {
+		case <-c.Done():
+			_ = ctx.closeConnection()
+			watched <- true
+		case <-stop:
+			watched <- false
+		}
+	}()
+	err := op()
+	close(stop)
+	if <-watched {
+		return c.Err()
+	}
+	return err
+}
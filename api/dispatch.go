@@ -0,0 +1,252 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultQueueLimit is how many unread frames a route may queue before the connection is considered out of sync
+var DefaultQueueLimit = 1024
+
+// ErrLostSync is returned when frames went unread for so long that the connection can't be trusted any more. The
+// connection is closed, reconnecting from the last known UID resyncs.
+var ErrLostSync = errors.New("lost protocol sync")
+
+// QueueOverflowError is returned when more frames queued on a route than the limit allows
+type QueueOverflowError struct {
+	Route string // Kind of frame that overflowed, e.g. "push"
+	Limit int
+}
+
+func (e *QueueOverflowError) Error() string {
+	return fmt.Sprintf("%s, more than %d %s frames went unread", ErrLostSync, e.Limit, e.Route)
+}
+
+// Is makes errors.Is(err, ErrLostSync) match
+func (e *QueueOverflowError) Is(target error) bool {
+	return target == ErrLostSync
+}
+
+// QueueStats describes the frames waiting to be read on a connection
+type QueueStats struct {
+	Queued    int   // Frames waiting now, on all routes
+	Peak      int   // Most frames ever waiting at once on this connection
+	Limit     int   // Most frames a single route may queue, zero is unlimited
+	Overflows int64 // Connections closed because a route overflowed, over the life of the context
+}
+
+// route is the kind of a frame, which decides which queue the read loop dispatches it to
+type route int
+
+const (
+	routePush     route = iota // {"op": "push"}, from other devices or echoing ours
+	routeReady                 // {"op": "ready"}, ending the pushes after init
+	routeOk                    // {"op": "ok"}, ending a push
+	routeResponse              // {"res": ...}, answering init or a push
+	routeError                 // {"res": "err"}, the server rejected a request
+	routeHeader                // The header of a pulled file
+	routeSize                  // The answer to size
+	routeItems                 // A list of versions answering history or deleted
+	routeBinary                // A piece of pulled content
+	routeOther                 // Anything else, only read by ReadOp
+	routeCount
+)
+
+var routeNames = [routeCount]string{"push", "ready", "ok", "response", "error", "header", "size", "items", "binary", "other"}
+
+func (r route) String() string {
+	return routeNames[r]
+}
+
+// allRoutes waits for a frame on any route
+var allRoutes = []route{routePush, routeReady, routeOk, routeResponse, routeError, routeHeader, routeSize, routeItems, routeBinary, routeOther}
+
+// queuedFrame is a frame waiting to be taken, seq orders frames across queues
+type queuedFrame struct {
+	seq   int64
+	frame *Frame
+}
+
+// dispatcher queues frames from the read loop by route, so each request only waits on the frames that can answer it
+// and frames meant for someone else, like pushes from other devices during a pull, stay queued in order
+type dispatcher struct {
+	mu        sync.Mutex
+	queues    [routeCount][]queuedFrame
+	seq       int64
+	arrived   chan struct{} // Closed and replaced whenever a frame arrives or the read loop stops
+	err       error         // Why the read loop stopped, nil while it runs
+	limit     int
+	queued    int
+	peak      int
+	overflows *atomic.Int64 // Shared by all connections of a context
+}
+
+func newDispatcher(limit int, overflows *atomic.Int64) *dispatcher {
+	return &dispatcher{arrived: make(chan struct{}), limit: limit, overflows: overflows}
+}
+
+// dispatch queues a frame on its route. If the route is full, nobody is reading it and we can't tell what the frames
+// that arrive next answer, so the route's frames are dropped and dispatch returns false to stop the read loop.
+// Dropping them is safe since reconnecting from the last UID we applied sends the pushes among them again.
+func (d *dispatcher) dispatch(f *Frame) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.limit > 0 && len(d.queues[f.route]) >= d.limit {
+		d.queued -= len(d.queues[f.route])
+		d.queues[f.route] = nil
+		d.err = &QueueOverflowError{Route: f.route.String(), Limit: d.limit}
+		d.overflows.Add(1)
+		d.wake()
+		return false
+	}
+	d.seq++
+	d.queues[f.route] = append(d.queues[f.route], queuedFrame{seq: d.seq, frame: f})
+	d.queued++
+	if d.queued > d.peak {
+		d.peak = d.queued
+	}
+	d.wake()
+	return true
+}
+
+// stop records why the read loop stopped, unless it already stopped on an overflow. Frames already queued can still
+// be taken.
+func (d *dispatcher) stop(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+	}
+	d.wake()
+}
+
+// overflow returns the overflow that stopped the read loop, if it stopped on one
+func (d *dispatcher) overflow() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var overflow *QueueOverflowError
+	if errors.As(d.err, &overflow) {
+		return overflow
+	}
+	return nil
+}
+
+// stats returns the current queue statistics
+func (d *dispatcher) stats() QueueStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return QueueStats{Queued: d.queued, Peak: d.peak, Limit: d.limit, Overflows: d.overflows.Load()}
+}
+
+// wake signals waiting takers, d.mu must be held
+func (d *dispatcher) wake() {
+	close(d.arrived)
+	d.arrived = make(chan struct{})
+}
+
+// take removes and returns the oldest frame on the given routes that satisfies match. If there is none it returns the
+// channel that's closed when that may have changed, or the error the read loop stopped with.
+func (d *dispatcher) take(routes []route, match func(*Frame) bool) (*Frame, <-chan struct{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	found, at := route(-1), -1
+	for _, r := range routes {
+		for i, q := range d.queues[r] {
+			if match != nil && !match(q.frame) {
+				continue
+			}
+			if at < 0 || q.seq < d.queues[found][at].seq {
+				found, at = r, i
+			}
+			break
+		}
+	}
+	if at >= 0 {
+		queue := d.queues[found]
+		f := queue[at].frame
+		d.queues[found] = append(queue[:at:at], queue[at+1:]...)
+		d.queued--
+		return f, nil, nil
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	return nil, d.arrived, nil
+}
+
+// next returns the oldest frame on the given routes that satisfies match, which may be nil, waiting for the read
+// deadline if none has arrived yet
+func (ctx *ObsidianSocketContext) next(match func(*Frame) bool, routes ...route) (*Frame, error) {
+	dl := ctx.readDeadline()
+	var expired <-chan time.Time
+	if !dl.at.IsZero() {
+		timer := time.NewTimer(time.Until(dl.at))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		f, arrived, err := ctx.reader.frames.take(routes, match)
+		if err != nil {
+			return nil, fmt.Errorf("error reading message: %w", err)
+		}
+		if f != nil {
+			traceFrame("⏪", f.Data)
+			return f, nil
+		}
+
+		select {
+		case <-arrived:
+		case <-expired:
+			// The read loop can't be interrupted without closing, and the protocol can't resume mid-operation anyway
+			err := fmt.Errorf("read deadline exceeded")
+			ctx.checkDeadline(dl, err)
+			_ = ctx.closeConnection()
+			return nil, fmt.Errorf("error reading message: %v", err)
+		}
+	}
+}
+
+// QueueStats returns the statistics of the current connection's frame queues
+func (ctx *ObsidianSocketContext) QueueStats() QueueStats {
+	if ctx.reader == nil {
+		return QueueStats{Limit: ctx.queueLimit(), Overflows: ctx.overflows.Load()}
+	}
+	return ctx.reader.frames.stats()
+}
+
+// queueLimit returns QueueLimit, or DefaultQueueLimit if it isn't set
+func (ctx *ObsidianSocketContext) queueLimit() int {
+	if ctx.QueueLimit != 0 {
+		return ctx.QueueLimit
+	}
+	return DefaultQueueLimit
+}
+
+// lostSync returns the overflow that closed the current connection, if it was closed by one
+func (ctx *ObsidianSocketContext) lostSync() error {
+	if ctx.reader == nil {
+		return nil
+	}
+	return ctx.reader.frames.overflow()
+}
+
+// nextOn returns the oldest frame on the given routes
+func (ctx *ObsidianSocketContext) nextOn(routes ...route) (*Frame, error) {
+	return ctx.next(nil, routes...)
+}
+
+// serverError returns the error the server sent in an error frame
+func serverError(f *Frame) error {
+	var data struct {
+		Msg string `json:"msg"`
+	}
+	if err := f.Decode(&data); err != nil || data.Msg == "" {
+		return fmt.Errorf("server error: %s", f.Data)
+	}
+	return fmt.Errorf("server error: %s", data.Msg)
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeConnection connects to a fake server running serve, then reading until the connection is closed
+func fakeConnection(t *testing.T, queueLimit int, serve func(ws *websocket.Conn)) *ObsidianSocketContext {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		serve(ws)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &ObsidianSocketContext{ws: ws, QueueLimit: queueLimit}
+	ctx.reader = startReader(ws, ctx.queueLimit(), &ctx.overflows)
+	t.Cleanup(func() { _ = ctx.Close() })
+	return ctx
+}
+
+// writeFrames sends each message as a text frame, or a binary one if it isn't JSON
+func writeFrames(ws *websocket.Conn, messages ...string) {
+	for _, msg := range messages {
+		messageType := websocket.TextMessage
+		if !strings.HasPrefix(msg, "{") {
+			messageType = websocket.BinaryMessage
+		}
+		if err := ws.WriteMessage(messageType, []byte(msg)); err != nil {
+			return
+		}
+	}
+}
+
+// waitFor returns what a call returns, failing if it blocks
+func waitFor(t *testing.T, call func() error) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- call() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after 5s")
+		return nil
+	}
+}
+
+func TestDispatchRoutes(t *testing.T) {
+	tests := []struct {
+		route route
+		msg   string
+	}{
+		{routePush, `{"op":"push","path":"a","uid":1}`},
+		{routeReady, `{"op":"ready","version":1}`},
+		{routeOk, `{"op":"ok"}`},
+		{routeResponse, `{"res":"next"}`},
+		{routeError, `{"res":"err","msg":"denied"}`},
+		{routeHeader, `{"hash":"h","size":1,"pieces":1}`},
+		{routeSize, `{"size":1,"limit":2}`},
+		{routeItems, `{"items":[]}`},
+		{routeBinary, `content`},
+		{routeOther, `{"op":"pong"}`},
+	}
+	ctx := fakeConnection(t, 0, func(ws *websocket.Conn) {
+		for _, tt := range tests {
+			writeFrames(ws, tt.msg)
+		}
+	})
+
+	// Taken in reverse, so each one waits behind frames of other routes
+	for i := len(tests) - 1; i >= 0; i-- {
+		tt := tests[i]
+		t.Run(tt.route.String(), func(t *testing.T) {
+			var f *Frame
+			err := waitFor(t, func() (err error) {
+				f, err = ctx.nextOn(tt.route)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("nextOn(%s) error = %v", tt.route, err)
+			}
+			if string(f.Data) != tt.msg {
+				t.Errorf("nextOn(%s) = %s, want %s", tt.route, f.Data, tt.msg)
+			}
+		})
+	}
+	if stats := ctx.QueueStats(); stats.Queued != 0 {
+		t.Errorf("%d frames still queued", stats.Queued)
+	}
+}
+
+// Frames are taken in the order they arrived, across the routes waited on
+func TestDispatchKeepsOrder(t *testing.T) {
+	ctx := fakeConnection(t, 0, func(ws *websocket.Conn) {
+		writeFrames(ws, `{"op":"push","uid":1}`, `{"res":"ok"}`, `{"op":"push","uid":2}`)
+	})
+	for _, want := range []string{`{"op":"push","uid":1}`, `{"res":"ok"}`, `{"op":"push","uid":2}`} {
+		f, err := ctx.nextOn(routePush, routeResponse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(f.Data) != want {
+			t.Errorf("nextOn() = %s, want %s", f.Data, want)
+		}
+	}
+}
+
+func TestDispatchOverflowFailsWaiters(t *testing.T) {
+	start := make(chan struct{})
+	ctx := fakeConnection(t, 2, func(ws *websocket.Conn) {
+		<-start
+		writeFrames(ws, `{"op":"push","uid":1}`, `{"op":"push","uid":2}`, `{"op":"push","uid":3}`, `{"op":"ok"}`)
+	})
+
+	// Waiting on a route that never gets its frame, while pushes go unread
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := ctx.nextOn(routeResponse)
+		waiting <- err
+	}()
+	close(start)
+
+	err := waitFor(t, func() error { return <-waiting })
+	if !errors.Is(err, ErrLostSync) {
+		t.Errorf("waiter error = %v, want ErrLostSync", err)
+	}
+	var overflow *QueueOverflowError
+	if !errors.As(err, &overflow) || overflow.Route != "push" || overflow.Limit != 2 {
+		t.Errorf("waiter error = %v, want an overflow of 2 push frames", err)
+	}
+	// The overflowed frames are dropped, and nothing after them is read
+	err = waitFor(t, func() error {
+		_, err := ctx.nextOn(routePush, routeOk)
+		return err
+	})
+	if !errors.Is(err, ErrLostSync) {
+		t.Errorf("nextOn() after the overflow error = %v, want ErrLostSync", err)
+	}
+	if stats := ctx.QueueStats(); stats.Overflows != 1 || stats.Queued != 0 {
+		t.Errorf("QueueStats() = %+v, want 1 overflow and nothing queued", stats)
+	}
+	if !errors.Is(ctx.lostSync(), ErrLostSync) {
+		t.Errorf("lostSync() = %v, want ErrLostSync", ctx.lostSync())
+	}
+}
+
+func TestCloseWakesReaders(t *testing.T) {
+	ctx := fakeConnection(t, 0, func(ws *websocket.Conn) {})
+
+	waiting := make(chan error, 2)
+	for _, r := range []route{routeResponse, routeBinary} {
+		r := r
+		go func() {
+			_, err := ctx.nextOn(r)
+			waiting <- err
+		}()
+	}
+	if err := ctx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := waitFor(t, func() error { return <-waiting }); err == nil {
+			t.Error("nextOn() returned no error after Close")
+		}
+	}
+	if ctx.lostSync() != nil {
+		t.Errorf("lostSync() = %v after Close, want nil", ctx.lostSync())
+	}
+}
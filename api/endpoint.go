@@ -0,0 +1,53 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Endpoint is where the client reaches the Obsidian API and the sync servers. Staging servers, proxies and
+// self-hosted sync relays can be used instead of the official servers.
+type Endpoint struct {
+	BaseURL  string // HTTP API, without a trailing slash
+	WSScheme string // Scheme of the websocket connection to a vault's sync host, "wss" or "ws"
+	SyncHost string // Sync host used for every vault instead of the host the API assigns it, empty to use the assigned host
+}
+
+// OfficialEndpoint is Obsidian's own servers
+var OfficialEndpoint = Endpoint{BaseURL: "https://api.obsidian.md", WSScheme: "wss"}
+
+// DefaultEndpoint is used by HTTP requests and new connections. The command line sets it from flags.
+var DefaultEndpoint = OfficialEndpoint
+
+// Validate checks the endpoint and normalizes its base URL
+func (e *Endpoint) Validate() error {
+	u, err := url.Parse(e.BaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid API URL %q: %s", e.BaseURL, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid API URL %q, expected http(s)://host[/path]", e.BaseURL)
+	}
+	e.BaseURL = strings.TrimSuffix(e.BaseURL, "/")
+	if e.WSScheme != "wss" && e.WSScheme != "ws" {
+		return fmt.Errorf("invalid websocket scheme %q, expected \"wss\" or \"ws\"", e.WSScheme)
+	}
+	if strings.Contains(e.SyncHost, "/") {
+		return fmt.Errorf("invalid sync host %q, expected host[:port] without a scheme", e.SyncHost)
+	}
+	return nil
+}
+
+// apiURL returns the URL of an API endpoint
+func (e Endpoint) apiURL(endpoint string) string {
+	return e.BaseURL + endpoint
+}
+
+// socketURL returns the websocket URL of a vault's sync host
+func (e Endpoint) socketURL(host string) string {
+	if e.SyncHost != "" {
+		host = e.SyncHost
+	}
+	return e.WSScheme + "://" + host + "/"
+}
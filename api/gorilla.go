@@ -1,8 +1,12 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -12,60 +16,89 @@ type SocketMessageSender interface {
 }
 
 type SocketMessageReceiver interface {
-	nextMessage() ([]byte, error)
+	next(match func(*Frame) bool, routes ...route) (*Frame, error)
 }
 
 type SocketConnection interface {
-	connect(url string) error
+	connect(c context.Context, host string) error
 	Close() error
 }
 
-func (ctx *ObsidianSocketContext) connect(url string) error {
-	conn, _, err := websocket.DefaultDialer.Dial("wss://"+url+"/", nil)
+// Compression negotiates permessage-deflate on new connections, which shrinks the JSON bursts of an initial sync of a
+// large vault. Servers that don't support it get uncompressed frames as before.
+var Compression = true
+
+// closeFrameTimeout bounds sending the close frame, which a broken connection can't take
+const closeFrameTimeout = time.Second
+
+func (ctx *ObsidianSocketContext) connect(c context.Context, host string) error {
+	dialer := *websocket.DefaultDialer
+	if ctx.Timeouts.Connect > 0 {
+		dialer.HandshakeTimeout = ctx.Timeouts.Connect
+	}
+	dialer.EnableCompression = Compression
+	dialer.TLSClientConfig = tlsConfig
+	conn, resp, err := dialer.DialContext(c, DefaultEndpoint.socketURL(host), nil)
 	if err != nil {
 		return err
 	}
+	if extensions := resp.Header.Get("Sec-Websocket-Extensions"); strings.Contains(extensions, "permessage-deflate") {
+		Log().Debug("🗜️ Compression negotiated", "extensions", extensions)
+	}
 	ctx.ws = conn
+	ctx.reader = startReader(conn, ctx.queueLimit(), &ctx.overflows)
 	return nil
 }
 
 // send sends a message to the websocket
 func (ctx *ObsidianSocketContext) sendMessage(msg interface{}) error {
-	if err := ctx.ws.WriteJSON(msg); err != nil {
+	d := ctx.writeDeadline()
+	_ = ctx.ws.SetWriteDeadline(d.at)
+	err := ctx.ws.WriteJSON(msg)
+	ctx.checkDeadline(d, err)
+	if err != nil {
 		return fmt.Errorf("could not send message: %v", err)
 	}
 
 	// Log JSON message
-	jsonMsg, err := json.Marshal(msg)
-	if err != nil {
-		return fmt.Errorf("could not marshal message: %v", err)
+	if TraceFrames || sessionTrace != nil {
+		jsonMsg, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("could not marshal message: %v", err)
+		}
+		traceFrame("⏩", jsonMsg)
+		recordFrame(true, jsonMsg)
 	}
-	fmt.Printf("⏩ %s\n", jsonMsg)
 
 	return nil
 }
 
 func (ctx *ObsidianSocketContext) sendBinary(msg []byte) error {
-	if err := ctx.ws.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+	d := ctx.writeDeadline()
+	_ = ctx.ws.SetWriteDeadline(d.at)
+	// Content is encrypted, so compressing it would only cost CPU
+	ctx.ws.EnableWriteCompression(false)
+	err := ctx.ws.WriteMessage(websocket.BinaryMessage, msg)
+	ctx.ws.EnableWriteCompression(true)
+	ctx.checkDeadline(d, err)
+	if err != nil {
 		return fmt.Errorf("could not send message: %v", err)
 	}
 
 	// Log binary message
-	fmt.Printf("⏩ Binary [%d]\n", len(msg))
+	traceFrame("⏩", msg)
+	recordFrame(true, msg)
 
 	return nil
 }
 
-// nextMessage returns the next message from the websocket
-func (ctx *ObsidianSocketContext) nextMessage() ([]byte, error) {
-	_, msg, err := ctx.ws.ReadMessage()
-	if err != nil {
-		return nil, fmt.Errorf("error reading message: %v", err)
-	}
-	fmt.Printf("⏪ %s\n", jsonOrBinary(msg))
-	return msg, nil
-}
-
+// Close sends a close frame, so the server knows we left rather than waiting out a dead connection, and closes the
+// websocket
 func (ctx *ObsidianSocketContext) Close() error {
-	return ctx.ws.Close()
+	if ctx.ws != nil {
+		// Best effort, the connection may already be broken
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		_ = ctx.ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(closeFrameTimeout))
+	}
+	return ctx.closeConnection()
 }
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"fmt"
+)
+
+// FileVersion is a version of a file the server kept, as listed by History
+type FileVersion struct {
+	Uid           int64  `json:"uid"`
+	EncryptedPath string `json:"path"`
+	EncryptedHash string `json:"hash"`
+	Size          int64  `json:"size"` // Of the encrypted content
+	Ctime         int64  `json:"ctime"`
+	Mtime         int64  `json:"mtime"`
+	Folder        bool   `json:"folder"`
+	Deleted       bool   `json:"deleted"`
+	Device        string `json:"device"` // Name of the device that pushed the version
+	Ts            int64  `json:"ts"`     // When the server received the version, in milliseconds
+}
+
+// History lists the versions the server kept of the file at path, newest first. Older versions can be pulled by UID
+// like any other. The connection has to be initialized first.
+func (ctx *ObsidianSocketContext) History(path string) ([]FileVersion, error) {
+	return ctx.HistoryContext(context.Background(), path)
+}
+
+// HistoryContext is History, giving up when c is done
+func (ctx *ObsidianSocketContext) HistoryContext(c context.Context, path string) ([]FileVersion, error) {
+	var versions []FileVersion
+	err := ctx.withContext(c, func() error {
+		return ctx.withDeadline("history", ctx.Timeouts.Transfer, func() error {
+			var err error
+			versions, err = ctx.history(path)
+			return err
+		})
+	})
+	return versions, ctx.transferError(err)
+}
+
+func (ctx *ObsidianSocketContext) history(path string) ([]FileVersion, error) {
+	encryptedPath, err := ctx.Cipher.EncryptString(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt path: %v", err)
+	}
+
+	// The server answers in pages, each continuing after the oldest UID of the last
+	var versions []FileVersion
+	var last int64
+	for {
+		historyMsg := struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+			Last int64  `json:"last"`
+		}{
+			Op:   "history",
+			Path: encryptedPath,
+			Last: last,
+		}
+		if err := ctx.sendMessage(historyMsg); err != nil {
+			return nil, fmt.Errorf("could not send history message: %v", err)
+		}
+
+		frame, err := ctx.nextOn(routeItems, routeError)
+		if err != nil {
+			return nil, fmt.Errorf("error reading history: %v", err)
+		}
+		if frame.route == routeError {
+			return nil, serverError(frame)
+		}
+		var page struct {
+			Items []FileVersion `json:"items"`
+			More  bool          `json:"more"`
+		}
+		if err := frame.Decode(&page); err != nil {
+			return nil, fmt.Errorf("could not unmarshal history: %v", err)
+		}
+		versions = append(versions, page.Items...)
+
+		// A page that doesn't move past the last one would loop forever
+		if !page.More || len(page.Items) == 0 || page.Items[len(page.Items)-1].Uid == last {
+			return versions, nil
+		}
+		last = page.Items[len(page.Items)-1].Uid
+	}
+}
+
+// DeletedFiles lists the last version of each file the server still tracks as deleted. Their paths are encrypted, and
+// their history can be listed and restored like that of any other file. The connection has to be initialized first.
+func (ctx *ObsidianSocketContext) DeletedFiles() ([]FileVersion, error) {
+	return ctx.DeletedFilesContext(context.Background())
+}
+
+// DeletedFilesContext is DeletedFiles, giving up when c is done
+func (ctx *ObsidianSocketContext) DeletedFilesContext(c context.Context) ([]FileVersion, error) {
+	var deleted []FileVersion
+	err := ctx.withContext(c, func() error {
+		return ctx.withDeadline("deleted", ctx.Timeouts.Transfer, func() error {
+			var err error
+			deleted, err = ctx.deletedFiles()
+			return err
+		})
+	})
+	return deleted, ctx.transferError(err)
+}
+
+func (ctx *ObsidianSocketContext) deletedFiles() ([]FileVersion, error) {
+	// Renames are pushed as a deletion of the old path, which isn't a file anyone lost
+	deletedMsg := struct {
+		Op              string `json:"op"`
+		SuppressRenames bool   `json:"suppressrenames"`
+	}{
+		Op:              "deleted",
+		SuppressRenames: true,
+	}
+	if err := ctx.sendMessage(deletedMsg); err != nil {
+		return nil, fmt.Errorf("could not send deleted message: %v", err)
+	}
+
+	frame, err := ctx.nextOn(routeItems, routeError)
+	if err != nil {
+		return nil, fmt.Errorf("error reading deleted files: %v", err)
+	}
+	if frame.route == routeError {
+		return nil, serverError(frame)
+	}
+	var list struct {
+		Items []FileVersion `json:"items"`
+	}
+	if err := frame.Decode(&list); err != nil {
+		return nil, fmt.Errorf("could not unmarshal deleted files: %v", err)
+	}
+	return list.Items, nil
+}
@@ -2,13 +2,30 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 )
 
+// StatusError is returned for API responses that aren't 200 OK
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request failed: (%d) %s", e.Code, e.Status)
+}
+
 func SendPostRequest(endpoint string, body []byte) (*http.Response, error) {
+	return SendPostRequestContext(context.Background(), endpoint, body)
+}
+
+// SendPostRequestContext is SendPostRequest, giving up when c is done
+func SendPostRequestContext(c context.Context, endpoint string, body []byte) (*http.Response, error) {
 	// Create request
-	req, err := http.NewRequest("POST", "https://api.obsidian.md"+endpoint, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(c, "POST", DefaultEndpoint.apiURL(endpoint), bytes.NewBuffer(body))
 	if err != nil {
 		return nil, fmt.Errorf("ould not create request: %v", err)
 	}
@@ -18,15 +35,41 @@ func SendPostRequest(endpoint string, body []byte) (*http.Response, error) {
 	req.Header.Set("Origin", "app://obsidian.md")
 
 	// send request
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("could not send request: %v", err)
 	}
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed: (%d) %s", resp.StatusCode, resp.Status)
+		_ = resp.Body.Close()
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
 	}
 
 	return resp, nil
 }
+
+// ClockSkew returns how far the local clock is ahead of the API server's, from the Date header of a request to it.
+// The header only has second resolution, so skews under a second or two are noise.
+func ClockSkew() (time.Duration, error) {
+	req, err := http.NewRequest("HEAD", DefaultEndpoint.apiURL("/"), nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %v", err)
+	}
+	sent := time.Now()
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not send request: %v", err)
+	}
+	_ = resp.Body.Close()
+	received := time.Now()
+
+	// Any response will do, even an error has the date
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("server sent no usable date: %v", err)
+	}
+	// The server's clock was read somewhere during the round trip, assume the middle
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(serverTime), nil
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// pingInterval is how often a ping control frame is sent to keep the connection alive
+	pingInterval = 20 * time.Second
+	// pongTimeout is how long the server may go without answering a ping or sending anything before the connection is
+	// considered dead
+	pongTimeout = 60 * time.Second
+)
+
+// ErrConnectionDead is returned when the server stopped answering pings, and the connection was closed
+var ErrConnectionDead = errors.New("connection dead, the server stopped answering pings")
+
+// errConnectionClosed is why the read loop of a connection we closed stopped
+var errConnectionClosed = errors.New("connection closed")
+
+// reader is the only reader of a websocket. Reading continuously lets gorilla answer and receive control frames even
+// while nothing waits for a message, so pongs are seen and dead connections are detected. Messages are dispatched to
+// frames by route.
+type reader struct {
+	frames   *dispatcher
+	stop     chan struct{}
+	stopOnce sync.Once
+	lastSign atomic.Int64 // Unix nanoseconds of the last pong or message
+	dead     atomic.Bool
+}
+
+// startReader starts the read loop and keepalive of a new connection
+func startReader(ws *websocket.Conn, queueLimit int, overflows *atomic.Int64) *reader {
+	r := &reader{
+		frames: newDispatcher(queueLimit, overflows),
+		stop:   make(chan struct{}),
+	}
+	r.lastSign.Store(time.Now().UnixNano())
+	ws.SetPongHandler(func(string) error {
+		r.lastSign.Store(time.Now().UnixNano())
+		return nil
+	})
+	go r.readLoop(ws)
+	go r.keepalive(ws)
+	return r
+}
+
+// halt stops the read loop and keepalive, the websocket should be closed too
+func (r *reader) halt() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+func (r *reader) readLoop(ws *websocket.Conn) {
+	defer r.halt()
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			switch {
+			case r.dead.Load():
+				r.frames.stop(ErrConnectionDead)
+			case r.halted():
+				r.frames.stop(errConnectionClosed)
+			default:
+				r.frames.stop(err)
+			}
+			return
+		}
+		r.lastSign.Store(time.Now().UnixNano())
+		recordFrame(false, msg)
+		if !r.frames.dispatch(parseFrame(msg)) {
+			Log().Warn("⚠️ Frames went unread, closing the connection", "err", r.frames.overflow())
+			r.halt()
+			_ = ws.Close()
+			return
+		}
+	}
+}
+
+// halted returns true once halt was called
+func (r *reader) halted() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// keepalive pings the server, closing the connection if it goes quiet for longer than pongTimeout
+func (r *reader) keepalive(ws *websocket.Conn) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, r.lastSign.Load())) > pongTimeout {
+				r.dead.Store(true)
+				_ = ws.Close()
+				return
+			}
+			// A failed ping means the connection is broken, which the read loop notices
+			_ = ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval))
+		}
+	}
+}
+
+// closeConnection closes the websocket and stops its reader, if there is one
+func (ctx *ObsidianSocketContext) closeConnection() error {
+	if ctx.reader != nil {
+		ctx.reader.halt()
+	}
+	if ctx.ws == nil {
+		return nil
+	}
+	return ctx.ws.Close()
+}
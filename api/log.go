@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a log line
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+)
+
+// ParseLevel parses "debug", "info" or "warn"
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected debug, info or warn", s)
+}
+
+// Logger receives the log lines of the api and sync packages. Arguments after the message are alternating keys and
+// values. The methods match those of *slog.Logger, so one can be passed to SetLogger as-is.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+// TraceFrames logs every websocket frame sent and received at debug level. Frames include the auth token, so it's off
+// by default.
+var TraceFrames = false
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger = NewTextLogger(os.Stdout, LevelInfo)
+)
+
+// Log returns the logger set with SetLogger, by default a TextLogger on stdout at info level
+func Log() Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// SetLogger replaces the logger of the api and sync packages, nil discards all log lines
+func SetLogger(l Logger) {
+	if l == nil {
+		l = NewTextLogger(io.Discard, LevelWarn+1)
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// TextLogger writes lines at or above its level as the message followed by key=value pairs
+type TextLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+// NewTextLogger returns a logger writing lines at or above level to w
+func NewTextLogger(w io.Writer, level Level) *TextLogger {
+	return &TextLogger{w: w, level: level}
+}
+
+func (l *TextLogger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args)
+}
+
+func (l *TextLogger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args)
+}
+
+func (l *TextLogger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args)
+}
+
+func (l *TextLogger) log(level Level, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	var line strings.Builder
+	line.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			// A key without a value, like slog's !BADKEY
+			_, _ = fmt.Fprintf(&line, " %v", args[i])
+			break
+		}
+		value := fmt.Sprint(args[i+1])
+		if value == "" || strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		_, _ = fmt.Fprintf(&line, " %v=%s", args[i], value)
+	}
+	line.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, line.String())
+}
+
+// JSONLogger writes lines at or above its level as JSON objects, one per line, for tools reading the output. Each has
+// the time, level and message, without its emoji, and the key/value pairs as fields.
+type JSONLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+// NewJSONLogger returns a logger writing lines at or above level to w as JSON
+func NewJSONLogger(w io.Writer, level Level) *JSONLogger {
+	return &JSONLogger{w: w, level: level}
+}
+
+func (l *JSONLogger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args)
+}
+
+func (l *JSONLogger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args)
+}
+
+func (l *JSONLogger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args)
+}
+
+var levelNames = map[Level]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn"}
+
+func (l *JSONLogger) log(level Level, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	fields := map[string]interface{}{
+		"time":  time.Now().Format(time.RFC3339Nano),
+		"level": levelNames[level],
+		"msg":   plainMessage(msg),
+	}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fields["!BADKEY"] = args[i]
+			break
+		}
+		value := args[i+1]
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case fmt.Stringer:
+			value = v.String()
+		}
+		fields[fmt.Sprint(args[i])] = value
+	}
+	line, err := json.Marshal(fields)
+	if err != nil {
+		// A value that can't be marshalled, log it as text rather than losing the line
+		for key, value := range fields {
+			fields[key] = fmt.Sprint(value)
+		}
+		line, _ = json.Marshal(fields)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(line)
+}
+
+// plainMessage strips the emoji a log message starts with
+func plainMessage(msg string) string {
+	for i, r := range msg {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return msg[i:]
+		}
+	}
+	return msg
+}
+
+// traceFrame logs a websocket frame if TraceFrames is set
+func traceFrame(direction string, data []byte) {
+	if TraceFrames {
+		Log().Debug(direction + " " + jsonOrBinary(data))
+	}
+}
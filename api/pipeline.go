@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrPushUnacknowledged is returned when the connection a push was sent on closed before the server acknowledged it.
+// The server may or may not have stored it, pushing it again on a new connection is safe.
+var ErrPushUnacknowledged = errors.New("push wasn't acknowledged")
+
+// PendingPush is a push whose content was sent, waiting for the server to acknowledge it, see SendPushContext
+type PendingPush struct {
+	Path string // Decrypted path that was pushed
+
+	message  *OutgoingPushMessage
+	conn     *websocket.Conn // The connection it was sent on, which may have been replaced since
+	frames   *dispatcher     // The frames of that connection
+	deadline deadline        // Of the whole push, from when it was sent
+	timeouts Timeouts
+}
+
+// SendPushContext sends a push like PushFileContext, but returns once the content is sent rather than when the server
+// acknowledged it. Wait for that with AckContext, which doesn't use the connection, so other operations can use it
+// meanwhile: each push costs a round trip for its acknowledgment, and sending the next file while waiting for it keeps
+// the connection busy during large uploads. The server handles messages in order, and acknowledgments are told apart
+// by path, so don't send the same path again before the first push was acknowledged.
+func (ctx *ObsidianSocketContext) SendPushContext(c context.Context, path string, extension string, ctime int64, mtime int64, folder bool, deleted bool, content []byte) (*PendingPush, error) {
+	if ctx.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	var pending *PendingPush
+	err := ctx.withContext(c, func() error {
+		return ctx.withDeadline("push", ctx.Timeouts.Transfer, func() error {
+			var err error
+			pending, err = ctx.sendPush(path, extension, ctime, mtime, folder, deleted, content)
+			return err
+		})
+	})
+	return pending, ctx.transferError(err)
+}
+
+// AckContext waits for the server to acknowledge the push, returning its echo like PushFileContext does. It may be
+// called while the connection is used for something else, and from several goroutines for different pushes. A push
+// whose echo doesn't match, or that isn't acknowledged in time, closes the connection, since its responses can't be
+// trusted anymore. Giving up when c is done closes it too.
+func (p *PendingPush) AckContext(c context.Context) (*IncomingPushMessage, error) {
+	dl := earliest(p.deadline, newDeadline("push ack", p.timeouts.PushAck))
+	echo, err := p.await(func(match func(*Frame) bool, routes ...route) (*Frame, error) {
+		return p.next(c, dl, match, routes...)
+	})
+	if errors.Is(err, ErrEchoMismatch) {
+		_ = p.conn.Close()
+	}
+	return echo, err
+}
+
+// next returns the oldest frame of the push's connection on the given routes that satisfies match, waiting for the
+// deadline of the acknowledgment and the Read timeout
+func (p *PendingPush) next(c context.Context, ack deadline, match func(*Frame) bool, routes ...route) (*Frame, error) {
+	dl := earliest(ack, newDeadline("read", p.timeouts.Read))
+	var expired <-chan time.Time
+	if !dl.at.IsZero() {
+		timer := time.NewTimer(time.Until(dl.at))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		f, arrived, err := p.frames.take(routes, match)
+		if errors.Is(err, ErrLostSync) {
+			return nil, fmt.Errorf("error reading message: %w", err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPushUnacknowledged, err)
+		}
+		if f != nil {
+			traceFrame("⏪", f.Data)
+			return f, nil
+		}
+
+		select {
+		case <-arrived:
+		case <-expired:
+			_ = p.conn.Close()
+			return nil, fmt.Errorf("error reading message: %w", &TimeoutError{Op: dl.op, After: dl.after})
+		case <-c.Done():
+			_ = p.conn.Close()
+			return nil, c.Err()
+		}
+	}
+}
+
+// Connected returns false once the connection was closed, by us or the server, and has to be reconnected before it's
+// used again
+func (ctx *ObsidianSocketContext) Connected() bool {
+	return ctx.reader != nil && !ctx.reader.halted()
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// pendingOn returns a push waiting for its acknowledgment on ctx, as if it was just sent
+func pendingOn(ctx *ObsidianSocketContext, message *OutgoingPushMessage) *PendingPush {
+	return &PendingPush{Path: message.Path, message: message, conn: ctx.ws, frames: ctx.reader.frames}
+}
+
+func echoOf(message *OutgoingPushMessage, uid int64) string {
+	echo, _ := json.Marshal(IncomingPushMessage{
+		Op:            "push",
+		EncryptedPath: message.Path,
+		EncryptedHash: message.Hash,
+		Folder:        message.Folder,
+		Deleted:       message.Deleted,
+		Uid:           uid,
+	})
+	return string(echo)
+}
+
+func TestAckInterleavedEchoes(t *testing.T) {
+	first := &OutgoingPushMessage{Op: "push", Path: "ea", Hash: "ha"}
+	second := &OutgoingPushMessage{Op: "push", Path: "eb", Hash: "hb"}
+	other := `{"op":"push","path":"ec","hash":"hc","uid":5}`
+	ctx := fakeConnection(t, 0, func(ws *websocket.Conn) {
+		// A push from another device arrives between the acknowledgments
+		writeFrames(ws, echoOf(first, 6), `{"op":"ok"}`, other, echoOf(second, 7), `{"op":"ok"}`)
+	})
+
+	// The second push is waited on first, and must not take the first one's echo
+	pushes := []struct {
+		message *OutgoingPushMessage
+		uid     int64
+	}{{second, 7}, {first, 6}}
+	acks := make(chan error, len(pushes))
+	for _, push := range pushes {
+		push := push
+		go func() {
+			echo, err := pendingOn(ctx, push.message).AckContext(context.Background())
+			if err == nil && (echo.EncryptedPath != push.message.Path || echo.Uid != push.uid) {
+				err = fmt.Errorf("%s acknowledged with the echo of %s, UID %d", push.message.Path, echo.EncryptedPath, echo.Uid)
+			}
+			acks <- err
+		}()
+	}
+	for range pushes {
+		if err := waitFor(t, func() error { return <-acks }); err != nil {
+			t.Errorf("AckContext() error = %v", err)
+		}
+	}
+
+	// The other device's push stays queued for whoever reads pushes
+	f, err := ctx.nextOn(routePush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Data) != other {
+		t.Errorf("left queued %s, want %s", f.Data, other)
+	}
+}
+
+func TestAckMismatchClosesConnection(t *testing.T) {
+	message := &OutgoingPushMessage{Op: "push", Path: "ea", Hash: "ha"}
+	ctx := fakeConnection(t, 0, func(ws *websocket.Conn) {
+		writeFrames(ws, echoOf(&OutgoingPushMessage{Path: "ea", Hash: "other"}, 1), `{"op":"ok"}`)
+	})
+	_, err := pendingOn(ctx, message).AckContext(context.Background())
+	if !errors.Is(err, ErrEchoMismatch) {
+		t.Fatalf("AckContext() error = %v, want ErrEchoMismatch", err)
+	}
+	if err := waitFor(t, func() error { _, err := ctx.nextOn(routeOther); return err }); err == nil {
+		t.Error("the connection is still read after a mismatched echo")
+	}
+}
+
+func TestAckUnacknowledged(t *testing.T) {
+	message := &OutgoingPushMessage{Op: "push", Path: "ea", Hash: "ha"}
+	ctx := fakeConnection(t, 0, func(ws *websocket.Conn) {
+		writeFrames(ws, echoOf(message, 1))
+	})
+	pending := pendingOn(ctx, message)
+	_ = ctx.Close()
+	err := waitFor(t, func() error { _, err := pending.AckContext(context.Background()); return err })
+	if !errors.Is(err, ErrPushUnacknowledged) {
+		t.Errorf("AckContext() error = %v, want ErrPushUnacknowledged", err)
+	}
+}
+
+func TestCheckEcho(t *testing.T) {
+	file := &OutgoingPushMessage{Path: "ea", Hash: "ha"}
+	folder := &OutgoingPushMessage{Path: "ef", Folder: true}
+	deleted := &OutgoingPushMessage{Path: "ed", Deleted: true}
+	tests := []struct {
+		name    string
+		message *OutgoingPushMessage
+		echo    IncomingPushMessage
+		wantErr bool
+	}{
+		{"file", file, IncomingPushMessage{EncryptedPath: "ea", EncryptedHash: "ha", Uid: 1}, false},
+		{"other path", file, IncomingPushMessage{EncryptedPath: "eb", EncryptedHash: "ha", Uid: 1}, true},
+		{"other hash", file, IncomingPushMessage{EncryptedPath: "ea", EncryptedHash: "hb", Uid: 1}, true},
+		{"no UID", file, IncomingPushMessage{EncryptedPath: "ea", EncryptedHash: "ha"}, true},
+		{"negative UID", file, IncomingPushMessage{EncryptedPath: "ea", EncryptedHash: "ha", Uid: -1}, true},
+		{"folder without hash", folder, IncomingPushMessage{EncryptedPath: "ef", Folder: true, Uid: 1}, false},
+		{"folder echoed as a file", folder, IncomingPushMessage{EncryptedPath: "ef", Uid: 1}, true},
+		{"deletion without hash", deleted, IncomingPushMessage{EncryptedPath: "ed", Deleted: true, Uid: 1}, false},
+		{"deletion echoed as a file", deleted, IncomingPushMessage{EncryptedPath: "ed", Uid: 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkEcho(tt.message, &tt.echo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkEcho() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrEchoMismatch) {
+				t.Errorf("checkEcho() error = %v, want ErrEchoMismatch", err)
+			}
+		})
+	}
+}
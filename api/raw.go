@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FrameType distinguishes JSON control frames from binary content frames
+type FrameType int
+
+const (
+	JsonFrame FrameType = iota
+	BinaryFrame
+)
+
+// Frame is a single raw message received from the sync server
+type Frame struct {
+	Type FrameType
+	Op   string // "op" field of a JSON frame, if present
+	Res  string // "res" field of a JSON frame, if present
+	Data []byte
+
+	route route
+}
+
+// Decode unmarshals a JSON frame into v
+func (f *Frame) Decode(v interface{}) error {
+	if f.Type != JsonFrame {
+		return fmt.Errorf("cannot decode binary frame")
+	}
+	return json.Unmarshal(f.Data, v)
+}
+
+// readOnlyOps are the ops a read-only connection may send, anything else could modify the vault
+var readOnlyOps = map[string]bool{
+	"init":    true,
+	"pull":    true,
+	"size":    true,
+	"history": true,
+	"deleted": true,
+	"ping":    true,
+}
+
+// SendOp sends a JSON message with the given op. The fields of payload, which may be a struct, map or nil, are merged
+// into the message. This allows experimenting with ops that have no high-level method.
+func (ctx *ObsidianSocketContext) SendOp(op string, payload interface{}) error {
+	if ctx.ReadOnly && !readOnlyOps[op] {
+		return ErrReadOnly
+	}
+
+	msg := map[string]json.RawMessage{}
+	if payload != nil {
+		payloadJson, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("could not marshal payload: %v", err)
+		}
+		if err := json.Unmarshal(payloadJson, &msg); err != nil {
+			return fmt.Errorf("payload must be a JSON object: %v", err)
+		}
+	}
+
+	opJson, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("could not marshal op: %v", err)
+	}
+	msg["op"] = opJson
+
+	return ctx.sendMessage(msg)
+}
+
+// SendRawBinary sends a binary frame as-is. Binary frames only carry push content, so read-only connections refuse them.
+func (ctx *ObsidianSocketContext) SendRawBinary(data []byte) error {
+	if ctx.ReadOnly {
+		return ErrReadOnly
+	}
+	return ctx.sendBinary(data)
+}
+
+// ReadOp returns the next frame from the server, including frames that earlier high-level calls left queued
+func (ctx *ObsidianSocketContext) ReadOp() (*Frame, error) {
+	return ctx.nextOn(allRoutes...)
+}
+
+// ReadOpMatching returns the next JSON frame with the given op, leaving other frames queued for later reads
+func (ctx *ObsidianSocketContext) ReadOpMatching(op string) (*Frame, error) {
+	return ctx.next(func(f *Frame) bool {
+		return f.Type == JsonFrame && f.Op == op
+	}, allRoutes...)
+}
+
+// parseFrame classifies a raw message, extracts its op and res fields and picks its route
+func parseFrame(msg []byte) *Frame {
+	var fields struct {
+		Op     string           `json:"op"`
+		Res    string           `json:"res"`
+		Pieces *json.RawMessage `json:"pieces"`
+		Limit  *json.RawMessage `json:"limit"`
+		Items  *json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(msg, &fields); err != nil {
+		return &Frame{Type: BinaryFrame, Data: msg, route: routeBinary}
+	}
+	f := &Frame{Type: JsonFrame, Op: fields.Op, Res: fields.Res, Data: msg, route: routeOther}
+	switch {
+	case f.Op == "push":
+		f.route = routePush
+	case f.Op == "ready":
+		f.route = routeReady
+	case f.Op == "ok":
+		f.route = routeOk
+	case f.Res == "err":
+		f.route = routeError
+	case f.Res != "":
+		f.route = routeResponse
+	case fields.Pieces != nil:
+		f.route = routeHeader
+	case fields.Limit != nil:
+		f.route = routeSize
+	case fields.Items != nil:
+		f.route = routeItems
+	}
+	return f
+}
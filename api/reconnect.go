@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// BackoffPolicy configures the delays between reconnection attempts
+type BackoffPolicy struct {
+	Initial     time.Duration // Delay before the first retry
+	Max         time.Duration // Upper bound for a single delay
+	MaxAttempts int           // Give up after this many attempts, zero retries forever
+}
+
+// DefaultBackoff retries forever, starting at one second and backing off to five minutes
+var DefaultBackoff = BackoffPolicy{
+	Initial: time.Second,
+	Max:     5 * time.Minute,
+}
+
+// Delay returns the jittered exponential delay before the given zero-based attempt
+func (p BackoffPolicy) Delay(attempt int) time.Duration {
+	d := p.Initial
+	for i := 0; i < attempt && d < p.Max; i++ {
+		d *= 2
+	}
+	if d > p.Max {
+		d = p.Max
+	}
+	// Jitter between half and the full delay so clients don't reconnect in lockstep
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Reconnect closes the current websocket, then reconnects and re-runs init from the given UID watermark, retrying
+// with exponential backoff until it succeeds or the policy's attempts are exhausted
+func (ctx *ObsidianSocketContext) Reconnect(policy BackoffPolicy, version int64) (*InitResult, error) {
+	return ctx.ReconnectContext(context.Background(), policy, version)
+}
+
+// ReconnectContext is Reconnect, giving up when c is done
+func (ctx *ObsidianSocketContext) ReconnectContext(c context.Context, policy BackoffPolicy, version int64) (*InitResult, error) {
+	_ = ctx.closeConnection()
+
+	var lastErr error
+	for attempt := 0; policy.MaxAttempts == 0 || attempt < policy.MaxAttempts; attempt++ {
+		delay := policy.Delay(attempt)
+		Log().Info("🔌 Reconnecting", "delay", delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-c.Done():
+			return nil, c.Err()
+		}
+
+		ctx.canceled.Store(false)
+
+		if err := ctx.connect(c, ctx.Vault.Host); err != nil {
+			lastErr = fmt.Errorf("error connecting to websocket: %v", err)
+			Log().Warn("⚠️ Reconnect failed", "err", lastErr)
+			continue
+		}
+
+		initResult, err := ctx.SendInitContext(c, version, false)
+		if c.Err() != nil {
+			return nil, c.Err()
+		}
+		if errors.Is(err, ErrKeyMismatch) {
+			// Retrying won't help until we have the new password
+			return nil, err
+		}
+		if err != nil {
+			_ = ctx.closeConnection()
+			lastErr = fmt.Errorf("error sending init message: %v", err)
+			Log().Warn("⚠️ Reconnect failed", "err", lastErr)
+			continue
+		}
+
+		Log().Info("🔌 Reconnected")
+		return initResult, nil
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempts: %v", policy.MaxAttempts, lastErr)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// Share is a user a vault is shared with
+type Share struct {
+	Uid      string `json:"uid"` // Identifies the share, for RemoveShare
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Accepted bool   `json:"accepted"` // False while the invite is pending
+}
+
+// ListShares returns the users a vault is shared with
+func ListShares(token *crypto.Secret, vaultId string) ([]Share, error) {
+	var data struct {
+		Shares []Share `json:"shares"`
+	}
+	err := postForJSON("/vault/share/list", "share list", map[string]string{
+		"token":     token.Reveal(),
+		"vault_uid": vaultId,
+	}, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data.Shares, nil
+}
+
+// InviteShare invites a user to collaborate on a vault by email. They get access once they accept.
+func InviteShare(token *crypto.Secret, vaultId string, email string) error {
+	if email == "" {
+		return fmt.Errorf("no email to invite")
+	}
+	return postForJSON("/vault/share/invite", "share invite", map[string]string{
+		"token":     token.Reveal(),
+		"vault_uid": vaultId,
+		"email":     email,
+	}, nil)
+}
+
+// RemoveShare revokes a user's access to a vault, or cancels their pending invite
+func RemoveShare(token *crypto.Secret, vaultId string, shareUid string) error {
+	return postForJSON("/vault/share/remove", "share remove", map[string]string{
+		"token":     token.Reveal(),
+		"vault_uid": vaultId,
+		"share_uid": shareUid,
+	}, nil)
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// PushFileFrom pushes a file like PushFile, reading its size bytes of content from r instead of taking them in memory.
+// The push message carries the content's hash, so the content is read twice: r is seeked back if it's an
+// io.ReadSeeker, like an *os.File, and spooled to a temporary file otherwise. Only a piece at a time is held in
+// memory either way.
+func (ctx *ObsidianSocketContext) PushFileFrom(path string, extension string, ctime int64, mtime int64, r io.Reader, size int64) (*IncomingPushMessage, error) {
+	return ctx.PushFileFromContext(context.Background(), path, extension, ctime, mtime, r, size)
+}
+
+// PushFileFromContext is PushFileFrom, giving up when c is done
+func (ctx *ObsidianSocketContext) PushFileFromContext(c context.Context, path string, extension string, ctime int64, mtime int64, r io.Reader, size int64) (*IncomingPushMessage, error) {
+	if ctx.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	streamCipher, ok := ctx.Cipher.(crypto.StreamCipher)
+	if !ok {
+		content, err := readSized(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.PushFileContext(c, path, extension, ctime, mtime, false, false, content)
+	}
+
+	src, hash, cleanup, err := hashContent(r, size)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var pushResponse *IncomingPushMessage
+	err = ctx.withContext(c, func() error {
+		return ctx.withDeadline("push", ctx.Timeouts.Transfer, func() error {
+			pending, err := ctx.sendPushFrom(streamCipher, path, extension, ctime, mtime, src, size, hash)
+			if err != nil {
+				return err
+			}
+			done := ctx.expectWithin("push ack", ctx.Timeouts.PushAck)
+			defer done()
+			pushResponse, err = pending.await(ctx.next)
+			return err
+		})
+	})
+	return pushResponse, ctx.transferError(err)
+}
+
+// SendPushFromContext sends a push like PushFileFromContext, but returns once the content is sent rather than when the
+// server acknowledged it, like SendPushContext. r is only read until it returns.
+func (ctx *ObsidianSocketContext) SendPushFromContext(c context.Context, path string, extension string, ctime int64, mtime int64, r io.Reader, size int64) (*PendingPush, error) {
+	if ctx.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	streamCipher, ok := ctx.Cipher.(crypto.StreamCipher)
+	if !ok {
+		content, err := readSized(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.SendPushContext(c, path, extension, ctime, mtime, false, false, content)
+	}
+
+	src, hash, cleanup, err := hashContent(r, size)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var pending *PendingPush
+	err = ctx.withContext(c, func() error {
+		return ctx.withDeadline("push", ctx.Timeouts.Transfer, func() error {
+			var err error
+			pending, err = ctx.sendPushFrom(streamCipher, path, extension, ctime, mtime, src, size, hash)
+			return err
+		})
+	})
+	return pending, ctx.transferError(err)
+}
+
+// sendPushFrom is sendPush for content read from src, whose hex SHA-256 is hash
+func (ctx *ObsidianSocketContext) sendPushFrom(cipher crypto.StreamCipher, path string, extension string, ctime int64, mtime int64, src io.Reader, size int64, hash string) (*PendingPush, error) {
+	encryptedPath, err := cipher.EncryptString(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt path: %s", err)
+	}
+	encryptedContentSum, err := cipher.EncryptString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt content sum: %s", err)
+	}
+
+	encryptedSize := crypto.EncryptedSize(size)
+	message := &OutgoingPushMessage{
+		Op:     "push",
+		Path:   encryptedPath,
+		Ext:    extension,
+		Hash:   encryptedContentSum,
+		Ctime:  ctime,
+		Mtime:  mtime,
+		Size:   encryptedSize,
+		Pieces: pieceCount(int(encryptedSize)),
+	}
+	if err := ctx.sendMessage(message); err != nil {
+		return nil, fmt.Errorf("could not send push message: %s", err)
+	}
+
+	// The server asks for each piece with a {"res": "next"} like it does for PushFile
+	pieces := &pieceSender{ctx: ctx, total: encryptedSize}
+	written, err := cipher.EncryptTo(pieces, io.LimitReader(src, size), pushPieceSize)
+	if err == nil && written != encryptedSize {
+		err = fmt.Errorf("content changed while it was pushed, read %d bytes instead of %d", crypto.PlaintextSize(written), size)
+	}
+	if err == nil {
+		err = pieces.flush()
+	}
+	if err != nil {
+		// The server is still waiting for the rest of the pieces
+		_ = ctx.closeConnection()
+		return nil, err
+	}
+
+	return &PendingPush{
+		Path:     path,
+		message:  message,
+		conn:     ctx.ws,
+		frames:   ctx.reader.frames,
+		deadline: ctx.opDeadline,
+		timeouts: ctx.Timeouts,
+	}, nil
+}
+
+// pieceSender sends the encrypted content written to it in pieces of pushPieceSize, each when the server asks for it
+type pieceSender struct {
+	ctx   *ObsidianSocketContext
+	buf   []byte
+	sent  int64
+	total int64
+}
+
+func (p *pieceSender) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for len(p.buf) >= pushPieceSize {
+		if err := p.send(p.buf[:pushPieceSize]); err != nil {
+			return 0, err
+		}
+		p.buf = append(p.buf[:0], p.buf[pushPieceSize:]...)
+	}
+	return len(data), nil
+}
+
+// flush sends what's left as the last piece
+func (p *pieceSender) flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.send(p.buf)
+	p.buf = nil
+	return err
+}
+
+func (p *pieceSender) send(piece []byte) error {
+	if err := p.ctx.awaitNext(); err != nil {
+		return err
+	}
+	if err := p.ctx.sendBinary(piece); err != nil {
+		return fmt.Errorf("could not send encrypted content: %s", err)
+	}
+	p.sent += int64(len(piece))
+	p.ctx.reportTransfer(p.sent, p.total)
+	return nil
+}
+
+// hashContent returns the hex SHA-256 of the size bytes of r, and a reader of the same content to push. Call cleanup
+// once the push is done.
+func hashContent(r io.Reader, size int64) (io.Reader, string, func(), error) {
+	sum := sha256.New()
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("could not read content: %s", err)
+		}
+		if err := copySized(sum, seeker, size); err != nil {
+			return nil, "", nil, err
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, "", nil, fmt.Errorf("could not read content: %s", err)
+		}
+		return seeker, hex.EncodeToString(sum.Sum(nil)), func() {}, nil
+	}
+
+	spool, err := os.CreateTemp("", "obsidian-sync-push-")
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("could not spool content: %s", err)
+	}
+	cleanup := func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}
+	if err := copySized(io.MultiWriter(sum, spool), r, size); err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, "", nil, fmt.Errorf("could not spool content: %s", err)
+	}
+	return spool, hex.EncodeToString(sum.Sum(nil)), cleanup, nil
+}
+
+// copySized copies r to w, failing unless r has exactly size bytes
+func copySized(w io.Writer, r io.Reader, size int64) error {
+	n, err := io.Copy(w, io.LimitReader(r, size+1))
+	if err != nil {
+		return fmt.Errorf("could not read content: %s", err)
+	}
+	if n > size {
+		return fmt.Errorf("content is longer than %d bytes", size)
+	}
+	if n < size {
+		return fmt.Errorf("content has %d bytes, not %d", n, size)
+	}
+	return nil
+}
+
+// readSized reads the size bytes of r into memory
+func readSized(r io.Reader, size int64) ([]byte, error) {
+	var content bytes.Buffer
+	if err := copySized(&content, r, size); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+// PullFileTo pulls a file like PullFile, writing its content to w as it's decrypted instead of returning it. w
+// receives content before its hash is checked, so discard what it received if an error is returned. Returns the
+// number of bytes written.
+func (ctx *ObsidianSocketContext) PullFileTo(uid int64, expectedEncryptedHash string, w io.Writer) (int64, error) {
+	return ctx.PullFileToContext(context.Background(), uid, expectedEncryptedHash, w)
+}
+
+// PullFileToContext is PullFileTo, giving up when c is done
+func (ctx *ObsidianSocketContext) PullFileToContext(c context.Context, uid int64, expectedEncryptedHash string, w io.Writer) (int64, error) {
+	streamCipher, ok := ctx.Cipher.(crypto.StreamCipher)
+	if !ok {
+		content, err := ctx.PullFileContext(c, uid, expectedEncryptedHash)
+		if err != nil {
+			return 0, err
+		}
+		n, err := w.Write(content)
+		return int64(n), err
+	}
+
+	decrypter := streamCipher.NewStreamDecrypter()
+	sum := sha256.New()
+	var written int64
+	err := ctx.withContext(c, func() error {
+		return ctx.withDeadline("pull", ctx.Timeouts.Transfer, func() error {
+			return ctx.pullPieces(uid, func(piece []byte) error {
+				plaintext := decrypter.Update(piece)
+				sum.Write(plaintext)
+				n, err := w.Write(plaintext)
+				written += int64(n)
+				return err
+			})
+		})
+	})
+	if err := ctx.transferError(err); err != nil {
+		return written, err
+	}
+	if err := decrypter.Final(); err != nil {
+		return written, fmt.Errorf("could not decrypt data: %s", err)
+	}
+	return written, ctx.checkContentHash(sum.Sum(nil), expectedEncryptedHash)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Timeouts bound how long network operations may take, so a stalled server fails the operation instead of hanging
+// forever. Zero means no limit.
+type Timeouts struct {
+	Connect    time.Duration // Dialing the websocket, including the handshake, and each HTTP API request
+	Init       time.Duration // Init, until the server has sent the whole remote index
+	Transfer   time.Duration // Pulling or pushing a single file
+	Read       time.Duration // Waiting for any one message during an operation, so a stall is noticed quickly
+	Write      time.Duration // Sending any one message
+	PullHeader time.Duration // Waiting for the header of a pull
+	Piece      time.Duration // Waiting for each piece of a pull
+	PushAck    time.Duration // Waiting for the server to acknowledge a push
+}
+
+// DefaultTimeouts are used by HTTP requests and new connections. The command line sets them from flags.
+var DefaultTimeouts Timeouts
+
+// ErrTimeout is returned when an operation runs past its timeout. Errors returned for timeouts are TimeoutErrors
+// wrapping it.
+var ErrTimeout = errors.New("timed out")
+
+// TimeoutError is returned when an operation or one of its steps runs past its timeout. The websocket is unusable
+// afterwards, reconnect before retrying.
+type TimeoutError struct {
+	Op    string        // What timed out, e.g. "init" or "pull header"
+	After time.Duration // The timeout that was exceeded
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Op, e.After)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// deadline is a point in time a read or write has to finish by, and what it bounds
+type deadline struct {
+	op    string
+	after time.Duration
+	at    time.Time
+}
+
+// newDeadline returns the deadline d from now, or a zero deadline if d is zero
+func newDeadline(op string, d time.Duration) deadline {
+	if d <= 0 {
+		return deadline{}
+	}
+	return deadline{op: op, after: d, at: time.Now().Add(d)}
+}
+
+// earliest returns the earliest of the deadlines that are set
+func earliest(deadlines ...deadline) deadline {
+	var first deadline
+	for _, d := range deadlines {
+		if !d.at.IsZero() && (first.at.IsZero() || d.at.Before(first.at)) {
+			first = d
+		}
+	}
+	return first
+}
+
+// passed returns true if the deadline is set and has passed
+func (d deadline) passed() bool {
+	return !d.at.IsZero() && !time.Now().Before(d.at)
+}
+
+// withDeadline runs op as the named operation, which has to finish within d. Each read and write of op is also bound
+// by the Read and Write timeouts, and by the timeout of the step it's part of, see expectWithin. A websocket that hit a
+// deadline is unusable and has to be reconnected.
+func (ctx *ObsidianSocketContext) withDeadline(op string, d time.Duration, fn func() error) error {
+	ctx.opDeadline = newDeadline(op, d)
+	ctx.inOp = true
+	ctx.lastTimeout = nil
+	defer func() {
+		ctx.opDeadline = deadline{}
+		ctx.inOp = false
+	}()
+
+	// Our errors format their causes rather than wrapping them, so the read or write that timed out records it
+	err := fn()
+	if err != nil && ctx.lastTimeout != nil {
+		return fmt.Errorf("%w: %v", ctx.lastTimeout, err)
+	}
+	return err
+}
+
+// expectWithin bounds the reads of one step of an operation, like waiting for a pull header, by d. Call the returned
+// function once the step is done.
+func (ctx *ObsidianSocketContext) expectWithin(step string, d time.Duration) func() {
+	ctx.stepDeadline = newDeadline(step, d)
+	return func() {
+		ctx.stepDeadline = deadline{}
+	}
+}
+
+// readDeadline returns the deadline for the next read. Reads outside an operation, like waiting for pushes, have
+// none.
+func (ctx *ObsidianSocketContext) readDeadline() deadline {
+	if !ctx.inOp {
+		return deadline{}
+	}
+	return earliest(ctx.opDeadline, ctx.stepDeadline, newDeadline("read", ctx.Timeouts.Read))
+}
+
+// writeDeadline returns the deadline for the next write
+func (ctx *ObsidianSocketContext) writeDeadline() deadline {
+	if !ctx.inOp {
+		return newDeadline("write", ctx.Timeouts.Write)
+	}
+	return earliest(ctx.opDeadline, newDeadline("write", ctx.Timeouts.Write))
+}
+
+// checkDeadline records a timeout if a read or write failed because its deadline passed
+func (ctx *ObsidianSocketContext) checkDeadline(d deadline, err error) {
+	if err != nil && d.passed() {
+		ctx.lastTimeout = &TimeoutError{Op: d.op, After: d.after}
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TLSOptions customizes how the API and sync hosts are verified, and how we authenticate to them
+type TLSOptions struct {
+	CABundle   string   // PEM file of the CAs to trust instead of the system's, e.g. for a corporate proxy
+	ClientCert string   // PEM file of a client certificate to present, needs ClientKey
+	ClientKey  string   // PEM file of the client certificate's private key
+	Pins       []string // Base64 SHA-256 hashes of public keys, "sha256/" prefix optional. Some certificate of every host's chain must match one.
+}
+
+// ErrPinMismatch is returned when no certificate of a host's chain matches a pinned key
+var ErrPinMismatch = errors.New("no certificate matches a pinned key")
+
+// tlsConfig is the config set by SetTLS, nil uses Go's defaults
+var tlsConfig *tls.Config
+
+// SetTLS applies the options to all API requests and connections made from now on
+func SetTLS(o TLSOptions) error {
+	config, err := o.Config()
+	if err != nil {
+		return err
+	}
+	tlsConfig = config
+	return nil
+}
+
+// Config returns the TLS config for the options, or nil if they're all empty
+func (o TLSOptions) Config() (*tls.Config, error) {
+	if o.CABundle == "" && o.ClientCert == "" && o.ClientKey == "" && len(o.Pins) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if o.CABundle != "" {
+		bundle, err := os.ReadFile(o.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.CABundle)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.ClientCert != "" || o.ClientKey != "" {
+		if o.ClientCert == "" || o.ClientKey == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(o.Pins) > 0 {
+		pins := make(map[string]bool, len(o.Pins))
+		for _, pin := range o.Pins {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+			if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("invalid pin %q, expected a base64 SHA-256 hash", pin)
+			}
+			pins[pin] = true
+		}
+		// Runs after the usual verification, so a pin narrows the trusted certificates rather than replacing the check
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			// Verified chains include the root, which servers usually don't send
+			for _, chain := range append(state.VerifiedChains, state.PeerCertificates) {
+				for _, cert := range chain {
+					sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("%w for %s", ErrPinMismatch, state.ServerName)
+		}
+	}
+
+	return config, nil
+}
+
+// httpClient returns a client for API requests using the TLS config
+func httpClient() *http.Client {
+	client := &http.Client{Timeout: DefaultTimeouts.Connect}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	return client
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"io"
+)
+
+// UserInfo is the account a token belongs to
+type UserInfo struct {
+	Uid     string `json:"uid"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	MFA     bool   `json:"mfa"`     // Whether two-factor authentication is enabled
+	License string `json:"license"` // Catalyst or commercial license, empty if none
+}
+
+// Subscription is the state of a paid add-on like Sync
+type Subscription struct {
+	Earlybird bool   `json:"earlybird"`
+	ExpiryTs  int64  `json:"expiry_ts"` // Milliseconds since the epoch
+	Renew     string `json:"renew"`     // Renewal period, empty if it won't renew
+}
+
+// Subscriptions lists the account's add-ons, nil if it doesn't have one
+type Subscriptions struct {
+	Sync    *Subscription `json:"sync"`
+	Publish *Subscription `json:"publish"`
+}
+
+func GetUserInfo(token *crypto.Secret) (*UserInfo, error) {
+	var info UserInfo
+	if err := postForJSON("/user/info", "user info", map[string]string{"token": token.Reveal()}, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func GetSubscriptions(token *crypto.Secret) (*Subscriptions, error) {
+	var subscriptions Subscriptions
+	if err := postForJSON("/subscription/list", "subscription", map[string]string{"token": token.Reveal()}, &subscriptions); err != nil {
+		return nil, err
+	}
+	return &subscriptions, nil
+}
+
+// postForJSON sends the request fields to an endpoint and decodes the response into result, failing if the server
+// returned an error instead. Result may be nil if the response has nothing else of interest. What names the request in
+// errors.
+func postForJSON(endpoint string, what string, request map[string]string, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("could not create %s request: %v", what, err)
+	}
+
+	// send request
+	resp, err := SendPostRequest(endpoint, body)
+	if err != nil {
+		return fmt.Errorf("could not send %s request: %v", what, err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			Log().Warn("could not close "+what+" response body", "err", err)
+		}
+	}(resp.Body)
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read %s response: %v", what, err)
+	}
+	var data struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("could not decode %s response: %v", what, err)
+	}
+	if data.Error != "" {
+		return fmt.Errorf("server returned error: %s", data.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("could not decode %s response: %v", what, err)
+	}
+	return nil
+}
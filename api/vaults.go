@@ -1,29 +1,38 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/nbadal/obsidian-sync/crypto"
 	"io"
-	"log"
+	"net/http"
+	"strings"
 )
 
-func ListVaults(token string) ([]VaultInfo, error) {
+func ListVaults(token *crypto.Secret) ([]VaultInfo, error) {
+	return ListVaultsContext(context.Background(), token)
+}
+
+// ListVaultsContext is ListVaults, giving up when c is done
+func ListVaultsContext(c context.Context, token *crypto.Secret) ([]VaultInfo, error) {
 	body, err := json.Marshal(map[string]string{
-		"token": token,
+		"token": token.Reveal(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("could not create vault list request: %v", err)
 	}
 
 	// send request
-	resp, err := SendPostRequest("/vault/list", body)
+	resp, err := SendPostRequestContext(c, "/vault/list", body)
 	if err != nil {
 		return nil, fmt.Errorf("could not send vault list request: %v", err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
 		if err != nil {
-			log.Printf("could not close vault list response body: %v", err)
+			Log().Warn("could not close vault list response body", "err", err)
 		}
 	}(resp.Body)
 
@@ -47,9 +56,112 @@ func ListVaults(token string) ([]VaultInfo, error) {
 }
 
 type VaultInfo struct {
-	Id       string `json:"id"`
-	Name     string `json:"name"`
-	Password string `json:"password"`
-	Salt     string `json:"salt"`
-	Host     string `json:"host"`
+	Id                string `json:"id"`
+	Name              string `json:"name"`
+	Password          string `json:"password"`
+	Salt              string `json:"salt"`
+	Host              string `json:"host"`
+	EncryptionVersion int    `json:"encryption_version"`
+	Size              int64  `json:"size"` // Bytes the vault uses of the account's storage
+}
+
+// CreateVault creates an end-to-end encrypted vault. The key hash and salt come from the vault's cipher, so the
+// password itself never leaves the client. Region picks the server, empty lets the server choose.
+func CreateVault(token *crypto.Secret, name string, keyHash string, salt string, region string, encryptionVersion int) (*VaultInfo, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"token":              token.Reveal(),
+		"name":               name,
+		"keyhash":            keyHash,
+		"salt":               salt,
+		"region":             region,
+		"encryption_version": encryptionVersion,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create vault create request: %v", err)
+	}
+
+	// send request
+	resp, err := SendPostRequest("/vault/create", body)
+	if err != nil {
+		return nil, fmt.Errorf("could not send vault create request: %v", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			Log().Warn("could not close vault create response body", "err", err)
+		}
+	}(resp.Body)
+
+	var data struct {
+		VaultInfo
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("could not decode vault create response: %v", err)
+	}
+	if data.Error != "" {
+		return nil, fmt.Errorf("server returned error: %s", data.Error)
+	}
+	if data.Id == "" {
+		return nil, fmt.Errorf("no vault returned")
+	}
+	return &data.VaultInfo, nil
+}
+
+var (
+	// ErrVaultShared is returned by DeleteVault for a vault shared with other users, who have to be removed first
+	ErrVaultShared = errors.New("vault is shared with other users")
+	// ErrNotVaultOwner is returned by DeleteVault for a vault the account doesn't own
+	ErrNotVaultOwner = errors.New("vault is not owned by this account")
+)
+
+// DeleteVault permanently deletes a remote vault and its history. Fails with ErrVaultShared or ErrNotVaultOwner when
+// the server refuses.
+func DeleteVault(token *crypto.Secret, vaultId string) error {
+	body, err := json.Marshal(map[string]string{
+		"token":     token.Reveal(),
+		"vault_uid": vaultId,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create vault delete request: %v", err)
+	}
+
+	// send request
+	resp, err := SendPostRequest("/vault/delete", body)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusForbidden {
+		return ErrNotVaultOwner
+	}
+	if err != nil {
+		return fmt.Errorf("could not send vault delete request: %v", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			Log().Warn("could not close vault delete response body", "err", err)
+		}
+	}(resp.Body)
+
+	var data struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("could not decode vault delete response: %v", err)
+	}
+	if data.Error != "" {
+		return deleteVaultError(data.Error)
+	}
+	return nil
+}
+
+// deleteVaultError maps the error message the delete endpoint returns to a typed error, keeping the server's wording
+func deleteVaultError(msg string) error {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "shared") || strings.Contains(lower, "collaborat"):
+		return fmt.Errorf("%w: %s", ErrVaultShared, msg)
+	case strings.Contains(lower, "not found") || strings.Contains(lower, "owner") || strings.Contains(lower, "permission"):
+		return fmt.Errorf("%w: %s", ErrNotVaultOwner, msg)
+	}
+	return fmt.Errorf("server returned error: %s", msg)
 }
@@ -2,13 +2,16 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/nbadal/obsidian-sync/crypto"
-	"math/rand"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -44,89 +47,157 @@ type PullHeaderMessage struct {
 	Pieces int    `json:"pieces"`
 }
 
+// ErrKeyMismatch is returned by SendInit when the server rejects our key hash, usually because the vault password
+// was changed on another device
+var ErrKeyMismatch = errors.New("vault key changed")
+
+// ErrReadOnly is returned when a read-only connection is asked to modify the vault
+var ErrReadOnly = errors.New("connection is read-only")
+
+// ErrEchoMismatch is returned when the server's echo of a push isn't the version we pushed, e.g. because responses got
+// crossed or corrupted. The push can't be trusted to have stored our content, reconnecting and pushing again may.
+var ErrEchoMismatch = errors.New("push echo doesn't match the push")
+
+// TransferFunc is called as file content is sent or received, with the bytes transferred so far and the total
+type TransferFunc func(transferred int64, total int64)
+
 type ObsidianSocketContext struct {
-	ws            *websocket.Conn
-	Vault         VaultInfo
-	authToken     string
-	keyhash       string
-	filteredQueue [][]byte
+	ws         *websocket.Conn
+	Vault      VaultInfo
+	OnTransfer TransferFunc       // Optional, called after each piece of a pull or push
+	Cipher     crypto.VaultCipher // Encrypts and decrypts vault data with the key derived for this connection
+	ReadOnly   bool               // Refuse to send anything that modifies the vault, for read-only credentials
+	DeviceName string             // Name other devices see for our changes, defaults to "obsidian-sync"
+	Timeouts   Timeouts           // Limits for init and transfers, defaults to DefaultTimeouts
+	QueueLimit int                // Most unread frames per route before the connection is closed, defaults to DefaultQueueLimit
+	authToken  *crypto.Secret
+	reader     *reader      // Reads the current websocket, see startReader
+	canceled   atomic.Bool  // Set by CancelTransfer
+	overflows  atomic.Int64 // Counted for QueueStats
+
+	// Deadlines of the operation in progress, see withDeadline
+	inOp         bool
+	opDeadline   deadline
+	stepDeadline deadline
+	lastTimeout  *TimeoutError
+}
+
+// ConnectToVault derives the vault key and connects. The password is only used to derive the key, the auth token is
+// kept for the connection's lifetime. Both remain owned by the caller.
+func ConnectToVault(vault VaultInfo, password *crypto.Secret, authToken *crypto.Secret) (*ObsidianSocketContext, error) {
+	return ConnectToVaultContext(context.Background(), vault, password, authToken)
 }
 
-func ConnectToVault(vault VaultInfo, password string, authToken string) (*ObsidianSocketContext, error) {
-	// Generate keyhash for vault
-	keyhash, err := crypto.KeyHash([]byte(password), []byte(vault.Salt))
+// ConnectToVaultContext is ConnectToVault, giving up on connecting when c is done
+func ConnectToVaultContext(c context.Context, vault VaultInfo, password *crypto.Secret, authToken *crypto.Secret) (*ObsidianSocketContext, error) {
+	// Derive the vault key once for this connection, failing early if we don't support the vault's encryption
+	cipher, err := crypto.NewVaultCipher(vault.EncryptionVersion, password, []byte(vault.Salt))
 	if err != nil {
-		return nil, fmt.Errorf("error generating keyhash: %s", err)
+		return nil, fmt.Errorf("error deriving vault key: %s", err)
 	}
+	return ConnectWithCipherContext(c, vault, cipher, authToken)
+}
+
+// ConnectWithCipher connects with a vault key that was already derived, e.g. by crypto.VerifyPassword
+func ConnectWithCipher(vault VaultInfo, cipher crypto.VaultCipher, authToken *crypto.Secret) (*ObsidianSocketContext, error) {
+	return ConnectWithCipherContext(context.Background(), vault, cipher, authToken)
+}
 
+// ConnectWithCipherContext is ConnectWithCipher, giving up on connecting when c is done
+func ConnectWithCipherContext(c context.Context, vault VaultInfo, cipher crypto.VaultCipher, authToken *crypto.Secret) (*ObsidianSocketContext, error) {
 	ctx := &ObsidianSocketContext{
-		Vault:         vault,
-		authToken:     authToken,
-		keyhash:       keyhash,
-		filteredQueue: [][]byte{},
+		Vault:     vault,
+		authToken: authToken,
+		Cipher:    cipher,
+		Timeouts:  DefaultTimeouts,
 	}
 
 	// Connect to websocket
-	err = ctx.connect(vault.Host)
-	if err != nil {
+	if err := ctx.connect(c, vault.Host); err != nil {
 		return nil, fmt.Errorf("error connecting to websocket: %s", err)
 	}
 
 	return ctx, nil
 }
 
+// Rekey derives the key for a new vault password, and reconnects so init can be retried with it. vault should be
+// freshly listed, since the salt may change along with the password.
+func (ctx *ObsidianSocketContext) Rekey(vault VaultInfo, password *crypto.Secret) error {
+	cipher, err := crypto.NewVaultCipher(vault.EncryptionVersion, password, []byte(vault.Salt))
+	if err != nil {
+		return fmt.Errorf("error deriving vault key: %s", err)
+	}
+
+	// The server may have hung up after rejecting our key
+	_ = ctx.closeConnection()
+	ctx.Vault = vault
+	ctx.Cipher = cipher
+	if err := ctx.connect(context.Background(), vault.Host); err != nil {
+		return fmt.Errorf("error connecting to websocket: %s", err)
+	}
+	return nil
+}
+
+// isKeyMismatch recognizes the server's rejection of a key hash that doesn't match the vault's password
+func isKeyMismatch(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "keyhash") || strings.Contains(msg, "key hash") ||
+		strings.Contains(msg, "password")
+}
+
+func (ctx *ObsidianSocketContext) deviceName() string {
+	if ctx.DeviceName == "" {
+		return "obsidian-sync"
+	}
+	return ctx.DeviceName
+}
+
 type InitResult struct {
 	RemoteUid   int64
 	PushedFiles []IncomingPushMessage
 }
 
-// SendInit sends the initial JSON message to the websocket
-func (ctx *ObsidianSocketContext) SendInit() (*InitResult, error) {
-	initialMsg := struct {
-		Op      string `json:"op"`
-		ID      string `json:"id"`
-		Token   string `json:"token"`
-		Keyhash string `json:"keyhash"`
-		Version int    `json:"version"`
-		Initial bool   `json:"initial"`
-		Device  string `json:"device"`
-	}{
-		Op:      "init",
-		ID:      ctx.Vault.Id,
-		Token:   ctx.authToken,
-		Keyhash: ctx.keyhash,
-		Version: 0,               // TODO: This should be the latest UID we're aware of
-		Initial: true,            // TODO: False if this isn't our first sync
-		Device:  "obsidian-sync", // TODO: Allow a device name override
-	}
-	if err := ctx.sendMessage(initialMsg); err != nil {
-		return nil, fmt.Errorf("could not send init message: %v", err)
-	}
+// SendInit sends the initial JSON message to the websocket. version is the latest UID we're aware of, and initial
+// should only be true if this is our first sync with the vault. Fails with ErrTimeout if the remote index takes longer
+// than the Init timeout.
+func (ctx *ObsidianSocketContext) SendInit(version int64, initial bool) (*InitResult, error) {
+	return ctx.SendInitContext(context.Background(), version, initial)
+}
 
-	// Next message should be an {res: ok}
-	response, err := ctx.nextMessageWithJsonValue("res", "ok")
-	if err != nil {
-		return nil, fmt.Errorf("error reading message: %v", err)
-	}
-	var data struct {
-		Res string `json:"res"`
-	}
-	if err := json.Unmarshal(response, &data); err != nil {
-		return nil, fmt.Errorf("error unmarshalling message: %v", err)
-	}
-	if data.Res != "ok" {
-		return nil, fmt.Errorf("expected ok message, got %s", data.Res)
+// SendInitContext is SendInit, giving up when c is done
+func (ctx *ObsidianSocketContext) SendInitContext(c context.Context, version int64, initial bool) (*InitResult, error) {
+	var result *InitResult
+	err := ctx.withContext(c, func() error {
+		return ctx.withDeadline("init", ctx.Timeouts.Init, func() error {
+			var err error
+			result, err = ctx.sendInit(version, initial)
+			return err
+		})
+	})
+	return result, err
+}
+
+// CheckKey sends init and returns once the server has accepted or rejected our key hash, without waiting for the remote
+// index. A wrong vault password fails with ErrKeyMismatch. The connection can't be used for anything else afterwards.
+func (ctx *ObsidianSocketContext) CheckKey() error {
+	return ctx.withDeadline("init", ctx.Timeouts.Init, func() error {
+		return ctx.sendInitMessage(0, false)
+	})
+}
+
+func (ctx *ObsidianSocketContext) sendInit(version int64, initial bool) (*InitResult, error) {
+	if err := ctx.sendInitMessage(version, initial); err != nil {
+		return nil, err
 	}
 
 	var pushedFiles []IncomingPushMessage
 	var remoteUid int64
 	for {
-		response, err := ctx.nextMessageMatchingJson(func(json map[string]interface{}) bool {
-			return json["op"] == "push" || json["op"] == "ready"
-		})
+		frame, err := ctx.nextOn(routePush, routeReady)
 		if err != nil {
 			return nil, fmt.Errorf("error reading message: %v", err)
 		}
+		response := frame.Data
 
 		var data map[string]interface{}
 		if err := json.Unmarshal(response, &data); err != nil {
@@ -160,6 +231,60 @@ func (ctx *ObsidianSocketContext) SendInit() (*InitResult, error) {
 	}, nil
 }
 
+// sendInitMessage sends init and reads the server's response to it, which accepts or rejects our key hash
+func (ctx *ObsidianSocketContext) sendInitMessage(version int64, initial bool) error {
+	initialMsg := struct {
+		Op                string `json:"op"`
+		ID                string `json:"id"`
+		Token             string `json:"token"`
+		Keyhash           string `json:"keyhash"`
+		Version           int64  `json:"version"`
+		Initial           bool   `json:"initial"`
+		Device            string `json:"device"`
+		EncryptionVersion int    `json:"encryption_version"`
+	}{
+		Op:                "init",
+		ID:                ctx.Vault.Id,
+		Token:             ctx.authToken.Reveal(),
+		Keyhash:           ctx.Cipher.KeyHash(),
+		Version:           version,
+		Initial:           initial,
+		Device:            ctx.deviceName(),
+		EncryptionVersion: ctx.Vault.EncryptionVersion,
+	}
+	if err := ctx.sendMessage(initialMsg); err != nil {
+		return fmt.Errorf("could not send init message: %v", err)
+	}
+
+	// Next message should be an {res: ok}, or an error if the server rejected us
+	frame, err := ctx.nextOn(routeResponse, routeError)
+	if err != nil {
+		return fmt.Errorf("error reading message: %v", err)
+	}
+	response := frame.Data
+	var data struct {
+		Res               string `json:"res"`
+		Msg               string `json:"msg"`
+		EncryptionVersion *int   `json:"encryption_version"`
+	}
+	if err := json.Unmarshal(response, &data); err != nil {
+		return fmt.Errorf("error unmarshalling message: %v", err)
+	}
+	if data.EncryptionVersion != nil && *data.EncryptionVersion > crypto.LatestEncryptionVersion {
+		return crypto.UnsupportedVersionError(*data.EncryptionVersion)
+	}
+	if data.Res != "ok" {
+		if isKeyMismatch(data.Msg) {
+			return fmt.Errorf("%w: %s", ErrKeyMismatch, data.Msg)
+		}
+		if data.Msg != "" {
+			return fmt.Errorf("server rejected init: %s", data.Msg)
+		}
+		return fmt.Errorf("expected ok message, got %s", data.Res)
+	}
+	return nil
+}
+
 // GetSizeConfig returns the size and limit of the vault
 func (ctx *ObsidianSocketContext) GetSizeConfig() (int64, int64, error) {
 	// send size op
@@ -172,10 +297,14 @@ func (ctx *ObsidianSocketContext) GetSizeConfig() (int64, int64, error) {
 	}
 
 	// Next message should be a size response
-	response, err := ctx.nextMessageWithJsonKeys("size", "limit")
+	frame, err := ctx.nextOn(routeSize, routeError)
 	if err != nil {
 		return 0, 0, fmt.Errorf("error reading size message: %v", err)
 	}
+	if frame.route == routeError {
+		return 0, 0, serverError(frame)
+	}
+	response := frame.Data
 
 	type SizeResponse struct {
 		Size  int64 `json:"size"`
@@ -189,9 +318,51 @@ func (ctx *ObsidianSocketContext) GetSizeConfig() (int64, int64, error) {
 	return sizeResponse.Size, sizeResponse.Limit, nil
 }
 
-// PullFile initiates a pull for a file, which should send a header and binary data
+// PullFile initiates a pull for a file, which should send a header and binary data, and returns the decrypted content
 // TODO: This should also support a deletion result
 func (ctx *ObsidianSocketContext) PullFile(uid int64, expectedEncryptedHash string) ([]byte, error) {
+	return ctx.PullFileContext(context.Background(), uid, expectedEncryptedHash)
+}
+
+// PullFileContext is PullFile, giving up when c is done
+func (ctx *ObsidianSocketContext) PullFileContext(c context.Context, uid int64, expectedEncryptedHash string) ([]byte, error) {
+	data, err := ctx.PullEncryptedContext(c, uid)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.DecryptContent(data, expectedEncryptedHash)
+}
+
+// PullEncrypted pulls a file's content as stored on the server, without decrypting it. Fails with ErrTimeout if the
+// transfer takes longer than the Transfer timeout.
+func (ctx *ObsidianSocketContext) PullEncrypted(uid int64) ([]byte, error) {
+	return ctx.PullEncryptedContext(context.Background(), uid)
+}
+
+// PullEncryptedContext is PullEncrypted, giving up when c is done
+func (ctx *ObsidianSocketContext) PullEncryptedContext(c context.Context, uid int64) ([]byte, error) {
+	var data []byte
+	err := ctx.withContext(c, func() error {
+		return ctx.withDeadline("pull", ctx.Timeouts.Transfer, func() error {
+			var err error
+			data, err = ctx.pullEncrypted(uid)
+			return err
+		})
+	})
+	return data, ctx.transferError(err)
+}
+
+func (ctx *ObsidianSocketContext) pullEncrypted(uid int64) ([]byte, error) {
+	var data []byte
+	err := ctx.pullPieces(uid, func(piece []byte) error {
+		data = append(data, piece...)
+		return nil
+	})
+	return data, err
+}
+
+// pullPieces pulls a file's encrypted content, passing each piece to onPiece as it arrives
+func (ctx *ObsidianSocketContext) pullPieces(uid int64, onPiece func(piece []byte) error) error {
 	// send a pull op for this UID
 	pullMsg := struct {
 		Op  string `json:"op"`
@@ -202,223 +373,371 @@ func (ctx *ObsidianSocketContext) PullFile(uid int64, expectedEncryptedHash stri
 	}
 
 	if err := ctx.sendMessage(pullMsg); err != nil {
-		return nil, fmt.Errorf("could not send pull message: %v", err)
+		return fmt.Errorf("could not send pull message: %v", err)
 	}
 
 	// Next message should be a header
-	header, err := ctx.nextMessageWithJsonKeys("hash", "size", "pieces")
+	done := ctx.expectWithin("pull header", ctx.Timeouts.PullHeader)
+	frame, err := ctx.nextOn(routeHeader, routeError)
+	done()
 	if err != nil {
-		return nil, fmt.Errorf("error reading header: %v", err)
+		return fmt.Errorf("error reading header: %v", err)
 	}
+	if frame.route == routeError {
+		return serverError(frame)
+	}
+	header := frame.Data
 
 	// Unmarshal pull header message
 	var headerMessage PullHeaderMessage
 	err = json.Unmarshal(header, &headerMessage)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal pull header message: %v", err)
+		return fmt.Errorf("could not unmarshal pull header message: %v", err)
 	}
 
-	fmt.Printf("ℹ️ Received header for %d\n", uid)
-	fmt.Printf("ℹ️ Size: %d\n", headerMessage.Size)
-	fmt.Printf("ℹ️ Pieces: %d\n", headerMessage.Pieces)
+	Log().Debug("ℹ️ Received header", "uid", uid, "size", headerMessage.Size, "pieces", headerMessage.Pieces)
 
-	var data []byte
-	// Intercept N websocket messages and append them to the session data
+	// Intercept N websocket messages and hand them on
+	var received int64
 	for i := 0; i < headerMessage.Pieces; i++ {
-		message, err := ctx.nextBinaryMessage()
+		done := ctx.expectWithin("piece", ctx.Timeouts.Piece)
+		piece, err := ctx.nextOn(routeBinary)
+		done()
 		if err != nil {
-			return nil, fmt.Errorf("error reading piece: %v", err)
+			return fmt.Errorf("error reading piece: %v", err)
 		}
-		data = append(data, message...)
+		if err := onPiece(piece.Data); err != nil {
+			// The rest of the pieces would be taken for the next pull's
+			_ = ctx.closeConnection()
+			return err
+		}
+		received += int64(len(piece.Data))
+		ctx.reportTransfer(received, headerMessage.Size)
 	}
 
 	// Ensure that our byte count matches the size
-	if int64(len(data)) != headerMessage.Size {
-		return nil, fmt.Errorf("decrypted data size does not match size in header")
+	if received != headerMessage.Size {
+		return fmt.Errorf("data size does not match size in header")
 	}
 
+	return nil
+}
+
+// DecryptContent decrypts pulled content and checks it against the entry's encrypted hash
+func (ctx *ObsidianSocketContext) DecryptContent(data []byte, expectedEncryptedHash string) ([]byte, error) {
 	// Decrypt the decryptedData
-	decryptedData, err := crypto.Decrypt(data, []byte(ctx.Vault.Password), []byte(ctx.Vault.Salt))
+	decryptedData, err := ctx.Cipher.Decrypt(data)
 	if err != nil {
 		return nil, fmt.Errorf("could not decrypt data: %v", err)
 	}
 
 	//Ensure the SHA-256 encryptedHash matches
 	contentSum := sha256.Sum256(decryptedData)
+	if err := ctx.checkContentHash(contentSum[:], expectedEncryptedHash); err != nil {
+		return nil, err
+	}
+
+	return decryptedData, nil
+}
 
+// checkContentHash checks the SHA-256 of decrypted content against an entry's encrypted hash
+func (ctx *ObsidianSocketContext) checkContentHash(contentSum []byte, expectedEncryptedHash string) error {
 	// Decrypt the expected hash
-	decryptedExpectedHash, err := crypto.DecryptString(expectedEncryptedHash, []byte(ctx.Vault.Password), []byte(ctx.Vault.Salt))
+	decryptedExpectedHash, err := ctx.Cipher.DecryptString(expectedEncryptedHash)
 	if err != nil {
-		return nil, fmt.Errorf("could not decrypt expected hash: %v", err)
+		return fmt.Errorf("could not decrypt expected hash: %v", err)
 	}
 
 	// Decode the expected hash
 	expectedHashBytes, err := hex.DecodeString(decryptedExpectedHash)
 	if err != nil {
-		return nil, fmt.Errorf("could not decode expected hash: %v", err)
+		return fmt.Errorf("could not decode expected hash: %v", err)
 	}
 
-	if !bytes.Equal(contentSum[:], expectedHashBytes) {
-		return nil, fmt.Errorf("decrypted content hash does not match expected hash")
+	if !bytes.Equal(contentSum, expectedHashBytes) {
+		return fmt.Errorf("decrypted content hash does not match expected hash")
 	}
+	return nil
+}
 
-	return decryptedData, nil
+// PushFile uploads a file, folder or deletion, returning the server's echo of the pushed entry. extension should be
+// derived from the path with Extension.
+func (ctx *ObsidianSocketContext) PushFile(path string, extension string, ctime int64, mtime int64, folder bool, deleted bool, content []byte) (*IncomingPushMessage, error) {
+	return ctx.PushFileContext(context.Background(), path, extension, ctime, mtime, folder, deleted, content)
+}
+
+// PushFileContext is PushFile, giving up when c is done
+func (ctx *ObsidianSocketContext) PushFileContext(c context.Context, path string, extension string, ctime int64, mtime int64, folder bool, deleted bool, content []byte) (*IncomingPushMessage, error) {
+	if ctx.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	var pushResponse *IncomingPushMessage
+	err := ctx.withContext(c, func() error {
+		return ctx.withDeadline("push", ctx.Timeouts.Transfer, func() error {
+			pending, err := ctx.sendPush(path, extension, ctime, mtime, folder, deleted, content)
+			if err != nil {
+				return err
+			}
+			done := ctx.expectWithin("push ack", ctx.Timeouts.PushAck)
+			defer done()
+			pushResponse, err = pending.await(ctx.next)
+			return err
+		})
+	})
+	return pushResponse, ctx.transferError(err)
+}
+
+// pushPieceSize is the most encrypted content pushes send in one binary message
+const pushPieceSize = 2 * 1024 * 1024
+
+// pieceCount returns how many pieces a push of encrypted content of the given size is sent in, at least one
+func pieceCount(size int) int {
+	if size == 0 {
+		return 1
+	}
+	return (size + pushPieceSize - 1) / pushPieceSize
 }
 
-func (ctx *ObsidianSocketContext) PushFile(path string, extension string, ctime int64, mtime int64, folder bool, deleted bool, content []byte) error {
+// awaitNext reads the {"res": "next"} the server sends when it's ready for the next piece of a push
+func (ctx *ObsidianSocketContext) awaitNext() error {
+	frame, err := ctx.next(func(f *Frame) bool {
+		return f.route == routeError || f.Res == "next"
+	}, routeResponse, routeError)
+	if err != nil {
+		return fmt.Errorf("error reading next response: %v", err)
+	}
+	if frame.route == routeError {
+		return serverError(frame)
+	}
+	var nextResponse struct {
+		Res string `json:"res"`
+	}
+	if err := json.Unmarshal(frame.Data, &nextResponse); err != nil {
+		return fmt.Errorf("could not unmarshal next response: %v", err)
+	}
+	if nextResponse.Res != "next" {
+		return fmt.Errorf("next response is not 'next'")
+	}
+	return nil
+}
+
+// sendPush sends a push message and the content the server asks for, returning the push waiting for its
+// acknowledgment
+func (ctx *ObsidianSocketContext) sendPush(path string, extension string, ctime int64, mtime int64, folder bool, deleted bool, content []byte) (*PendingPush, error) {
+
+	// Other devices will open text files as text, so warn about content they can't display
+	if !folder && !deleted && !hasValidText(path, content) {
+		Log().Warn("⚠️ File has a text extension but is not valid UTF-8", "path", path)
+	}
+
 	// Encrypt the content
-	encryptedContent, err := crypto.Encrypt(content, []byte(ctx.Vault.Password), []byte(ctx.Vault.Salt))
+	encryptedContent, err := ctx.Cipher.Encrypt(content)
 	if err != nil {
-		return fmt.Errorf("could not encrypt content: %v", err)
+		return nil, fmt.Errorf("could not encrypt content: %v", err)
 	}
 
 	// Encrypt the path
-	encryptedPath, err := crypto.Encrypt([]byte(path), []byte(ctx.Vault.Password), []byte(ctx.Vault.Salt))
+	encryptedPath, err := ctx.Cipher.EncryptString(path)
 	if err != nil {
-		return fmt.Errorf("could not encrypt path: %v", err)
+		return nil, fmt.Errorf("could not encrypt path: %v", err)
 	}
 
 	// Calculate the SHA-256 encryptedHash of the encrypted content
 	contentSum := sha256.Sum256(content)
 
-	// Encrypt the content sum
-	encryptedContentSum, err := crypto.Encrypt(contentSum[:], []byte(ctx.Vault.Password), []byte(ctx.Vault.Salt))
+	// Encrypt the hex content sum, which is what PullFile expects to decrypt
+	encryptedContentSum, err := ctx.Cipher.EncryptString(hex.EncodeToString(contentSum[:]))
 	if err != nil {
-		return fmt.Errorf("could not encrypt content sum: %v", err)
+		return nil, fmt.Errorf("could not encrypt content sum: %v", err)
 	}
 
 	message := &OutgoingPushMessage{
 		Op:      "push",
-		Path:    hex.EncodeToString(encryptedPath),
+		Path:    encryptedPath,
 		Ext:     extension,
-		Hash:    hex.EncodeToString(encryptedContentSum),
+		Hash:    encryptedContentSum,
 		Ctime:   ctime,
 		Mtime:   mtime,
 		Folder:  folder,
 		Deleted: deleted,
 		Size:    int64(len(encryptedContent)),
-		Pieces:  1, // TODO: 2MB chunks
+		Pieces:  pieceCount(len(encryptedContent)),
 	}
 
 	if err := ctx.sendMessage(message); err != nil {
-		return fmt.Errorf("could not send push message: %v", err)
-	}
-
-	// Next message should be a {"res": "next"}
-	response, err := ctx.nextMessageWithJsonValue("res", "next")
-	if err != nil {
-		return fmt.Errorf("error reading next response: %v", err)
-	}
-	var nextResponse struct {
-		Res string `json:"res"`
-	}
-	if err := json.Unmarshal(response, &nextResponse); err != nil {
-		return fmt.Errorf("could not unmarshal next response: %v", err)
-	}
-	if nextResponse.Res != "next" {
-		return fmt.Errorf("next response is not 'next'")
+		return nil, fmt.Errorf("could not send push message: %v", err)
 	}
 
-	// send the encrypted content
-	if err := ctx.sendBinary(encryptedContent); err != nil {
-		return fmt.Errorf("could not send encrypted content: %v", err)
+	// The server asks for each piece of the encrypted content with a {"res": "next"}
+	total := int64(len(encryptedContent))
+	for i := 0; i < message.Pieces; i++ {
+		if err := ctx.awaitNext(); err != nil {
+			return nil, err
+		}
+		end := (i + 1) * pushPieceSize
+		if end > len(encryptedContent) {
+			end = len(encryptedContent)
+		}
+		if err := ctx.sendBinary(encryptedContent[i*pushPieceSize : end]); err != nil {
+			return nil, fmt.Errorf("could not send encrypted content: %v", err)
+		}
+		ctx.reportTransfer(int64(end), total)
 	}
 
-	// TODO: Loop this next+binary pair for each 2MB chunk of the file
+	return &PendingPush{
+		Path:     path,
+		message:  message,
+		conn:     ctx.ws,
+		frames:   ctx.reader.frames,
+		deadline: ctx.opDeadline,
+		timeouts: ctx.Timeouts,
+	}, nil
+}
 
-	// Next message should be an incoming push
-	response, err = ctx.nextMessageWithJsonValue("op", "push")
+// await reads the server's acknowledgment of the push with next, and checks its echo
+func (p *PendingPush) await(next func(match func(*Frame) bool, routes ...route) (*Frame, error)) (*IncomingPushMessage, error) {
+	// Next message should be an incoming push, acknowledging ours, followed by an ok. Pushes from other devices for
+	// other paths, and echoes of other pushes in flight, stay queued.
+	var pushResponse IncomingPushMessage
+	frame, err := next(func(f *Frame) bool {
+		var push IncomingPushMessage
+		return f.Decode(&push) == nil && push.EncryptedPath == p.message.Path
+	}, routePush)
 	if err != nil {
-		return fmt.Errorf("error reading push response: %v", err)
+		return nil, fmt.Errorf("error reading push response: %w", err)
 	}
-	var pushResponse IncomingPushMessage
-	if err := json.Unmarshal(response, &pushResponse); err != nil {
-		return fmt.Errorf("could not unmarshal push response: %v", err)
+	if err := json.Unmarshal(frame.Data, &pushResponse); err != nil {
+		return nil, fmt.Errorf("could not unmarshal push response: %v", err)
 	}
-	// TODO: Make sure the incoming push response matches the outgoing push message
 
 	// Next message should be an {"op": "ok"}
-	response, err = ctx.nextMessageWithJsonValue("op", "ok")
+	frame, err = next(nil, routeOk)
 	if err != nil {
-		return fmt.Errorf("error reading ok response: %v", err)
+		return nil, fmt.Errorf("error reading ok response: %w", err)
 	}
+	response := frame.Data
 	var okResponse struct {
 		Op string `json:"op"`
 	}
 	if err := json.Unmarshal(response, &okResponse); err != nil {
-		return fmt.Errorf("could not unmarshal ok response: %v", err)
+		return nil, fmt.Errorf("could not unmarshal ok response: %v", err)
 	}
 	if okResponse.Op != "ok" {
-		return fmt.Errorf("ok response is not 'ok'")
+		return nil, fmt.Errorf("ok response is not 'ok'")
 	}
 
+	if err := checkEcho(p.message, &pushResponse); err != nil {
+		return nil, err
+	}
+	return &pushResponse, nil
+}
+
+// checkEcho makes sure the server's echo of a push acknowledges the version we sent, with a new UID. Folders and
+// deletions have no content, so only files are checked for their hash.
+func checkEcho(message *OutgoingPushMessage, echo *IncomingPushMessage) error {
+	switch {
+	case echo.EncryptedPath != message.Path:
+		return fmt.Errorf("%w: echoed a different path", ErrEchoMismatch)
+	case echo.Uid <= 0:
+		return fmt.Errorf("%w: echoed UID %d", ErrEchoMismatch, echo.Uid)
+	case echo.Folder != message.Folder || echo.Deleted != message.Deleted:
+		return fmt.Errorf("%w: echoed folder %t and deleted %t, pushed %t and %t", ErrEchoMismatch, echo.Folder, echo.Deleted, message.Folder, message.Deleted)
+	case !message.Folder && !message.Deleted && echo.EncryptedHash != message.Hash:
+		return fmt.Errorf("%w: echoed a different hash", ErrEchoMismatch)
+	}
 	return nil
 }
 
+// reportTransfer calls the transfer callback, if set
+func (ctx *ObsidianSocketContext) reportTransfer(transferred int64, total int64) {
+	if ctx.OnTransfer != nil {
+		ctx.OnTransfer(transferred, total)
+	}
+}
+
+// WaitForPushMessage waits for another device to push a change, pinging the server to keep the connection alive
 func (ctx *ObsidianSocketContext) WaitForPushMessage() (*IncomingPushMessage, error) {
-	// Send ping message every 20-30s until we get a push message then return
-	resultChan := make(chan *IncomingPushMessage)
-	errorChan := make(chan error)
-	doneChan := make(chan bool)
-
-	// Start pinging until we get a push message, then signal that we're done
-	var unansweredPingCount = 0
-	go func() {
+	return ctx.WaitForPushMessageContext(context.Background())
+}
+
+// WaitForPushMessageContext is WaitForPushMessage, giving up when c is done
+func (ctx *ObsidianSocketContext) WaitForPushMessageContext(c context.Context) (*IncomingPushMessage, error) {
+	var result *IncomingPushMessage
+	err := ctx.withContext(c, func() error {
+		var err error
+		result, err = ctx.waitForPushMessage()
+		return err
+	})
+	return result, err
+}
+
+// WaitForPushMessageWithin is WaitForPushMessageContext, but returns a nil message once d passed without one. Unlike
+// giving up when c is done, that leaves the connection open, so callers can check on something else every d and wait
+// again. Waits as long as WaitForPushMessageContext if d isn't positive.
+func (ctx *ObsidianSocketContext) WaitForPushMessageWithin(c context.Context, d time.Duration) (*IncomingPushMessage, error) {
+	if d <= 0 {
+		return ctx.WaitForPushMessageContext(c)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	var result *IncomingPushMessage
+	err := ctx.withContext(c, func() error {
 		for {
-			message, err := ctx.nextMessageMatchingJson(func(json map[string]interface{}) bool {
-				return json["op"] == "push" || json["op"] == "pong"
-			})
+			frame, arrived, err := ctx.reader.frames.take([]route{routePush}, nil)
 			if err != nil {
-				errorChan <- fmt.Errorf("error reading message: %v", err)
+				return fmt.Errorf("error reading message: %w", err)
 			}
-			var data map[string]interface{}
-			if err := json.Unmarshal(message, &data); err != nil {
-				errorChan <- fmt.Errorf("could not unmarshal message: %v", err)
+			if frame != nil {
+				traceFrame("⏪", frame.Data)
+				result, err = decodePushMessage(frame)
+				return err
 			}
-			if data["op"] == "push" {
-				var pushMessage IncomingPushMessage
-				if err := json.Unmarshal(message, &pushMessage); err != nil {
-					errorChan <- fmt.Errorf("could not unmarshal push message: %v", err)
-				}
-				resultChan <- &pushMessage
-
-				// Stop listening if we've got a push and no unanswered pings
-				if unansweredPingCount == 0 {
-					doneChan <- true
-					return
-				}
-			} else if data["op"] == "pong" {
-				unansweredPingCount--
-			} else {
-				errorChan <- fmt.Errorf("unexpected message: %v", string(message))
-			}
-		}
-	}()
-
-	// Ping until done
-	go func() {
-		for {
-			secs := rand.Intn(10) + 20
 			select {
-			case <-time.After(time.Duration(secs) * time.Second):
-				if err := ctx.sendMessage(struct {
-					Op string `json:"op"`
-				}{
-					Op: "ping",
-				}); err != nil {
-					errorChan <- fmt.Errorf("could not send ping message: %v", err)
-				}
-				unansweredPingCount++
-			case <-doneChan:
-				return
+			case <-arrived:
+			case <-timer.C:
+				return nil
 			}
 		}
-	}()
+	})
+	return result, err
+}
 
-	select {
-	case result := <-resultChan:
-		return result, nil
-	case err := <-errorChan:
+func (ctx *ObsidianSocketContext) waitForPushMessage() (*IncomingPushMessage, error) {
+	// Keepalive pings are control frames answered by the read loop, so this only waits for the push
+	frame, err := ctx.nextOn(routePush)
+	if err != nil {
 		return nil, err
 	}
+	return decodePushMessage(frame)
+}
+
+// decodePushMessage decodes a frame on the push route
+func decodePushMessage(frame *Frame) (*IncomingPushMessage, error) {
+	var pushMessage IncomingPushMessage
+	if err := json.Unmarshal(frame.Data, &pushMessage); err != nil {
+		return nil, fmt.Errorf("could not unmarshal push message: %v", err)
+	}
+	return &pushMessage, nil
+}
+
+// PendingPushMessages returns the push messages that arrived since they were last read, without waiting for more.
+// Fails like WaitForPushMessage if the connection was lost, once the messages that arrived before are read.
+func (ctx *ObsidianSocketContext) PendingPushMessages() ([]*IncomingPushMessage, error) {
+	var pushes []*IncomingPushMessage
+	for {
+		frame, _, err := ctx.reader.frames.take([]route{routePush}, nil)
+		if err != nil {
+			return pushes, fmt.Errorf("error reading message: %w", err)
+		}
+		if frame == nil {
+			return pushes, nil
+		}
+		traceFrame("⏪", frame.Data)
+		pushMessage, err := decodePushMessage(frame)
+		if err != nil {
+			return pushes, err
+		}
+		pushes = append(pushes, pushMessage)
+	}
 }
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// redactedKeys are the fields of JSON frames that are never written to a trace
+var redactedKeys = []string{"token", "keyhash"}
+
+// TraceEntry is one websocket frame in a session trace
+type TraceEntry struct {
+	Time     time.Time       `json:"time"`
+	Outbound bool            `json:"out"`
+	Size     int             `json:"size"`
+	Frame    json.RawMessage `json:"frame,omitempty"` // The redacted JSON frame, absent for binary frames
+}
+
+// Binary returns true if the entry is a binary frame, whose content isn't traced
+func (e TraceEntry) Binary() bool {
+	return e.Frame == nil
+}
+
+// SessionTrace records every frame of every connection to a file, with secrets redacted
+type SessionTrace struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// sessionTrace is the trace set with SetSessionTrace, if any
+var sessionTrace *SessionTrace
+
+// OpenSessionTrace opens a trace file for appending, creating it if needed
+func OpenSessionTrace(path string) (*SessionTrace, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open websocket trace: %v", err)
+	}
+	return &SessionTrace{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// SetSessionTrace records the frames of all connections to t from now on, nil stops recording
+func SetSessionTrace(t *SessionTrace) {
+	sessionTrace = t
+}
+
+// Close closes the trace file
+func (t *SessionTrace) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Close()
+}
+
+// record appends a frame to the trace. Frames that can't be written are dropped, a trace must never break a sync.
+func (t *SessionTrace) record(outbound bool, data []byte) {
+	entry := TraceEntry{Time: time.Now(), Outbound: outbound, Size: len(data)}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err == nil {
+		for _, key := range redactedKeys {
+			if _, ok := fields[key]; ok {
+				fields[key] = json.RawMessage(`"[redacted]"`)
+			}
+		}
+		entry.Frame, _ = json.Marshal(fields)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_ = t.enc.Encode(entry)
+}
+
+// recordFrame adds a frame to the session trace, if one is set
+func recordFrame(outbound bool, data []byte) {
+	if sessionTrace != nil {
+		sessionTrace.record(outbound, data)
+	}
+}
+
+// ReadSessionTrace calls fn with each entry of a trace, in order
+func ReadSessionTrace(r io.Reader, fn func(TraceEntry) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var entry TraceEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read trace entry: %v", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
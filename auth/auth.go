@@ -2,48 +2,150 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
 	"io"
+	"net/http"
+	"strings"
 )
 
-func Login(email, password string) (string, error) {
+var (
+	// ErrInvalidCredentials is returned when the email or password is wrong
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrMFARequired is returned when the account has two-factor authentication and the TOTP code is missing or wrong
+	ErrMFARequired = errors.New("two-factor authentication code required")
+	// ErrRateLimited is returned after too many sign in attempts
+	ErrRateLimited = errors.New("too many sign in attempts, try again later")
+	// ErrNotLoggedIn is returned by LoadSession when no token is stored
+	ErrNotLoggedIn = errors.New("not logged in, run login first")
+)
+
+// Session is a signed in account. Wipe it once it's no longer needed.
+type Session struct {
+	Token *crypto.Secret
+	Scope Scope
+}
+
+// Wipe clears the session's token
+func (s *Session) Wipe() {
+	s.Token.Wipe()
+}
+
+// Login signs in with email and password, plus a TOTP code for accounts with two-factor authentication, and returns a
+// session with full scope. Fails with ErrInvalidCredentials, ErrMFARequired or ErrRateLimited when the server refuses
+// the sign in. The password remains owned by the caller.
+func Login(email string, password *crypto.Secret, mfa string) (*Session, error) {
 	// Create request body
-	reqBody := []byte(fmt.Sprintf(`{"email":"%s","password":"%s"}`, email, password))
+	reqBody, err := json.Marshal(map[string]string{
+		"email":    email,
+		"password": password.Reveal(),
+		"mfa":      mfa,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create login request: %v", err)
+	}
 
 	// send request
 	resp, err := api.SendPostRequest("/user/signin", reqBody)
+	var statusErr *api.StatusError
+	if errors.As(err, &statusErr) {
+		return nil, statusError(statusErr)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("could not read response body: %v", err)
+		return nil, fmt.Errorf("could not read response body: %v", err)
 	}
 
 	// Parse response body
-	var data map[string]interface{}
+	var data struct {
+		Token string `json:"token"`
+		Error string `json:"error"`
+	}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return "", fmt.Errorf("could not parse response body: %v", err)
+		return nil, fmt.Errorf("could not parse response body: %v", err)
 	}
 
 	// Check for error
-	if data["error"] != nil {
-		return "", fmt.Errorf("error logging in: %s", data["error"])
+	if data.Error != "" {
+		return nil, signinError(data.Error)
 	}
-
-	// Get token
-	token := data["token"].(string)
-	if token == "" {
-		return "", fmt.Errorf("token not found in response")
+	if data.Token == "" {
+		return nil, fmt.Errorf("token not found in response")
 	}
 
-	return token, nil
+	return &Session{Token: crypto.SecretString(data.Token), Scope: ScopeFull}, nil
 }
-func StoreToken(token string) error {
-	fmt.Printf("TODO: Store token %s\n", token)
+
+// Signout invalidates the session's token on the server, so it can't be used again even if a copy was kept. A token
+// the server no longer accepts counts as signed out.
+func (s *Session) Signout() error {
+	reqBody, err := json.Marshal(map[string]string{
+		"token": s.Token.Reveal(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not create signout request: %v", err)
+	}
+
+	resp, err := api.SendPostRequest("/user/signout", reqBody)
+	var statusErr *api.StatusError
+	if errors.As(err, &statusErr) && (statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Check for error
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response body: %v", err)
+	}
+	var data struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &data) == nil && data.Error != "" {
+		return fmt.Errorf("error signing out: %s", data.Error)
+	}
 	return nil
 }
+
+// NormalizeTOTP removes the spaces and dashes authenticator apps show in codes, e.g. "123 456"
+func NormalizeTOTP(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+}
+
+// statusError maps an HTTP status from the sign in endpoint to a typed error
+func statusError(err *api.StatusError) error {
+	switch err.Code {
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// signinError maps the error message the sign in endpoint returns to a typed error, keeping the server's wording
+func signinError(msg string) error {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "2fa") || strings.Contains(lower, "mfa") ||
+		strings.Contains(lower, "two-factor") || strings.Contains(lower, "authenticator"):
+		return fmt.Errorf("%w: %s", ErrMFARequired, msg)
+	case strings.Contains(lower, "too many") || strings.Contains(lower, "rate limit"):
+		return fmt.Errorf("%w: %s", ErrRateLimited, msg)
+	case strings.Contains(lower, "login failed") || strings.Contains(lower, "password") || strings.Contains(lower, "email"):
+		return fmt.Errorf("%w: %s", ErrInvalidCredentials, msg)
+	}
+	return fmt.Errorf("error logging in: %s", msg)
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/nbadal/obsidian-sync/config"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// Scope limits what a stored credential may be used for. The API has no scoped tokens, so scopes are enforced
+// client-side by the connection.
+type Scope string
+
+const (
+	ScopeFull     Scope = ""
+	ScopeReadOnly Scope = "read-only"
+)
+
+// ParseScope validates a scope name from the command line
+func ParseScope(name string) (Scope, error) {
+	switch Scope(name) {
+	case ScopeFull, "full":
+		return ScopeFull, nil
+	case ScopeReadOnly:
+		return ScopeReadOnly, nil
+	}
+	return ScopeFull, fmt.Errorf("unknown scope %q, expected \"full\" or %q", name, ScopeReadOnly)
+}
+
+// Save stores the session's token and scope in the encrypted config
+func (s *Session) Save() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg.AuthToken = s.Token.Reveal()
+	cfg.TokenScope = string(s.Scope)
+	return cfg.Save()
+}
+
+// LoadSession returns the stored session, or ErrNotLoggedIn if there is none
+func LoadSession() (*Session, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AuthToken == "" {
+		return nil, ErrNotLoggedIn
+	}
+	return &Session{Token: crypto.SecretString(cfg.AuthToken), Scope: Scope(cfg.TokenScope)}, nil
+}
+
+// Logout removes the stored session. Call Session.Signout first to invalidate the token on the server.
+func Logout() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg.AuthToken = ""
+	cfg.TokenScope = ""
+	return cfg.Save()
+}
+
+// StoreVaultPassword saves a vault's encryption password in the encrypted config
+func StoreVaultPassword(vaultId string, password *crypto.Secret) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if cfg.VaultPasswords == nil {
+		cfg.VaultPasswords = map[string]string{}
+	}
+	cfg.VaultPasswords[vaultId] = password.Reveal()
+	return cfg.Save()
+}
+
+// LoadVaultPassword returns the stored password for a vault, or an empty secret if none is stored
+func LoadVaultPassword(vaultId string) (*crypto.Secret, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.SecretString(cfg.VaultPasswords[vaultId]), nil
+}
+
+// ForgetVaultPasswords removes every stored vault password
+func ForgetVaultPasswords() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg.VaultPasswords = nil
+	return cfg.Save()
+}
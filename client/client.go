@@ -0,0 +1,86 @@
+// Package client embeds Obsidian Sync in other Go programs, without the CLI. A Client lists the vaults of an account,
+// and connects to one as a Vault to list, pull, push, delete and watch its files:
+//
+//	c := client.New(token)
+//	vault, err := c.Connect(ctx, vaultId, password)
+//	if err != nil {
+//		return err
+//	}
+//	defer vault.Close()
+//	content, err := vault.Pull(ctx, "Daily Notes/2024-01-01.md")
+//
+// Every call takes a context, and gives up when it's done. The api and sync packages log to stdout by default, pass a
+// Logger to SetLogger, or nil to discard the logs. To keep a whole folder in sync, use sync.SyncContext.
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// VaultInfo describes a vault the account can access
+type VaultInfo = api.VaultInfo
+
+// Logger receives log lines, see SetLogger
+type Logger = api.Logger
+
+// SetLogger replaces the logger of the library, nil discards all log lines
+func SetLogger(l Logger) {
+	api.SetLogger(l)
+}
+
+// Client accesses the vaults of an account with its auth token
+type Client struct {
+	// DeviceName is the name other devices see for changes pushed by this client, "obsidian-sync" if empty
+	DeviceName string
+
+	token *crypto.Secret
+}
+
+// New returns a client using an auth token, e.g. one stored by the CLI's login
+func New(token string) *Client {
+	return &Client{token: crypto.SecretString(token)}
+}
+
+// ListVaults lists the vaults the account owns or was shared
+func (c *Client) ListVaults(ctx context.Context) ([]VaultInfo, error) {
+	return api.ListVaultsContext(ctx, c.token)
+}
+
+// Connect connects to a vault and receives its file index. The password is the vault's encryption password, not the
+// account's. The vault should be closed when done.
+func (c *Client) Connect(ctx context.Context, vaultId string, password string) (*Vault, error) {
+	vaults, err := c.ListVaults(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range vaults {
+		if info.Id == vaultId {
+			return c.connect(ctx, info, password)
+		}
+	}
+	return nil, fmt.Errorf("vault %s not found", vaultId)
+}
+
+// connect connects to a listed vault
+func (c *Client) connect(ctx context.Context, info VaultInfo, password string) (*Vault, error) {
+	secret := crypto.SecretString(password)
+	defer secret.Wipe()
+	conn, err := api.ConnectToVaultContext(ctx, info, secret, c.token)
+	if err != nil {
+		return nil, err
+	}
+	conn.DeviceName = c.DeviceName
+
+	vault := &Vault{Info: info, conn: conn, files: make(map[string]File)}
+	initResult, err := conn.SendInitContext(ctx, 0, true)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error receiving the file index: %s", err)
+	}
+	vault.apply(initResult)
+	return vault, nil
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// refreshBackoff is how Refresh reconnects a lost connection
+var refreshBackoff = api.BackoffPolicy{Initial: time.Second, Max: time.Second, MaxAttempts: 1}
+
+// File is a file or folder in a vault
+type File struct {
+	Path     string
+	Folder   bool
+	Size     int64 // Bytes stored, which are encrypted
+	Created  time.Time
+	Modified time.Time
+	Device   string // Name of the device that last changed it
+
+	uid  int64
+	hash string
+}
+
+// Change is a file changed in a vault, see Vault.Watch
+type Change struct {
+	File
+	Deleted bool
+}
+
+// Vault is a connection to a vault, see Client.Connect. It carries one operation at a time, so it isn't safe to use
+// from several goroutines at once.
+type Vault struct {
+	Info VaultInfo
+
+	conn      *api.ObsidianSocketContext
+	files     map[string]File // By path
+	remoteUid int64           // Latest change received, to resume from after reconnecting
+}
+
+// Files lists the files and folders in the vault, as of the last change received, sorted by path
+func (v *Vault) Files() []File {
+	files := make([]File, 0, len(v.files))
+	for _, file := range v.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// Stat returns the file or folder at path, and false if the vault has none
+func (v *Vault) Stat(path string) (File, bool) {
+	file, ok := v.files[path]
+	return file, ok
+}
+
+// Pull downloads and decrypts the current content of the file at path
+func (v *Vault) Pull(ctx context.Context, path string) ([]byte, error) {
+	file, ok := v.files[path]
+	if !ok || file.Folder {
+		return nil, fmt.Errorf("no file %s in the vault", path)
+	}
+	return v.conn.PullFileContext(ctx, file.uid, file.hash)
+}
+
+// PullTo downloads and decrypts the current content of the file at path into w, a piece at a time. w receives content
+// before it's verified, so discard what it received if an error is returned.
+func (v *Vault) PullTo(ctx context.Context, path string, w io.Writer) error {
+	file, ok := v.files[path]
+	if !ok || file.Folder {
+		return fmt.Errorf("no file %s in the vault", path)
+	}
+	_, err := v.conn.PullFileToContext(ctx, file.uid, file.hash, w)
+	return err
+}
+
+// Push encrypts and uploads content as the file at path, creating it or replacing its content
+func (v *Vault) Push(ctx context.Context, path string, content []byte, modified time.Time) error {
+	created := v.created(path, modified)
+	echo, err := v.conn.PushFileContext(ctx, path, api.Extension(path), created.UnixMilli(), modified.UnixMilli(), false, false, content)
+	if err != nil {
+		return err
+	}
+	v.pushed(path, created, modified, echo)
+	return nil
+}
+
+// PushFrom is Push for the size bytes of content read from r, which are encrypted and uploaded a piece at a time, see
+// api.ObsidianSocketContext.PushFileFrom
+func (v *Vault) PushFrom(ctx context.Context, path string, r io.Reader, size int64, modified time.Time) error {
+	created := v.created(path, modified)
+	echo, err := v.conn.PushFileFromContext(ctx, path, api.Extension(path), created.UnixMilli(), modified.UnixMilli(), r, size)
+	if err != nil {
+		return err
+	}
+	v.pushed(path, created, modified, echo)
+	return nil
+}
+
+// created returns the creation time of the file at path, or modified for a new file
+func (v *Vault) created(path string, modified time.Time) time.Time {
+	if file, ok := v.files[path]; ok {
+		return file.Created
+	}
+	return modified
+}
+
+// pushed updates the index with a file we pushed
+func (v *Vault) pushed(path string, created time.Time, modified time.Time, echo *api.IncomingPushMessage) {
+	if echo.Uid > v.remoteUid {
+		v.remoteUid = echo.Uid
+	}
+	v.files[path] = File{
+		Path:     path,
+		Size:     echo.Size,
+		Created:  created,
+		Modified: modified,
+		Device:   v.conn.DeviceName,
+		uid:      echo.Uid,
+		hash:     echo.EncryptedHash,
+	}
+}
+
+// Delete deletes the file or folder at path
+func (v *Vault) Delete(ctx context.Context, path string) error {
+	file, ok := v.files[path]
+	if !ok {
+		return fmt.Errorf("no file %s in the vault", path)
+	}
+	now := time.Now().UnixMilli()
+	echo, err := v.conn.PushFileContext(ctx, path, api.Extension(path), now, now, file.Folder, true, nil)
+	if err != nil {
+		return err
+	}
+	if echo.Uid > v.remoteUid {
+		v.remoteUid = echo.Uid
+	}
+	delete(v.files, path)
+	return nil
+}
+
+// Watch calls onChange for each change made to the vault by other devices, until ctx is done or onChange fails.
+// Lost connections are reconnected, receiving the changes made in the meantime. Returns nil once ctx is done.
+func (v *Vault) Watch(ctx context.Context, onChange func(Change) error) error {
+	for {
+		push, err := v.conn.WaitForPushMessageContext(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			api.Log().Warn("⚠️ Connection lost", "err", err)
+			changes, err := v.reconnect(ctx, api.DefaultBackoff)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for _, change := range changes {
+				if err := onChange(change); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if change, ok := v.update(push); ok {
+			if err := onChange(change); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Refresh applies the changes made to the vault by other devices since they were last received, without waiting for
+// more, and returns them. An alternative to Watch for programs that can't dedicate a goroutine to the vault. A lost
+// connection gets a single attempt to reconnect, so Refresh doesn't block while offline, the next call tries again.
+func (v *Vault) Refresh(ctx context.Context) ([]Change, error) {
+	pushes, err := v.conn.PendingPushMessages()
+	var changes []Change
+	for _, push := range pushes {
+		if change, ok := v.update(push); ok {
+			changes = append(changes, change)
+		}
+	}
+	if err != nil {
+		api.Log().Warn("⚠️ Connection lost", "err", err)
+		reconnected, err := v.reconnect(ctx, refreshBackoff)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, reconnected...)
+	}
+	return changes, nil
+}
+
+// Close closes the connection
+func (v *Vault) Close() error {
+	return v.conn.Close()
+}
+
+// reconnect reconnects from the latest change received, and applies the changes made in the meantime
+func (v *Vault) reconnect(ctx context.Context, policy api.BackoffPolicy) ([]Change, error) {
+	initResult, err := v.conn.ReconnectContext(ctx, policy, v.remoteUid)
+	if err != nil {
+		return nil, fmt.Errorf("error reconnecting: %s", err)
+	}
+	var changes []Change
+	for i := range initResult.PushedFiles {
+		if change, ok := v.update(&initResult.PushedFiles[i]); ok {
+			changes = append(changes, change)
+		}
+	}
+	if initResult.RemoteUid > v.remoteUid {
+		v.remoteUid = initResult.RemoteUid
+	}
+	return changes, nil
+}
+
+// apply updates the index with the changes received by init
+func (v *Vault) apply(initResult *api.InitResult) {
+	for i := range initResult.PushedFiles {
+		v.update(&initResult.PushedFiles[i])
+	}
+	if initResult.RemoteUid > v.remoteUid {
+		v.remoteUid = initResult.RemoteUid
+	}
+}
+
+// update updates the index with a change, returning false if its path can't be decrypted
+func (v *Vault) update(push *api.IncomingPushMessage) (Change, bool) {
+	if push.Uid > v.remoteUid {
+		v.remoteUid = push.Uid
+	}
+	path, err := v.conn.Cipher.DecryptString(push.EncryptedPath)
+	if err != nil {
+		api.Log().Warn("⚠️ Could not decrypt pushed path", "err", err)
+		return Change{}, false
+	}
+
+	change := Change{Deleted: push.Deleted, File: File{
+		Path:     path,
+		Folder:   push.Folder,
+		Size:     push.Size,
+		Created:  time.UnixMilli(push.Ctime),
+		Modified: time.UnixMilli(push.Mtime),
+		Device:   push.Device,
+		uid:      push.Uid,
+		hash:     push.EncryptedHash,
+	}}
+	if push.Deleted {
+		delete(v.files, path)
+	} else {
+		v.files[path] = change.File
+	}
+	return change, true
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	benchmarkCmd.Flags().Int("encryptionVersion", crypto.LatestEncryptionVersion, "Encryption version whose KDF to benchmark")
+	benchmarkCmd.Flags().Int("n", 0, "Override the scrypt CPU and memory cost, a power of two")
+	benchmarkCmd.Flags().Int("r", 0, "Override the scrypt block size")
+	benchmarkCmd.Flags().Int("p", 0, "Override the scrypt parallelization")
+	benchmarkCmd.Flags().Int("runs", 3, "Number of derivations to average")
+	rootCmd.AddCommand(benchmarkCmd)
+}
+
+var benchmarkCmd = &cobra.Command{
+	Use:   "benchmark",
+	Short: "Time key derivation",
+	Long:  "Time how long deriving a vault key takes on this machine, which is the bulk of connecting to a vault",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		version, _ := cmd.Flags().GetInt("encryptionVersion")
+		n, _ := cmd.Flags().GetInt("n")
+		r, _ := cmd.Flags().GetInt("r")
+		p, _ := cmd.Flags().GetInt("p")
+		runs, _ := cmd.Flags().GetInt("runs")
+
+		kdf, err := crypto.VersionKDF(version)
+		if err != nil {
+			fmt.Printf("Error benchmarking: %s\n", err)
+			return
+		}
+		if s, ok := kdf.(crypto.Scrypt); ok {
+			if n > 0 {
+				s.N = n
+			}
+			if r > 0 {
+				s.R = r
+			}
+			if p > 0 {
+				s.P = p
+			}
+			kdf = s
+		}
+
+		perRun, err := crypto.BenchmarkKDF(kdf, runs)
+		if err != nil {
+			fmt.Printf("Error benchmarking: %s\n", err)
+			return
+		}
+		fmt.Printf("⏱️ %v: %s per derivation, averaged over %d runs\n", kdf, perRun.Round(time.Millisecond), runs)
+	},
+}
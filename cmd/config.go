@@ -0,0 +1,371 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nbadal/obsidian-sync/config"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+// deviceNameKey is the config key for the device name, every other key is a flag preference
+const deviceNameKey = "deviceName"
+
+func init() {
+	configCmd.AddCommand(configSetCmd, configGetCmd, configUnsetCmd, configListCmd, configPassphraseCmd)
+	rootCmd.AddCommand(configCmd)
+
+	config.PromptPassphrase = promptPassphrase
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the encrypted config",
+	Long: "Manage the encrypted config file holding the auth token, vault passwords, device name and flag preferences. " +
+		"Preferences are defaults for any command flag, e.g. \"config set evictBelow 500\". Environment variables and " +
+		"the plain YAML settings file given by --config take precedence over them",
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set [key] [value]",
+	Short: "Set the device name or a flag preference",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		key, value := args[0], args[1]
+		if key == deviceNameKey {
+			cfg.DeviceName = value
+		} else {
+			flag := lookupFlag(key)
+			if flag == nil {
+				return fmt.Errorf("unknown config key %q", key)
+			}
+			if err := validateFlagValue(flag, value); err != nil {
+				return err
+			}
+			if cfg.Preferences == nil {
+				cfg.Preferences = map[string]string{}
+			}
+			cfg.Preferences[key] = value
+		}
+		return cfg.Save()
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get [key]",
+	Short: "Print the device name or a flag preference",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		if args[0] == deviceNameKey {
+			fmt.Println(cfg.DeviceName)
+		} else {
+			fmt.Println(cfg.Preferences[args[0]])
+		}
+		return nil
+	},
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset [key]",
+	Short: "Remove the device name or a flag preference",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		if args[0] == deviceNameKey {
+			cfg.DeviceName = ""
+		} else {
+			delete(cfg.Preferences, args[0])
+		}
+		return cfg.Save()
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List config values, without secrets",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("authToken: %s\n", storedLabel(cfg.AuthToken != ""))
+		if cfg.TokenScope != "" {
+			fmt.Printf("tokenScope: %s\n", cfg.TokenScope)
+		}
+		fmt.Printf("vaultPasswords: %d stored\n", len(cfg.VaultPasswords))
+		fmt.Printf("%s: %s\n", deviceNameKey, cfg.DeviceName)
+
+		keys := make([]string, 0, len(cfg.Preferences))
+		for key := range cfg.Preferences {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s: %s\n", key, cfg.Preferences[key])
+		}
+		return nil
+	},
+}
+
+var configPassphraseCmd = &cobra.Command{
+	Use:   "passphrase",
+	Short: "Encrypt the config with a new master passphrase instead of the OS keychain",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		passphrase, err := promptPassphrase(true)
+		if err != nil {
+			return err
+		}
+		if err := cfg.UsePassphrase(passphrase); err != nil {
+			return err
+		}
+		return cfg.Save()
+	},
+}
+
+const (
+	accountPasswordEnv = "OBSIDIAN_SYNC_ACCOUNT_PASSWORD" // --password of login
+	vaultPasswordEnv   = "OBSIDIAN_SYNC_VAULT_PASSWORD"   // --password of the commands opening a vault
+)
+
+// secretFlags are the flags that take a secret, which the plain-text settings file can't set
+var secretFlags = map[string]bool{"password": true, "authToken": true, "mirrorPassword": true, "totp": true}
+
+// applyPreferences sets flags the user didn't pass. Each flag takes the first of, in order of precedence: its
+// OBSIDIAN_SYNC_* environment variable, the settings file, and the preference stored with config set.
+func applyPreferences(cmd *cobra.Command, args []string) error {
+	settingsPath, settings, err := loadSettings(cmd)
+	if err != nil {
+		return err
+	}
+	applied := make(map[string]bool)
+	err = applyFlags(cmd, applied, func(name string) ([]string, string, bool) {
+		env := flagEnv(cmd, name)
+		if value, ok := os.LookupEnv(env); ok {
+			return []string{value}, env, true
+		}
+		if values, ok := settings[name]; ok {
+			return values, fmt.Sprintf("setting %s in %s", name, settingsPath), true
+		}
+		return nil, "", false
+	})
+	if err != nil {
+		return err
+	}
+
+	// Loading the stored preferences may ask for the config passphrase, which --nonInteractive from the environment or
+	// settings file has to stop
+	applyInteractivity(cmd)
+	preferences, err := loadStoredPreferences()
+	if err != nil {
+		return err
+	}
+	return applyFlags(cmd, applied, func(name string) ([]string, string, bool) {
+		value, ok := preferences[name]
+		return []string{value}, "preference " + name, ok
+	})
+}
+
+// applyFlags sets the flags the user didn't pass and that weren't applied already to the values lookup returns for
+// them, along with where they came from for errors
+func applyFlags(cmd *cobra.Command, applied map[string]bool, lookup func(name string) ([]string, string, bool)) error {
+	var setErr error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed || applied[flag.Name] || setErr != nil || flag.Name == "config" {
+			return
+		}
+		values, source, ok := lookup(flag.Name)
+		if !ok {
+			return
+		}
+		applied[flag.Name] = true
+		for _, value := range values {
+			if err := flag.Value.Set(value); err != nil {
+				setErr = fmt.Errorf("invalid %s: %s", source, err)
+				return
+			}
+		}
+	})
+	return setErr
+}
+
+// loadSettings reads the settings file given by --config, OBSIDIAN_SYNC_CONFIG or at the default path. Settings are
+// shared by all commands, so only names that aren't a flag of any command are rejected.
+func loadSettings(cmd *cobra.Command) (string, map[string][]string, error) {
+	path, _ := cmd.Flags().GetString("config")
+	if path == "" {
+		path = os.Getenv(config.SettingsEnv)
+	}
+	if path == "" {
+		var err error
+		if path, err = config.SettingsPath(); err != nil {
+			return "", nil, err
+		}
+	} else if _, err := os.Stat(path); err != nil {
+		// A file that was asked for by name has to exist
+		return "", nil, fmt.Errorf("error reading settings: %s", err)
+	}
+
+	settings, err := config.LoadSettings(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading settings: %s", err)
+	}
+	for name := range settings {
+		if name == "config" || lookupFlag(name) == nil {
+			return "", nil, fmt.Errorf("unknown setting %q in %s", name, path)
+		}
+		if secretFlags[name] {
+			return "", nil, fmt.Errorf("%s can't be set in %s, which isn't encrypted. Use login, --rememberPassword or "+
+				"the %s environment variable", name, path, flagEnv(cmd, name))
+		}
+	}
+	return path, settings, nil
+}
+
+// loadStoredPreferences returns the preferences stored with config set. A missing config has none, and isn't created,
+// so commands that don't need it never ask for a passphrase.
+func loadStoredPreferences() (map[string]string, error) {
+	path, err := config.Path()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %s", err)
+	}
+	return cfg.Preferences, nil
+}
+
+// flagEnv returns the environment variable for a flag of a command, e.g. OBSIDIAN_SYNC_LOG_LEVEL for logLevel. The
+// password of login is the account's while other commands take the vault's, so they get their own variables.
+func flagEnv(cmd *cobra.Command, name string) string {
+	if name == "password" {
+		if cmd == loginCmd {
+			return accountPasswordEnv
+		}
+		return vaultPasswordEnv
+	}
+	var env strings.Builder
+	env.WriteString("OBSIDIAN_SYNC_")
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			env.WriteByte('_')
+		}
+		env.WriteRune(unicode.ToUpper(r))
+	}
+	return env.String()
+}
+
+// lookupFlag finds a flag by name on any command
+func lookupFlag(name string) *pflag.Flag {
+	var found *pflag.Flag
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		if flag := cmd.Flags().Lookup(name); flag != nil && found == nil {
+			found = flag
+		}
+		if flag := cmd.PersistentFlags().Lookup(name); flag != nil && found == nil {
+			found = flag
+		}
+		for _, child := range cmd.Commands() {
+			visit(child)
+		}
+	}
+	visit(rootCmd)
+	return found
+}
+
+// validateFlagValue checks a value parses for the flag's type, without changing the flag
+func validateFlagValue(flag *pflag.Flag, value string) error {
+	var err error
+	switch flag.Value.Type() {
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "int", "int64":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "duration":
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %s", flag.Name, err)
+	}
+	return nil
+}
+
+func storedLabel(stored bool) string {
+	if stored {
+		return "stored"
+	}
+	return "not set"
+}
+
+// promptPassphrase reads the master passphrase from stdin, asking twice when choosing a new one
+func promptPassphrase(confirm bool) (*crypto.Secret, error) {
+	if nonInteractive {
+		if confirm {
+			return nil, errNonInteractive("a new config passphrase", "choose it without --nonInteractive")
+		}
+		return nil, errNonInteractive("the config passphrase", "set "+config.PassphraseEnv)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	read := func(prompt string) (string, error) {
+		fmt.Print(prompt)
+		if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+			secret, err := term.ReadPassword(fd)
+			fmt.Println()
+			if err != nil {
+				return "", fmt.Errorf("error reading passphrase: %s", err)
+			}
+			return string(secret), nil
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("error reading passphrase: %s", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	passphrase, err := read("Config passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !confirm {
+		return crypto.SecretString(passphrase), nil
+	}
+	again, err := read("Confirm passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if again != passphrase {
+		return nil, fmt.Errorf("passphrases don't match")
+	}
+	return crypto.SecretString(passphrase), nil
+}
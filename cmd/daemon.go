@@ -0,0 +1,285 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+const (
+	daemonStartWait = 15 * time.Second // How long daemon start waits for the daemon to answer
+	daemonStopWait  = 45 * time.Second // How long daemon stop waits for the daemon to exit, longer than it lets transfers finish
+)
+
+func init() {
+	addSyncFlags(daemonStartCmd)
+	addSyncFlags(daemonRestartCmd)
+	daemonStartCmd.Args = cobra.ExactArgs(1)
+	daemonRestartCmd.Args = cobra.ExactArgs(1)
+	daemonStopCmd.Args = cobra.ExactArgs(1)
+	daemonStatusCmd.Args = cobra.ExactArgs(1)
+	daemonEventsCmd.Args = cobra.ExactArgs(1)
+	daemonCmd.AddCommand(daemonStartCmd, daemonStopCmd, daemonStatusCmd, daemonRestartCmd, daemonEventsCmd)
+	rootCmd.AddCommand(daemonCmd)
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Manage a daemon syncing a vault folder in the background",
+}
+
+var daemonStartCmd = &cobra.Command{
+	Use:   "start [target path]",
+	Short: "Start a daemon syncing a vault folder in the background",
+	Long: "Start sync --daemon in the background, logging to a file next to the folder's sync state. Takes the flags " +
+		"of sync. The daemon can't prompt, so pass the vault ID, and the password with --password, --passwordCommand " +
+		"or --rememberPassword",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return startDaemon(cmd, args[0])
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop [target path]",
+	Short: "Stop the daemon syncing a vault folder",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targetPath := args[0]
+		if err := validateFolder(&targetPath, true); err != nil {
+			return fmt.Errorf("invalid target: %s", err)
+		}
+		if err := stopDaemon(targetPath); err != nil {
+			return err
+		}
+		fmt.Println("✅ Daemon stopped")
+		return nil
+	},
+}
+
+var daemonRestartCmd = &cobra.Command{
+	Use:   "restart [target path]",
+	Short: "Restart the daemon syncing a vault folder",
+	Long:  "Stop the daemon syncing a vault folder if there is one, and start a new one. Takes the flags of sync, like daemon start",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targetPath := args[0]
+		if err := validateFolder(&targetPath, true); err != nil {
+			return fmt.Errorf("invalid target: %s", err)
+		}
+		if err := stopDaemon(targetPath); err != nil && !errors.Is(err, sync.ErrNoDaemon) {
+			return err
+		}
+		return startDaemon(cmd, args[0])
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status [target path]",
+	Short: "Show whether a daemon is syncing a vault folder",
+	Long:  "Show whether a daemon is syncing a vault folder, and its failing self-checks. Exits non-zero if none is running",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targetPath := args[0]
+		if err := validateFolder(&targetPath, true); err != nil {
+			return fmt.Errorf("invalid target: %s", err)
+		}
+
+		report, err := sync.QueryDaemon(targetPath)
+		if errors.Is(err, sync.ErrNoDaemon) {
+			// A daemon that doesn't answer may still have left its status file
+			report.DaemonStatus, err = sync.ReadDaemonStatus(targetPath)
+			report.Running = false
+		}
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput(cmd) {
+			printJSON(report)
+		} else {
+			printDaemonReport(report)
+		}
+		if !report.Running {
+			return fmt.Errorf("no daemon is syncing %s", targetPath)
+		}
+		return nil
+	},
+}
+
+var daemonEventsCmd = &cobra.Command{
+	Use:   "events [target path]",
+	Short: "Follow what a daemon syncs as JSON",
+	Long: "Print a JSON object per file the daemon syncing a vault folder pulls, pushes or deletes, and per conflict and " +
+		"error, one per line, until it stops or this is interrupted. For jq, log shippers or custom tooling",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targetPath := args[0]
+		if err := validateFolder(&targetPath, true); err != nil {
+			return fmt.Errorf("invalid target: %s", err)
+		}
+		events := newEventPrinter()
+		err := sync.FollowDaemon(targetPath, events.OnEvent)
+		if errors.Is(err, sync.ErrNoDaemon) {
+			return fmt.Errorf("no daemon is syncing %s", targetPath)
+		}
+		return err
+	},
+}
+
+// printDaemonReport prints a daemon's report for people to read
+func printDaemonReport(report sync.DaemonReport) {
+	switch {
+	case report.Running:
+		fmt.Printf("Daemon running as PID %d since %s\n", report.Pid, report.Started.Format("2006-01-02 15:04:05"))
+	case !report.Heartbeat.IsZero():
+		fmt.Printf("Daemon not running, PID %d stopped responding %s ago\n", report.Pid, time.Since(report.Heartbeat).Round(time.Second))
+	default:
+		fmt.Println("Daemon not running")
+	}
+	if report.Paused {
+		fmt.Println("Paused, not applying changes")
+	}
+	if report.Sync != nil && !report.Sync.LastSync.IsZero() {
+		fmt.Printf("Last synced %s, %d files, %s of %s used\n", report.Sync.LastSync.Format("2006-01-02 15:04:05"),
+			report.Sync.Files, formatMB(report.Sync.Size), formatMB(report.Sync.Limit))
+	}
+	if len(report.Failed) > 0 {
+		fmt.Println("Degraded, failing self-checks:")
+		for _, check := range report.Failed {
+			fmt.Printf("  %s\n", check)
+		}
+	}
+}
+
+// startDaemon starts sync --daemon for the folder at target in the background with the command's sync flags, and waits
+// for it to answer on its control socket
+func startDaemon(cmd *cobra.Command, target string) error {
+	targetPath, args, err := daemonArgs(cmd, target)
+	if err != nil {
+		return err
+	}
+	if _, err := sync.QueryDaemon(targetPath); err == nil {
+		return fmt.Errorf("a daemon is already syncing %s", targetPath)
+	}
+
+	logPath, err := sync.DaemonLogPath(targetPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening daemon log: %s", err)
+	}
+	defer logFile.Close()
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	process := exec.Command(executable, args...)
+	process.Stdout = logFile
+	process.Stderr = logFile
+	detach(process)
+	if err := process.Start(); err != nil {
+		return fmt.Errorf("error starting daemon: %s", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- process.Wait()
+	}()
+
+	deadline := time.After(daemonStartWait)
+	for {
+		select {
+		case err := <-exited:
+			// sync prints its errors rather than exiting non-zero, so the log has the reason
+			if line := lastLine(logPath); line != "" {
+				err = errors.New(line)
+			} else if err == nil {
+				err = errors.New("it exited")
+			}
+			return fmt.Errorf("the daemon didn't start: %s, see %s", err, logPath)
+		case <-deadline:
+			return fmt.Errorf("the daemon didn't answer within %s, see %s", daemonStartWait, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if report, err := sync.QueryDaemon(targetPath); err == nil {
+			fmt.Printf("✅ Daemon started as PID %d, logging to %s\n", report.Pid, logPath)
+			return nil
+		}
+	}
+}
+
+// lastLine returns the last non-empty line of a file, or empty if it can't be read
+func lastLine(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// daemonArgs checks the folder at target and returns its absolute path, with the arguments to run a daemon syncing it
+// with. They're those of the daemon subcommand's own command line, which takes the flags of sync.
+func daemonArgs(cmd *cobra.Command, target string) (string, []string, error) {
+	if cmd.Flags().Changed("passwordStdin") || cmd.Flags().Changed("passwordFd") {
+		return "", nil, fmt.Errorf("the daemon can't read the password from stdin or a file descriptor, use --passwordCommand or --rememberPassword")
+	}
+	targetPath := target
+	force, _ := cmd.Flags().GetBool("force")
+	if err := validateFolder(&targetPath, force); err != nil {
+		return "", nil, fmt.Errorf("invalid target: %s", err)
+	}
+
+	// The folder was confirmed above, and nobody is there to answer prompts
+	args := []string{"sync", "--daemon", "--nonInteractive", "--force"}
+	seenDaemon, seenName, seenTarget := false, false, false
+	for _, arg := range os.Args[1:] {
+		switch {
+		case !seenDaemon && arg == "daemon":
+			seenDaemon = true
+		case seenDaemon && !seenName && arg == cmd.Name():
+			seenName = true
+		case seenName && !seenTarget && arg == target:
+			// The daemon may not run in the same working directory
+			seenTarget = true
+			args = append(args, targetPath)
+		default:
+			args = append(args, arg)
+		}
+	}
+	return targetPath, args, nil
+}
+
+// stopDaemon asks the daemon syncing targetPath to stop. A daemon without a control socket is sent an interrupt, if
+// its status file says it's running.
+func stopDaemon(targetPath string) error {
+	err := sync.StopDaemon(targetPath, daemonStopWait)
+	if !errors.Is(err, sync.ErrNoDaemon) {
+		return err
+	}
+	status, statusErr := sync.ReadDaemonStatus(targetPath)
+	if statusErr != nil || !status.Running {
+		return err
+	}
+	process, findErr := os.FindProcess(status.Pid)
+	if findErr != nil {
+		return err
+	}
+	if err := process.Signal(os.Interrupt); err != nil {
+		return fmt.Errorf("error interrupting daemon PID %d: %s", status.Pid, err)
+	}
+	for deadline := time.Now().Add(daemonStopWait); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if status, err := sync.ReadDaemonStatus(targetPath); err == nil && status.Pid == 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("daemon PID %d didn't stop within %s", status.Pid, daemonStopWait)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package cmd
+
+import "os/exec"
+
+// detach does nothing, processes outlive the console they were started from on this platform
+func detach(process *exec.Cmd) {}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package cmd
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts the process in its own session, so it outlives the terminal it was started from
+func detach(process *exec.Cmd) {
+	process.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	doctorCmd.Flags().StringP("vaultId", "v", "", "Vault ID to check, defaults to the one the folder was synced with")
+	doctorCmd.Flags().StringP("password", "p", "", "Password of the vault, defaults to the stored one")
+	addVaultPasswordSources(doctorCmd)
+	doctorCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	doctorCmd.Args = cobra.RangeArgs(0, 1)
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [target path]",
+	Short: "Diagnose connectivity and setup problems",
+	Long: "Check the auth token, access to the vault, the websocket connection to the vault's host, the vault password, " +
+		"the clock, and the vault folder's disk space, permissions and file names, with a hint for each failing check. " +
+		"Nothing is changed. Exits non-zero if any check fails",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		passwordFlag, _ := cmd.Flags().GetString("password")
+		tokenFlag, _ := cmd.Flags().GetString("authToken")
+
+		// Get args
+		targetPath := ""
+		if len(args) > 0 {
+			targetPath = args[0]
+			if err := validateFolder(&targetPath, true); err != nil {
+				return fmt.Errorf("invalid target: %s", err)
+			}
+			if vaultId == "" {
+				if state, err := sync.LoadState(targetPath); err == nil && state != nil {
+					vaultId = state.VaultId
+				}
+			}
+		}
+
+		authToken, _, err := loadAuthToken(tokenFlag)
+		if err != nil {
+			printDiagnosis(sync.Diagnosis{Check: sync.Check{Name: "auth token", Err: err}, Hint: "log in with login, or pass a token with --authToken"})
+			return fmt.Errorf("no auth token to check with")
+		}
+		defer authToken.Wipe()
+
+		password := crypto.SecretString(passwordFlag)
+		if password.Empty() && vaultId != "" {
+			if stored, err := auth.LoadVaultPassword(vaultId); err == nil && !stored.Empty() {
+				password.Wipe()
+				password = stored
+			}
+		}
+		defer password.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		failed := 0
+		for _, diagnosis := range sync.Doctor(c, targetPath, authToken, vaultId, password) {
+			printDiagnosis(diagnosis)
+			if diagnosis.Err != nil {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d checks failed", failed)
+		}
+		return nil
+	},
+}
+
+// printDiagnosis prints the result of a doctor check, with its hint if it failed
+func printDiagnosis(diagnosis sync.Diagnosis) {
+	switch {
+	case diagnosis.Skipped:
+		fmt.Printf("⏭️  %-16s skipped, %s\n", diagnosis.Name, diagnosis.Detail)
+	case diagnosis.Err != nil:
+		fmt.Printf("❌ %-16s %s\n", diagnosis.Name, diagnosis.Err)
+		fmt.Printf("   %-16s %s\n", "", diagnosis.Hint)
+	default:
+		fmt.Printf("✅ %-16s %s\n", diagnosis.Name, diagnosis.Detail)
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// Environment variables that set the endpoint flags when they aren't given on the command line
+const (
+	apiURLEnv   = "OBSIDIAN_SYNC_API_URL"
+	wsSchemeEnv = "OBSIDIAN_SYNC_WS_SCHEME"
+	syncHostEnv = "OBSIDIAN_SYNC_HOST"
+)
+
+func init() {
+	rootCmd.PersistentFlags().String("apiUrl", api.OfficialEndpoint.BaseURL, "Base URL of the Obsidian API, for staging servers, proxies or self-hosted relays. Also set by "+apiURLEnv)
+	rootCmd.PersistentFlags().String("wsScheme", api.OfficialEndpoint.WSScheme, "Websocket scheme of sync hosts, \"wss\" or \"ws\". Also set by "+wsSchemeEnv)
+	rootCmd.PersistentFlags().String("syncHost", "", "Sync host to use for every vault instead of the one the API assigns. Also set by "+syncHostEnv)
+}
+
+// applyEndpoint points the API client at the endpoint from the global flags. Flags given on the command line win over
+// the environment, which wins over preferences.
+func applyEndpoint(cmd *cobra.Command) error {
+	endpoint := api.Endpoint{
+		BaseURL:  endpointSetting(cmd, "apiUrl", apiURLEnv),
+		WSScheme: endpointSetting(cmd, "wsScheme", wsSchemeEnv),
+		SyncHost: endpointSetting(cmd, "syncHost", syncHostEnv),
+	}
+	if err := endpoint.Validate(); err != nil {
+		return err
+	}
+	if endpoint != api.OfficialEndpoint {
+		// On stderr, so it doesn't end up in JSON output
+		_, _ = fmt.Fprintf(os.Stderr, "🌐 Using API at %s\n", endpoint.BaseURL)
+	}
+	api.DefaultEndpoint = endpoint
+	return nil
+}
+
+// endpointSetting returns the value of an endpoint flag, or of its environment variable if the flag wasn't given
+func endpointSetting(cmd *cobra.Command, flagName string, env string) string {
+	value, _ := cmd.Flags().GetString(flagName)
+	if flag := cmd.Flags().Lookup(flagName); flag != nil && !flag.Changed {
+		if envValue := os.Getenv(env); envValue != "" {
+			return envValue
+		}
+	}
+	return value
+}
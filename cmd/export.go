@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	exportCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(exportCmd)
+	exportCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	exportCmd.Flags().String("format", "", "Archive format, tar.gz or zip. Defaults to the one the output's extension names")
+	exportCmd.Flags().Bool("overwrite", false, "Replace the output if it exists")
+	exportCmd.Args = cobra.ExactArgs(2)
+	rootCmd.AddCommand(exportCmd)
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export [vault ID] [output]",
+	Short: "Export a whole vault, decrypted, to a tar.gz or zip archive",
+	Long: "Stream every file of the remote vault, decrypted, into an archive, without a synced folder. For backups " +
+		"and migrations. Files are under a folder named after the vault, with their modified times. The output is " +
+		"only written once the export succeeds",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Get flags
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		formatName, _ := cmd.Flags().GetString("format")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		vaultId := args[0]
+		output := args[1]
+
+		format := sync.ArchiveFormat(formatName)
+		if formatName == "" {
+			var err error
+			if format, err = sync.ArchiveFormatOf(output); err != nil {
+				return err
+			}
+		} else if format != sync.ArchiveTarGz && format != sync.ArchiveZip {
+			return fmt.Errorf("unknown archive format %q, use tar.gz or zip", formatName)
+		}
+		if _, err := os.Stat(output); err == nil && !overwrite {
+			return fmt.Errorf("%s already exists, pass --overwrite to replace it", output)
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			return err
+		}
+		defer creds.Wipe()
+
+		// Written next to the output, so a failed export leaves no partial archive behind
+		partial, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*")
+		if err != nil {
+			return fmt.Errorf("error creating output: %s", err)
+		}
+		defer os.Remove(partial.Name())
+		defer partial.Close()
+
+		c, stop := interruptContext()
+		defer stop()
+		result, err := sync.ExportContext(c, partial, format, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("error exporting: %s", err)
+		}
+		if err := partial.Close(); err != nil {
+			return fmt.Errorf("error writing output: %s", err)
+		}
+		if err := os.Rename(partial.Name(), output); err != nil {
+			return fmt.Errorf("error writing output: %s", err)
+		}
+		fmt.Printf("📦 Exported %d files (%s) and %d folders to %s\n", result.Files, formatMB(result.Bytes), result.Folders, output)
+		return nil
+	},
+}
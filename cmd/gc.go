@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	gcCmd.Flags().Duration("maxAge", 30*24*time.Hour, "Remove files older than this duration")
+	gcCmd.Flags().Int64("maxSize", 0, "Remove the oldest files once the total exceeds this many MB")
+	gcCmd.Args = cobra.ExactArgs(1)
+	rootCmd.AddCommand(gcCmd)
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc [target path]",
+	Short: "Prune local trash",
+	Long:  "Apply retention limits to the local trash and other client managed folders, reporting reclaimed space",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		maxAge, _ := cmd.Flags().GetDuration("maxAge")
+		maxSize, _ := cmd.Flags().GetInt64("maxSize")
+
+		// Get args
+		targetPath := args[0]
+		err := validateFolder(&targetPath, true)
+		if err != nil {
+			fmt.Printf("Invalid target: %s\n", err)
+			return
+		}
+
+		result, err := sync.CollectGarbage(targetPath, sync.RetentionPolicy{
+			MaxAge:  maxAge,
+			MaxSize: maxSize * 1024 * 1024,
+		})
+		if err != nil {
+			fmt.Printf("Error collecting garbage: %s\n", err)
+			return
+		}
+		fmt.Printf("🧹 Removed %d files, reclaimed %d bytes\n", result.FilesRemoved, result.BytesReclaimed)
+	},
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	historyCmd.Flags().StringP("vaultId", "v", "", "Vault ID of the file")
+	historyCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(historyCmd)
+	historyCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	historyCmd.Args = cobra.ExactArgs(1)
+	rootCmd.AddCommand(historyCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [remote path]",
+	Short: "List the versions the server kept of a file",
+	Long: "List the versions the sync server kept of a file in the vault, newest first, with when they were pushed, " +
+		"their size and the device that pushed them",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		asJSON := jsonOutput(cmd)
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		remotePath := args[0]
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			printError(cmd, "Error: %s", err)
+			return
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		versions, err := sync.FileHistoryContext(c, remotePath, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			printError(cmd, "Error listing history of %s: %s", remotePath, err)
+			return
+		}
+
+		if asJSON {
+			printJSON(versions)
+			return
+		}
+		if len(versions) == 0 {
+			fmt.Printf("No versions of %s found\n", remotePath)
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "UID\tTIME\tSIZE\tDEVICE")
+		for _, version := range versions {
+			size := fmt.Sprintf("%d bytes", crypto.PlaintextSize(version.Size))
+			if version.Deleted {
+				size = "deleted"
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", version.Uid, versionTime(version).Format(time.RFC3339), size, version.Device)
+		}
+		_ = writer.Flush()
+	},
+}
+
+// versionTime returns when a version was pushed. Versions from older servers may not have the time they were received.
+func versionTime(version api.FileVersion) time.Time {
+	if version.Ts == 0 {
+		return time.UnixMilli(version.Mtime)
+	}
+	return time.UnixMilli(version.Ts)
+}
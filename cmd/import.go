@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	importCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(importCmd)
+	importCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	importCmd.Flags().String("deviceName", "", "Device name other clients see for the imported files, defaults to the configured name")
+	importCmd.Flags().Bool("merge", false, "Import into a vault that has other files, keeping them and replacing files at the same paths")
+	importCmd.Flags().StringArray("include", nil, "Glob of the only paths to import, e.g. \"*.md\". A pattern without a slash matches names in any folder. Repeatable")
+	importCmd.Flags().StringArray("exclude", nil, "Glob of paths to leave out, e.g. \"attachments/**\". Wins over --include. Repeatable")
+	importCmd.Flags().StringArray("priority", nil, "Glob of paths to push first, e.g. \"Daily Notes/**\". Repeat in order of priority")
+	importCmd.Flags().Bool("progress", true, "Draw progress bars for pushes when stdout is a terminal, otherwise only log lines are printed")
+	importCmd.Flags().Int("parallel", 4, "Number of files to push at once, each over its own connection")
+	importCmd.Flags().Bool("skipOverQuota", false, "Skip files that would exceed the vault size limit instead of failing")
+	importCmd.Args = cobra.ExactArgs(2)
+	rootCmd.AddCommand(importCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import [vault ID] [source path]",
+	Short: "Seed an empty vault with an existing folder of notes",
+	Long: "Push every file of a folder into an empty vault, for moving notes from another sync solution. Files are " +
+		"pushed in parallel and large ones in pieces. If the import is interrupted, run it again to push the rest: files " +
+		"the vault already has with the same content are skipped. The folder isn't synced afterwards, sync a folder with " +
+		"the vault for that. Refuses vaults with other files unless --merge is passed",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Get flags
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		deviceName, _ := cmd.Flags().GetString("deviceName")
+		merge, _ := cmd.Flags().GetBool("merge")
+		include, _ := cmd.Flags().GetStringArray("include")
+		exclude, _ := cmd.Flags().GetStringArray("exclude")
+		priorities, _ := cmd.Flags().GetStringArray("priority")
+		progress, _ := cmd.Flags().GetBool("progress")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		skipOverQuota, _ := cmd.Flags().GetBool("skipOverQuota")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		vaultId := args[0]
+		sourcePath := args[1]
+		if err := validateFolder(&sourcePath, true); err != nil {
+			return fmt.Errorf("invalid source: %s", err)
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			return err
+		}
+		defer creds.Wipe()
+		if creds.Scope == auth.ScopeReadOnly {
+			return fmt.Errorf("the stored token is read-only, login with full scope to import")
+		}
+		if deviceName == "" {
+			deviceName = creds.DeviceName
+		}
+
+		opts := sync.Options{
+			DeviceName:    deviceName,
+			SkipOverQuota: skipOverQuota,
+			Priorities:    priorities,
+			Include:       include,
+			Exclude:       exclude,
+			Parallelism:   parallel,
+			Timeout:       timeout,
+		}
+		if progress && logLevel <= api.LevelInfo && isTerminal(os.Stdout) {
+			// Log lines go through the bars so they're printed above them
+			bars := newProgressBars(os.Stdout)
+			opts.Progress = bars
+			api.SetLogger(api.NewTextLogger(bars, logLevel))
+		}
+
+		c, stop := interruptContext()
+		defer stop()
+		result, err := sync.ImportContext(c, sourcePath, merge, creds.AuthToken, creds.Vault, creds.Password, opts)
+		if errors.Is(err, sync.ErrVaultNotEmpty) {
+			return fmt.Errorf("%s, pass --merge to import into it anyway", err)
+		}
+		if err != nil {
+			if result.Files > 0 || errors.Is(err, context.Canceled) {
+				fmt.Printf("⏸️ Pushed %d files (%s) before stopping, run import again to push the rest\n", result.Files, formatMB(result.Bytes))
+			}
+			return fmt.Errorf("error importing: %s", err)
+		}
+		fmt.Printf("📥 Imported %d files (%s) into %s", result.Files, formatMB(result.Bytes), creds.Vault.Name)
+		if result.Unchanged > 0 {
+			fmt.Printf(", %d were already there", result.Unchanged)
+		}
+		if result.OverQuota > 0 {
+			fmt.Printf(", skipped %d over the size limit", result.OverQuota)
+		}
+		fmt.Println()
+		return nil
+	},
+}
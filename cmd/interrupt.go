@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// exitStopped is the exit status of a sync that was stopped before it finished, the status shells give an interrupted
+// command
+const exitStopped = 130
+
+// interruptContext returns a context that's canceled when the process is interrupted or terminated, so a sync can stop
+// its network operations and daemons can exit cleanly. A second signal kills the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	c, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c.Done()
+		stop()
+	}()
+	return c, stop
+}
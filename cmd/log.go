@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	rootCmd.PersistentFlags().String("logLevel", "info", "Only log lines at or above this level: debug, info or warn")
+	// No -v shorthand, it's --vaultId on the vault commands
+	rootCmd.PersistentFlags().Bool("verbose", false, "Log debug lines too, like --logLevel debug")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Only log warnings, without per-file lines or progress bars, like --logLevel warn")
+	rootCmd.MarkFlagsMutuallyExclusive("verbose", "quiet")
+	rootCmd.PersistentFlags().Bool("traceFrames", false, "Log every websocket frame at debug level. Frames include the auth token, don't share the output")
+	rootCmd.PersistentFlags().String("wsLog", "", "Append every websocket frame to this file with timestamps and secrets redacted, view it with the trace command")
+}
+
+// logLevel is the level set by --logLevel, for commands that replace the logger
+var logLevel = api.LevelInfo
+
+// applyLogging sets up the api and sync logger, and the websocket trace, from the global flags
+func applyLogging(cmd *cobra.Command) error {
+	levelName, _ := cmd.Flags().GetString("logLevel")
+	level, err := api.ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	if verbose, _ := cmd.Flags().GetBool("verbose"); verbose {
+		level = api.LevelDebug
+	}
+	if quiet, _ := cmd.Flags().GetBool("quiet"); quiet {
+		level = api.LevelWarn
+	}
+	traceFrames, _ := cmd.Flags().GetBool("traceFrames")
+	if traceFrames && level > api.LevelDebug {
+		// Tracing is pointless without debug lines
+		level = api.LevelDebug
+	}
+	logLevel = level
+	if jsonOutput(cmd) {
+		api.SetLogger(api.NewJSONLogger(os.Stdout, level))
+	} else {
+		api.SetLogger(api.NewTextLogger(os.Stdout, level))
+	}
+	api.TraceFrames = traceFrames
+
+	wsLog, _ := cmd.Flags().GetString("wsLog")
+	if wsLog != "" {
+		trace, err := api.OpenSessionTrace(wsLog)
+		if err != nil {
+			return err
+		}
+		// Every frame is written straight to the file, so it's left for the process exit to close
+		api.SetSessionTrace(trace)
+	}
+	return nil
+}
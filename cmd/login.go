@@ -1,15 +1,22 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/crypto"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	loginCmd.Flags().StringP("email", "e", "", "Obsidian Sync email address")
 	loginCmd.Flags().StringP("password", "p", "", "Obsidian Sync password")
-	loginCmd.Flags().StringP("token", "t", "", "Obsidian Sync password")
+	addPasswordSources(loginCmd)
+	loginCmd.Flags().String("totp", "", "Two-factor authentication code from your authenticator app, prompted for if the account needs one")
+	loginCmd.Flags().String("mfa", "", "Two-factor authentication code")
+	_ = loginCmd.Flags().MarkDeprecated("mfa", "use --totp instead")
+	loginCmd.Flags().StringP("token", "t", "", "Obsidian Sync auth token")
+	loginCmd.Flags().String("scope", "full", "Scope of the stored credential, \"full\" or \"read-only\". Read-only credentials never push changes")
 	rootCmd.AddCommand(loginCmd)
 }
 
@@ -22,16 +29,31 @@ var loginCmd = &cobra.Command{
 		token, _ := cmd.Flags().GetString("token")
 		email, _ := cmd.Flags().GetString("email")
 		password, _ := cmd.Flags().GetString("password")
+		totp, _ := cmd.Flags().GetString("totp")
+		if totp == "" {
+			totp, _ = cmd.Flags().GetString("mfa")
+		}
+		scopeName, _ := cmd.Flags().GetString("scope")
 
-		getTokenIfNeededAndStore(token, email, password)
+		scope, err := auth.ParseScope(scopeName)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		getTokenIfNeededAndStore(token, email, password, totp, scope)
 	},
 }
 
-func getTokenIfNeededAndStore(token, email, password string) {
+// maxTOTPAttempts is how many times login prompts for a two-factor code before giving up
+const maxTOTPAttempts = 3
+
+func getTokenIfNeededAndStore(token, email, password, totp string, scope auth.Scope) {
 	// Store token if provided. Ignore email and password.
 	if token != "" {
-		err := auth.StoreToken(token)
-		if err != nil {
+		session := &auth.Session{Token: crypto.SecretString(token), Scope: scope}
+		defer session.Wipe()
+		if err := session.Save(); err != nil {
 			fmt.Printf("Error storing token: %s\n", err)
 		}
 		return
@@ -39,31 +61,52 @@ func getTokenIfNeededAndStore(token, email, password string) {
 
 	// Prompt for email and password if needed
 	if email == "" {
-		promptFor("Email: ", &email)
+		if err := ask("Email: ", &email, "an email address", "pass --email or --token"); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
 	}
 	if password == "" {
-		promptFor("Password: ", &password)
+		if err := askSecret("Password: ", &password, "a password", "pass --password or --token"); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
 	}
 
-	// Login and store token
-	token, err := auth.Login(email, password)
-	if err != nil {
+	// Login, asking for a two-factor code if the account needs one
+	secretPassword := crypto.SecretString(password)
+	defer secretPassword.Wipe()
+	session, err := auth.Login(email, secretPassword, auth.NormalizeTOTP(totp))
+	for attempt := 0; errors.Is(err, auth.ErrMFARequired) && attempt < maxTOTPAttempts; attempt++ {
+		if attempt > 0 || totp != "" {
+			fmt.Println("Wrong two-factor code, try again")
+		}
+		if err := ask("Two-factor code: ", &totp, "a two-factor code", "pass --totp"); err != nil {
+			fmt.Printf("Error logging in: %s\n", err)
+			return
+		}
+		session, err = auth.Login(email, secretPassword, auth.NormalizeTOTP(totp))
+	}
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		fmt.Println("Error logging in: wrong email or password")
+		return
+	case errors.Is(err, auth.ErrMFARequired):
+		fmt.Println("Error logging in: wrong two-factor code")
+		return
+	case errors.Is(err, auth.ErrRateLimited):
+		fmt.Println("Error logging in: too many attempts, wait a few minutes and try again")
+		return
+	case err != nil:
 		fmt.Printf("Error logging in: %s\n", err)
 		return
 	}
-	err = auth.StoreToken(token)
+	defer session.Wipe()
+	session.Scope = scope
+	err = session.Save()
 	if err != nil {
 		fmt.Printf("Error storing token: %s\n", err)
 		return
 	}
-}
-
-func promptFor(prompt string, value *string) {
-	fmt.Print(prompt)
-	_, err := fmt.Scanln(value)
-	// TODO: Support empty input (throws unexpected newline error)
-	if err != nil {
-		fmt.Printf("Error reading input: %s\n", err)
-		return
-	}
+	fmt.Println("✅ Logged in")
 }
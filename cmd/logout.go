@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	logoutCmd.Flags().Bool("forgetPasswords", false, "Also remove the stored vault passwords")
+	rootCmd.AddCommand(logoutCmd)
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Logout of Obsidian API",
+	Long: "Sign out on the server so the stored auth token stops working, then remove it from the config. " +
+		"Use --forgetPasswords on shared machines to also remove the stored vault passwords",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		forgetPasswords, _ := cmd.Flags().GetBool("forgetPasswords")
+
+		session, err := auth.LoadSession()
+		switch {
+		case errors.Is(err, auth.ErrNotLoggedIn):
+			fmt.Println("Not logged in")
+		case err != nil:
+			fmt.Printf("Error loading token: %s\n", err)
+			return
+		default:
+			defer session.Wipe()
+			// Still remove the token locally, the user asked for it to be gone from this machine
+			if err := session.Signout(); err != nil {
+				fmt.Printf("⚠️ Could not sign out on the server, the token may stay valid: %s\n", err)
+			}
+			if err := auth.Logout(); err != nil {
+				fmt.Printf("Error removing token: %s\n", err)
+				return
+			}
+			fmt.Println("✅ Logged out")
+		}
+
+		if forgetPasswords {
+			if err := auth.ForgetVaultPasswords(); err != nil {
+				fmt.Printf("Error removing vault passwords: %s\n", err)
+				return
+			}
+			fmt.Println("✅ Vault passwords removed")
+		}
+	},
+}
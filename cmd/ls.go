@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	lsCmd.Flags().StringP("vaultId", "v", "", "Vault ID to list")
+	lsCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(lsCmd)
+	lsCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	lsCmd.Flags().Bool("tree", false, "Show the folders as a tree")
+	lsCmd.Args = cobra.MaximumNArgs(1)
+	rootCmd.AddCommand(lsCmd)
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [path]",
+	Short: "List the files in a vault",
+	Long: "List the files and folders in a vault, or under a folder of it, with their size, when they were modified and " +
+		"the device that pushed them. Nothing is downloaded, the listing comes from the vault's index",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		tree, _ := cmd.Flags().GetBool("tree")
+		asJSON := jsonOutput(cmd)
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		remotePath := ""
+		if len(args) > 0 {
+			remotePath = args[0]
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			printError(cmd, "Error: %s", err)
+			return
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		files, err := sync.ListRemoteContext(c, remotePath, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			printError(cmd, "Error listing %s: %s", creds.Vault.Name, err)
+			return
+		}
+
+		if asJSON {
+			printJSON(files)
+			return
+		}
+		if len(files) == 0 {
+			fmt.Println("📂 The vault is empty")
+			return
+		}
+		if tree {
+			printTree(files, strings.Trim(path.Clean("/"+remotePath), "/"))
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "PATH\tSIZE\tMODIFIED\tDEVICE")
+		for _, file := range files {
+			if file.Folder {
+				fmt.Fprintf(writer, "%s/\t-\t-\t%s\n", file.Path, file.Device)
+				continue
+			}
+			fmt.Fprintf(writer, "%s\t%d bytes\t%s\t%s\n", file.Path, file.Size, file.Modified.Format(time.RFC3339), file.Device)
+		}
+		_ = writer.Flush()
+	},
+}
+
+// treeNode is a file or folder in the tree printed by printTree
+type treeNode struct {
+	file     sync.RemoteFile
+	children map[string]*treeNode
+}
+
+// printTree prints files as a tree of the folders under root. Folders the index doesn't list themselves are still shown.
+func printTree(files []sync.RemoteFile, root string) {
+	// A single file is shown in its folder
+	if len(files) == 1 && !files[0].Folder && files[0].Path == root {
+		root = strings.TrimSuffix(path.Dir(root), ".")
+	}
+	top := &treeNode{children: make(map[string]*treeNode)}
+	for _, file := range files {
+		relPath := strings.TrimPrefix(strings.TrimPrefix(file.Path, root), "/")
+		if relPath == "" {
+			continue
+		}
+		node := top
+		for _, name := range strings.Split(relPath, "/") {
+			child, ok := node.children[name]
+			if !ok {
+				child = &treeNode{file: sync.RemoteFile{Folder: true}, children: make(map[string]*treeNode)}
+				node.children[name] = child
+			}
+			node = child
+		}
+		node.file = file
+	}
+
+	if root == "" {
+		fmt.Println(".")
+	} else {
+		fmt.Println(root + "/")
+	}
+	printTreeChildren(top, "")
+}
+
+// printTreeChildren prints the children of node, folders first, with lines drawn from indent
+func printTreeChildren(node *treeNode, indent string) {
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := node.children[names[i]], node.children[names[j]]
+		if a.file.Folder != b.file.Folder {
+			return a.file.Folder
+		}
+		return names[i] < names[j]
+	})
+
+	for i, name := range names {
+		child := node.children[name]
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		if child.file.Folder {
+			fmt.Printf("%s%s%s/\n", indent, branch, name)
+			printTreeChildren(child, indent+next)
+			continue
+		}
+		fmt.Printf("%s%s%s (%d bytes)\n", indent, branch, name, child.file.Size)
+	}
+}
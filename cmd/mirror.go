@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mirrorRestoreCmd.Flags().String("mirrorPassword", "", "Password the mirror was encrypted with, prompted for if not set")
+	mirrorRestoreCmd.Args = cobra.ExactArgs(2)
+	mirrorCmd.AddCommand(mirrorRestoreCmd)
+	rootCmd.AddCommand(mirrorCmd)
+}
+
+// addMirrorFlags adds the flags of a sync's backup mirror, see mirrorFromFlags
+func addMirrorFlags(cmd *cobra.Command) {
+	cmd.Flags().String("mirrorDir", "", "Also write every file pulled or pushed to this directory, e.g. on a backup drive")
+	cmd.Flags().String("mirrorS3", "", "Also upload every file pulled or pushed to this S3 bucket, as bucket or bucket/prefix. Credentials "+
+		"come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN")
+	cmd.Flags().String("mirrorS3Endpoint", "", "Endpoint of an S3-compatible service for --mirrorS3, e.g. https://minio.example.com. Defaults to AWS")
+	cmd.Flags().String("mirrorS3Region", "us-east-1", "Region of the --mirrorS3 bucket")
+	cmd.Flags().String("mirrorPassword", "", "Encrypt mirrored files with this password instead of mirroring them decrypted, see mirror restore")
+}
+
+// mirrorFromFlags returns the mirror the flags added by addMirrorFlags ask for, nil if none, and its password if it's
+// encrypted
+func mirrorFromFlags(cmd *cobra.Command) (sync.Mirror, *crypto.Secret, error) {
+	dir, _ := cmd.Flags().GetString("mirrorDir")
+	bucket, _ := cmd.Flags().GetString("mirrorS3")
+	endpoint, _ := cmd.Flags().GetString("mirrorS3Endpoint")
+	region, _ := cmd.Flags().GetString("mirrorS3Region")
+	password, _ := cmd.Flags().GetString("mirrorPassword")
+
+	var mirror sync.Mirror
+	switch {
+	case dir != "" && bucket != "":
+		return nil, nil, fmt.Errorf("pass either --mirrorDir or --mirrorS3, not both")
+	case dir != "":
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, nil, fmt.Errorf("error creating mirror directory: %s", err)
+		}
+		mirror = sync.DirMirror{Dir: dir}
+	case bucket != "":
+		accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if accessKey == "" || secretKey == "" {
+			return nil, nil, fmt.Errorf("--mirrorS3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY set")
+		}
+		name, prefix, _ := strings.Cut(strings.Trim(bucket, "/"), "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		mirror = &sync.S3Mirror{
+			Endpoint:     endpoint,
+			Region:       region,
+			Bucket:       name,
+			Prefix:       prefix,
+			AccessKey:    accessKey,
+			SecretKey:    crypto.SecretString(secretKey),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	default:
+		if password != "" {
+			return nil, nil, fmt.Errorf("--mirrorPassword needs --mirrorDir or --mirrorS3")
+		}
+		return nil, nil, nil
+	}
+	if password == "" {
+		return mirror, nil, nil
+	}
+	return mirror, crypto.SecretString(password), nil
+}
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Work with the backup mirrors syncs write with --mirrorDir or --mirrorS3",
+}
+
+var mirrorRestoreCmd = &cobra.Command{
+	Use:   "restore [mirror path] [target path]",
+	Short: "Decrypt an encrypted mirror into a folder",
+	Long: "Decrypt a mirror written with --mirrorPassword into a folder, e.g. to recover notes from a backup. For an " +
+		"S3 mirror, download the bucket's prefix first. Decrypted mirrors are plain copies and need no restoring",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password, _ := cmd.Flags().GetString("mirrorPassword")
+		mirrorPath := args[0]
+		targetPath := args[1]
+		if password == "" {
+			if err := askSecret("Mirror password: ", &password, "the mirror password", "pass --mirrorPassword"); err != nil {
+				return err
+			}
+		}
+		secret := crypto.SecretString(password)
+		defer secret.Wipe()
+
+		result, err := sync.RestoreMirror(mirrorPath, targetPath, secret)
+		if err != nil {
+			return fmt.Errorf("error restoring mirror: %s", err)
+		}
+		fmt.Printf("🪞 Restored %d files (%s) to %s\n", result.Files, formatMB(result.Bytes), targetPath)
+		return nil
+	},
+}
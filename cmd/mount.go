@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/client"
+	"github.com/nbadal/obsidian-sync/mount"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mountCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(mountCmd)
+	mountCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	mountCmd.Flags().Bool("readOnly", false, "Mount the vault read-only")
+	mountCmd.Flags().Duration("refreshInterval", mount.DefaultRefreshInterval, "How often to receive the changes other devices made")
+	mountCmd.Args = cobra.ExactArgs(2)
+	rootCmd.AddCommand(mountCmd)
+}
+
+var mountCmd = &cobra.Command{
+	Use:   "mount [vault ID] [mountpoint]",
+	Short: "Mount a vault as a FUSE filesystem",
+	Long: "Serve a vault as a filesystem at mountpoint, to browse it without a local copy. Files are pulled when " +
+		"they're opened and pushed when they're closed. Runs until interrupted or unmounted. Needs FUSE, on Linux " +
+		"and macOS",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		readOnly, _ := cmd.Flags().GetBool("readOnly")
+		refreshInterval, _ := cmd.Flags().GetDuration("refreshInterval")
+
+		// Get args
+		vaultId := args[0]
+		mountpoint := args[1]
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer creds.Wipe()
+		if creds.Scope == auth.ScopeReadOnly && !readOnly {
+			fmt.Println("⚠️ The stored token is read-only, mounting the vault read-only")
+			readOnly = true
+		}
+
+		c, stop := interruptContext()
+		defer stop()
+		vaultClient := client.New(creds.AuthToken.Reveal())
+		vaultClient.DeviceName = creds.DeviceName
+		vault, err := vaultClient.Connect(c, creds.Vault.Id, creds.Password.Reveal())
+		if err != nil {
+			fmt.Printf("Error connecting to vault: %s\n", err)
+			return
+		}
+		defer vault.Close()
+
+		fmt.Printf("📂 Mounting %s at %s, interrupt or unmount it to stop\n", creds.Vault.Name, mountpoint)
+		err = mount.Mount(c, vault, mountpoint, mount.Options{ReadOnly: readOnly, RefreshInterval: refreshInterval})
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		fmt.Println("✅ Unmounted")
+	},
+}
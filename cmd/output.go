@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+	gosync "sync"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Bool("json", false, "Print results and log lines as JSON on stdout instead of text, for scripts")
+}
+
+// jsonOutput returns true if the command should print JSON
+func jsonOutput(cmd *cobra.Command) bool {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	return asJSON
+}
+
+// printJSON prints a command's result as JSON on stdout. It's a single line, like the JSON log lines before it, so the
+// whole output can be read as JSON Lines.
+func printJSON(result interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	if err := encoder.Encode(result); err != nil {
+		fmt.Printf("Error encoding JSON: %s\n", err)
+	}
+}
+
+// printError prints why a command failed, e.g. printError(cmd, "Error listing vaults: %s", err). With --json it's
+// printed as a JSON object instead, so scripts reading stdout see it.
+func printError(cmd *cobra.Command, format string, args ...interface{}) {
+	if jsonOutput(cmd) {
+		printJSON(struct {
+			Error string `json:"error"`
+		}{fmt.Sprintf(format, args...)})
+		return
+	}
+	fmt.Printf(format+"\n", args...)
+}
+
+// eventPrinter prints sync events as JSON Lines on stdout. Parallel tasks emit events at once, so lines are written one
+// at a time.
+type eventPrinter struct {
+	mu gosync.Mutex
+}
+
+func newEventPrinter() *eventPrinter {
+	return &eventPrinter{}
+}
+
+func (p *eventPrinter) OnEvent(event sync.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	printJSON(event)
+}
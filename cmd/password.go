@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.PersistentFlags().String("tokenCommand", "", "Shell command printing the auth token to use when --authToken "+
+		"isn't passed, e.g. \"pass show obsidian/token\", instead of the one stored by login")
+}
+
+// tokenCommand is the command set by --tokenCommand, loadAuthToken runs it
+var tokenCommand string
+
+// addPasswordSources adds --passwordStdin and --passwordFd to a command with a --password flag, so scripts don't have
+// to put the password on the command line. OBSIDIAN_SYNC_VAULT_PASSWORD, or OBSIDIAN_SYNC_ACCOUNT_PASSWORD for login,
+// sets it like any other flag.
+func addPasswordSources(cmd *cobra.Command) {
+	cmd.Flags().Bool("passwordStdin", false, "Read the password from the first line of stdin")
+	cmd.Flags().Int("passwordFd", -1, "Read the password from the first line of this open file descriptor")
+	cmd.MarkFlagsMutuallyExclusive("password", "passwordStdin", "passwordFd")
+}
+
+// addVaultPasswordSources is addPasswordSources for a vault password, adding --passwordCommand too. It's kept off
+// login, whose password is the account's, so a password command in the settings file only ever gives vault passwords.
+func addVaultPasswordSources(cmd *cobra.Command) {
+	addPasswordSources(cmd)
+	cmd.Flags().String("passwordCommand", "", "Shell command printing the vault password on its first line, e.g. "+
+		"\"op read op://vaults/obsidian/password\"")
+	cmd.MarkFlagsMutuallyExclusive("password", "passwordStdin", "passwordFd", "passwordCommand")
+}
+
+// applySecretSources reads --tokenCommand, and sets --password from stdin, a file descriptor or a command if the
+// command was asked to read it from one
+func applySecretSources(cmd *cobra.Command) error {
+	tokenCommand, _ = cmd.Flags().GetString("tokenCommand")
+
+	fromStdin, _ := cmd.Flags().GetBool("passwordStdin")
+	command, _ := cmd.Flags().GetString("passwordCommand")
+	fd, err := cmd.Flags().GetInt("passwordFd")
+	if err != nil || cmd.Flags().Changed("password") {
+		// The command doesn't take a password, or it was passed, which beats the environment and settings
+		return nil
+	}
+
+	var password string
+	switch {
+	case fromStdin:
+		password, err = readPassword(os.Stdin)
+	case fd >= 0:
+		file := os.NewFile(uintptr(fd), "passwordFd")
+		if file == nil {
+			return fmt.Errorf("invalid --passwordFd %d", fd)
+		}
+		password, err = readPassword(file)
+		_ = file.Close()
+	case command != "":
+		password, err = runSecretCommand(command)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return cmd.Flags().Lookup("password").Value.Set(password)
+}
+
+// readPassword reads a password from the first line of r
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("error reading password: %s", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("error reading password: no password given")
+	}
+	return password, nil
+}
+
+// runSecretCommand runs a command with the shell and returns the first line it prints. Tools like pass print other
+// fields on the lines after. The command can prompt, e.g. to unlock a password manager, on the terminal.
+func runSecretCommand(command string) (string, error) {
+	var shell *exec.Cmd
+	if runtime.GOOS == "windows" {
+		shell = exec.Command("cmd", "/C", command)
+	} else {
+		shell = exec.Command("sh", "-c", command)
+	}
+	shell.Stdin = os.Stdin
+	shell.Stderr = os.Stderr
+	out, err := shell.Output()
+	defer func() {
+		for i := range out {
+			out[i] = 0
+		}
+	}()
+	if err != nil {
+		return "", fmt.Errorf("error running %q: %s", command, err)
+	}
+	secret, err := readPassword(bytes.NewReader(out))
+	if err != nil {
+		return "", fmt.Errorf("%q printed nothing", command)
+	}
+	return secret, nil
+}
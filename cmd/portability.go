@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	checkPortabilityCmd.Args = cobra.ExactArgs(1)
+	rootCmd.AddCommand(checkPortabilityCmd)
+}
+
+var checkPortabilityCmd = &cobra.Command{
+	Use:   "check-portability [target path]",
+	Short: "Find file names that will break on other platforms",
+	Long:  "Scan a vault folder for names that are invalid on Windows, collide on case-insensitive filesystems, or are too long, before another device hits errors syncing them",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get args
+		targetPath := args[0]
+		err := validateFolder(&targetPath, true)
+		if err != nil {
+			fmt.Printf("Invalid target: %s\n", err)
+			return
+		}
+
+		issues, err := sync.CheckPortability(targetPath)
+		if err != nil {
+			fmt.Printf("Error scanning vault: %s\n", err)
+			return
+		}
+		if len(issues) == 0 {
+			fmt.Println("✅ All names are portable")
+			return
+		}
+		for _, issue := range issues {
+			fmt.Printf("⚠️ %s %s\n", issue.Path, issue.Problem)
+		}
+		fmt.Printf("%d portability issues found\n", len(issues))
+	},
+}
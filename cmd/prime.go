@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	primeCmd.Flags().String("cacheDir", "", "Directory to store the cache in, restore it and pass it to sync --cacheDir")
+	primeCmd.Flags().StringP("vaultId", "v", "", "Vault ID to prime")
+	primeCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(primeCmd)
+	primeCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	_ = primeCmd.MarkFlagRequired("cacheDir")
+	rootCmd.AddCommand(primeCmd)
+}
+
+var primeCmd = &cobra.Command{
+	Use:   "prime",
+	Short: "Download a vault into a reusable cache",
+	Long: "Download the encrypted content and index of a vault into a cache directory. CI runners and other ephemeral " +
+		"environments can restore the cache and sync with --cacheDir to check out the vault without pulling every file",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		cacheDir, _ := cmd.Flags().GetString("cacheDir")
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		result, err := sync.PrimeContext(c, cacheDir, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			fmt.Printf("Error priming cache: %s\n", err)
+			return
+		}
+		fmt.Printf("📦 Cache primed: %d pulled, %d already cached, %d removed\n", result.Pulled, result.Cached, result.Removed)
+	},
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/sync"
+)
+
+const (
+	progressBarWidth = 24
+	progressPathMax  = 48
+	progressRedraw   = 100 * time.Millisecond // Most often the bars are redrawn for byte progress
+)
+
+// isTerminal returns true if f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// transferProgress is a file being pulled or pushed
+type transferProgress struct {
+	bytes int64
+	total int64
+}
+
+// progressBars draws the progress of a sync's transfers at the bottom of the terminal: a bar for all files being pulled
+// and pushed, with the bytes transferred and an ETA, and a bar for the current file. Pulls and pushes run in parallel, so
+// they're counted together. Log lines written to it are printed above the bars.
+type progressBars struct {
+	mu gosync.Mutex
+	w  io.Writer
+
+	files      int   // Files in the pull and push phases of this pass
+	filesDone  int   // Files that finished
+	bytes      int64 // Bytes in the pull and push phases of this pass
+	bytesDone  int64 // Bytes of files that finished
+	phasesOpen int   // Transfer phases started but not finished
+	started    time.Time
+	current    map[string]*transferProgress
+	last       string // The file that most recently made progress, shown in the second bar
+
+	lines    int // Lines of bars on screen
+	lastDraw time.Time
+}
+
+func newProgressBars(w io.Writer) *progressBars {
+	return &progressBars{w: w, current: map[string]*transferProgress{}}
+}
+
+func (p *progressBars) OnProgress(event sync.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if event.Phase != sync.PhasePull && event.Phase != sync.PhasePush {
+		return
+	}
+	force := false
+	switch event.Kind {
+	case sync.PhaseStarted:
+		if p.phasesOpen == 0 {
+			// A new pass, e.g. the next one of a daemon
+			p.files, p.filesDone, p.bytes, p.bytesDone = 0, 0, 0, 0
+			p.started = time.Now()
+		}
+		p.phasesOpen++
+		p.files += event.FileCount
+		p.bytes += event.PhaseBytes
+		force = true
+	case sync.FileStarted:
+		p.current[event.Path] = &transferProgress{}
+		p.last = event.Path
+	case sync.FileBytes:
+		if transfer, ok := p.current[event.Path]; ok {
+			transfer.bytes, transfer.total = event.Bytes, event.TotalBytes
+			p.last = event.Path
+		}
+	case sync.FileFinished:
+		if transfer, ok := p.current[event.Path]; ok {
+			p.bytesDone += transfer.bytes
+			delete(p.current, event.Path)
+		}
+		p.filesDone++
+		force = p.filesDone == p.files
+	case sync.PhaseFinished:
+		p.phasesOpen--
+		if p.phasesOpen == 0 {
+			p.clear()
+			return
+		}
+	}
+	if force || time.Since(p.lastDraw) >= progressRedraw {
+		p.draw()
+	}
+}
+
+// Write prints log lines above the bars
+func (p *progressBars) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.w.Write(data)
+	if p.phasesOpen > 0 {
+		p.draw()
+	}
+	return n, err
+}
+
+// clear removes the bars from the screen, p.mu must be held
+func (p *progressBars) clear() {
+	for i := 0; i < p.lines; i++ {
+		if i > 0 {
+			// Up a line
+			_, _ = io.WriteString(p.w, "\x1b[1A")
+		}
+		_, _ = io.WriteString(p.w, "\r\x1b[2K")
+	}
+	p.lines = 0
+}
+
+// draw redraws the bars, p.mu must be held
+func (p *progressBars) draw() {
+	p.clear()
+	p.lastDraw = time.Now()
+
+	done := p.bytesDone
+	for _, transfer := range p.current {
+		done += transfer.bytes
+	}
+	overall := fmt.Sprintf("%s %3.0f%%  %d/%d files", bar(done, p.bytes), percent(done, p.bytes), p.filesDone, p.files)
+	if p.bytes > 0 {
+		overall += fmt.Sprintf("  %s/%s", formatMB(done), formatMB(p.bytes))
+		if eta, ok := estimateRemaining(time.Since(p.started), done, p.bytes); ok {
+			overall += fmt.Sprintf("  ETA %s", eta)
+		}
+	}
+	_, _ = io.WriteString(p.w, overall)
+	p.lines = 1
+
+	if transfer, ok := p.current[p.last]; ok {
+		_, _ = fmt.Fprintf(p.w, "\n%s %3.0f%%  %s", bar(transfer.bytes, transfer.total), percent(transfer.bytes, transfer.total), shortenPath(p.last))
+		p.lines = 2
+	}
+}
+
+// bar draws a bar filled in proportion to done of total
+func bar(done int64, total int64) string {
+	filled := 0
+	if total > 0 {
+		filled = int(int64(progressBarWidth) * done / total)
+	}
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
+
+// percent returns done as a percentage of total, zero if the total isn't known
+func percent(done int64, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	p := float64(done) * 100 / float64(total)
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
+
+// estimateRemaining extrapolates how long the rest of the bytes will take at the rate so far. ok is false until there
+// is enough progress to go on.
+func estimateRemaining(elapsed time.Duration, done int64, total int64) (time.Duration, bool) {
+	if done <= 0 || elapsed < time.Second || done >= total {
+		return 0, false
+	}
+	remaining := time.Duration(float64(elapsed) * float64(total-done) / float64(done))
+	return remaining.Round(time.Second), true
+}
+
+// shortenPath keeps the end of a long path, which has the file name
+func shortenPath(path string) string {
+	runes := []rune(path)
+	if len(runes) <= progressPathMax {
+		return path
+	}
+	return "…" + string(runes[len(runes)-progressPathMax+1:])
+}
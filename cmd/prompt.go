@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Bool("nonInteractive", false, "Never prompt, fail instead when something isn't given by a "+
+		"flag, setting or stored value. For cron jobs and containers")
+	rootCmd.PersistentFlags().BoolP("yes", "y", false, "Answer yes to confirmations, e.g. syncing into a folder that isn't empty")
+}
+
+var (
+	nonInteractive bool // Prompts fail instead of reading stdin
+	assumeYes      bool // Confirmations are answered yes without asking
+)
+
+// applyInteractivity reads --nonInteractive and --yes
+func applyInteractivity(cmd *cobra.Command) {
+	nonInteractive, _ = cmd.Flags().GetBool("nonInteractive")
+	assumeYes, _ = cmd.Flags().GetBool("yes")
+}
+
+// errNonInteractive is the error for input that's needed while prompts are off. hint says how to give it instead.
+func errNonInteractive(what string, hint string) error {
+	return fmt.Errorf("%s is needed but --nonInteractive is set, %s", what, hint)
+}
+
+// ask prompts for value, or fails with --nonInteractive. what names the value and hint says how to give it instead.
+func ask(prompt string, value *string, what string, hint string) error {
+	if nonInteractive {
+		return errNonInteractive(what, hint)
+	}
+	promptFor(prompt, value)
+	return nil
+}
+
+// confirm asks a yes or no question, defaulting to no. --yes answers it without asking, otherwise it fails with
+// --nonInteractive and hint says how to allow what's being confirmed.
+func confirm(question string, hint string) (bool, error) {
+	if assumeYes {
+		return true, nil
+	}
+	if nonInteractive {
+		return false, errNonInteractive("confirmation", hint)
+	}
+	var answer string
+	promptFor(question+" [y/N]: ", &answer)
+	return answer == "y" || answer == "Y", nil
+}
+
+// askSecret is ask without echoing what's typed
+func askSecret(prompt string, value *string, what string, hint string) error {
+	if nonInteractive {
+		return errNonInteractive(what, hint)
+	}
+	promptForSecret(prompt, value)
+	return nil
+}
+
+// promptForSecret is promptFor without echoing what's typed. Input that isn't from a terminal, e.g. a pipe, is read
+// like any other.
+func promptForSecret(prompt string, value *string) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		promptFor(prompt, value)
+		return
+	}
+	fmt.Print(prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		fmt.Printf("Error reading input: %s\n", err)
+		return
+	}
+	*value = string(secret)
+}
+
+func promptFor(prompt string, value *string) {
+	fmt.Print(prompt)
+	_, err := fmt.Scanln(value)
+	// TODO: Support empty input (throws unexpected newline error)
+	if err != nil {
+		fmt.Printf("Error reading input: %s\n", err)
+		return
+	}
+}
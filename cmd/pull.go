@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	pullCmd.Flags().StringP("vaultId", "v", "", "Vault ID to pull from")
+	pullCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(pullCmd)
+	pullCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	pullCmd.Args = cobra.RangeArgs(1, 2)
+	rootCmd.AddCommand(pullCmd)
+}
+
+var pullCmd = &cobra.Command{
+	Use:   "pull [remote path] [dest]",
+	Short: "Download a single file or folder from a vault",
+	Long: "Download the file at a path in the vault, or every file under a folder, without running a sync. A file is " +
+		"written to dest, or into it if it's a folder. dest defaults to the name of the remote path in the working directory",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		remotePath := args[0]
+		dest := ""
+		if len(args) > 1 {
+			dest = args[1]
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		result, err := sync.FetchPathContext(c, remotePath, dest, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			fmt.Printf("Error pulling %s: %s\n", remotePath, err)
+			return
+		}
+		fmt.Printf("📥 Pulled %d files (%s) and %d folders to %s\n", result.Files, formatMB(result.Bytes), result.Folders, result.Dest)
+	},
+}
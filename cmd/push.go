@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	pushCmd.Flags().StringP("vaultId", "v", "", "Vault ID to push to")
+	pushCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(pushCmd)
+	pushCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	pushCmd.Flags().Bool("overwrite", false, "Replace the file if the vault already has one at the remote path")
+	pushCmd.Args = cobra.RangeArgs(1, 2)
+	rootCmd.AddCommand(pushCmd)
+}
+
+var pushCmd = &cobra.Command{
+	Use:   "push [local file] [remote path]",
+	Short: "Upload a single file to a vault",
+	Long: "Upload a file to a path in the vault without running a sync. The remote path defaults to the file's name at " +
+		"the root of the vault, and a remote path ending in a slash is a folder to upload into",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		localPath := args[0]
+		remotePath := ""
+		if len(args) > 1 {
+			remotePath = args[1]
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer creds.Wipe()
+		if creds.Scope == auth.ScopeReadOnly {
+			fmt.Println("Error: the stored token is read-only, login with full scope to push files")
+			return
+		}
+
+		c, stop := interruptContext()
+		defer stop()
+		result, err := sync.PushPathContext(c, localPath, remotePath, overwrite, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			fmt.Printf("Error pushing %s: %s\n", localPath, err)
+			return
+		}
+		verb := "Pushed"
+		if result.Replaced {
+			verb = "Replaced"
+		}
+		fmt.Printf("📤 %s %s (%s)\n", verb, result.Path, formatMB(result.Bytes))
+	},
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	quarantineListCmd.Args = cobra.ExactArgs(1)
+	quarantineReleaseCmd.Args = cobra.ExactArgs(2)
+	quarantineCmd.AddCommand(quarantineListCmd)
+	quarantineCmd.AddCommand(quarantineReleaseCmd)
+	rootCmd.AddCommand(quarantineCmd)
+}
+
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Manage files whose transfer was skipped",
+	Long: "Files whose transfer was skipped during a sync, by sending SIGUSR1 to the sync process, are quarantined and " +
+		"left alone by later syncs until they're released",
+}
+
+var quarantineListCmd = &cobra.Command{
+	Use:   "list [target path]",
+	Short: "List quarantined files",
+	Run: func(cmd *cobra.Command, args []string) {
+		state, ok := loadQuarantineState(args[0])
+		if !ok {
+			return
+		}
+		paths := state.QuarantinedPaths()
+		if len(paths) == 0 {
+			fmt.Println("No quarantined files")
+			return
+		}
+		for _, path := range paths {
+			file := state.Quarantined[path]
+			fmt.Printf("%s\t%s skipped %s\n", path, file.Phase, file.At.Format(time.RFC3339))
+		}
+	},
+}
+
+var quarantineReleaseCmd = &cobra.Command{
+	Use:   "release [target path] [file path]",
+	Short: "Let a quarantined file sync again",
+	Long:  "Let a quarantined file sync again on the next sync. Stop any daemon syncing the folder first, it keeps its own copy of the quarantine",
+	Run: func(cmd *cobra.Command, args []string) {
+		state, ok := loadQuarantineState(args[0])
+		if !ok {
+			return
+		}
+		if !state.ReleaseQuarantined(args[1]) {
+			fmt.Printf("%s isn't quarantined\n", args[1])
+			return
+		}
+		if err := state.Save(); err != nil {
+			fmt.Printf("Error saving state: %s\n", err)
+			return
+		}
+		fmt.Printf("✅ Released %s\n", args[1])
+	},
+}
+
+// loadQuarantineState loads the saved sync state of the target folder, printing why if it can't
+func loadQuarantineState(targetPath string) (*sync.State, bool) {
+	err := validateFolder(&targetPath, true)
+	if err != nil {
+		fmt.Printf("Invalid target: %s\n", err)
+		return nil, false
+	}
+	state, err := sync.LoadState(targetPath)
+	if err != nil {
+		fmt.Printf("Error loading state: %s\n", err)
+		return nil, false
+	}
+	if state == nil {
+		fmt.Printf("No sync state found for %s\n", targetPath)
+		return nil, false
+	}
+	return state, true
+}
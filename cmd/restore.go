@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	restoreCmd.Flags().StringP("vaultId", "v", "", "Vault ID of the file")
+	restoreCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(restoreCmd)
+	restoreCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	restoreCmd.Flags().Int64("version", 0, "UID of the version to restore, as listed by history. Defaults to the newest")
+	restoreCmd.Flags().Bool("deleted", false, "Restore a deleted file as it was before it was deleted")
+	restoreCmd.MarkFlagsMutuallyExclusive("version", "deleted")
+	restoreCmd.Args = cobra.RangeArgs(1, 2)
+	rootCmd.AddCommand(restoreCmd)
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [remote path] [dest]",
+	Short: "Download an older version of a file, or a deleted file",
+	Long: "Download a version of a file from the sync server's history, or the last version of a deleted file, without " +
+		"running a sync. The file is written to dest like pull writes it, push it to put the version back in the vault",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		uid, _ := cmd.Flags().GetInt64("version")
+		deleted, _ := cmd.Flags().GetBool("deleted")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		remotePath := args[0]
+		dest := ""
+		if len(args) > 1 {
+			dest = args[1]
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		result, err := sync.RestoreContext(c, remotePath, dest, uid, deleted, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			fmt.Printf("Error restoring %s: %s\n", remotePath, err)
+			return
+		}
+		fmt.Printf("⏪ Restored version %d from %s (%d bytes) to %s\n", result.Version.Uid,
+			versionTime(result.Version).Format(time.RFC3339), result.Bytes, result.Dest)
+	},
+}
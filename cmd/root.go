@@ -4,13 +4,21 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/nbadal/obsidian-sync/config"
 	"github.com/spf13/cobra"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "obsidian-sync",
 	Short: "A command line utility for Obsidian Sync",
-	Long:  "A command line utility for interacting with the Obsidian Sync API and syncing local files with the cloud",
+	Long: "A command line utility for interacting with the Obsidian Sync API and syncing local files with the cloud.\n\n" +
+		"Flags can also be set, in order of precedence, by an environment variable named after the flag, e.g. " +
+		"OBSIDIAN_SYNC_LOG_LEVEL for --logLevel, by the YAML settings file given by --config, and by preferences " +
+		"stored with config set. Passwords are OBSIDIAN_SYNC_ACCOUNT_PASSWORD for login and OBSIDIAN_SYNC_VAULT_PASSWORD " +
+		"for vaults, and secrets can't be in the settings file",
+	// Execute prints errors itself, and usage is noise for errors that aren't about arguments
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
 func Execute() {
@@ -20,14 +28,29 @@ func Execute() {
 	}
 }
 
-func init() {
-	// Here you will define your flags and configuration settings.
-	// Cobra supports persistent flags, which, if defined here,
-	// will be global for your application.
+// persistentPreRun runs before every command. Settings and preferences go first, since they can set the logging,
+// timeout, TLS and endpoint flags.
+func persistentPreRun(cmd *cobra.Command, args []string) error {
+	if err := applyPreferences(cmd, args); err != nil {
+		return err
+	}
+	applyInteractivity(cmd)
+	if err := applySecretSources(cmd); err != nil {
+		return err
+	}
+	if err := applyLogging(cmd); err != nil {
+		return err
+	}
+	applyNetwork(cmd)
+	if err := applyTLS(cmd); err != nil {
+		return err
+	}
+	return applyEndpoint(cmd)
+}
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.obsidian-sync.yaml)")
+func init() {
+	rootCmd.PersistentPreRunE = persistentPreRun
 
-	// Cobra also supports local flags, which will only run
-	// when this action is called directly.
-	//rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().String("config", "", "YAML settings file of flag names and values, defaults to config.yaml "+
+		"in the obsidian-sync config folder, e.g. ~/.config/obsidian-sync. Also set by "+config.SettingsEnv)
 }
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/client"
+	"github.com/nbadal/obsidian-sync/dav"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/webdav"
+)
+
+func init() {
+	serveWebdavCmd.Flags().Int("port", 8090, "Port to serve WebDAV on at 127.0.0.1")
+	serveWebdavCmd.Flags().StringP("vaultId", "v", "", "Serve this remote vault directly, instead of a synced folder")
+	serveWebdavCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(serveWebdavCmd)
+	serveWebdavCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	serveWebdavCmd.Flags().Bool("readOnly", false, "Refuse changes")
+	serveWebdavCmd.Args = cobra.MaximumNArgs(1)
+	serveCmd.AddCommand(serveWebdavCmd)
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve a vault to other programs",
+}
+
+var serveWebdavCmd = &cobra.Command{
+	Use:   "webdav [target path]",
+	Short: "Serve a vault over WebDAV on localhost",
+	Long: "Serve a synced folder over WebDAV, for editors and apps without Obsidian. Changes made over WebDAV are " +
+		"synced by the folder's daemon, or the next sync. With --vaultId, serve the remote vault directly instead: " +
+		"files are pulled when they're read and pushed when they're written. Runs until interrupted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Get flags
+		port, _ := cmd.Flags().GetInt("port")
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		readOnly, _ := cmd.Flags().GetBool("readOnly")
+
+		if (len(args) == 0) == (vaultId == "") {
+			return fmt.Errorf("pass either a target path or --vaultId")
+		}
+
+		c, stop := interruptContext()
+		defer stop()
+		if vaultId == "" {
+			targetPath := args[0]
+			if err := validateFolder(&targetPath, true); err != nil {
+				return fmt.Errorf("invalid target: %s", err)
+			}
+			if _, err := sync.QueryDaemon(targetPath); errors.Is(err, sync.ErrNoDaemon) {
+				fmt.Printf("⚠️ No daemon is syncing %s, changes are synced by the next sync\n", targetPath)
+			}
+			fmt.Printf("🌐 Serving %s at http://127.0.0.1:%d/\n", targetPath, port)
+			return dav.Serve(c, port, webdav.Dir(targetPath), readOnly)
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			return err
+		}
+		defer creds.Wipe()
+		if creds.Scope == auth.ScopeReadOnly && !readOnly {
+			fmt.Println("⚠️ The stored token is read-only, serving the vault read-only")
+			readOnly = true
+		}
+		vaultClient := client.New(creds.AuthToken.Reveal())
+		vaultClient.DeviceName = creds.DeviceName
+		vault, err := vaultClient.Connect(c, creds.Vault.Id, creds.Password.Reveal())
+		if err != nil {
+			return fmt.Errorf("error connecting to vault: %s", err)
+		}
+		defer vault.Close()
+		fmt.Printf("🌐 Serving %s at http://127.0.0.1:%d/\n", creds.Vault.Name, port)
+		return dav.Serve(c, port, dav.NewVaultFS(c, vault), readOnly)
+	},
+}
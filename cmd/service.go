@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/nbadal/obsidian-sync/config"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+// secretEnv are the environment variables that aren't written to service files, since anyone reading them would get
+// the secret
+var secretEnv = map[string]bool{
+	accountPasswordEnv:              true,
+	vaultPasswordEnv:                true,
+	"OBSIDIAN_SYNC_MIRROR_PASSWORD": true,
+	config.PassphraseEnv:            true,
+}
+
+func init() {
+	addSyncFlags(daemonInstallCmd)
+	daemonInstallCmd.Args = cobra.ExactArgs(1)
+	daemonUninstallCmd.Args = cobra.ExactArgs(1)
+	daemonCmd.AddCommand(daemonInstallCmd, daemonUninstallCmd)
+}
+
+var daemonInstallCmd = &cobra.Command{
+	Use:   "install [target path]",
+	Short: "Install a service running a daemon syncing a vault folder on login",
+	Long: "Write a systemd user unit on Linux, or a launchd agent on macOS, running sync --daemon for the folder with " +
+		"the flags of sync, restarting it when it stops, and start it. OBSIDIAN_SYNC_* environment variables are " +
+		"passed on, except the password and passphrase: use --passwordCommand or --rememberPassword",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, arg := range os.Args[1:] {
+			if arg == "--password" || strings.HasPrefix(arg, "--password=") {
+				return fmt.Errorf("--password would be written to the service file, use --passwordCommand or --rememberPassword")
+			}
+		}
+		targetPath, syncArgs, err := daemonArgs(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if executable, err = filepath.EvalSymlinks(executable); err != nil {
+			return err
+		}
+		svc, err := newService(targetPath)
+		if err != nil {
+			return err
+		}
+
+		var env []string
+		for _, variable := range os.Environ() {
+			name, _, _ := strings.Cut(variable, "=")
+			switch {
+			case secretEnv[name]:
+				fmt.Printf("⚠️ %s isn't written to the service file\n", name)
+			case strings.HasPrefix(name, "OBSIDIAN_SYNC_"), name == "PATH":
+				// PATH finds the tools of --passwordCommand and --tokenCommand
+				env = append(env, variable)
+			}
+		}
+		if err := svc.install(append([]string{executable}, syncArgs...), env); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Installed %s, syncing %s\n", svc.path, targetPath)
+		return nil
+	},
+}
+
+var daemonUninstallCmd = &cobra.Command{
+	Use:   "uninstall [target path]",
+	Short: "Stop and remove the service installed for a vault folder",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targetPath := args[0]
+		if err := validateFolder(&targetPath, true); err != nil {
+			return fmt.Errorf("invalid target: %s", err)
+		}
+		svc, err := newService(targetPath)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(svc.path); os.IsNotExist(err) {
+			return fmt.Errorf("no service is installed for %s", targetPath)
+		}
+		if err := svc.uninstall(); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Removed %s\n", svc.path)
+		return nil
+	},
+}
+
+// service is the systemd unit or launchd agent running the daemon for a vault folder
+type service struct {
+	name       string // Unit name or agent label
+	path       string // Unit or plist file
+	targetPath string
+	launchd    bool
+}
+
+// newService returns the service for the vault folder at targetPath on this platform. It's named after the folder's
+// sync state, so every folder gets its own.
+func newService(targetPath string) (service, error) {
+	statePath, err := sync.StatePath(targetPath)
+	if err != nil {
+		return service{}, err
+	}
+	id := strings.TrimSuffix(filepath.Base(statePath), ".json")
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return service{}, err
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return service{}, err
+		}
+		name := "obsidian-sync-" + id + ".service"
+		return service{name: name, path: filepath.Join(configDir, "systemd", "user", name), targetPath: targetPath}, nil
+	case "darwin":
+		name := "md.obsidian-sync." + id
+		path := filepath.Join(home, "Library", "LaunchAgents", name+".plist")
+		return service{name: name, path: path, targetPath: targetPath, launchd: true}, nil
+	default:
+		return service{}, fmt.Errorf("services can only be installed with systemd or launchd, not on %s", runtime.GOOS)
+	}
+}
+
+// install writes the service running command with env, and starts it
+func (s service) install(command []string, env []string) error {
+	var contents []byte
+	var err error
+	if s.launchd {
+		contents, err = s.plist(command, env)
+	} else {
+		contents, err = s.unit(command, env)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A service replaced by a reinstall must be stopped with its old definition
+	if _, err := os.Stat(s.path); err == nil {
+		if err := s.uninstall(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	// The command line can have a token command, so it's kept private
+	if err := os.WriteFile(s.path, contents, 0600); err != nil {
+		return fmt.Errorf("error writing %s: %s", s.path, err)
+	}
+
+	if s.launchd {
+		return runServiceTool("launchctl", "load", "-w", s.path)
+	}
+	if err := runServiceTool("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return runServiceTool("systemctl", "--user", "enable", "--now", s.name)
+}
+
+// uninstall stops the service and removes its file
+func (s service) uninstall() error {
+	if s.launchd {
+		if err := runServiceTool("launchctl", "unload", "-w", s.path); err != nil {
+			return err
+		}
+		return os.Remove(s.path)
+	}
+	if err := runServiceTool("systemctl", "--user", "disable", "--now", s.name); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return runServiceTool("systemctl", "--user", "daemon-reload")
+}
+
+// runServiceTool runs systemctl or launchctl, failing with what it printed
+func runServiceTool(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running %s %s: %s %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unitTemplate is the systemd user unit. sync doesn't always exit non-zero when it fails, so it's always restarted,
+// after a pause so a daemon that can't start doesn't spin.
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=Obsidian Sync for {{.Target}}
+
+[Service]
+ExecStart={{.Command}}
+{{range .Env}}Environment={{.}}
+{{end}}Restart=always
+RestartSec=30
+
+[Install]
+WantedBy=default.target
+`))
+
+// unit returns the systemd unit running command with env
+func (s service) unit(command []string, env []string) ([]byte, error) {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = systemdQuote(arg)
+	}
+	quotedEnv := make([]string, len(env))
+	for i, variable := range env {
+		quotedEnv[i] = systemdQuote(variable)
+	}
+	var unit bytes.Buffer
+	err := unitTemplate.Execute(&unit, struct {
+		Target  string
+		Command string
+		Env     []string
+	}{strings.ReplaceAll(s.targetPath, "%", "%%"), strings.Join(quoted, " "), quotedEnv})
+	return unit.Bytes(), err
+}
+
+// systemdQuote quotes a word of a unit's command line or environment, escaping what systemd would expand
+func systemdQuote(word string) string {
+	word = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$", "\n", `\n`).Replace(word)
+	return `"` + word + `"`
+}
+
+// plistTemplate is the launchd agent. It's restarted whenever it stops, launchd throttles restarts of a daemon that
+// can't start.
+var plistTemplate = template.Must(template.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{range .Command}}		<string>{{.}}</string>
+{{end}}	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+{{range .Env}}		<key>{{.Name}}</key>
+		<string>{{.Value}}</string>
+{{end}}	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ThrottleInterval</key>
+	<integer>30</integer>
+	<key>ExitTimeOut</key>
+	<integer>45</integer>
+	<key>StandardOutPath</key>
+	<string>{{.Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{.Log}}</string>
+</dict>
+</plist>
+`))
+
+// plist returns the launchd agent running command with env, logging where daemon start would
+func (s service) plist(command []string, env []string) ([]byte, error) {
+	logPath, err := sync.DaemonLogPath(s.targetPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return nil, err
+	}
+
+	type variable struct{ Name, Value string }
+	data := struct {
+		Label   string
+		Command []string
+		Env     []variable
+		Log     string
+	}{Label: xmlEscape(s.name), Log: xmlEscape(logPath)}
+	for _, arg := range command {
+		data.Command = append(data.Command, xmlEscape(arg))
+	}
+	for _, v := range env {
+		name, value, _ := strings.Cut(v, "=")
+		data.Env = append(data.Env, variable{xmlEscape(name), xmlEscape(value)})
+	}
+	var plist bytes.Buffer
+	err = plistTemplate.Execute(&plist, data)
+	return plist.Bytes(), err
+}
+
+// xmlEscape escapes text for a plist element
+func xmlEscape(text string) string {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	vaultShareCmd.PersistentFlags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	vaultShareListCmd.Args = cobra.ExactArgs(1)
+	vaultShareInviteCmd.Args = cobra.ExactArgs(2)
+	vaultShareRevokeCmd.Args = cobra.ExactArgs(2)
+	vaultShareCmd.AddCommand(vaultShareListCmd)
+	vaultShareCmd.AddCommand(vaultShareInviteCmd)
+	vaultShareCmd.AddCommand(vaultShareRevokeCmd)
+	vaultCmd.AddCommand(vaultShareCmd)
+}
+
+var vaultShareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "Manage who a vault is shared with",
+}
+
+var vaultShareListCmd = &cobra.Command{
+	Use:   "list [vault ID]",
+	Short: "List the users a vault is shared with",
+	Run: func(cmd *cobra.Command, args []string) {
+		authToken, ok := loadShareToken(cmd, false)
+		if !ok {
+			return
+		}
+		defer authToken.Wipe()
+
+		shares, err := api.ListShares(authToken, args[0])
+		if err != nil {
+			fmt.Printf("Error listing shares: %s\n", err)
+			return
+		}
+		if len(shares) == 0 {
+			fmt.Println("Not shared with anyone")
+			return
+		}
+		for _, share := range shares {
+			status := "accepted"
+			if !share.Accepted {
+				status = "invited"
+			}
+			fmt.Printf("%s\t%s\t%s\n", share.Email, share.Name, status)
+		}
+	},
+}
+
+var vaultShareInviteCmd = &cobra.Command{
+	Use:   "invite [vault ID] [email]",
+	Short: "Invite a collaborator to a vault",
+	Long:  "Invite a collaborator to a vault by email. They get access once they accept the invite in Obsidian",
+	Run: func(cmd *cobra.Command, args []string) {
+		authToken, ok := loadShareToken(cmd, true)
+		if !ok {
+			return
+		}
+		defer authToken.Wipe()
+
+		if err := api.InviteShare(authToken, args[0], args[1]); err != nil {
+			fmt.Printf("Error inviting %s: %s\n", args[1], err)
+			return
+		}
+		fmt.Printf("✉️ Invited %s\n", args[1])
+	},
+}
+
+var vaultShareRevokeCmd = &cobra.Command{
+	Use:   "revoke [vault ID] [email]",
+	Short: "Revoke a collaborator's access to a vault",
+	Long:  "Revoke a collaborator's access to a vault, or cancel their pending invite. Files they already synced stay on their devices",
+	Run: func(cmd *cobra.Command, args []string) {
+		authToken, ok := loadShareToken(cmd, true)
+		if !ok {
+			return
+		}
+		defer authToken.Wipe()
+
+		// Shares are removed by their UID, find it from the email
+		shares, err := api.ListShares(authToken, args[0])
+		if err != nil {
+			fmt.Printf("Error listing shares: %s\n", err)
+			return
+		}
+		shareUid := ""
+		for _, share := range shares {
+			if share.Email == args[1] || share.Uid == args[1] {
+				shareUid = share.Uid
+			}
+		}
+		if shareUid == "" {
+			fmt.Printf("Error: vault isn't shared with %s\n", args[1])
+			return
+		}
+
+		if err := api.RemoveShare(authToken, args[0], shareUid); err != nil {
+			fmt.Printf("Error revoking access: %s\n", err)
+			return
+		}
+		fmt.Printf("✅ Revoked access of %s\n", args[1])
+	},
+}
+
+// loadShareToken loads the auth token for a share command, printing why if it can't. Commands that change a vault's
+// shares need a token with full scope.
+func loadShareToken(cmd *cobra.Command, modifies bool) (*crypto.Secret, bool) {
+	tokenFlag, _ := cmd.Flags().GetString("authToken")
+	authToken, scope, err := loadAuthToken(tokenFlag)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return nil, false
+	}
+	if modifies && scope == auth.ScopeReadOnly {
+		authToken.Wipe()
+		fmt.Println("Error: the stored token is read-only, login with full scope to change shares")
+		return nil, false
+	}
+	return authToken, true
+}
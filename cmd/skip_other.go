@@ -0,0 +1,8 @@
+//go:build !unix
+
+package cmd
+
+// notifySkips returns nil, there's no signal to skip transfers with on this platform
+func notifySkips() <-chan struct{} {
+	return nil
+}
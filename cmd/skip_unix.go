@@ -0,0 +1,22 @@
+//go:build unix
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySkips returns a channel that receives each time the process gets SIGUSR1, to skip the transfer in progress
+func notifySkips() <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	skips := make(chan struct{})
+	go func() {
+		for range signals {
+			skips <- struct{}{}
+		}
+	}()
+	return skips
+}
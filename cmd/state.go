@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	stateDumpCmd.Flags().Bool("redact", false, "Hash paths and omit names so the dump can be shared")
+	stateDumpCmd.Args = cobra.ExactArgs(1)
+	stateCmd.AddCommand(stateDumpCmd)
+	rootCmd.AddCommand(stateCmd)
+}
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect the local sync state",
+}
+
+var stateDumpCmd = &cobra.Command{
+	Use:   "dump [target path]",
+	Short: "Print the saved sync state as JSON",
+	Long:  "Print the saved sync state of a vault folder as JSON. Use --redact to produce a snapshot safe to attach to bug reports",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		redact, _ := cmd.Flags().GetBool("redact")
+
+		// Get args
+		targetPath := args[0]
+		err := validateFolder(&targetPath, true)
+		if err != nil {
+			fmt.Printf("Invalid target: %s\n", err)
+			return
+		}
+
+		state, err := sync.LoadState(targetPath)
+		if err != nil {
+			fmt.Printf("Error loading state: %s\n", err)
+			return
+		}
+		if state == nil {
+			fmt.Printf("No sync state found for %s\n", targetPath)
+			return
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(state.Dump(redact)); err != nil {
+			fmt.Printf("Error encoding state: %s\n", err)
+		}
+	},
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	statsCmd.Flags().StringP("vaultId", "v", "", "Vault ID to report on")
+	statsCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(statsCmd)
+	statsCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	statsCmd.Flags().Int("top", 10, "How many of the largest files to list")
+	rootCmd.AddCommand(statsCmd)
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Break down what a vault stores",
+	Long: "Count a vault's files by extension, list its largest files and show how much of its quota is used, to " +
+		"find what takes up the space. Sizes are of the encrypted files, as the quota counts them. Nothing is downloaded",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		top, _ := cmd.Flags().GetInt("top")
+		asJSON := jsonOutput(cmd)
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			printError(cmd, "Error: %s", err)
+			return
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		stats, err := sync.StatsContext(c, top, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			printError(cmd, "Error getting vault stats: %s", err)
+			return
+		}
+
+		if asJSON {
+			printJSON(stats)
+			return
+		}
+		fmt.Printf("📊 %s: %d files in %d folders, %s\n", creds.Vault.Name, stats.Files, stats.Folders, formatMB(stats.Bytes))
+		if stats.Limit > 0 {
+			fmt.Printf("Using %s of %s (%.1f%%)\n", formatMB(stats.Used), formatMB(stats.Limit), float64(stats.Used)*100/float64(stats.Limit))
+		}
+		if stats.Used > stats.Bytes {
+			// The server counts more than the current files, e.g. the versions it keeps of changed and deleted ones
+			fmt.Printf("%s is used beyond the current files, e.g. by version history\n", formatMB(stats.Used-stats.Bytes))
+		}
+
+		if len(stats.Extensions) > 0 {
+			fmt.Println()
+			writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(writer, "EXTENSION\tFILES\tSIZE\tSHARE")
+			for _, ext := range stats.Extensions {
+				name := ext.Extension
+				if name == "" {
+					name = "(none)"
+				}
+				fmt.Fprintf(writer, "%s\t%d\t%s\t%.1f%%\n", name, ext.Files, formatMB(ext.Bytes), percent(ext.Bytes, stats.Bytes))
+			}
+			_ = writer.Flush()
+		}
+
+		if len(stats.Largest) > 0 {
+			fmt.Println()
+			writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(writer, "LARGEST FILES\tSIZE")
+			for _, file := range stats.Largest {
+				fmt.Fprintf(writer, "%s\t%s\n", file.Path, formatMB(file.Bytes))
+			}
+			_ = writer.Flush()
+		}
+	},
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	statusCmd.Args = cobra.ExactArgs(1)
+	rootCmd.AddCommand(statusCmd)
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [target path]",
+	Short: "Show what a sync of a vault folder would do",
+	Long: "Show the last sync time, local changes not synced yet, remote changes not applied yet, conflicts, quota usage " +
+		"and whether a daemon is running, like git status for a vault folder. Works offline from the saved state, so " +
+		"remote changes made since the last sync aren't known",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		asJSON := jsonOutput(cmd)
+
+		// Get args
+		targetPath := args[0]
+		err := validateFolder(&targetPath, true)
+		if err != nil {
+			printError(cmd, "Invalid target: %s", err)
+			return
+		}
+
+		status, err := sync.GetStatus(targetPath)
+		if err != nil {
+			printError(cmd, "Error reading status: %s", err)
+			return
+		}
+		if status == nil {
+			printError(cmd, "No sync state found for %s", targetPath)
+			return
+		}
+
+		if asJSON {
+			printJSON(status)
+			return
+		}
+		printStatus(status)
+	},
+}
+
+// printStatus prints a status for people to read
+func printStatus(status *sync.Status) {
+	fmt.Printf("Vault %s in %s\n", status.VaultId, status.TargetPath)
+	if status.LastSync.IsZero() {
+		fmt.Println("Never synced")
+	} else {
+		fmt.Printf("Last synced %s (%s ago)\n", status.LastSync.Format("2006-01-02 15:04:05"), time.Since(status.LastSync).Round(time.Second))
+	}
+	if status.Limit > 0 {
+		fmt.Printf("Using %s of %s (%.1f%%)\n", formatMB(status.Size), formatMB(status.Limit), float64(status.Size)*100/float64(status.Limit))
+	}
+	daemon := status.Daemon
+	switch {
+	case daemon.Running && daemon.Degraded:
+		fmt.Printf("Daemon running as PID %d in degraded mode, a self-check is failing\n", daemon.Pid)
+	case daemon.Running:
+		fmt.Printf("Daemon running as PID %d since %s\n", daemon.Pid, daemon.Started.Format("2006-01-02 15:04:05"))
+	case !daemon.Heartbeat.IsZero():
+		fmt.Printf("Daemon not running, PID %d stopped responding %s ago\n", daemon.Pid, time.Since(daemon.Heartbeat).Round(time.Second))
+	default:
+		fmt.Println("Daemon not running")
+	}
+
+	if status.Clean() {
+		fmt.Println("\nEverything is in sync")
+	}
+	printPaths("Local changes not synced yet",
+		pathGroup{"added", status.LocalAdded},
+		pathGroup{"modified", status.LocalModified},
+		pathGroup{"deleted", status.LocalDeleted})
+	if len(status.RemotePending) > 0 || status.RemoteHidden > 0 {
+		fmt.Println("\nRemote changes not applied yet:")
+		for _, path := range status.RemotePending {
+			fmt.Printf("  %s\n", path)
+		}
+		if status.RemoteHidden > 0 {
+			fmt.Printf("  and %d files whose names are only known after connecting\n", status.RemoteHidden)
+		}
+	}
+	printPaths("Conflicts", pathGroup{"both changed", status.Conflicts})
+	printPaths("Quarantined, release with quarantine release", pathGroup{"skipped", status.Quarantined})
+}
+
+// pathGroup is a kind of change and the paths it applies to
+type pathGroup struct {
+	kind  string
+	paths []string
+}
+
+// printPaths prints a section of paths labeled by kind, if there are any
+func printPaths(title string, groups ...pathGroup) {
+	count := 0
+	for _, group := range groups {
+		count += len(group.paths)
+	}
+	if count == 0 {
+		return
+	}
+	fmt.Printf("\n%s:\n", title)
+	for _, group := range groups {
+		for _, path := range group.paths {
+			fmt.Printf("  %-13s %s\n", group.kind+":", path)
+		}
+	}
+}
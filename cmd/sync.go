@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/config"
+	"github.com/nbadal/obsidian-sync/crypto"
 	"github.com/nbadal/obsidian-sync/sync"
 	"github.com/spf13/cobra"
 	"io"
@@ -11,41 +15,203 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func init() {
-	syncCmd.Flags().StringP("vaultId", "v", "", "Vault ID to sync")
-	syncCmd.Flags().StringP("password", "p", "", "Password to decrypt vault")
-	syncCmd.Flags().StringP("authToken", "t", "", "Auth token to use")
-	syncCmd.Flags().BoolP("daemon", "d", false, "Run as a daemon, continuously syncing in the background")
-	syncCmd.Flags().BoolP("force", "f", false, "Force sync, even if folder is not empty")
+	addSyncFlags(syncCmd)
+	syncCmd.Flags().BoolP("daemon", "d", false, "Run as a daemon in the foreground, continuously syncing. Use daemon start to run one in the background")
+	syncCmd.Flags().Bool("events", false, "Print a JSON object per file pulled, pushed or deleted, conflict and error on stdout, for jq or log shippers. Log lines go to stderr")
 	syncCmd.Args = cobra.ExactArgs(1)
 	rootCmd.AddCommand(syncCmd)
 }
 
+// addSyncFlags adds the flags of a sync, which daemon start passes on to the daemon it starts
+func addSyncFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("vaultId", "v", "", "Vault ID to sync")
+	cmd.Flags().StringP("password", "p", "", "Password to decrypt vault")
+	addVaultPasswordSources(cmd)
+	cmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	cmd.Flags().String("deviceName", "", "Device name other clients see for changes from this sync, defaults to the configured name")
+	cmd.Flags().String("cacheDir", "", "Cache directory made by prime, used instead of pulling cached files")
+	cmd.Flags().String("conflictTemplate", sync.DefaultConflictTemplate, "Name for conflict copies, using {name}, {ext}, {device}, {date}, {time} and {counter}")
+	cmd.Flags().Bool("fullInit", false, "Receive the whole remote index instead of resuming from the last sync")
+	cmd.Flags().String("preSyncHook", "", "Shell command to run before each sync pass. Hooks get the event's details in OBSIDIAN_SYNC_EVENT_* environment variables")
+	cmd.Flags().String("postSyncHook", "", "Shell command to run after each sync pass that succeeded, e.g. to rebuild a site, with the counts of ADDED, MODIFIED and DELETED files")
+	cmd.Flags().String("conflictHook", "", "Shell command to run for each file changed on both sides, with its PATH")
+	cmd.Flags().String("errorHook", "", "Shell command to run when a sync pass fails, with the ERROR")
+	cmd.Flags().String("fileChangedHook", "", "Shell command to run for each file a sync pass changed locally, with its PATH and the CHANGE: added, modified or deleted")
+	cmd.Flags().Bool("gitCommit", false, "Commit the folder to a git repository in it after each sync pass that changed something, creating the repository if needed")
+	cmd.Flags().String("gitMessage", sync.DefaultGitMessage, "Commit message for --gitCommit, using {device}, {uid}, {date}, {time}, {added}, {modified} and {deleted}")
+	addMirrorFlags(cmd)
+	cmd.Flags().String("journalDir", "", "Write a JSON list of the files each sync changed to this directory, for backup tools")
+	cmd.Flags().Bool("forceUnlock", false, "Sync even if the folder's lock says another sync is running, e.g. after a crash on a network drive")
+	cmd.Flags().Bool("rebind", false, "If the folder was moved, reuse its sync state without asking")
+	cmd.Flags().Bool("readOnly", false, "Only pull remote changes, never push or delete anything in the vault")
+	cmd.Flags().Bool("rememberPassword", false, "Store the vault password in the encrypted config for future syncs")
+	cmd.Flags().BoolP("force", "f", false, "Force sync, even if folder is not empty")
+	cmd.Flags().StringArray("include", nil, "Glob of the only paths to sync, e.g. \"*.md\". A pattern without a slash matches names in any folder. Repeatable")
+	cmd.Flags().StringArray("exclude", nil, "Glob of paths to leave alone in both directions, e.g. \"attachments/**\". Wins over --include. Repeatable")
+	cmd.Flags().StringSlice("fileTypes", nil, "Attachment types to sync along with notes, canvases and the .obsidian config folder, like the "+
+		"file types chosen in the app's sync settings: image, audio, video, pdf, other or all. Defaults to none")
+	cmd.Flags().StringArray("priority", nil, "Glob of paths to sync first, e.g. \"Daily Notes/**\". Repeat in order of priority")
+	cmd.Flags().Bool("progress", true, "Draw progress bars for transfers when stdout is a terminal, otherwise only log lines are printed")
+	cmd.Flags().Int("parallel", 4, "Number of files to apply at once. Transfers share one connection, so this mostly overlaps disk work and decryption")
+	cmd.Flags().Bool("skipOverQuota", false, "Skip files that would exceed the vault size limit instead of failing")
+	cmd.Flags().Int64("evictBelow", 0, "Evict least-recently-accessed attachments when free disk space drops below this many MB")
+	cmd.Flags().Int64("evictMinSize", 1, "Minimum attachment size in MB to consider for eviction")
+	cmd.Flags().StringArray("fetch", nil, "Path of an evicted attachment to download again before syncing. It isn't evicted again while the sync runs. Repeatable")
+	cmd.Flags().Duration("sweepInterval", 30*time.Minute, "How often a daemon reconciles the whole vault against the folder, catching changes it missed. Zero never does")
+	cmd.Flags().Duration("debounce", sync.DefaultDebounce, "How long a daemon waits for the vault or the folder to go quiet after a change before syncing, so a burst of saves syncs in one pass. Zero syncs each change right away")
+	cmd.Flags().Duration("pollInterval", sync.DefaultPollInterval, "How often a daemon checks the folder for local changes to push. Zero only pushes them along with remote changes and sweeps")
+	cmd.Flags().Duration("mtimeTolerance", sync.DefaultModifiedTolerance, "Modification times this close to those a file last synced with are checked by content rather than counted as a change, absorbing clock skew and rounding between devices")
+	cmd.Flags().Int("statusPort", 0, "Port for a daemon to serve /healthz, /status, Prometheus /metrics and POST /pause and /resume on at 127.0.0.1, for monitoring. Zero doesn't")
+	cmd.Flags().Duration("trashMaxAge", 0, "Prune trash files older than this duration after each sync")
+	cmd.Flags().Int64("trashMaxSize", 0, "Prune the oldest trash files once trash exceeds this many MB")
+}
+
 var syncCmd = &cobra.Command{
 	Use:   "sync [target path]",
 	Short: "Sync local files with the cloud",
-	Long:  "Sync local files with the cloud, uploading local changes and downloading remote changes",
+	Long: "Sync local files with the cloud, uploading local changes and downloading remote changes. Send SIGUSR1 to " +
+		"skip a stuck file transfer, the file is quarantined until released with the quarantine command",
 	Run: func(cmd *cobra.Command, args []string) {
 		// Get flags
-		vault, _ := cmd.Flags().GetString("vault")
+		vault, _ := cmd.Flags().GetString("vaultId")
 		password, _ := cmd.Flags().GetString("password")
 		authToken, _ := cmd.Flags().GetString("authToken")
 		daemon, _ := cmd.Flags().GetBool("daemon")
+		rememberPassword, _ := cmd.Flags().GetBool("rememberPassword")
+		readOnly, _ := cmd.Flags().GetBool("readOnly")
+		deviceName, _ := cmd.Flags().GetString("deviceName")
+		cacheDir, _ := cmd.Flags().GetString("cacheDir")
+		rebind, _ := cmd.Flags().GetBool("rebind")
+		forceUnlock, _ := cmd.Flags().GetBool("forceUnlock")
+		fullInit, _ := cmd.Flags().GetBool("fullInit")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		conflictTemplate, _ := cmd.Flags().GetString("conflictTemplate")
 		force, _ := cmd.Flags().GetBool("force")
+		skipOverQuota, _ := cmd.Flags().GetBool("skipOverQuota")
+		priorities, _ := cmd.Flags().GetStringArray("priority")
+		include, _ := cmd.Flags().GetStringArray("include")
+		exclude, _ := cmd.Flags().GetStringArray("exclude")
+		fileTypeNames, _ := cmd.Flags().GetStringSlice("fileTypes")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		journalDir, _ := cmd.Flags().GetString("journalDir")
+		var hooks sync.Hooks
+		hooks.PreSync, _ = cmd.Flags().GetString("preSyncHook")
+		hooks.PostSync, _ = cmd.Flags().GetString("postSyncHook")
+		hooks.OnConflict, _ = cmd.Flags().GetString("conflictHook")
+		hooks.OnError, _ = cmd.Flags().GetString("errorHook")
+		hooks.OnFileChanged, _ = cmd.Flags().GetString("fileChangedHook")
+		evictBelow, _ := cmd.Flags().GetInt64("evictBelow")
+		evictMinSize, _ := cmd.Flags().GetInt64("evictMinSize")
+		fetch, _ := cmd.Flags().GetStringArray("fetch")
+		sweepInterval, _ := cmd.Flags().GetDuration("sweepInterval")
+		mtimeTolerance, _ := cmd.Flags().GetDuration("mtimeTolerance")
+		debounce, _ := cmd.Flags().GetDuration("debounce")
+		pollInterval, _ := cmd.Flags().GetDuration("pollInterval")
+		statusPort, _ := cmd.Flags().GetInt("statusPort")
+		trashMaxAge, _ := cmd.Flags().GetDuration("trashMaxAge")
+		trashMaxSize, _ := cmd.Flags().GetInt64("trashMaxSize")
+		progress, _ := cmd.Flags().GetBool("progress")
+		gitCommit, _ := cmd.Flags().GetBool("gitCommit")
+		gitMessage, _ := cmd.Flags().GetString("gitMessage")
+
+		fileTypes, err := sync.ParseFileTypes(fileTypeNames)
+		if err != nil {
+			printError(cmd, "Error: %s", err)
+			return
+		}
+		mirror, mirrorPassword, err := mirrorFromFlags(cmd)
+		if err != nil {
+			printError(cmd, "Error: %s", err)
+			return
+		}
+
+		opts := sync.Options{
+			Daemon:        daemon,
+			ReadOnly:      readOnly,
+			DeviceName:    deviceName,
+			CacheDir:      cacheDir,
+			SkipOverQuota: skipOverQuota,
+			Priorities:    priorities,
+			Include:       include,
+			Exclude:       exclude,
+			SkipFileTypes: sync.OtherFileTypes(fileTypes),
+			FullInit:      fullInit,
+			Timeout:       timeout,
+			Parallelism:   parallel,
+			JournalDir:    journalDir,
+			Hooks:         hooks,
+			GitCommit:     gitCommit,
+			GitMessage:    gitMessage,
+			Mirror:        mirror,
+			SweepInterval: sweepInterval,
+			StatusPort:    statusPort,
+			ForceUnlock:   forceUnlock,
+			SkipRequests:  notifySkips(),
+			Eviction: sync.EvictionPolicy{
+				MinFreeBytes: evictBelow * 1024 * 1024,
+				MinFileSize:  evictMinSize * 1024 * 1024,
+			},
+			Fetch: fetch,
+			Retention: sync.RetentionPolicy{
+				MaxAge:  trashMaxAge,
+				MaxSize: trashMaxSize * 1024 * 1024,
+			},
+			MirrorPassword:    mirrorPassword,
+			ModifiedTolerance: mtimeTolerance,
+			Debounce:          debounce,
+			PollInterval:      pollInterval,
+			ConflictTemplate:  conflictTemplate,
+			ConfirmRebind: func(oldPath string, newPath string) bool {
+				if rebind || assumeYes {
+					return true
+				}
+				if nonInteractive {
+					// Syncing from scratch is safe, it only takes longer
+					return false
+				}
+				fmt.Printf("%s was previously synced at %s.\n", newPath, oldPath)
+				var confirm string
+				promptFor("Reuse its sync state? [Y/n]: ", &confirm)
+				return confirm != "n" && confirm != "N"
+			},
+		}
+
+		if events, _ := cmd.Flags().GetBool("events"); events {
+			// Stdout is only events, one per line
+			if jsonOutput(cmd) {
+				api.SetLogger(api.NewJSONLogger(os.Stderr, logLevel))
+			} else {
+				api.SetLogger(api.NewTextLogger(os.Stderr, logLevel))
+			}
+			opts.Events = newEventPrinter()
+			progress = false
+		}
+		if progress && logLevel <= api.LevelInfo && !jsonOutput(cmd) && isTerminal(os.Stdout) {
+			// Log lines go through the bars so they're printed above them
+			bars := newProgressBars(os.Stdout)
+			opts.Progress = bars
+			api.SetLogger(api.NewTextLogger(bars, logLevel))
+		}
 
 		// Get args
 		targetPath := args[0]
-		err := validateFolder(&targetPath, force)
+		err = validateFolder(&targetPath, force)
 		if err != nil {
-			fmt.Printf("Invalid target: %s\n", err)
+			printError(cmd, "Invalid target: %s", err)
 			return
 		}
 
-		err = promptForNeededInfoThenSync(targetPath, authToken, vault, password, daemon)
+		err = promptForNeededInfoThenSync(targetPath, authToken, vault, password, rememberPassword, opts)
 		if err != nil {
-			fmt.Printf("Error syncing: %s\n", err)
+			printError(cmd, "Error syncing: %s", err)
+			if errors.Is(err, sync.ErrStopped) {
+				// Unlike other errors, scripts and service managers need to know the folder is only partly synced
+				os.Exit(exitStopped)
+			}
 			return
 		}
 	},
@@ -84,14 +250,19 @@ func validateFolder(targetPath *string, skipEmptyCheck bool) error {
 		return fmt.Errorf("target path is not a folder")
 	}
 
-	if !skipEmptyCheck {
+	// Folders we've synced before, even from another location, are expected to have files
+	if !skipEmptyCheck && !sync.IsSyncedFolder(*targetPath) {
 		// Check if folder is empty, and prompt for confirmation if not
 		_, err = file.Readdirnames(1)
 		if err != io.EOF {
-			fmt.Printf("Warning: target folder is not empty. Existing files may be overwritten.\n")
-			var confirm string
-			promptFor("Continue? [y/N]: ", &confirm)
-			if confirm != "y" && confirm != "Y" {
+			if !assumeYes {
+				fmt.Printf("Warning: target folder is not empty. Existing files may be overwritten.\n")
+			}
+			ok, err := confirm("Continue?", "pass --force or --yes to sync into a folder that isn't empty")
+			if err != nil {
+				return err
+			}
+			if !ok {
 				return fmt.Errorf("user cancelled")
 			}
 		}
@@ -100,18 +271,107 @@ func validateFolder(targetPath *string, skipEmptyCheck bool) error {
 	return nil
 }
 
-func promptForNeededInfoThenSync(targetPath, authToken, vaultId, password string, daemon bool) error {
-	if authToken == "" {
-		// TODO: Get auth token if needed from config file
-		return fmt.Errorf("no auth token provided")
+func promptForNeededInfoThenSync(targetPath, authToken, vaultId, password string, rememberPassword bool, opts sync.Options) error {
+	creds, err := promptForVaultCredentials(authToken, vaultId, password, rememberPassword)
+	if err != nil {
+		return err
+	}
+	defer creds.Wipe()
+	if creds.Scope == auth.ScopeReadOnly {
+		opts.ReadOnly = true
+	}
+	if opts.DeviceName == "" {
+		opts.DeviceName = creds.DeviceName
+	}
+	opts.PromptNewPassword = func(vault api.VaultInfo) (*crypto.Secret, error) {
+		var prompted string
+		err := askSecret(fmt.Sprintf("New password for vault %s: ", vault.Name), &prompted, "the new vault password", "pass it with --password")
+		if err != nil {
+			return nil, err
+		}
+		password := crypto.SecretString(prompted)
+
+		// Don't leave the old password behind in the config
+		if rememberPassword || creds.PasswordStored {
+			if err := auth.StoreVaultPassword(vault.Id, password); err != nil {
+				fmt.Printf("⚠️ Could not store the new vault password: %s\n", err)
+			}
+		}
+		return password, nil
 	}
 
+	// Sync
+	c, stop := interruptContext()
+	defer stop()
+	err = sync.SyncContext(c, targetPath, creds.AuthToken, creds.Vault, creds.Password, opts)
+	if errors.Is(err, sync.ErrStopped) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error syncing: %s", err)
+	}
+	return nil
+}
+
+// vaultCredentials is everything needed to connect to a vault
+type vaultCredentials struct {
+	AuthToken      *crypto.Secret
+	Scope          auth.Scope
+	Vault          api.VaultInfo
+	Password       *crypto.Secret
+	PasswordStored bool   // The password came from the config
+	DeviceName     string // Configured device name, may be empty
+}
+
+// Wipe clears the secrets once they're no longer needed
+func (c *vaultCredentials) Wipe() {
+	c.AuthToken.Wipe()
+	c.Password.Wipe()
+}
+
+// loadAuthToken returns the token from the flag or printed by --tokenCommand, or the one stored by login and its scope
+func loadAuthToken(tokenFlag string) (*crypto.Secret, auth.Scope, error) {
+	authToken := crypto.SecretString(tokenFlag)
+	if !authToken.Empty() {
+		return authToken, auth.ScopeFull, nil
+	}
+	if tokenCommand != "" {
+		token, err := runSecretCommand(tokenCommand)
+		if err != nil {
+			return nil, auth.ScopeFull, fmt.Errorf("error getting auth token: %s", err)
+		}
+		return crypto.SecretString(token), auth.ScopeFull, nil
+	}
+	session, err := auth.LoadSession()
+	if errors.Is(err, auth.ErrNotLoggedIn) {
+		return nil, auth.ScopeFull, fmt.Errorf("no auth token provided, run login first")
+	}
+	if err != nil {
+		return nil, auth.ScopeFull, fmt.Errorf("error loading stored auth token: %s", err)
+	}
+	return session.Token, session.Scope, nil
+}
+
+// promptForVaultCredentials fills in the auth token, vault and password from the config, prompting for anything
+// missing. The caller should wipe the returned credentials when done.
+func promptForVaultCredentials(tokenFlag, vaultId, passwordFlag string, rememberPassword bool) (*vaultCredentials, error) {
+	authToken, scope, err := loadAuthToken(tokenFlag)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %s", err)
+	}
 	// Select vault if needed
 	var vaultInfo api.VaultInfo
 	if vaultId == "" {
+		if nonInteractive {
+			return nil, errNonInteractive("a vault ID", "pass --vaultId")
+		}
 		vaults, err := api.ListVaults(authToken)
 		if err != nil {
-			return fmt.Errorf("error listing vaults: %s", err)
+			return nil, fmt.Errorf("error listing vaults: %s", err)
 		}
 
 		// Print out vaults and prompt for selection and select vault info
@@ -122,17 +382,17 @@ func promptForNeededInfoThenSync(targetPath, authToken, vaultId, password string
 		promptFor("Select vault: ", &vaultNum)
 		vaultNumInt, err := strconv.Atoi(vaultNum)
 		if err != nil {
-			return fmt.Errorf("error parsing vault number: %s", err)
+			return nil, fmt.Errorf("error parsing vault number: %s", err)
 		}
 		if vaultNumInt < 1 || vaultNumInt > len(vaults) {
-			return fmt.Errorf("invalid vault number")
+			return nil, fmt.Errorf("invalid vault number")
 		}
 		vaultInfo = vaults[vaultNumInt-1]
 	} else {
 		// Find vault info matching vault ID
 		vaults, err := api.ListVaults(authToken)
 		if err != nil {
-			return fmt.Errorf("error listing vaults: %s", err)
+			return nil, fmt.Errorf("error listing vaults: %s", err)
 		}
 		var vaultFound = false
 		for _, v := range vaults {
@@ -143,24 +403,44 @@ func promptForNeededInfoThenSync(targetPath, authToken, vaultId, password string
 			}
 		}
 		if !vaultFound {
-			return fmt.Errorf("vault not found")
+			return nil, fmt.Errorf("vault not found")
 		}
 	}
 
-	// Use password if set in vault info, otherwise prompt
-	if password == "" {
+	// Use password if set in vault info or stored, otherwise prompt
+	password := crypto.SecretString(passwordFlag)
+	passwordStored := false
+	if password.Empty() {
 		if vaultInfo.Password != "" {
-			password = vaultInfo.Password
+			password = crypto.SecretString(vaultInfo.Password)
 		} else {
-			promptFor("Vault Password: ", &password)
+			storedPassword, err := auth.LoadVaultPassword(vaultInfo.Id)
+			if err != nil {
+				return nil, fmt.Errorf("error loading stored vault password: %s", err)
+			}
+			password = storedPassword
+			passwordStored = !password.Empty()
+		}
+		if password.Empty() {
+			var prompted string
+			if err := askSecret("Vault Password: ", &prompted, "the vault password", "pass --password or store it with --rememberPassword"); err != nil {
+				return nil, err
+			}
+			password = crypto.SecretString(prompted)
 		}
 	}
-	vaultInfo.Password = password
-
-	// Sync
-	err := sync.Sync(targetPath, authToken, vaultInfo, password, daemon)
-	if err != nil {
-		return fmt.Errorf("error syncing: %s", err)
+	if rememberPassword {
+		if err := auth.StoreVaultPassword(vaultInfo.Id, password); err != nil {
+			return nil, fmt.Errorf("error storing vault password: %s", err)
+		}
 	}
-	return nil
+
+	return &vaultCredentials{
+		AuthToken:      authToken,
+		Scope:          scope,
+		Vault:          vaultInfo,
+		Password:       password,
+		PasswordStored: passwordStored,
+		DeviceName:     cfg.DeviceName,
+	}, nil
 }
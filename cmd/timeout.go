@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Give up on a sync or prime that takes longer than this, zero waits forever. Ignored by daemons")
+	rootCmd.PersistentFlags().Duration("connectTimeout", 30*time.Second, "Give up on connecting, or on an API request, after this long")
+	rootCmd.PersistentFlags().Duration("initTimeout", 0, "Give up on receiving the remote index after this long, zero waits forever")
+	rootCmd.PersistentFlags().Duration("transferTimeout", 0, "Give up on pulling or pushing a single file after this long, zero waits forever")
+	rootCmd.PersistentFlags().Duration("readTimeout", 5*time.Minute, "Give up when the server sends nothing for this long during an operation, zero waits forever")
+	rootCmd.PersistentFlags().Duration("writeTimeout", 2*time.Minute, "Give up on sending a single message after this long, zero waits forever")
+	rootCmd.PersistentFlags().Duration("pullHeaderTimeout", 0, "Give up on the header of a pull after this long, zero only applies readTimeout")
+	rootCmd.PersistentFlags().Duration("pieceTimeout", 0, "Give up on each piece of a pull after this long, zero only applies readTimeout")
+	rootCmd.PersistentFlags().Duration("pushAckTimeout", 0, "Give up on the server acknowledging a push after this long, zero only applies readTimeout")
+	rootCmd.PersistentFlags().Bool("disableCompression", false, "Don't negotiate websocket compression, so frames can be read in a packet capture")
+	rootCmd.PersistentFlags().Int("queueLimit", api.DefaultQueueLimit, "Reconnect and resync when more than this many frames of one kind go unread, zero never does")
+}
+
+// applyNetwork sets the network timeouts, frame queue limit and compression from the global flags
+func applyNetwork(cmd *cobra.Command) {
+	connect, _ := cmd.Flags().GetDuration("connectTimeout")
+	initTimeout, _ := cmd.Flags().GetDuration("initTimeout")
+	transfer, _ := cmd.Flags().GetDuration("transferTimeout")
+	read, _ := cmd.Flags().GetDuration("readTimeout")
+	write, _ := cmd.Flags().GetDuration("writeTimeout")
+	pullHeader, _ := cmd.Flags().GetDuration("pullHeaderTimeout")
+	piece, _ := cmd.Flags().GetDuration("pieceTimeout")
+	pushAck, _ := cmd.Flags().GetDuration("pushAckTimeout")
+	queueLimit, _ := cmd.Flags().GetInt("queueLimit")
+	disableCompression, _ := cmd.Flags().GetBool("disableCompression")
+	api.DefaultTimeouts = api.Timeouts{
+		Connect:    connect,
+		Init:       initTimeout,
+		Transfer:   transfer,
+		Read:       read,
+		Write:      write,
+		PullHeader: pullHeader,
+		Piece:      piece,
+		PushAck:    pushAck,
+	}
+	api.DefaultQueueLimit = queueLimit
+	api.Compression = !disableCompression
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.PersistentFlags().String("caBundle", "", "PEM file of the CAs to trust instead of the system's, e.g. for a TLS-intercepting proxy")
+	rootCmd.PersistentFlags().String("clientCert", "", "PEM file of a client certificate to present to the API and sync hosts, needs clientKey")
+	rootCmd.PersistentFlags().String("clientKey", "", "PEM file of the client certificate's private key")
+	rootCmd.PersistentFlags().StringSlice("pinSha256", nil, "Only trust hosts whose certificate chain has one of these base64 SHA-256 public key hashes")
+}
+
+// applyTLS sets up TLS for the API and sync hosts from the global flags
+func applyTLS(cmd *cobra.Command) error {
+	caBundle, _ := cmd.Flags().GetString("caBundle")
+	clientCert, _ := cmd.Flags().GetString("clientCert")
+	clientKey, _ := cmd.Flags().GetString("clientKey")
+	pins, _ := cmd.Flags().GetStringSlice("pinSha256")
+	return api.SetTLS(api.TLSOptions{
+		CABundle:   caBundle,
+		ClientCert: clientCert,
+		ClientKey:  clientKey,
+		Pins:       pins,
+	})
+}
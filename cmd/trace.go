@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+func init() {
+	traceCmd.Flags().Bool("indent", false, "Print JSON frames indented over several lines")
+	traceCmd.Args = cobra.ExactArgs(1)
+	rootCmd.AddCommand(traceCmd)
+}
+
+var traceCmd = &cobra.Command{
+	Use:   "trace [file]",
+	Short: "Pretty-print a websocket trace",
+	Long:  "Pretty-print a websocket trace recorded with --wsLog, one frame per line with its time and the time since the previous frame",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		indent, _ := cmd.Flags().GetBool("indent")
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			fmt.Printf("Error opening trace: %s\n", err)
+			return
+		}
+		defer file.Close()
+
+		var previous time.Time
+		err = api.ReadSessionTrace(file, func(entry api.TraceEntry) error {
+			since := time.Duration(0)
+			if !previous.IsZero() {
+				since = entry.Time.Sub(previous)
+			}
+			previous = entry.Time
+
+			direction := "⏪"
+			if entry.Outbound {
+				direction = "⏩"
+			}
+			fmt.Printf("%s %+8.3fs %s %s\n", entry.Time.Format("15:04:05.000"), since.Seconds(), direction, traceFrame(entry, indent))
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Error reading trace: %s\n", err)
+		}
+	},
+}
+
+// traceFrame formats the frame of a trace entry
+func traceFrame(entry api.TraceEntry, indent bool) string {
+	if entry.Binary() {
+		return fmt.Sprintf("Binary [%d]", entry.Size)
+	}
+	if !indent {
+		return string(entry.Frame)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, entry.Frame, "", "  "); err != nil {
+		return string(entry.Frame)
+	}
+	return out.String()
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func init() {
+	trashCmd.Flags().StringP("vaultId", "v", "", "Vault ID to list")
+	trashCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(trashCmd)
+	trashCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	rootCmd.AddCommand(trashCmd)
+}
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List the vault's deleted files",
+	Long: "List the files the sync server still tracks as deleted, with when and by which device they were deleted. " +
+		"Use restore --deleted to get one back",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		asJSON := jsonOutput(cmd)
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			printError(cmd, "Error: %s", err)
+			return
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		deleted, err := sync.ListDeletedContext(c, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			printError(cmd, "Error listing deleted files: %s", err)
+			return
+		}
+
+		if asJSON {
+			printJSON(deleted)
+			return
+		}
+		if len(deleted) == 0 {
+			fmt.Println("🗑️ No deleted files")
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "PATH\tDELETED\tDEVICE")
+		for _, file := range deleted {
+			path := file.Path
+			if path == "" {
+				path = fmt.Sprintf("(undecryptable, uid %d)", file.Uid)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", path, versionTime(file.FileVersion).Format(time.RFC3339), file.Device)
+		}
+		_ = writer.Flush()
+	},
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	vaultCreateCmd.Flags().StringP("password", "p", "", "Encryption password of the new vault")
+	addVaultPasswordSources(vaultCreateCmd)
+	vaultCreateCmd.Flags().String("region", "", "Region to host the vault in, the server picks one if empty")
+	vaultCreateCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	vaultCreateCmd.Flags().Bool("rememberPassword", false, "Store the vault password in the encrypted config")
+	vaultCreateCmd.Args = cobra.ExactArgs(1)
+	vaultDeleteCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	vaultDeleteCmd.Args = cobra.ExactArgs(1)
+	vaultCmd.AddCommand(vaultCreateCmd)
+	vaultCmd.AddCommand(vaultDeleteCmd)
+	rootCmd.AddCommand(vaultCmd)
+}
+
+var vaultCmd = &cobra.Command{
+	Use:   "vault",
+	Short: "Manage remote vaults",
+}
+
+var vaultCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create an end-to-end encrypted remote vault",
+	Long: "Create an end-to-end encrypted remote vault, ready to sync a folder into with sync --vaultId. The password " +
+		"can't be recovered, keep it somewhere safe",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		password, _ := cmd.Flags().GetString("password")
+		region, _ := cmd.Flags().GetString("region")
+		tokenFlag, _ := cmd.Flags().GetString("authToken")
+		rememberPassword, _ := cmd.Flags().GetBool("rememberPassword")
+
+		authToken, scope, err := loadAuthToken(tokenFlag)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer authToken.Wipe()
+		if scope == auth.ScopeReadOnly {
+			fmt.Println("Error: the stored token is read-only, login with full scope to create vaults")
+			return
+		}
+
+		if password == "" {
+			if err := askSecret("Vault password: ", &password, "a vault password", "pass --password"); err != nil {
+				fmt.Printf("Error: %s\n", err)
+				return
+			}
+		}
+		if password == "" {
+			fmt.Println("Error: a vault password is required")
+			return
+		}
+		secretPassword := crypto.SecretString(password)
+		defer secretPassword.Wipe()
+
+		// Derive the key the vault will be encrypted with, only its hash is sent
+		salt, err := crypto.NewSalt()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		cipher, err := crypto.NewVaultCipher(crypto.LatestEncryptionVersion, secretPassword, []byte(salt))
+		if err != nil {
+			fmt.Printf("Error deriving vault key: %s\n", err)
+			return
+		}
+
+		vault, err := api.CreateVault(authToken, args[0], cipher.KeyHash(), salt, region, crypto.LatestEncryptionVersion)
+		if err != nil {
+			fmt.Printf("Error creating vault: %s\n", err)
+			return
+		}
+		fmt.Printf("✅ Created vault %s with ID %s\n", args[0], vault.Id)
+
+		if rememberPassword {
+			if err := auth.StoreVaultPassword(vault.Id, secretPassword); err != nil {
+				fmt.Printf("Error storing vault password: %s\n", err)
+			}
+		}
+	},
+}
+
+var vaultDeleteCmd = &cobra.Command{
+	Use:   "delete [vault ID]",
+	Short: "Permanently delete a remote vault",
+	Long: "Permanently delete a remote vault and its version history. Local copies of the vault are left alone. " +
+		"Asks for confirmation unless --yes is set",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		tokenFlag, _ := cmd.Flags().GetString("authToken")
+		vaultId := args[0]
+
+		authToken, scope, err := loadAuthToken(tokenFlag)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer authToken.Wipe()
+		if scope == auth.ScopeReadOnly {
+			fmt.Println("Error: the stored token is read-only, login with full scope to delete vaults")
+			return
+		}
+
+		// Name the vault in the confirmation, IDs are easy to mix up
+		vaults, err := api.ListVaults(authToken)
+		if err != nil {
+			fmt.Printf("Error listing vaults: %s\n", err)
+			return
+		}
+		name := ""
+		for _, vault := range vaults {
+			if vault.Id == vaultId {
+				name = vault.Name
+			}
+		}
+		if name == "" {
+			fmt.Printf("Error: vault %s not found, only vaults you own can be deleted\n", vaultId)
+			return
+		}
+		if !assumeYes {
+			fmt.Printf("Vault %s (%s) and its version history will be deleted permanently.\n", name, vaultId)
+		}
+		ok, err := confirm("Continue?", "pass --yes to delete it")
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if !ok {
+			fmt.Println("Cancelled")
+			return
+		}
+
+		err = api.DeleteVault(authToken, vaultId)
+		switch {
+		case errors.Is(err, api.ErrVaultShared):
+			fmt.Println("Error deleting vault: it's shared with other users, remove them first")
+			return
+		case errors.Is(err, api.ErrNotVaultOwner):
+			fmt.Println("Error deleting vault: only its owner can delete it")
+			return
+		case err != nil:
+			fmt.Printf("Error deleting vault: %s\n", err)
+			return
+		}
+		fmt.Printf("🗑️ Deleted vault %s\n", name)
+	},
+}
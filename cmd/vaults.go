@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	vaultsCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	rootCmd.AddCommand(vaultsCmd)
+}
+
+// vaultListing is what the vaults command shows of a vault. It leaves out the password of managed vaults.
+type vaultListing struct {
+	Id                string `json:"id"`
+	Name              string `json:"name"`
+	Host              string `json:"host"`
+	Encryption        string `json:"encryption"`
+	EncryptionVersion int    `json:"encryptionVersion"`
+}
+
+var vaultsCmd = &cobra.Command{
+	Use:   "vaults",
+	Short: "List the account's remote vaults",
+	Long:  "List the ID, name, host and encryption of the account's remote vaults. Use --json to resolve vault IDs from scripts",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		tokenFlag, _ := cmd.Flags().GetString("authToken")
+		asJSON := jsonOutput(cmd)
+
+		authToken, _, err := loadAuthToken(tokenFlag)
+		if err != nil {
+			printError(cmd, "Error: %s", err)
+			return
+		}
+		defer authToken.Wipe()
+
+		vaults, err := api.ListVaults(authToken)
+		if err != nil {
+			printError(cmd, "Error listing vaults: %s", err)
+			return
+		}
+		listings := make([]vaultListing, 0, len(vaults))
+		for _, vault := range vaults {
+			// The server only knows the password of vaults with managed encryption
+			encryption := "end-to-end"
+			if vault.Password != "" {
+				encryption = "managed"
+			}
+			listings = append(listings, vaultListing{
+				Id:                vault.Id,
+				Name:              vault.Name,
+				Host:              vault.Host,
+				Encryption:        encryption,
+				EncryptionVersion: vault.EncryptionVersion,
+			})
+		}
+
+		if asJSON {
+			printJSON(listings)
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tHOST\tENCRYPTION")
+		for _, listing := range listings {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s (v%d)\n", listing.Id, listing.Name, listing.Host, listing.Encryption, listing.EncryptionVersion)
+		}
+		_ = writer.Flush()
+	},
+}
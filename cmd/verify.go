@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/nbadal/obsidian-sync/sync"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyPasswordCmd.Flags().StringP("vaultId", "v", "", "Vault ID to check the password of")
+	verifyPasswordCmd.Flags().StringP("password", "p", "", "Password to check, defaults to the stored one")
+	addVaultPasswordSources(verifyPasswordCmd)
+	verifyPasswordCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	rootCmd.AddCommand(verifyPasswordCmd)
+
+	verifyCmd.Flags().StringP("vaultId", "v", "", "Vault ID to compare with, defaults to the one the folder was synced with")
+	verifyCmd.Flags().StringP("password", "p", "", "Password of the vault")
+	addVaultPasswordSources(verifyCmd)
+	verifyCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	verifyCmd.Args = cobra.ExactArgs(1)
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [target path]",
+	Short: "Check a vault folder's files against the vault",
+	Long: "Hash every file in a vault folder and compare it with the hash the vault has for it, listing files whose " +
+		"content differs, files missing from the folder and files the vault doesn't have. Nothing is changed. Exits " +
+		"non-zero if any differences are found",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+		asJSON := jsonOutput(cmd)
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		// Get args
+		targetPath := args[0]
+		if err := validateFolder(&targetPath, true); err != nil {
+			return fmt.Errorf("invalid target: %s", err)
+		}
+		if vaultId == "" {
+			state, err := sync.LoadState(targetPath)
+			if err != nil {
+				return fmt.Errorf("error loading sync state: %s", err)
+			}
+			if state != nil {
+				vaultId = state.VaultId
+			}
+		}
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			return err
+		}
+		defer creds.Wipe()
+
+		c, stop := interruptContext()
+		defer stop()
+		report, err := sync.VerifyContext(c, targetPath, creds.AuthToken, creds.Vault, creds.Password, sync.Options{
+			DeviceName: creds.DeviceName,
+			Timeout:    timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("error verifying: %s", err)
+		}
+
+		if asJSON {
+			printJSON(report)
+		} else {
+			printPaths("Differences",
+				pathGroup{"mismatched", report.Mismatched},
+				pathGroup{"missing", report.Missing},
+				pathGroup{"extra", report.Extra})
+			printPaths("Not compared", pathGroup{"skipped", report.Skipped})
+			fmt.Println()
+		}
+		if !report.OK() {
+			return fmt.Errorf("%d files differ from vault %s", len(report.Mismatched)+len(report.Missing)+len(report.Extra), creds.Vault.Name)
+		}
+		if !asJSON {
+			fmt.Printf("✅ All %d files match vault %s\n", report.Checked, creds.Vault.Name)
+		}
+		return nil
+	},
+}
+
+var verifyPasswordCmd = &cobra.Command{
+	Use:   "verify-password",
+	Short: "Check a vault password",
+	Long: "Check a vault password with the server, without syncing anything. Exits non-zero if the password is wrong, " +
+		"for setup scripts and checking credentials after a password change",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Get flags
+		vaultId, _ := cmd.Flags().GetString("vaultId")
+		password, _ := cmd.Flags().GetString("password")
+		authToken, _ := cmd.Flags().GetString("authToken")
+
+		creds, err := promptForVaultCredentials(authToken, vaultId, password, false)
+		if err != nil {
+			return err
+		}
+		defer creds.Wipe()
+
+		cipher, err := crypto.VerifyPassword(creds.Vault.EncryptionVersion, creds.Password, []byte(creds.Vault.Salt))
+		if err != nil {
+			return fmt.Errorf("error deriving vault key: %s", err)
+		}
+		ctx, err := api.ConnectWithCipher(creds.Vault, cipher, creds.AuthToken)
+		if err != nil {
+			return fmt.Errorf("error connecting to vault: %s", err)
+		}
+		defer ctx.Close()
+		ctx.ReadOnly = true
+		ctx.DeviceName = creds.DeviceName
+
+		err = ctx.CheckKey()
+		if errors.Is(err, api.ErrKeyMismatch) {
+			return fmt.Errorf("wrong password for vault %s", creds.Vault.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("error checking password: %s", err)
+		}
+		fmt.Printf("✅ Password for vault %s is correct\n", creds.Vault.Name)
+		return nil
+	},
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+func init() {
+	whoamiCmd.Flags().StringP("authToken", "t", "", "Auth token to use, defaults to the one stored by login")
+	rootCmd.AddCommand(whoamiCmd)
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the account the auth token belongs to",
+	Long:  "Show the email, subscriptions and storage used of the account the auth token belongs to",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get flags
+		tokenFlag, _ := cmd.Flags().GetString("authToken")
+
+		authToken, scope, err := loadAuthToken(tokenFlag)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		defer authToken.Wipe()
+
+		user, err := api.GetUserInfo(authToken)
+		if err != nil {
+			fmt.Printf("Error getting user info: %s\n", err)
+			return
+		}
+		fmt.Printf("email: %s\n", user.Email)
+		if user.Name != "" {
+			fmt.Printf("name: %s\n", user.Name)
+		}
+		fmt.Printf("twoFactor: %t\n", user.MFA)
+		if scope == auth.ScopeReadOnly {
+			fmt.Println("tokenScope: read-only")
+		}
+		if user.License != "" {
+			fmt.Printf("license: %s\n", user.License)
+		}
+
+		subscriptions, err := api.GetSubscriptions(authToken)
+		if err != nil {
+			fmt.Printf("Error getting subscriptions: %s\n", err)
+			return
+		}
+		fmt.Printf("sync: %s\n", subscriptionStatus(subscriptions.Sync))
+		fmt.Printf("publish: %s\n", subscriptionStatus(subscriptions.Publish))
+
+		vaults, err := api.ListVaults(authToken)
+		if err != nil {
+			fmt.Printf("Error listing vaults: %s\n", err)
+			return
+		}
+		var total int64
+		for _, vault := range vaults {
+			fmt.Printf("vault %s: %s\n", vault.Name, formatMB(vault.Size))
+			total += vault.Size
+		}
+		fmt.Printf("storageUsed: %s in %d vaults\n", formatMB(total), len(vaults))
+	},
+}
+
+// subscriptionStatus describes a subscription in a few words
+func subscriptionStatus(subscription *api.Subscription) string {
+	if subscription == nil {
+		return "none"
+	}
+	expiry := time.UnixMilli(subscription.ExpiryTs)
+	status := "active until"
+	if expiry.Before(time.Now()) {
+		status = "expired"
+	}
+	status = fmt.Sprintf("%s %s", status, expiry.Format("2006-01-02"))
+	if subscription.Renew != "" {
+		status += fmt.Sprintf(", renews %s", subscription.Renew)
+	}
+	if subscription.Earlybird {
+		status += ", early bird"
+	}
+	return status
+}
+
+// formatMB formats a size in bytes as megabytes, the unit size flags use
+func formatMB(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/1024/1024)
+}
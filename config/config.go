@@ -0,0 +1,273 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+const (
+	configVersion = 1
+
+	// keySourceKeychain means the config is encrypted with a random secret stored in the OS keychain
+	keySourceKeychain = "keychain"
+	// keySourcePassphrase means the config is encrypted with a passphrase chosen by the user
+	keySourcePassphrase = "passphrase"
+
+	// configKeyAccount is the keychain account the config secret is stored under
+	configKeyAccount = "config-key"
+
+	// PassphraseEnv can hold the master passphrase for non-interactive use
+	PassphraseEnv = "OBSIDIAN_SYNC_PASSPHRASE"
+)
+
+// ErrNoPassphrase is returned when the config needs a passphrase and there is no way to ask for one
+var ErrNoPassphrase = errors.New("config is encrypted with a passphrase, set " + PassphraseEnv)
+
+// PromptPassphrase asks the user for the master passphrase. confirm is true when a new passphrase is being chosen.
+// Set by the CLI, leave nil to only read the passphrase from PassphraseEnv.
+var PromptPassphrase func(confirm bool) (*crypto.Secret, error)
+
+// Config holds credentials and preferences shared by all commands
+type Config struct {
+	AuthToken      string            `json:"authToken,omitempty"`
+	TokenScope     string            `json:"tokenScope,omitempty"`
+	VaultPasswords map[string]string `json:"vaultPasswords,omitempty"` // Keyed by vault ID
+	DeviceName     string            `json:"deviceName,omitempty"`     // Reported to the server, defaults to "obsidian-sync"
+	Preferences    map[string]string `json:"preferences,omitempty"`    // Default values for command flags, keyed by flag name
+
+	keySource     string
+	secret        *crypto.Secret
+	salt          []byte
+	staleKeychain bool // The keychain secret is no longer used and can be removed after saving
+}
+
+// envelope is the on-disk format, only the key source and salt are stored in the clear
+type envelope struct {
+	Version   int    `json:"version"`
+	KeySource string `json:"keySource"`
+	Salt      string `json:"salt"` // Hex encoded
+	Data      string `json:"data"` // Base64 encoded encrypted Config
+}
+
+// current is the config loaded by this process, so a passphrase is only asked for once
+var current *Config
+
+// Path returns the location of the config file
+func Path() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "obsidian-sync", "config.json"), nil
+}
+
+// Load reads and decrypts the config, returning an empty config if none has been saved
+func Load() (*Config, error) {
+	if current != nil {
+		return current, nil
+	}
+
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		current = &Config{}
+		return current, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("could not parse config file: %v", err)
+	}
+	if env.Version != configVersion {
+		return nil, fmt.Errorf("unsupported config version %d", env.Version)
+	}
+	salt, err := hex.DecodeString(env.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config salt: %v", err)
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config data: %v", err)
+	}
+
+	var secret *crypto.Secret
+	switch env.KeySource {
+	case keySourceKeychain:
+		key, err := keychainGet(configKeyAccount)
+		if err != nil {
+			return nil, fmt.Errorf("could not read config key from keychain: %v", err)
+		}
+		secret = crypto.SecretString(key)
+	case keySourcePassphrase:
+		secret, err = passphrase(false)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config key source %q", env.KeySource)
+	}
+
+	cipher, err := crypto.NewCipher(secret, salt)
+	if err != nil {
+		secret.Wipe()
+		return nil, err
+	}
+	decrypted, err := cipher.Decrypt(encrypted)
+	if err != nil {
+		secret.Wipe()
+		return nil, fmt.Errorf("could not decrypt config, wrong passphrase? %v", err)
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(decrypted, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse config: %v", err)
+	}
+	cfg.keySource = env.KeySource
+	cfg.secret = secret
+	cfg.salt = salt
+	current = cfg
+	return cfg, nil
+}
+
+// Save encrypts and writes the config. The first save picks the key source: a passphrase if PassphraseEnv is set,
+// otherwise a random secret in the OS keychain, falling back to asking for a passphrase.
+func (c *Config) Save() error {
+	if c.secret.Empty() {
+		if err := c.chooseKey(); err != nil {
+			return err
+		}
+	}
+
+	plaintext, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	cipher, err := crypto.NewCipher(c.secret, c.salt)
+	if err != nil {
+		return err
+	}
+	encrypted, err := cipher.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(envelope{
+		Version:   configVersion,
+		KeySource: c.keySource,
+		Salt:      hex.EncodeToString(c.salt),
+		Data:      base64.StdEncoding.EncodeToString(encrypted),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write atomically so an interrupted save can't lose credentials
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	if c.staleKeychain {
+		c.staleKeychain = false
+		if err := keychainDelete(configKeyAccount); err != nil && err != errKeychainNotFound {
+			api.Log().Warn("⚠️ Could not remove old config key from keychain", "err", err)
+		}
+	}
+	return nil
+}
+
+// UsePassphrase switches the config to be encrypted with the given passphrase on the next save
+func (c *Config) UsePassphrase(passphrase *crypto.Secret) error {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return err
+	}
+	c.staleKeychain = c.keySource == keySourceKeychain
+	c.keySource = keySourcePassphrase
+	c.secret.Wipe()
+	c.secret = passphrase
+	c.salt = salt
+	return nil
+}
+
+// chooseKey picks the key source and a fresh salt for a new config
+func (c *Config) chooseKey() error {
+	if os.Getenv(PassphraseEnv) == "" {
+		secret, err := randomBytes(32)
+		if err != nil {
+			return err
+		}
+		defer crypto.NewSecret(secret).Wipe()
+		salt, err := randomBytes(16)
+		if err != nil {
+			return err
+		}
+		key := crypto.SecretString(hex.EncodeToString(secret))
+		err = keychainSet(configKeyAccount, key.Reveal())
+		if err == nil {
+			c.keySource = keySourceKeychain
+			c.secret = key
+			c.salt = salt
+			return nil
+		}
+		key.Wipe()
+		api.Log().Warn("⚠️ Could not use the OS keychain, encrypting config with a passphrase", "err", err)
+	}
+
+	secret, err := passphrase(true)
+	if err != nil {
+		return err
+	}
+	return c.UsePassphrase(secret)
+}
+
+// passphrase reads the master passphrase from the environment, or asks for it
+func passphrase(confirm bool) (*crypto.Secret, error) {
+	if env := os.Getenv(PassphraseEnv); env != "" {
+		return crypto.SecretString(env), nil
+	}
+	if PromptPassphrase == nil {
+		return nil, ErrNoPassphrase
+	}
+	secret, err := PromptPassphrase(confirm)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Empty() {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	return secret, nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
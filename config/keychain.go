@@ -0,0 +1,9 @@
+package config
+
+import "errors"
+
+// keychainService is the service name credentials are stored under in the OS keychain
+const keychainService = "obsidian-sync"
+
+// errKeychainNotFound is returned by the keychain backends when no credential is stored for an account
+var errKeychainNotFound = errors.New("credential not found in keychain")
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychainSet stores a secret in the macOS Keychain. The command goes to security's interactive mode on stdin, so the
+// secret doesn't show up in the process list.
+func keychainSet(account, secret string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", securityQuote(keychainService),
+		securityQuote(account), hex.EncodeToString([]byte(secret)))
+	cmd := exec.Command("/usr/bin/security", "-i")
+	cmd.Stdin = strings.NewReader(command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	// Interactive mode exits cleanly even if a command failed
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// securityQuote quotes an argument for security's interactive mode
+func securityQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// keychainGet reads a secret from the macOS Keychain
+func keychainGet(account string) (string, error) {
+	out, err := exec.Command("/usr/bin/security", "find-generic-password",
+		"-s", keychainService, "-a", account, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+		return "", errKeychainNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// keychainDelete removes a secret from the macOS Keychain
+func keychainDelete(account string) error {
+	err := exec.Command("/usr/bin/security", "delete-generic-password",
+		"-s", keychainService, "-a", account).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 44 {
+		return errKeychainNotFound
+	}
+	return err
+}
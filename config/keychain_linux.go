@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// keychainSet stores a secret with libsecret through secret-tool, which reads it from stdin
+func keychainSet(account, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", keychainService+" "+account,
+		"service", keychainService, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	return cmd.Run()
+}
+
+// keychainGet reads a secret with libsecret through secret-tool
+func keychainGet(account string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService, "account", account).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(out) == 0 {
+		// secret-tool exits non-zero without output when nothing is stored
+		return "", errKeychainNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// keychainDelete removes a secret with libsecret through secret-tool
+func keychainDelete(account string) error {
+	return exec.Command("secret-tool", "clear", "service", keychainService, "account", account).Run()
+}
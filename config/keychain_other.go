@@ -0,0 +1,19 @@
+//go:build !darwin && !linux && !windows
+
+package config
+
+import "errors"
+
+var errKeychainUnsupported = errors.New("no keychain support on this platform")
+
+func keychainSet(account, secret string) error {
+	return errKeychainUnsupported
+}
+
+func keychainGet(account string) (string, error) {
+	return "", errKeychainUnsupported
+}
+
+func keychainDelete(account string) error {
+	return errKeychainUnsupported
+}
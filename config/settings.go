@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SettingsEnv can hold the path of the settings file, instead of the --config flag
+const SettingsEnv = "OBSIDIAN_SYNC_CONFIG"
+
+// SettingsPath returns the default location of the settings file, next to the encrypted config
+func SettingsPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "obsidian-sync", "config.yaml"), nil
+}
+
+// LoadSettings reads a YAML settings file, a mapping of flag names to values. Unlike the encrypted config it's plain
+// text meant to be written by hand or by deployment tools, so it shouldn't hold secrets. List values, e.g. for
+// repeatable flags, are returned in order. A missing file has no settings.
+func LoadSettings(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	settings := make(map[string][]string, len(raw))
+	for key, node := range raw {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			settings[key] = []string{node.Value}
+		case yaml.SequenceNode:
+			values := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("%s in %s: list items must be plain values", key, path)
+				}
+				values = append(values, item.Value)
+			}
+			settings[key] = values
+		default:
+			return nil, fmt.Errorf("%s in %s: expected a value or a list of values", key, path)
+		}
+	}
+	return settings, nil
+}
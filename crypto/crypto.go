@@ -6,41 +6,35 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"io"
-
-	"golang.org/x/crypto/scrypt"
 )
 
 const (
 	nonceSize = 12
+	tagSize   = 16
 )
 
-// deriveKey derives a key from the password and salt.
-func deriveKey(password, salt []byte) ([]byte, error) {
-	return scrypt.Key(password, salt, 32768, 8, 1, 32)
+// Cipher encrypts and decrypts vault data with a key that is derived once, since scrypt is deliberately slow.
+type Cipher struct {
+	block   cipher.Block
+	aead    cipher.AEAD
+	keyHash string
 }
 
-// KeyHash returns the hash of the key derived from the password and salt.
-func KeyHash(password, salt []byte) (string, error) {
-	keyData, err := deriveKey(password, salt)
-	if err != nil {
-		return "", err
-	}
-	hash := sha256.Sum256(keyData)
-	return hex.EncodeToString(hash[:]), nil
+// NewCipher derives the key for the password and salt with DefaultScrypt and returns a Cipher using it. The derived
+// key is wiped once the cipher is set up, the password is left to the caller.
+func NewCipher(password *Secret, salt []byte) (*Cipher, error) {
+	return NewCipherWithKDF(DefaultScrypt, password, salt)
 }
 
-// Encrypt encrypts the input using the password and salt.
-func Encrypt(input, password, salt []byte) ([]byte, error) {
-	key, err := deriveKey(password, salt)
+// NewCipherWithKDF is NewCipher with a different KDF, e.g. cheap scrypt parameters in tests
+func NewCipherWithKDF(kdf KDF, password *Secret, salt []byte) (*Cipher, error) {
+	key, err := kdf.DeriveKey(password, salt)
 	if err != nil {
 		return nil, err
 	}
-
-	nonce := make([]byte, nonceSize)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
-	}
+	defer wipe(key)
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -52,7 +46,27 @@ func Encrypt(input, password, salt []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	ciphertext := aesgcm.Seal(nil, nonce, input, nil)
+	hash := sha256.Sum256(key)
+	return &Cipher{
+		block:   block,
+		aead:    aesgcm,
+		keyHash: hex.EncodeToString(hash[:]),
+	}, nil
+}
+
+// KeyHash returns the hash of the derived key, which the server uses to check the vault password.
+func (c *Cipher) KeyHash() string {
+	return c.keyHash
+}
+
+// Encrypt encrypts the input, prefixing the output with a random nonce.
+func (c *Cipher) Encrypt(input []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	ciphertext := c.aead.Seal(nil, nonce, input, nil)
 
 	encrypted := make([]byte, nonceSize+len(ciphertext))
 	copy(encrypted, nonce)
@@ -61,48 +75,95 @@ func Encrypt(input, password, salt []byte) ([]byte, error) {
 	return encrypted, nil
 }
 
-// DecryptString decrypts the encrypted string using the password and salt.
-func DecryptString(encryptedString string, password, salt []byte) (string, error) {
-	encrypted, err := hex.DecodeString(encryptedString)
+// EncryptString encrypts the string and returns the result hex encoded.
+func (c *Cipher) EncryptString(input string) (string, error) {
+	encrypted, err := c.Encrypt([]byte(input))
 	if err != nil {
 		return "", err
 	}
-	decrypted, err := Decrypt(encrypted, password, salt)
-	if err != nil {
-		return "", err
-	}
-	return string(decrypted), nil
+	return hex.EncodeToString(encrypted), nil
 }
 
-// Decrypt decrypts the encrypted data using the password and salt.
-func Decrypt(encrypted, password, salt []byte) ([]byte, error) {
+// Decrypt decrypts data produced by Encrypt.
+func (c *Cipher) Decrypt(encrypted []byte) ([]byte, error) {
 	// Return empty slice if encrypted is empty
 	if len(encrypted) == 0 {
 		return []byte{}, nil
 	}
+	if len(encrypted) < nonceSize {
+		return nil, fmt.Errorf("encrypted data is too short")
+	}
+
+	nonce := encrypted[:nonceSize]
+	ciphertext := encrypted[nonceSize:]
 
-	key, err := deriveKey(password, salt)
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	block, err := aes.NewCipher(key)
+	return plaintext, nil
+}
+
+// DecryptString decrypts a hex encoded string produced by EncryptString.
+func (c *Cipher) DecryptString(encryptedString string) (string, error) {
+	encrypted, err := hex.DecodeString(encryptedString)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
 
-	aesgcm, err := cipher.NewGCM(block)
+// EncryptedSize returns the size of the output of Encrypt for an input of the given size.
+func EncryptedSize(plaintextSize int64) int64 {
+	return plaintextSize + nonceSize + tagSize
+}
+
+// PlaintextSize returns the size of the input to Encrypt for an output of the given size, zero for no output
+func PlaintextSize(encryptedSize int64) int64 {
+	if encryptedSize < nonceSize+tagSize {
+		return 0
+	}
+	return encryptedSize - nonceSize - tagSize
+}
+
+// KeyHash returns the hash of the key derived from the password and salt.
+func KeyHash(password *Secret, salt []byte) (string, error) {
+	c, err := NewCipher(password, salt)
+	if err != nil {
+		return "", err
+	}
+	return c.KeyHash(), nil
+}
+
+// Encrypt encrypts the input using the password and salt. Prefer a Cipher when encrypting more than once.
+func Encrypt(input []byte, password *Secret, salt []byte) ([]byte, error) {
+	c, err := NewCipher(password, salt)
 	if err != nil {
 		return nil, err
 	}
+	return c.Encrypt(input)
+}
 
-	nonce := encrypted[:nonceSize]
-	ciphertext := encrypted[nonceSize:]
+// DecryptString decrypts the encrypted string using the password and salt. Prefer a Cipher when decrypting more than
+// once.
+func DecryptString(encryptedString string, password *Secret, salt []byte) (string, error) {
+	c, err := NewCipher(password, salt)
+	if err != nil {
+		return "", err
+	}
+	return c.DecryptString(encryptedString)
+}
 
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
+// Decrypt decrypts the encrypted data using the password and salt. Prefer a Cipher when decrypting more than once.
+func Decrypt(encrypted []byte, password *Secret, salt []byte) ([]byte, error) {
+	c, err := NewCipher(password, salt)
 	if err != nil {
 		return nil, err
 	}
-
-	return plaintext, nil
+	return c.Decrypt(encrypted)
 }
@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF derives an encryption key from a password and salt. Implementations are deliberately slow, so derive once and
+// reuse the Cipher.
+type KDF interface {
+	// DeriveKey returns the key for the password and salt. The caller should wipe the key once it's no longer needed.
+	DeriveKey(password *Secret, salt []byte) ([]byte, error)
+}
+
+// Scrypt derives keys with scrypt
+type Scrypt struct {
+	N      int // CPU and memory cost, a power of two
+	R      int // Block size
+	P      int // Parallelization
+	KeyLen int // Key length in bytes
+}
+
+// DefaultScrypt is the KDF Obsidian uses for vault keys
+var DefaultScrypt = Scrypt{N: 32768, R: 8, P: 1, KeyLen: 32}
+
+// DeriveKey implements KDF
+func (s Scrypt) DeriveKey(password *Secret, salt []byte) ([]byte, error) {
+	return scrypt.Key(password.Bytes(), salt, s.N, s.R, s.P, s.KeyLen)
+}
+
+// String describes the parameters
+func (s Scrypt) String() string {
+	return fmt.Sprintf("scrypt N=%d r=%d p=%d", s.N, s.R, s.P)
+}
+
+// BenchmarkKDF derives a key runs times with a throwaway password and returns the average time per derivation
+func BenchmarkKDF(kdf KDF, runs int) (time.Duration, error) {
+	if runs < 1 {
+		return 0, fmt.Errorf("runs must be at least 1")
+	}
+	password := SecretString("benchmark")
+	salt := []byte("benchmark")
+	start := time.Now()
+	for i := 0; i < runs; i++ {
+		key, err := kdf.DeriveKey(password, salt)
+		if err != nil {
+			return 0, err
+		}
+		wipe(key)
+	}
+	return time.Since(start) / time.Duration(runs), nil
+}
@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"fmt"
+	"runtime"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret holds a password, derived key or auth token in a buffer that Wipe zeroes. It never prints or marshals its
+// value, so secrets can't leak into logs by accident. A nil Secret is empty.
+type Secret struct {
+	b []byte
+}
+
+// NewSecret wraps b, which the Secret takes ownership of and zeroes when wiped
+func NewSecret(b []byte) *Secret {
+	return &Secret{b: b}
+}
+
+// SecretString copies s into a new Secret. The original string can't be wiped, so prefer NewSecret where the value
+// is already a byte slice.
+func SecretString(s string) *Secret {
+	return &Secret{b: []byte(s)}
+}
+
+// Bytes returns the secret's buffer, which is zeroed by Wipe. Don't keep it beyond the Secret's lifetime.
+func (s *Secret) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+	return s.b
+}
+
+// Reveal returns the secret as a string for APIs that need one. Strings are immutable and can't be wiped, so only
+// call this right where the value is sent.
+func (s *Secret) Reveal() string {
+	return string(s.Bytes())
+}
+
+// Empty returns true if there is no secret
+func (s *Secret) Empty() bool {
+	return len(s.Bytes()) == 0
+}
+
+// Wipe zeroes the secret's buffer
+func (s *Secret) Wipe() {
+	if s == nil {
+		return
+	}
+	wipe(s.b)
+	s.b = nil
+}
+
+// String redacts the secret, so printing it with %s or %v is safe
+func (s *Secret) String() string {
+	return redacted
+}
+
+// GoString redacts the secret for %#v
+func (s *Secret) GoString() string {
+	return redacted
+}
+
+// Format redacts the secret for every verb, including %x and %q
+func (s *Secret) Format(f fmt.State, verb rune) {
+	_, _ = f.Write([]byte(redacted))
+}
+
+// MarshalJSON redacts the secret
+func (s *Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+// wipe zeroes b, keeping it alive so the writes can't be optimized away
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
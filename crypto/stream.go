@@ -0,0 +1,263 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// StreamCipher is a VaultCipher that can also encrypt and decrypt content as a stream, like Cipher
+type StreamCipher interface {
+	VaultCipher
+	EncryptTo(dst io.Writer, src io.Reader, pieceSize int) (int64, error)
+	DecryptTo(dst io.Writer, src io.Reader, pieceSize int) error
+	NewStreamEncrypter() (*StreamEncrypter, error)
+	NewStreamDecrypter() *StreamDecrypter
+}
+
+// StreamEncrypter encrypts content piece by piece. The concatenation of Header, every Update and Final is identical
+// to the output of Cipher.Encrypt, so large files never need to be held in memory in full.
+type StreamEncrypter struct {
+	nonce  []byte
+	ctr    cipher.Stream
+	hash   *ghash
+	tagKey [16]byte
+}
+
+// NewStreamEncrypter starts encrypting a new message with a random nonce
+func (c *Cipher) NewStreamEncrypter() (*StreamEncrypter, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	ctr, hash, tagKey := c.gcmState(nonce)
+	return &StreamEncrypter{nonce: nonce, ctr: ctr, hash: hash, tagKey: tagKey}, nil
+}
+
+// Header returns the nonce, which must precede the ciphertext
+func (e *StreamEncrypter) Header() []byte {
+	return e.nonce
+}
+
+// Update encrypts the next piece of plaintext
+func (e *StreamEncrypter) Update(plaintext []byte) []byte {
+	ciphertext := make([]byte, len(plaintext))
+	e.ctr.XORKeyStream(ciphertext, plaintext)
+	e.hash.update(ciphertext)
+	return ciphertext
+}
+
+// Final returns the authentication tag, which must follow the ciphertext
+func (e *StreamEncrypter) Final() []byte {
+	return e.hash.tag(e.tagKey)
+}
+
+// StreamDecrypter decrypts data produced by Cipher.Encrypt or StreamEncrypter piece by piece. Plaintext is released
+// before it is authenticated, so callers must discard everything they received if Final fails.
+type StreamDecrypter struct {
+	c       *Cipher
+	ctr     cipher.Stream
+	hash    *ghash
+	tagKey  [16]byte
+	started bool
+	pending []byte // Nonce bytes before start, then the trailing bytes that may be the tag
+}
+
+// NewStreamDecrypter starts decrypting a new message
+func (c *Cipher) NewStreamDecrypter() *StreamDecrypter {
+	return &StreamDecrypter{c: c}
+}
+
+// Update consumes the next piece of encrypted data and returns the plaintext that can be released so far
+func (d *StreamDecrypter) Update(piece []byte) []byte {
+	d.pending = append(d.pending, piece...)
+
+	if !d.started {
+		if len(d.pending) < nonceSize {
+			return nil
+		}
+		d.ctr, d.hash, d.tagKey = d.c.gcmState(d.pending[:nonceSize])
+		d.pending = d.pending[nonceSize:]
+		d.started = true
+	}
+
+	// Hold back what could be the tag until we know more data follows
+	if len(d.pending) <= tagSize {
+		return nil
+	}
+	ciphertext := d.pending[:len(d.pending)-tagSize]
+	d.hash.update(ciphertext)
+	plaintext := make([]byte, len(ciphertext))
+	d.ctr.XORKeyStream(plaintext, ciphertext)
+	d.pending = append([]byte(nil), d.pending[len(ciphertext):]...)
+	return plaintext
+}
+
+// Final verifies the authentication tag at the end of the data
+func (d *StreamDecrypter) Final() error {
+	// Empty input decrypts to empty output, matching Decrypt
+	if !d.started && len(d.pending) == 0 {
+		return nil
+	}
+	if !d.started || len(d.pending) != tagSize {
+		return fmt.Errorf("encrypted data is too short")
+	}
+	expected := d.hash.tag(d.tagKey)
+	if subtle.ConstantTimeCompare(expected, d.pending) != 1 {
+		return fmt.Errorf("message authentication failed")
+	}
+	return nil
+}
+
+// EncryptTo encrypts everything read from src into dst, reading pieceSize bytes at a time. Returns the number of
+// bytes written.
+func (c *Cipher) EncryptTo(dst io.Writer, src io.Reader, pieceSize int) (int64, error) {
+	encrypter, err := c.NewStreamEncrypter()
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := dst.Write(encrypter.Header())
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	buf := make([]byte, pieceSize)
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 {
+			written, err := dst.Write(encrypter.Update(buf[:n]))
+			total += int64(written)
+			if err != nil {
+				return total, err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return total, readErr
+		}
+	}
+
+	written, err = dst.Write(encrypter.Final())
+	total += int64(written)
+	return total, err
+}
+
+// DecryptTo decrypts everything read from src into dst, reading pieceSize bytes at a time. dst receives plaintext
+// before it is authenticated, so its contents must be discarded if an error is returned.
+func (c *Cipher) DecryptTo(dst io.Writer, src io.Reader, pieceSize int) error {
+	decrypter := c.NewStreamDecrypter()
+	buf := make([]byte, pieceSize)
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 {
+			if _, err := dst.Write(decrypter.Update(buf[:n])); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	return decrypter.Final()
+}
+
+// gcmState sets up the GCM counter stream, GHASH and tag mask for a 96-bit nonce, as in NIST SP 800-38D
+func (c *Cipher) gcmState(nonce []byte) (cipher.Stream, *ghash, [16]byte) {
+	// Hash subkey H is the encryption of the zero block
+	var h [16]byte
+	c.block.Encrypt(h[:], h[:])
+
+	// J0 is the nonce followed by a 32-bit counter of 1, which masks the tag. Content starts at counter 2.
+	var j0 [16]byte
+	copy(j0[:], nonce)
+	binary.BigEndian.PutUint32(j0[12:], 1)
+	var tagKey [16]byte
+	c.block.Encrypt(tagKey[:], j0[:])
+
+	counter := j0
+	binary.BigEndian.PutUint32(counter[12:], 2)
+	return cipher.NewCTR(c.block, counter[:]), newGhash(h), tagKey
+}
+
+// ghash computes GCM's authentication hash incrementally over the ciphertext (there is no additional data)
+type ghash struct {
+	h      [2]uint64
+	y      [2]uint64
+	buf    []byte
+	length uint64
+}
+
+func newGhash(h [16]byte) *ghash {
+	return &ghash{h: [2]uint64{binary.BigEndian.Uint64(h[:8]), binary.BigEndian.Uint64(h[8:])}}
+}
+
+func (g *ghash) update(data []byte) {
+	g.length += uint64(len(data))
+	g.buf = append(g.buf, data...)
+	for len(g.buf) >= 16 {
+		g.block(g.buf[:16])
+		g.buf = g.buf[16:]
+	}
+	g.buf = append([]byte(nil), g.buf...)
+}
+
+func (g *ghash) block(block []byte) {
+	g.y[0] ^= binary.BigEndian.Uint64(block[:8])
+	g.y[1] ^= binary.BigEndian.Uint64(block[8:])
+	g.y = gfMul(g.y, g.h)
+}
+
+// tag finishes the hash and returns the authentication tag
+func (g *ghash) tag(tagKey [16]byte) []byte {
+	if len(g.buf) > 0 {
+		var padded [16]byte
+		copy(padded[:], g.buf)
+		g.block(padded[:])
+		g.buf = nil
+	}
+
+	// Final block holds the bit lengths of the additional data (none) and the ciphertext
+	var lengths [16]byte
+	binary.BigEndian.PutUint64(lengths[8:], g.length*8)
+	g.block(lengths[:])
+
+	tag := make([]byte, 16)
+	binary.BigEndian.PutUint64(tag[:8], g.y[0])
+	binary.BigEndian.PutUint64(tag[8:], g.y[1])
+	for i := range tag {
+		tag[i] ^= tagKey[i]
+	}
+	return tag
+}
+
+// gfMul multiplies two elements of GF(2^128) in GCM's bit order
+func gfMul(x [2]uint64, y [2]uint64) [2]uint64 {
+	var z [2]uint64
+	v := y
+	for i := 0; i < 128; i++ {
+		var bit uint64
+		if i < 64 {
+			bit = (x[0] >> (63 - i)) & 1
+		} else {
+			bit = (x[1] >> (127 - i)) & 1
+		}
+		mask := -bit
+		z[0] ^= v[0] & mask
+		z[1] ^= v[1] & mask
+
+		lsb := v[1] & 1
+		v[1] = v[1]>>1 | v[0]<<63
+		v[0] = v[0]>>1 ^ (0xe100000000000000 & -lsb)
+	}
+	return z
+}
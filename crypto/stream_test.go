@@ -0,0 +1,201 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+// fixedKey is a KDF that returns the same key for any password, to test with known keys
+type fixedKey []byte
+
+func (k fixedKey) DeriveKey(password *Secret, salt []byte) ([]byte, error) {
+	return append([]byte(nil), k...), nil
+}
+
+func testCipher(t *testing.T, key []byte) *Cipher {
+	t.Helper()
+	c, err := NewCipherWithKDF(fixedKey(key), SecretString(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// sealStream encrypts plaintext with a given nonce through StreamEncrypter, in pieces of pieceSize
+func sealStream(c *Cipher, nonce []byte, plaintext []byte, pieceSize int) []byte {
+	ctr, hash, tagKey := c.gcmState(nonce)
+	e := &StreamEncrypter{nonce: nonce, ctr: ctr, hash: hash, tagKey: tagKey}
+	out := append([]byte(nil), e.Header()...)
+	for len(plaintext) > 0 {
+		n := pieceSize
+		if n > len(plaintext) {
+			n = len(plaintext)
+		}
+		out = append(out, e.Update(plaintext[:n])...)
+		plaintext = plaintext[n:]
+	}
+	return append(out, e.Final()...)
+}
+
+// openStream decrypts through StreamDecrypter, in pieces of pieceSize
+func openStream(c *Cipher, encrypted []byte, pieceSize int) ([]byte, error) {
+	d := c.NewStreamDecrypter()
+	var out []byte
+	for len(encrypted) > 0 {
+		n := pieceSize
+		if n > len(encrypted) {
+			n = len(encrypted)
+		}
+		out = append(out, d.Update(encrypted[:n])...)
+		encrypted = encrypted[n:]
+	}
+	return out, d.Final()
+}
+
+// Test cases without additional data from the GCM specification, as used by NIST's validation of SP 800-38D
+func TestStreamKnownAnswers(t *testing.T) {
+	plaintext := "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a72" +
+		"1c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b391aafd255"
+	tests := []struct {
+		name       string
+		key        string
+		nonce      string
+		plaintext  string
+		ciphertext string
+		tag        string
+	}{
+		{"AES-128 empty", "00000000000000000000000000000000", "000000000000000000000000", "", "",
+			"58e2fccefa7e3061367f1d57a4e7455a"},
+		{"AES-128 one block", "00000000000000000000000000000000", "000000000000000000000000",
+			"00000000000000000000000000000000", "0388dace60b6a392f328c2b971b2fe78", "ab6e47d42cec13bdf53a67b21257bddf"},
+		{"AES-128 four blocks", "feffe9928665731c6d6a8f9467308308", "cafebabefacedbaddecaf888", plaintext,
+			"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e" +
+				"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091473f5985",
+			"4d5c2af327cd64a62cf35abd2ba6fab4"},
+		{"AES-256 empty", "0000000000000000000000000000000000000000000000000000000000000000", "000000000000000000000000",
+			"", "", "530f8afbc74536b9a963b4f1c4cb738b"},
+		{"AES-256 one block", "0000000000000000000000000000000000000000000000000000000000000000", "000000000000000000000000",
+			"00000000000000000000000000000000", "cea7403d4d606b6e074ec5d3baf39d18", "d0d1c8a799996bf0265b98b5d48ab919"},
+		{"AES-256 four blocks", "feffe9928665731c6d6a8f9467308308feffe9928665731c6d6a8f9467308308", "cafebabefacedbaddecaf888",
+			plaintext,
+			"522dc1f099567d07f47f37a32a84427d643a8cdcbfe5c0c97598a2bd2555d1aa" +
+				"8cb08e48590dbb3da7b08b1056828838c5f61e6393ba7a0abcc9f662898015ad",
+			"b094dac5d93471bdec1a502270e3cc6c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testCipher(t, unhex(t, tt.key))
+			nonce := unhex(t, tt.nonce)
+			want := append(append(append([]byte(nil), nonce...), unhex(t, tt.ciphertext)...), unhex(t, tt.tag)...)
+
+			// Pieces that split blocks exercise the buffering of GHASH
+			for _, pieceSize := range []int{1, 7, 16, 64} {
+				if got := sealStream(c, nonce, unhex(t, tt.plaintext), pieceSize); !bytes.Equal(got, want) {
+					t.Errorf("encrypting in pieces of %d = %x, want %x", pieceSize, got, want)
+				}
+				got, err := openStream(c, want, pieceSize)
+				if err != nil {
+					t.Errorf("decrypting in pieces of %d: %s", pieceSize, err)
+				}
+				if !bytes.Equal(got, unhex(t, tt.plaintext)) {
+					t.Errorf("decrypting in pieces of %d = %x, want %s", pieceSize, got, tt.plaintext)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamMatchesGCM(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	c := testCipher(t, key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const pieceSize = 64
+	sizes := []int{0, 1, 15, 16, 17, 100, pieceSize - 1, pieceSize, pieceSize + 1, 3*pieceSize + 5, 10 * pieceSize}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		if _, err := rand.Read(plaintext); err != nil {
+			t.Fatal(err)
+		}
+
+		// Streamed encryption opens with the standard library
+		var encrypted bytes.Buffer
+		if _, err := c.EncryptTo(&encrypted, bytes.NewReader(plaintext), pieceSize); err != nil {
+			t.Fatal(err)
+		}
+		if got := int64(encrypted.Len()); got != EncryptedSize(int64(size)) {
+			t.Errorf("size %d: encrypted to %d bytes, want %d", size, got, EncryptedSize(int64(size)))
+		}
+		nonce, sealed := encrypted.Bytes()[:nonceSize], encrypted.Bytes()[nonceSize:]
+		opened, err := aead.Open(nil, nonce, sealed, nil)
+		if err != nil {
+			t.Errorf("size %d: standard library can't open the stream: %s", size, err)
+		} else if !bytes.Equal(opened, plaintext) {
+			t.Errorf("size %d: standard library opened different content", size)
+		}
+
+		// And the standard library's encryption decrypts as a stream
+		sealed = aead.Seal(append([]byte(nil), nonce...), nonce, plaintext, nil)
+		var decrypted bytes.Buffer
+		if err := c.DecryptTo(&decrypted, bytes.NewReader(sealed), pieceSize); err != nil {
+			t.Errorf("size %d: can't decrypt the standard library's output: %s", size, err)
+		} else if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Errorf("size %d: decrypted different content", size)
+		}
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	c := testCipher(t, make([]byte, 32))
+	plaintext := bytes.Repeat([]byte("tamper"), 50)
+	var encrypted bytes.Buffer
+	if _, err := c.EncryptTo(&encrypted, bytes.NewReader(plaintext), 64); err != nil {
+		t.Fatal(err)
+	}
+	valid := encrypted.Bytes()
+
+	tests := []struct {
+		name   string
+		tamper func([]byte) []byte
+	}{
+		{"nonce", func(b []byte) []byte { b[0] ^= 1; return b }},
+		{"first ciphertext byte", func(b []byte) []byte { b[nonceSize] ^= 1; return b }},
+		{"last ciphertext byte", func(b []byte) []byte { b[len(b)-tagSize-1] ^= 0x80; return b }},
+		{"tag", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"truncated tag", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"dropped ciphertext", func(b []byte) []byte { return append(b[:nonceSize+16], b[nonceSize+32:]...) }},
+		{"appended byte", func(b []byte) []byte { return append(b, 0) }},
+		{"only a nonce", func(b []byte) []byte { return b[:nonceSize] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(append([]byte(nil), valid...))
+			if err := c.DecryptTo(&bytes.Buffer{}, bytes.NewReader(tampered), 64); err == nil {
+				t.Error("DecryptTo() accepted tampered data")
+			}
+		})
+	}
+}
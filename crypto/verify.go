@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrWrongPassword is returned when a vault key can't decrypt data that was encrypted for the vault
+var ErrWrongPassword = errors.New("wrong vault password")
+
+// selfTestInput is encrypted and decrypted by SelfTest
+var selfTestInput = []byte("obsidian-sync self-test")
+
+// VerifyPassword derives the key for a vault's encryption version and salt, and checks it with VerifyCipher. samples
+// are hex encoded strings encrypted for the vault, like the encrypted paths and hashes of remote entries. The cipher is
+// returned so the key only has to be derived once.
+func VerifyPassword(version int, password *Secret, salt []byte, samples ...string) (VaultCipher, error) {
+	c, err := NewVaultCipher(version, password, salt)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyCipher(c, samples...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// VerifyCipher runs SelfTest and decrypts each sample, failing with ErrWrongPassword if a sample doesn't decrypt.
+// Without samples only the self-test runs, and any password passes.
+func VerifyCipher(c VaultCipher, samples ...string) error {
+	if err := SelfTest(c); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		if _, err := c.DecryptString(sample); err != nil {
+			return fmt.Errorf("%w: %s", ErrWrongPassword, err)
+		}
+	}
+	return nil
+}
+
+// SelfTest checks that the cipher decrypts what it encrypts
+func SelfTest(c VaultCipher) error {
+	encrypted, err := c.Encrypt(selfTestInput)
+	if err != nil {
+		return fmt.Errorf("crypto self-test failed to encrypt: %s", err)
+	}
+	decrypted, err := c.Decrypt(encrypted)
+	if err != nil {
+		return fmt.Errorf("crypto self-test failed to decrypt: %s", err)
+	}
+	if !bytes.Equal(decrypted, selfTestInput) {
+		return fmt.Errorf("crypto self-test decrypted the wrong data")
+	}
+	return nil
+}
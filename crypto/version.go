@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// LatestEncryptionVersion is the newest vault encryption format this client supports
+const LatestEncryptionVersion = 0
+
+// VaultCipher encrypts and decrypts vault data for one encryption version
+type VaultCipher interface {
+	// KeyHash returns the hash the server uses to check the vault password
+	KeyHash() string
+	Encrypt(input []byte) ([]byte, error)
+	Decrypt(encrypted []byte) ([]byte, error)
+	EncryptString(input string) (string, error)
+	DecryptString(encryptedString string) (string, error)
+}
+
+// cipherVersion is how one encryption version derives its key and creates its cipher
+type cipherVersion struct {
+	kdf       KDF
+	newCipher func(kdf KDF, password *Secret, salt []byte) (VaultCipher, error)
+}
+
+// cipherVersions describes each supported encryption version
+var cipherVersions = map[int]cipherVersion{
+	0: {
+		kdf: DefaultScrypt,
+		newCipher: func(kdf KDF, password *Secret, salt []byte) (VaultCipher, error) {
+			return NewCipherWithKDF(kdf, password, salt)
+		},
+	},
+}
+
+// NewVaultCipher returns the cipher for a vault's encryption version, or an error explaining that the client needs
+// upgrading if the version isn't supported.
+func NewVaultCipher(version int, password *Secret, salt []byte) (VaultCipher, error) {
+	v, ok := cipherVersions[version]
+	if !ok {
+		return nil, UnsupportedVersionError(version)
+	}
+	return v.newCipher(v.kdf, password, salt)
+}
+
+// VersionKDF returns the KDF an encryption version derives keys with
+func VersionKDF(version int) (KDF, error) {
+	v, ok := cipherVersions[version]
+	if !ok {
+		return nil, UnsupportedVersionError(version)
+	}
+	return v.kdf, nil
+}
+
+// UnsupportedVersionError returns an actionable error for a vault using an encryption version we can't handle
+func UnsupportedVersionError(version int) error {
+	return fmt.Errorf("vault uses encryption version %d, but this client only supports up to version %d. "+
+		"Please upgrade obsidian-sync", version, LatestEncryptionVersion)
+}
+
+// saltAlphabet is what new vault salts are made of, printable so the salt survives the JSON API as a string
+const saltAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// NewSalt returns a random salt for a new vault
+func NewSalt() (string, error) {
+	salt := make([]byte, 20)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate salt: %v", err)
+	}
+	for i, b := range salt {
+		salt[i] = saltAlphabet[int(b)%len(saltAlphabet)]
+	}
+	return string(salt), nil
+}
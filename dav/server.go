@@ -0,0 +1,75 @@
+// Package dav serves a vault over WebDAV, so editors and apps without Obsidian can read and write notes. It serves
+// either a synced folder, whose changes are then synced by a daemon, or a remote vault directly, see VaultFS.
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"golang.org/x/net/webdav"
+)
+
+// shutdownTimeout is how long Serve waits for requests in progress when it stops
+const shutdownTimeout = 10 * time.Second
+
+// Serve serves fs over WebDAV on 127.0.0.1 at port until ctx is done. A read-only server refuses every change.
+func Serve(ctx context.Context, port int, fs webdav.FileSystem, readOnly bool) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("error opening WebDAV server: %s", err)
+	}
+	var handler http.Handler = &webdav.Handler{
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				api.Log().Warn("⚠️ WebDAV request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			} else {
+				api.Log().Debug("🌐 WebDAV request", "method", r.Method, "path", r.URL.Path)
+			}
+		},
+	}
+	if readOnly {
+		handler = readOnlyHandler(handler)
+	}
+	server := &http.Server{Handler: handler}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+		return nil
+	}
+	return err
+}
+
+// readOnlyMethods are the WebDAV methods that don't change anything
+var readOnlyMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// readOnlyHandler refuses the requests of next that would change something
+func readOnlyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !readOnlyMethods[r.Method] {
+			http.Error(w, "read-only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,484 @@
+package dav
+
+import (
+	"context"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/client"
+	"golang.org/x/net/webdav"
+)
+
+// VaultFS is a WebDAV file system serving a vault directly, without a local copy. Files are pulled when they're first
+// read, and pushed when they're closed after being written. The vault must stay open while it's served, and isn't safe
+// to use elsewhere meanwhile.
+type VaultFS struct {
+	// ctx is used for vault operations instead of the context of requests, since canceling an operation closes the
+	// vault's connection
+	ctx   context.Context
+	vault *client.Vault
+
+	// The vault carries one operation at a time, so everything holds mu while it uses it
+	mu      gosync.Mutex
+	sizes   map[string]knownSize // Decrypted sizes of the files that were pulled or pushed, by path
+	folders map[string]bool      // Folders made with MKCOL, the vault only has them once a file is pushed in them
+}
+
+// knownSize is the decrypted size of a file, the vault only knows its encrypted size
+type knownSize struct {
+	modified time.Time // Modified time of the version the size is of
+	size     int64
+}
+
+// NewVaultFS returns a file system serving vault, using ctx for its operations
+func NewVaultFS(ctx context.Context, vault *client.Vault) *VaultFS {
+	return &VaultFS{ctx: ctx, vault: vault, sizes: make(map[string]knownSize), folders: make(map[string]bool)}
+}
+
+// vaultPath returns the vault path of a WebDAV name, empty for the root
+func vaultPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// refresh receives the changes other devices made, mu must be held
+func (fs *VaultFS) refresh() {
+	changes, err := fs.vault.Refresh(fs.ctx)
+	if err != nil {
+		api.Log().Warn("⚠️ Could not receive changes", "err", err)
+	}
+	for _, change := range changes {
+		api.Log().Debug("🔄 Changed by another device", "path", change.Path, "deleted", change.Deleted)
+	}
+}
+
+// stat returns the file or folder at path. Folders made with MKCOL, or only known from the files in them, have a zero
+// modified time. mu must be held.
+func (fs *VaultFS) stat(p string) (client.File, bool) {
+	if p == "" {
+		return client.File{Folder: true}, true
+	}
+	if file, ok := fs.vault.Stat(p); ok {
+		return file, true
+	}
+	if fs.folders[p] || len(fs.under(p)) > 0 {
+		return client.File{Path: p, Folder: true}, true
+	}
+	return client.File{}, false
+}
+
+// under returns the files and folders the vault has anywhere under the folder at dir, mu must be held
+func (fs *VaultFS) under(dir string) []client.File {
+	var files []client.File
+	for _, file := range fs.vault.Files() {
+		if dir == "" || strings.HasPrefix(file.Path, dir+"/") {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// children returns the files and folders directly in the folder at dir, sorted by name. mu must be held.
+func (fs *VaultFS) children(dir string) []os.FileInfo {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	children := make(map[string]client.File)
+	add := func(file client.File) {
+		if !strings.HasPrefix(file.Path, prefix) || file.Path == dir {
+			return
+		}
+		name, _, nested := strings.Cut(file.Path[len(prefix):], "/")
+		if !nested {
+			children[name] = file
+		} else if _, ok := children[name]; !ok {
+			// A folder the vault may not have an entry for
+			children[name] = client.File{Path: prefix + name, Folder: true}
+		}
+	}
+	for _, file := range fs.vault.Files() {
+		add(file)
+	}
+	for p := range fs.folders {
+		add(client.File{Path: p, Folder: true})
+	}
+
+	infos := make([]os.FileInfo, 0, len(children))
+	for _, file := range children {
+		infos = append(infos, fs.info(file))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+	return infos
+}
+
+// info returns a file or folder's info, with its decrypted size if it's known. mu must be held.
+func (fs *VaultFS) info(file client.File) fileInfo {
+	size := file.Size
+	if known, ok := fs.sizes[file.Path]; ok && known.modified.Equal(file.Modified) {
+		size = known.size
+	}
+	return fileInfo{file: file, size: size}
+}
+
+// pull pulls the content of a file, mu must be held
+func (fs *VaultFS) pull(file client.File) ([]byte, error) {
+	api.Log().Debug("📥 Pulling", "path", file.Path)
+	content, err := fs.vault.Pull(fs.ctx, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	fs.sizes[file.Path] = knownSize{modified: file.Modified, size: int64(len(content))}
+	return content, nil
+}
+
+// push pushes the content of the file at path, mu must be held
+func (fs *VaultFS) push(p string, content []byte, modified time.Time) error {
+	api.Log().Info("📤 Pushing", "path", p, "size", len(content))
+	if err := fs.vault.Push(fs.ctx, p, content, modified); err != nil {
+		return err
+	}
+	fs.sizes[p] = knownSize{modified: modified, size: int64(len(content))}
+	return nil
+}
+
+// delete deletes the file or folder at path, mu must be held
+func (fs *VaultFS) delete(p string) error {
+	api.Log().Info("🗑️ Deleting", "path", p)
+	return fs.vault.Delete(fs.ctx, p)
+}
+
+// parentExists returns true if the folder path would be in exists, mu must be held
+func (fs *VaultFS) parentExists(p string) bool {
+	dir := path.Dir(p)
+	if dir == "." {
+		dir = ""
+	}
+	parent, ok := fs.stat(dir)
+	return ok && parent.Folder
+}
+
+func (fs *VaultFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.refresh()
+	file, ok := fs.stat(vaultPath(name))
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return fs.info(file), nil
+}
+
+// Mkdir creates the folder in the server, the vault only has it once a file is pushed in it
+func (fs *VaultFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p := vaultPath(name)
+	if _, ok := fs.stat(p); ok {
+		return os.ErrExist
+	}
+	if !fs.parentExists(p) {
+		return os.ErrNotExist
+	}
+	fs.folders[p] = true
+	return nil
+}
+
+func (fs *VaultFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.refresh()
+	p := vaultPath(name)
+	file, ok := fs.stat(p)
+	switch {
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, os.ErrNotExist
+	case !ok:
+		if !fs.parentExists(p) {
+			return nil, os.ErrNotExist
+		}
+		now := time.Now()
+		return &vaultFile{fs: fs, file: client.File{Path: p, Created: now, Modified: now}, loaded: true, dirty: true}, nil
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, os.ErrExist
+	case file.Folder:
+		return &vaultFile{fs: fs, file: file}, nil
+	}
+
+	f := &vaultFile{fs: fs, file: file}
+	if flag&os.O_TRUNC != 0 {
+		f.loaded, f.dirty = true, true
+		f.file.Modified = time.Now()
+	}
+	return f, nil
+}
+
+// RemoveAll deletes a file, or a folder with everything in it
+func (fs *VaultFS) RemoveAll(ctx context.Context, name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p := vaultPath(name)
+	if p == "" {
+		return os.ErrPermission
+	}
+	// Deepest first, so folders are empty when they're deleted
+	files := fs.under(p)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path > files[j].Path
+	})
+	if file, ok := fs.vault.Stat(p); ok {
+		files = append(files, file)
+	}
+	for _, file := range files {
+		if err := fs.delete(file.Path); err != nil {
+			return err
+		}
+	}
+	for folder := range fs.folders {
+		if folder == p || strings.HasPrefix(folder, p+"/") {
+			delete(fs.folders, folder)
+		}
+	}
+	return nil
+}
+
+// Rename moves a file by pushing it to its new path and deleting the old one, and a folder by moving everything in it
+func (fs *VaultFS) Rename(ctx context.Context, oldName, newName string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	from, to := vaultPath(oldName), vaultPath(newName)
+	file, ok := fs.stat(from)
+	if !ok {
+		return os.ErrNotExist
+	}
+	if from == "" || to == from || strings.HasPrefix(to, from+"/") {
+		return os.ErrInvalid
+	}
+	if !fs.parentExists(to) {
+		return os.ErrNotExist
+	}
+	if !file.Folder {
+		return fs.move(file, to)
+	}
+
+	for _, moved := range fs.under(from) {
+		if moved.Folder {
+			continue
+		}
+		if err := fs.move(moved, to+strings.TrimPrefix(moved.Path, from)); err != nil {
+			return err
+		}
+	}
+	// The folders left behind are empty now
+	folders := fs.under(from)
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Path > folders[j].Path
+	})
+	if folder, ok := fs.vault.Stat(from); ok {
+		folders = append(folders, folder)
+	}
+	for _, folder := range folders {
+		if err := fs.delete(folder.Path); err != nil {
+			return err
+		}
+	}
+	for folder := range fs.folders {
+		if folder == from || strings.HasPrefix(folder, from+"/") {
+			delete(fs.folders, folder)
+			fs.folders[to+strings.TrimPrefix(folder, from)] = true
+		}
+	}
+	if _, ok := fs.stat(to); !ok {
+		// An empty folder has to stay
+		fs.folders[to] = true
+	}
+	return nil
+}
+
+// move pushes a file to a new path and deletes it from the old one, mu must be held
+func (fs *VaultFS) move(file client.File, to string) error {
+	content, err := fs.pull(file)
+	if err != nil {
+		return err
+	}
+	if err := fs.push(to, content, file.Modified); err != nil {
+		return err
+	}
+	return fs.delete(file.Path)
+}
+
+// vaultFile is an open file or folder of a VaultFS. A file's content is pulled when it's first read, and pushed when
+// it's closed if it was written to.
+type vaultFile struct {
+	fs   *VaultFS
+	file client.File
+
+	// Guarded by fs.mu
+	content []byte
+	loaded  bool // content was pulled, or replaced
+	dirty   bool // content changed since it was opened
+	offset  int64
+	listed  int // Folder entries already returned by Readdir
+}
+
+// load pulls the content if it wasn't yet, fs.mu must be held
+func (f *vaultFile) load() error {
+	if f.loaded {
+		return nil
+	}
+	if f.file.Folder {
+		return os.ErrInvalid
+	}
+	content, err := f.fs.pull(f.file)
+	if err != nil {
+		return err
+	}
+	f.content = content
+	f.loaded = true
+	return nil
+}
+
+func (f *vaultFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.content)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.content[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *vaultFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.content)) {
+		f.content = append(f.content, make([]byte, end-int64(len(f.content)))...)
+	}
+	copy(f.content[f.offset:], p)
+	f.offset += int64(len(p))
+	f.file.Modified = time.Now()
+	f.dirty = true
+	return len(p), nil
+}
+
+func (f *vaultFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.content))
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *vaultFile) Readdir(count int) ([]os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if !f.file.Folder {
+		return nil, os.ErrInvalid
+	}
+	children := f.fs.children(f.file.Path)
+	if f.listed > len(children) {
+		f.listed = len(children)
+	}
+	children = children[f.listed:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		if len(children) > count {
+			children = children[:count]
+		}
+	}
+	f.listed += len(children)
+	return children, nil
+}
+
+func (f *vaultFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.loaded {
+		return fileInfo{file: f.file, size: int64(len(f.content))}, nil
+	}
+	return f.fs.info(f.file), nil
+}
+
+// Close pushes the file if it was written to
+func (f *vaultFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if !f.dirty {
+		return nil
+	}
+	f.dirty = false
+	return f.fs.push(f.file.Path, f.content, f.file.Modified)
+}
+
+// fileInfo describes a file or folder of a VaultFS
+type fileInfo struct {
+	file client.File
+	size int64
+}
+
+func (i fileInfo) Name() string {
+	return path.Base("/" + i.file.Path)
+}
+
+func (i fileInfo) Size() int64 {
+	return i.size
+}
+
+func (i fileInfo) Mode() os.FileMode {
+	if i.file.Folder {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (i fileInfo) ModTime() time.Time {
+	return i.file.Modified
+}
+
+func (i fileInfo) IsDir() bool {
+	return i.file.Folder
+}
+
+func (i fileInfo) Sys() interface{} {
+	return nil
+}
+
+// ContentType tells the content type from the extension, since sniffing it would pull the file. Only files with
+// unknown extensions are sniffed.
+func (i fileInfo) ContentType(ctx context.Context) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(i.file.Path)); contentType != "" {
+		return contentType, nil
+	}
+	if api.Extension(i.file.Path) == "md" {
+		return "text/markdown; charset=utf-8", nil
+	}
+	return "", webdav.ErrNotImplemented
+}
@@ -0,0 +1,459 @@
+// Package e2e exercises the client against the live Obsidian Sync service.
+//
+// These tests are opt-in: they only run when OBSIDIAN_SYNC_E2E_EMAIL, OBSIDIAN_SYNC_E2E_PASSWORD,
+// OBSIDIAN_SYNC_E2E_VAULT_ID and OBSIDIAN_SYNC_E2E_VAULT_PASSWORD are set, and they write to the given vault.
+// Always point them at a dedicated test vault:
+//
+//	OBSIDIAN_SYNC_E2E_EMAIL=... OBSIDIAN_SYNC_E2E_PASSWORD=... \
+//	OBSIDIAN_SYNC_E2E_VAULT_ID=... OBSIDIAN_SYNC_E2E_VAULT_PASSWORD=... \
+//	go test -v -count=1 ./e2e/
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/auth"
+	"github.com/nbadal/obsidian-sync/client"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"github.com/nbadal/obsidian-sync/dav"
+	"github.com/nbadal/obsidian-sync/sync"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type e2eEnv struct {
+	token         *crypto.Secret
+	vault         api.VaultInfo
+	vaultPassword *crypto.Secret
+}
+
+var cachedEnv *e2eEnv
+
+// requireEnv skips the test unless e2e credentials are configured, and logs in once per test run
+func requireEnv(t *testing.T) *e2eEnv {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode")
+	}
+
+	email := os.Getenv("OBSIDIAN_SYNC_E2E_EMAIL")
+	password := os.Getenv("OBSIDIAN_SYNC_E2E_PASSWORD")
+	vaultId := os.Getenv("OBSIDIAN_SYNC_E2E_VAULT_ID")
+	vaultPassword := os.Getenv("OBSIDIAN_SYNC_E2E_VAULT_PASSWORD")
+	if email == "" || password == "" || vaultId == "" || vaultPassword == "" {
+		t.Skip("e2e credentials not set")
+	}
+	if cachedEnv != nil {
+		return cachedEnv
+	}
+
+	session, err := auth.Login(email, crypto.SecretString(password), "")
+	if err != nil {
+		t.Fatalf("error logging in: %s", err)
+	}
+	token := session.Token
+
+	vaults, err := api.ListVaults(token)
+	if err != nil {
+		t.Fatalf("error listing vaults: %s", err)
+	}
+	for _, vault := range vaults {
+		if vault.Id == vaultId {
+			cachedEnv = &e2eEnv{token: token, vault: vault, vaultPassword: crypto.SecretString(vaultPassword)}
+			return cachedEnv
+		}
+	}
+	t.Fatalf("vault %s not found", vaultId)
+	return nil
+}
+
+// connect opens and initializes a new connection to the test vault
+func connect(t *testing.T, env *e2eEnv) (*api.ObsidianSocketContext, *api.InitResult) {
+	t.Helper()
+	ctx, err := api.ConnectToVault(env.vault, env.vaultPassword, env.token)
+	if err != nil {
+		t.Fatalf("error connecting to vault: %s", err)
+	}
+	t.Cleanup(func() { _ = ctx.Close() })
+
+	initResult, err := ctx.SendInit(0, true)
+	if err != nil {
+		t.Fatalf("error sending init: %s", err)
+	}
+	return ctx, initResult
+}
+
+// testPath returns a unique path inside the e2e folder of the test vault
+func testPath(t *testing.T, name string) string {
+	return fmt.Sprintf("e2e/%s-%d-%s", t.Name(), time.Now().UnixNano(), name)
+}
+
+// findRemote returns the latest remote entry for a decrypted path, or nil if it's missing or deleted
+func findRemote(t *testing.T, env *e2eEnv, path string) *api.IncomingPushMessage {
+	t.Helper()
+	ctx, initResult := connect(t, env)
+
+	var found *api.IncomingPushMessage
+	for i, push := range initResult.PushedFiles {
+		decryptedPath, err := ctx.Cipher.DecryptString(push.EncryptedPath)
+		if err != nil {
+			t.Fatalf("error decrypting path: %s", err)
+		}
+		if decryptedPath != path {
+			continue
+		}
+		if found == nil || push.Uid > found.Uid {
+			found = &initResult.PushedFiles[i]
+		}
+	}
+	if found != nil && found.Deleted {
+		return nil
+	}
+	return found
+}
+
+func push(t *testing.T, ctx *api.ObsidianSocketContext, path string, content []byte, deleted bool) {
+	t.Helper()
+	now := time.Now().UnixMilli()
+	if _, err := ctx.PushFile(path, api.Extension(path), now, now, false, deleted, content); err != nil {
+		t.Fatalf("error pushing %s: %s", path, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	env := requireEnv(t)
+	if env.token.Empty() {
+		t.Fatal("expected a token")
+	}
+}
+
+func TestInit(t *testing.T) {
+	env := requireEnv(t)
+	ctx, initResult := connect(t, env)
+	if initResult.RemoteUid < 0 {
+		t.Fatalf("unexpected remote uid %d", initResult.RemoteUid)
+	}
+
+	size, limit, err := ctx.GetSizeConfig()
+	if err != nil {
+		t.Fatalf("error getting size config: %s", err)
+	}
+	if limit <= 0 || size < 0 {
+		t.Fatalf("unexpected size config: size=%d limit=%d", size, limit)
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	env := requireEnv(t)
+	ctx, _ := connect(t, env)
+
+	path := testPath(t, "note.md")
+	content := []byte("# e2e\n\npushed at " + time.Now().String())
+	push(t, ctx, path, content, false)
+
+	remote := findRemote(t, env, path)
+	if remote == nil {
+		t.Fatalf("pushed file %s not found remotely", path)
+	}
+
+	pulled, err := ctx.PullFile(remote.Uid, remote.EncryptedHash)
+	if err != nil {
+		t.Fatalf("error pulling file: %s", err)
+	}
+	if string(pulled) != string(content) {
+		t.Fatalf("pulled content mismatch: got %q, want %q", pulled, content)
+	}
+}
+
+func TestPushPullLarge(t *testing.T) {
+	env := requireEnv(t)
+	ctx, _ := connect(t, env)
+
+	// Big enough to be pushed in several pieces
+	path := testPath(t, "large.bin")
+	content := make([]byte, 5*1024*1024)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	push(t, ctx, path, content, false)
+
+	remote := findRemote(t, env, path)
+	if remote == nil {
+		t.Fatalf("pushed file %s not found remotely", path)
+	}
+	pulled, err := ctx.PullFile(remote.Uid, remote.EncryptedHash)
+	if err != nil {
+		t.Fatalf("error pulling file: %s", err)
+	}
+	if string(pulled) != string(content) {
+		t.Fatalf("pulled content mismatch: got %d bytes, want %d", len(pulled), len(content))
+	}
+}
+
+func TestImport(t *testing.T) {
+	env := requireEnv(t)
+
+	// Everything the import pushes lands in the e2e folder of the test vault
+	source := t.TempDir()
+	folder := filepath.Join(source, filepath.FromSlash(testPath(t, "import")))
+	if err := os.MkdirAll(filepath.Join(folder, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.md", "sub/b.md"} {
+		if err := os.WriteFile(filepath.Join(folder, filepath.FromSlash(name)), []byte("# "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The test vault has other files, so it needs merge
+	opts := sync.Options{Parallelism: 2}
+	if _, err := sync.Import(source, false, env.token, env.vault, env.vaultPassword, opts); !errors.Is(err, sync.ErrVaultNotEmpty) {
+		t.Fatalf("expected ErrVaultNotEmpty, got %v", err)
+	}
+	result, err := sync.Import(source, true, env.token, env.vault, env.vaultPassword, opts)
+	if err != nil {
+		t.Fatalf("error importing: %s", err)
+	}
+	if result.Files != 2 {
+		t.Fatalf("expected 2 files pushed, got %+v", result)
+	}
+
+	// Running it again finds everything already there
+	result, err = sync.Import(source, true, env.token, env.vault, env.vaultPassword, opts)
+	if err != nil {
+		t.Fatalf("error importing again: %s", err)
+	}
+	if result.Files != 0 || result.Unchanged < 2 {
+		t.Fatalf("expected nothing pushed on the second import, got %+v", result)
+	}
+}
+
+func TestClient(t *testing.T) {
+	env := requireEnv(t)
+	c := client.New(env.token.Reveal())
+	ctx := context.Background()
+	vault, err := c.Connect(ctx, env.vault.Id, env.vaultPassword.Reveal())
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer vault.Close()
+
+	path := testPath(t, "client.md")
+	content := []byte("pushed by the client at " + time.Now().String())
+	if err := vault.Push(ctx, path, content, time.Now()); err != nil {
+		t.Fatalf("error pushing: %s", err)
+	}
+	if _, ok := vault.Stat(path); !ok {
+		t.Fatalf("pushed file %s not in the index", path)
+	}
+	pulled, err := vault.Pull(ctx, path)
+	if err != nil {
+		t.Fatalf("error pulling: %s", err)
+	}
+	if string(pulled) != string(content) {
+		t.Fatalf("pulled content mismatch: got %q, want %q", pulled, content)
+	}
+
+	if err := vault.Delete(ctx, path); err != nil {
+		t.Fatalf("error deleting: %s", err)
+	}
+	if findRemote(t, env, path) != nil {
+		t.Fatalf("deleted path %s still exists remotely", path)
+	}
+}
+
+func TestWebDAV(t *testing.T) {
+	env := requireEnv(t)
+	c := client.New(env.token.Reveal())
+	ctx := context.Background()
+	vault, err := c.Connect(ctx, env.vault.Id, env.vaultPassword.Reveal())
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	defer vault.Close()
+	fs := dav.NewVaultFS(ctx, vault)
+
+	// The e2e folder may not exist yet, a file pushed in it creates it
+	_ = fs.Mkdir(ctx, "/e2e", 0755)
+	path := testPath(t, "webdav.md")
+	content := []byte("written over WebDAV at " + time.Now().String())
+	f, err := fs.OpenFile(ctx, "/"+path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("error creating: %s", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("error pushing on close: %s", err)
+	}
+	if info, err := fs.Stat(ctx, "/"+path); err != nil || info.Size() != int64(len(content)) {
+		t.Fatalf("stat after push: %v, %v", info, err)
+	}
+
+	movedPath := testPath(t, "moved.md")
+	if err := fs.Rename(ctx, "/"+path, "/"+movedPath); err != nil {
+		t.Fatalf("error renaming: %s", err)
+	}
+	f, err = fs.OpenFile(ctx, "/"+movedPath, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("error opening: %s", err)
+	}
+	pulled, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatalf("error pulling: %s", err)
+	}
+	if string(pulled) != string(content) {
+		t.Fatalf("pulled content mismatch: got %q, want %q", pulled, content)
+	}
+	if findRemote(t, env, path) != nil {
+		t.Fatalf("renamed path %s still exists remotely", path)
+	}
+
+	if err := fs.RemoveAll(ctx, "/"+movedPath); err != nil {
+		t.Fatalf("error deleting: %s", err)
+	}
+	if findRemote(t, env, movedPath) != nil {
+		t.Fatalf("deleted path %s still exists remotely", movedPath)
+	}
+}
+
+func TestRename(t *testing.T) {
+	env := requireEnv(t)
+	ctx, _ := connect(t, env)
+
+	oldPath := testPath(t, "old.md")
+	newPath := testPath(t, "new.md")
+	content := []byte("rename me")
+	push(t, ctx, oldPath, content, false)
+
+	// A rename is a push of the new path followed by a deletion of the old one
+	push(t, ctx, newPath, content, false)
+	push(t, ctx, oldPath, nil, true)
+
+	if findRemote(t, env, oldPath) != nil {
+		t.Fatalf("old path %s still exists remotely", oldPath)
+	}
+	if findRemote(t, env, newPath) == nil {
+		t.Fatalf("new path %s not found remotely", newPath)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	env := requireEnv(t)
+	ctx, _ := connect(t, env)
+
+	path := testPath(t, "delete.md")
+	push(t, ctx, path, []byte("delete me"), false)
+	push(t, ctx, path, nil, true)
+
+	if findRemote(t, env, path) != nil {
+		t.Fatalf("deleted path %s still exists remotely", path)
+	}
+}
+
+func TestConflict(t *testing.T) {
+	env := requireEnv(t)
+	deviceA, _ := connect(t, env)
+	deviceB, _ := connect(t, env)
+
+	// Both devices write the same path, the latest write should win remotely
+	path := testPath(t, "conflict.md")
+	push(t, deviceA, path, []byte("from device A"), false)
+	push(t, deviceB, path, []byte("from device B"), false)
+
+	remote := findRemote(t, env, path)
+	if remote == nil {
+		t.Fatalf("conflicted path %s not found remotely", path)
+	}
+	pulled, err := deviceA.PullFile(remote.Uid, remote.EncryptedHash)
+	if err != nil {
+		t.Fatalf("error pulling file: %s", err)
+	}
+	if string(pulled) != "from device B" {
+		t.Fatalf("expected latest write to win, got %q", pulled)
+	}
+}
+
+func TestSyncToFolder(t *testing.T) {
+	env := requireEnv(t)
+
+	err := sync.Sync(t.TempDir(), env.token, env.vault, env.vaultPassword, sync.Options{})
+	if err != nil {
+		t.Fatalf("error syncing: %s", err)
+	}
+}
+
+func TestFetchEvicted(t *testing.T) {
+	env := requireEnv(t)
+	ctx, _ := connect(t, env)
+
+	path := testPath(t, "attachment.png")
+	content := []byte("evicted at " + time.Now().String())
+	push(t, ctx, path, content, false)
+
+	// Evict everything that's synced, which is only the attachment
+	dir := t.TempDir()
+	opts := sync.Options{Include: []string{path}, Eviction: sync.EvictionPolicy{MinFreeBytes: math.MaxInt64}}
+	if err := sync.Sync(dir, env.token, env.vault, env.vaultPassword, opts); err != nil {
+		t.Fatalf("error syncing: %s", err)
+	}
+	fullPath := filepath.Join(dir, filepath.FromSlash(path))
+	if _, err := os.Stat(fullPath); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be evicted, got %v", path, err)
+	}
+
+	opts.Fetch = []string{path}
+	if err := sync.Sync(dir, env.token, env.vault, env.vaultPassword, opts); err != nil {
+		t.Fatalf("error syncing: %s", err)
+	}
+	fetched, err := os.ReadFile(fullPath)
+	if err != nil {
+		t.Fatalf("error reading fetched file: %s", err)
+	}
+	if string(fetched) != string(content) {
+		t.Fatalf("fetched content mismatch: got %q, want %q", fetched, content)
+	}
+}
+
+func TestDaemonReceivesPush(t *testing.T) {
+	env := requireEnv(t)
+	listener, _ := connect(t, env)
+	pusher, _ := connect(t, env)
+
+	received := make(chan *api.IncomingPushMessage, 1)
+	errs := make(chan error, 1)
+	go func() {
+		msg, err := listener.WaitForPushMessage()
+		if err != nil {
+			errs <- err
+			return
+		}
+		received <- msg
+	}()
+
+	path := testPath(t, "daemon.md")
+	push(t, pusher, path, []byte("daemon"), false)
+
+	select {
+	case msg := <-received:
+		decryptedPath, err := listener.Cipher.DecryptString(msg.EncryptedPath)
+		if err != nil {
+			t.Fatalf("error decrypting pushed path: %s", err)
+		}
+		if decryptedPath != path {
+			t.Fatalf("received push for %s, want %s", decryptedPath, path)
+		}
+	case err := <-errs:
+		t.Fatalf("error waiting for push: %s", err)
+	case <-time.After(time.Minute):
+		t.Fatal("timed out waiting for push")
+	}
+}
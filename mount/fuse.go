@@ -0,0 +1,590 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	gosync "sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/client"
+)
+
+// Mount serves the vault at mountpoint until ctx is done, or it's unmounted by other means. The vault must stay open
+// meanwhile, and isn't safe to use elsewhere since the mount uses it from every FUSE request.
+func Mount(ctx context.Context, vault *client.Vault, mountpoint string, opts Options) error {
+	vfs := &vaultFS{
+		ctx:     ctx,
+		vault:   vault,
+		sizes:   make(map[string]knownSize),
+		folders: make(map[string]bool),
+		pending: make(map[string]*handle),
+	}
+	// Other devices' changes show up after a second at most once they're received
+	timeout := time.Second
+	options := &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName: "obsidian-sync",
+			Name:   "obsidian",
+		},
+		EntryTimeout:    &timeout,
+		AttrTimeout:     &timeout,
+		NegativeTimeout: &timeout,
+		UID:             uint32(os.Getuid()),
+		GID:             uint32(os.Getgid()),
+	}
+	if opts.ReadOnly {
+		options.MountOptions.Options = append(options.MountOptions.Options, "ro")
+	}
+	server, err := fs.Mount(mountpoint, &node{vfs: vfs}, options)
+	if err != nil {
+		return fmt.Errorf("error mounting %s: %s", mountpoint, err)
+	}
+
+	interval := opts.RefreshInterval
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Also reads pushes as they arrive, which would overflow their queue otherwise
+				vfs.mu.Lock()
+				vfs.refresh()
+				vfs.mu.Unlock()
+			case <-ctx.Done():
+				// Unmounting fails while files are open in the mount, so it's retried until they're closed
+				for warned := false; ; warned = true {
+					err := server.Unmount()
+					if err == nil {
+						return
+					}
+					if !warned {
+						api.Log().Warn("⚠️ Could not unmount, retrying until the files open in it are closed", "err", err)
+					}
+					select {
+					case <-done:
+						return
+					case <-time.After(time.Second):
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	server.Wait()
+	close(done)
+	return nil
+}
+
+// vaultFS is the state shared by the nodes of a mount. The vault carries one operation at a time, so everything holds
+// mu while it uses it.
+type vaultFS struct {
+	// ctx is used for vault operations instead of the context of FUSE requests, since canceling an operation closes
+	// the vault's connection
+	ctx   context.Context
+	vault *client.Vault
+
+	mu      gosync.Mutex
+	sizes   map[string]knownSize // Decrypted sizes of the files that were pulled or pushed, by path
+	folders map[string]bool      // Folders made with mkdir, the vault only has them once a file is pushed in them
+	pending map[string]*handle   // Files written to and not pushed yet, by path
+}
+
+// knownSize is the decrypted size of a file, the vault only knows its encrypted size
+type knownSize struct {
+	modified time.Time // Modified time of the version the size is of
+	size     int64
+}
+
+// refresh receives the changes other devices made, mu must be held
+func (v *vaultFS) refresh() {
+	changes, err := v.vault.Refresh(v.ctx)
+	if err != nil {
+		api.Log().Warn("⚠️ Could not receive changes", "err", err)
+	}
+	for _, change := range changes {
+		api.Log().Debug("🔄 Changed by another device", "path", change.Path, "deleted", change.Deleted)
+	}
+}
+
+// stat returns the file or folder at path. Folders made with mkdir, or only known from the files in them, have a zero
+// modified time. mu must be held.
+func (v *vaultFS) stat(p string) (client.File, bool) {
+	if p == "" {
+		return client.File{Folder: true}, true
+	}
+	if h, ok := v.pending[p]; ok {
+		return h.file(), true
+	}
+	if file, ok := v.vault.Stat(p); ok {
+		return file, true
+	}
+	if v.folders[p] || len(v.children(p)) > 0 {
+		return client.File{Path: p, Folder: true}, true
+	}
+	return client.File{}, false
+}
+
+// children returns the files and folders directly in the folder at dir, by name. mu must be held.
+func (v *vaultFS) children(dir string) map[string]client.File {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	children := make(map[string]client.File)
+	add := func(file client.File) {
+		if !strings.HasPrefix(file.Path, prefix) || file.Path == dir {
+			return
+		}
+		name, _, nested := strings.Cut(file.Path[len(prefix):], "/")
+		if !nested {
+			children[name] = file
+		} else if _, ok := children[name]; !ok {
+			// A folder the vault may not have an entry for
+			children[name] = client.File{Path: prefix + name, Folder: true}
+		}
+	}
+	for _, file := range v.vault.Files() {
+		add(file)
+	}
+	for p := range v.folders {
+		add(client.File{Path: p, Folder: true})
+	}
+	for _, h := range v.pending {
+		add(h.file())
+	}
+	return children
+}
+
+// fillAttr fills the attributes of a file or folder, mu must be held
+func (v *vaultFS) fillAttr(file client.File, out *fuse.Attr) {
+	if file.Folder {
+		out.Mode = fuse.S_IFDIR | 0755
+	} else {
+		out.Mode = fuse.S_IFREG | 0644
+		out.Size = uint64(file.Size)
+		// Until a file is pulled its encrypted size is all we know, which is a little larger. Reads are direct, so
+		// they aren't cut short either way.
+		if known, ok := v.sizes[file.Path]; ok && known.modified.Equal(file.Modified) {
+			out.Size = uint64(known.size)
+		}
+	}
+	modified := file.Modified
+	out.SetTimes(nil, &modified, &modified)
+}
+
+// pull pulls the content of the file at path, mu must be held
+func (v *vaultFS) pull(file client.File) ([]byte, error) {
+	api.Log().Debug("📥 Pulling", "path", file.Path)
+	content, err := v.vault.Pull(v.ctx, file.Path)
+	if err != nil {
+		return nil, err
+	}
+	v.sizes[file.Path] = knownSize{modified: file.Modified, size: int64(len(content))}
+	return content, nil
+}
+
+// push pushes the content of the file at path, mu must be held
+func (v *vaultFS) push(p string, content []byte, modified time.Time) error {
+	api.Log().Info("📤 Pushing", "path", p, "size", len(content))
+	if err := v.vault.Push(v.ctx, p, content, modified); err != nil {
+		return err
+	}
+	v.sizes[p] = knownSize{modified: modified, size: int64(len(content))}
+	return nil
+}
+
+// failed logs a vault operation that failed, and returns the error the FUSE request fails with
+func failed(what string, p string, err error) syscall.Errno {
+	api.Log().Warn("⚠️ Could not "+what, "path", p, "err", err)
+	return syscall.EIO
+}
+
+// node is a file or folder in the mount. Nodes only know their path, which go-fuse keeps up to date across renames,
+// everything else is asked of the vault when needed.
+type node struct {
+	fs.Inode
+	vfs *vaultFS
+}
+
+var (
+	_ = (fs.NodeGetattrer)((*node)(nil))
+	_ = (fs.NodeSetattrer)((*node)(nil))
+	_ = (fs.NodeLookuper)((*node)(nil))
+	_ = (fs.NodeReaddirer)((*node)(nil))
+	_ = (fs.NodeOpener)((*node)(nil))
+	_ = (fs.NodeCreater)((*node)(nil))
+	_ = (fs.NodeMkdirer)((*node)(nil))
+	_ = (fs.NodeUnlinker)((*node)(nil))
+	_ = (fs.NodeRmdirer)((*node)(nil))
+	_ = (fs.NodeRenamer)((*node)(nil))
+)
+
+// path returns the node's path in the vault, empty for the root
+func (n *node) path() string {
+	return n.Path(nil)
+}
+
+// child returns the path of the child called name
+func (n *node) child(name string) string {
+	return path.Join(n.path(), name)
+}
+
+// newChild returns the inode of a child with its attributes, mu must be held
+func (n *node) newChild(ctx context.Context, file client.File, out *fuse.EntryOut) *fs.Inode {
+	n.vfs.fillAttr(file, &out.Attr)
+	mode := uint32(fuse.S_IFREG)
+	if file.Folder {
+		mode = fuse.S_IFDIR
+	}
+	return n.NewInode(ctx, &node{vfs: n.vfs}, fs.StableAttr{Mode: mode})
+}
+
+func (n *node) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	if h, ok := f.(*handle); ok {
+		n.vfs.fillAttr(h.file(), &out.Attr)
+		return 0
+	}
+	file, ok := n.vfs.stat(n.path())
+	if !ok {
+		return syscall.ENOENT
+	}
+	n.vfs.fillAttr(file, &out.Attr)
+	return 0
+}
+
+// Setattr only changes sizes, the vault has no permissions or owners and times are set when files are pushed
+func (n *node) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	p := n.path()
+	if size, ok := in.GetSize(); ok {
+		if h, ok := f.(*handle); ok {
+			h.truncate(int64(size))
+		} else {
+			// Truncating a file that isn't open replaces it right away
+			file, ok := n.vfs.stat(p)
+			if !ok {
+				return syscall.ENOENT
+			}
+			if file.Folder {
+				return syscall.EISDIR
+			}
+			var content []byte
+			if size > 0 {
+				pulled, err := n.vfs.pull(file)
+				if err != nil {
+					return failed("pull", p, err)
+				}
+				content = pulled
+			}
+			if err := n.vfs.push(p, resize(content, int64(size)), time.Now()); err != nil {
+				return failed("push", p, err)
+			}
+		}
+	}
+	file, ok := n.vfs.stat(p)
+	if !ok {
+		return syscall.ENOENT
+	}
+	n.vfs.fillAttr(file, &out.Attr)
+	return 0
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	file, ok := n.vfs.stat(n.child(name))
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return n.newChild(ctx, file, out), 0
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	n.vfs.refresh()
+	var entries []fuse.DirEntry
+	for name, file := range n.vfs.children(n.path()) {
+		mode := uint32(fuse.S_IFREG)
+		if file.Folder {
+			mode = fuse.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: mode})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return fs.NewListDirStream(entries), 0
+}
+
+// Open pulls the file, unless it's truncated. Reads are direct since the kernel may still have the encrypted size.
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	p := n.path()
+	h := &handle{vfs: n.vfs, path: p}
+	if flags&syscall.O_TRUNC != 0 {
+		h.truncate(0)
+		return h, fuse.FOPEN_DIRECT_IO, 0
+	}
+	if pending, ok := n.vfs.pending[p]; ok {
+		h.content = append([]byte(nil), pending.content...)
+		h.modified = pending.modified
+		return h, fuse.FOPEN_DIRECT_IO, 0
+	}
+	file, ok := n.vfs.vault.Stat(p)
+	if !ok {
+		return nil, 0, syscall.ENOENT
+	}
+	content, err := n.vfs.pull(file)
+	if err != nil {
+		return nil, 0, failed("pull", p, err)
+	}
+	h.content = content
+	h.modified = file.Modified
+	return h, fuse.FOPEN_DIRECT_IO, 0
+}
+
+// Create creates the file in the mount, it's pushed once it's closed
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	h := &handle{vfs: n.vfs, path: n.child(name)}
+	h.truncate(0)
+	return n.newChild(ctx, h.file(), out), h, fuse.FOPEN_DIRECT_IO, 0
+}
+
+// Mkdir creates the folder in the mount, the vault only has it once a file is pushed in it
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	p := n.child(name)
+	if _, ok := n.vfs.stat(p); ok {
+		return nil, syscall.EEXIST
+	}
+	n.vfs.folders[p] = true
+	return n.newChild(ctx, client.File{Path: p, Folder: true}, out), 0
+}
+
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	p := n.child(name)
+	h, pending := n.vfs.pending[p]
+	if pending {
+		h.deleted = true
+		delete(n.vfs.pending, p)
+	}
+	if _, ok := n.vfs.vault.Stat(p); !ok {
+		if pending {
+			return 0
+		}
+		return syscall.ENOENT
+	}
+	api.Log().Info("🗑️ Deleting", "path", p)
+	if err := n.vfs.vault.Delete(n.vfs.ctx, p); err != nil {
+		return failed("delete", p, err)
+	}
+	return 0
+}
+
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	p := n.child(name)
+	if len(n.vfs.children(p)) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	delete(n.vfs.folders, p)
+	if file, ok := n.vfs.vault.Stat(p); ok && file.Folder {
+		api.Log().Info("🗑️ Deleting", "path", p)
+		if err := n.vfs.vault.Delete(n.vfs.ctx, p); err != nil {
+			return failed("delete", p, err)
+		}
+	}
+	return 0
+}
+
+// Rename moves a file by pushing it to its new path and deleting the old one. Folders can't be moved that way without
+// pushing everything in them, so moving one fails with EXDEV, which makes mv copy it instead.
+func (n *node) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if flags != 0 {
+		return syscall.EINVAL
+	}
+	n.vfs.mu.Lock()
+	defer n.vfs.mu.Unlock()
+	from := n.child(name)
+	to := path.Join(newParent.EmbeddedInode().Path(nil), newName)
+	file, ok := n.vfs.stat(from)
+	if !ok {
+		return syscall.ENOENT
+	}
+	if file.Folder {
+		if n.vfs.folders[from] && len(n.vfs.children(from)) == 0 {
+			delete(n.vfs.folders, from)
+			n.vfs.folders[to] = true
+			return 0
+		}
+		return syscall.EXDEV
+	}
+
+	if h, ok := n.vfs.pending[from]; ok {
+		// Not pushed yet, it's pushed to its new path once it's closed
+		delete(n.vfs.pending, from)
+		h.path = to
+		n.vfs.pending[to] = h
+	} else {
+		content, err := n.vfs.pull(file)
+		if err != nil {
+			return failed("pull", from, err)
+		}
+		if err := n.vfs.push(to, content, file.Modified); err != nil {
+			return failed("push", to, err)
+		}
+	}
+	if _, ok := n.vfs.vault.Stat(from); ok {
+		api.Log().Info("🗑️ Deleting", "path", from)
+		if err := n.vfs.vault.Delete(n.vfs.ctx, from); err != nil {
+			return failed("delete", from, err)
+		}
+	}
+	return 0
+}
+
+// handle is an open file. Its content is pulled when it's opened, and pushed when it's closed if it was written to.
+type handle struct {
+	vfs *vaultFS
+
+	// Guarded by vfs.mu
+	path     string
+	content  []byte
+	modified time.Time
+	dirty    bool // Written to since it was last pushed
+	deleted  bool // Unlinked while open, so it isn't pushed
+}
+
+var (
+	_ = (fs.FileReader)((*handle)(nil))
+	_ = (fs.FileWriter)((*handle)(nil))
+	_ = (fs.FileFlusher)((*handle)(nil))
+	_ = (fs.FileFsyncer)((*handle)(nil))
+	_ = (fs.FileReleaser)((*handle)(nil))
+)
+
+// file returns the handle as a file, with its current size. mu must be held.
+func (h *handle) file() client.File {
+	file, ok := h.vfs.vault.Stat(h.path)
+	if !ok {
+		file = client.File{Path: h.path, Created: h.modified}
+	}
+	file.Size = int64(len(h.content))
+	file.Modified = h.modified
+	return file
+}
+
+// truncate changes the size of the content and marks it to be pushed, mu must be held
+func (h *handle) truncate(size int64) {
+	h.content = resize(h.content, size)
+	h.changed()
+}
+
+// changed marks the content to be pushed, and makes the mount show it until it is. mu must be held.
+func (h *handle) changed() {
+	h.modified = time.Now()
+	h.dirty = true
+	if !h.deleted {
+		h.vfs.pending[h.path] = h
+	}
+}
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+	if off >= int64(len(h.content)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.content)) {
+		end = int64(len(h.content))
+	}
+	// Copied, since the content may change before the result is sent
+	return fuse.ReadResultData(append([]byte(nil), h.content[off:end]...)), 0
+}
+
+func (h *handle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+	if end := off + int64(len(data)); end > int64(len(h.content)) {
+		h.truncate(end)
+	}
+	copy(h.content[off:], data)
+	h.changed()
+	return uint32(len(data)), 0
+}
+
+// Flush pushes the file if it was written to, on every close so close reports failures
+func (h *handle) Flush(ctx context.Context) syscall.Errno {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+	return h.push()
+}
+
+func (h *handle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+	return h.push()
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	h.vfs.mu.Lock()
+	defer h.vfs.mu.Unlock()
+	if h.dirty && !h.deleted {
+		api.Log().Warn("⚠️ Changes were never pushed", "path", h.path)
+	}
+	if h.vfs.pending[h.path] == h {
+		delete(h.vfs.pending, h.path)
+	}
+	return 0
+}
+
+// push pushes the content if it was written to, mu must be held
+func (h *handle) push() syscall.Errno {
+	if !h.dirty || h.deleted {
+		return 0
+	}
+	if err := h.vfs.push(h.path, h.content, h.modified); err != nil {
+		return failed("push", h.path, err)
+	}
+	h.dirty = false
+	if h.vfs.pending[h.path] == h {
+		delete(h.vfs.pending, h.path)
+	}
+	return 0
+}
+
+// resize cuts content to size, or pads it with zeros
+func resize(content []byte, size int64) []byte {
+	if size <= int64(len(content)) {
+		return content[:size]
+	}
+	return append(content, make([]byte, size-int64(len(content)))...)
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package mount
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/nbadal/obsidian-sync/client"
+)
+
+// Mount needs FUSE, which is only supported on Linux and macOS
+func Mount(ctx context.Context, vault *client.Vault, mountpoint string, opts Options) error {
+	return fmt.Errorf("vaults can only be mounted on Linux and macOS, not on %s", runtime.GOOS)
+}
@@ -0,0 +1,14 @@
+// Package mount serves a vault as a FUSE filesystem, so it can be browsed without a local copy. Files are pulled when
+// they're opened and pushed when they're closed, nothing is kept on disk.
+package mount
+
+import "time"
+
+// DefaultRefreshInterval is how often a mount receives the changes other devices made, if Options doesn't say
+const DefaultRefreshInterval = 10 * time.Second
+
+// Options configures a mount
+type Options struct {
+	ReadOnly        bool          // Mount read-only, e.g. for a read-only token
+	RefreshInterval time.Duration // How often changes made by other devices are received, DefaultRefreshInterval if zero
+}
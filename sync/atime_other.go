@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package sync
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime falls back to the modification time on platforms where access time isn't exposed
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,277 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// BlobCache is a directory of encrypted file content keyed by remote UID, plus the remote index it was primed from.
+// Ephemeral environments like CI runners can restore it to check out a vault without pulling every file.
+type BlobCache struct {
+	Dir string
+}
+
+// CacheIndex is the remote index a cache was primed from
+type CacheIndex struct {
+	VaultId       string
+	RemoteUid     int64
+	RemoteEntries map[string]ObsidianRemoteEntry
+	Size          int64
+	Limit         int64
+}
+
+// PrimeResult summarizes a Prime run
+type PrimeResult struct {
+	Pulled  int // Blobs downloaded
+	Cached  int // Blobs already in the cache
+	Removed int // Blobs no longer referenced by the index
+}
+
+func (c *BlobCache) indexPath() string {
+	return filepath.Join(c.Dir, "index.json")
+}
+
+func (c *BlobCache) blobPath(uid int64) string {
+	return filepath.Join(c.Dir, "blobs", strconv.FormatInt(uid, 10))
+}
+
+// Get returns the encrypted content for a UID, if cached
+func (c *BlobCache) Get(uid int64) ([]byte, bool) {
+	data, err := os.ReadFile(c.blobPath(uid))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Put stores the encrypted content for a UID
+func (c *BlobCache) Put(uid int64, data []byte) error {
+	return writeAtomic(c.blobPath(uid), data)
+}
+
+// LoadIndex returns the cached remote index, or nil if the cache hasn't been primed
+func (c *BlobCache) LoadIndex() (*CacheIndex, error) {
+	data, err := os.ReadFile(c.indexPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index := &CacheIndex{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("could not parse cache index: %v", err)
+	}
+	return index, nil
+}
+
+// SaveIndex writes the remote index
+func (c *BlobCache) SaveIndex(index *CacheIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(c.indexPath(), data)
+}
+
+// prune removes blobs for UIDs the index no longer references
+func (c *BlobCache) prune(index *CacheIndex) (int, error) {
+	keep := make(map[string]bool, len(index.RemoteEntries))
+	for _, entry := range index.RemoteEntries {
+		keep[strconv.FormatInt(entry.Uid, 10)] = true
+	}
+
+	files, err := os.ReadDir(filepath.Join(c.Dir, "blobs"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, file := range files {
+		if keep[file.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, "blobs", file.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Prime downloads the remote index and the encrypted content of every file into the cache. A previously primed cache
+// for the same vault is updated incrementally, pulling only new versions.
+func Prime(cacheDir string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (PrimeResult, error) {
+	return PrimeContext(context.Background(), cacheDir, authToken, vault, password, opts)
+}
+
+// PrimeContext is Prime, giving up when c is done
+func PrimeContext(c context.Context, cacheDir string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (PrimeResult, error) {
+	var result PrimeResult
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+	cache := &BlobCache{Dir: cacheDir}
+
+	index, err := cache.LoadIndex()
+	if err != nil {
+		return result, err
+	}
+	if index == nil || index.VaultId != vault.Id {
+		index = &CacheIndex{VaultId: vault.Id, RemoteEntries: map[string]ObsidianRemoteEntry{}}
+	}
+
+	ctx, err := api.ConnectToVaultContext(c, vault, password, authToken)
+	if timedOut(c) && err != nil {
+		return result, fmt.Errorf("prime timed out after %s: %s", opts.Timeout, err)
+	}
+	if err != nil {
+		return result, fmt.Errorf("error connecting to vault: %s", err)
+	}
+	defer ctx.Close()
+	ctx.ReadOnly = true
+	ctx.DeviceName = opts.DeviceName
+	defer closeWhenDone(c, ctx)()
+
+	api.Log().Info("🔄 Initializing")
+	initResult, err := ctx.SendInitContext(c, index.RemoteUid, index.RemoteUid == 0)
+	if err != nil {
+		return result, fmt.Errorf("error sending init message: %s", err)
+	}
+	size, limit, err := ctx.GetSizeConfig()
+	if err != nil {
+		return result, fmt.Errorf("error getting size info: %s", err)
+	}
+
+	// Reuse the sync state's bookkeeping to apply the pushes to the index, which stays encrypted
+	state := &State{RemoteEntries: index.RemoteEntries, RemoteUid: index.RemoteUid}
+	state.applyInit(initResult)
+	index.RemoteEntries = state.RemoteEntries
+	index.RemoteUid = state.RemoteUid
+	index.Size = size
+	index.Limit = limit
+
+	for _, entry := range index.RemoteEntries {
+		if entry.IsFolder {
+			continue
+		}
+		if _, ok := cache.Get(entry.Uid); ok {
+			result.Cached++
+			continue
+		}
+		data, err := ctx.PullEncryptedContext(c, entry.Uid)
+		if err != nil && timedOut(c) {
+			return result, fmt.Errorf("prime timed out after %s: %s", opts.Timeout, err)
+		}
+		if err != nil {
+			return result, fmt.Errorf("error pulling %d: %s", entry.Uid, err)
+		}
+		if err := cache.Put(entry.Uid, data); err != nil {
+			return result, fmt.Errorf("error caching %d: %s", entry.Uid, err)
+		}
+		result.Pulled++
+	}
+
+	if err := cache.SaveIndex(index); err != nil {
+		return result, fmt.Errorf("error saving cache index: %s", err)
+	}
+	result.Removed, err = cache.prune(index)
+	if err != nil {
+		return result, fmt.Errorf("error pruning cache: %s", err)
+	}
+	return result, nil
+}
+
+// pullTempPrefix starts the names of the files content is pulled into before it replaces the local file
+const pullTempPrefix = ".obsidian-sync-pull-"
+
+// pullToFile writes an entry's decrypted content to the file at fullPath, returning its size. Without a cache the
+// content is streamed to disk as it's pulled, so large files aren't held in memory. With one it's pulled whole, from
+// the cache if possible, since the cache keeps the encrypted content. The file is only replaced once all the content
+// arrived and matched its hash, with the remote modification time.
+func (s *State) pullToFile(ws *api.ObsidianSocketContext, entry ObsidianRemoteEntry, fullPath string, onTransfer api.TransferFunc) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), pullTempPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("error writing file to disk: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var size int64
+	if s.Cache != nil {
+		content, err := s.pullContent(ws, entry, onTransfer)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tmp.Write(content); err != nil {
+			return 0, fmt.Errorf("error writing file to disk: %s", err)
+		}
+		size = int64(len(content))
+	} else {
+		err = s.useSocket(ws, entry.Path, onTransfer, func() error {
+			// A retry writes the content again from the start
+			if err := tmp.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			size, err = ws.PullFileToContext(s.context(), entry.Uid, entry.EncryptedHash, tmp)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return 0, fmt.Errorf("error writing file to disk: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("error writing file to disk: %s", err)
+	}
+	setModified(tmp.Name(), entry.Modified)
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return 0, fmt.Errorf("error writing file to disk: %s", err)
+	}
+	return size, nil
+}
+
+// pullContent returns an entry's decrypted content, from the cache if possible. Pulled content is added to the cache.
+// Only the pull itself holds the connection, so content can be decrypted in parallel with other transfers.
+func (s *State) pullContent(ws *api.ObsidianSocketContext, entry ObsidianRemoteEntry, onTransfer api.TransferFunc) ([]byte, error) {
+	if s.Cache != nil {
+		if data, ok := s.Cache.Get(entry.Uid); ok {
+			content, err := ws.DecryptContent(data, entry.EncryptedHash)
+			if err == nil {
+				return content, nil
+			}
+			api.Log().Warn("⚠️ Ignoring bad cached content", "uid", entry.Uid, "err", err)
+		}
+	}
+
+	var data []byte
+	err := s.useSocket(ws, entry.Path, onTransfer, func() error {
+		var err error
+		data, err = ws.PullEncryptedContext(s.context(), entry.Uid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.Cache != nil {
+		if err := s.Cache.Put(entry.Uid, data); err != nil {
+			api.Log().Warn("⚠️ Could not cache content", "uid", entry.Uid, "err", err)
+		}
+	}
+	return ws.DecryptContent(data, entry.EncryptedHash)
+}
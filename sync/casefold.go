@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// foldsCase returns true if the filesystem of dir treats names differing only by case as the same file, like those of
+// Windows and macOS do by default. Guesses from the platform if dir can't be written to.
+func foldsCase(dir string) bool {
+	probe, err := os.CreateTemp(dir, ".obsidian-sync-case-")
+	if err != nil {
+		return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+	}
+	name := probe.Name()
+	_ = probe.Close()
+	defer os.Remove(name)
+	_, err = os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(name))))
+	return err == nil
+}
+
+// findCaseCollisions records the remote paths that differ only by case from another one, if the folder's filesystem
+// folds case. They would be the same local file, each pull clobbering the other. The first of each group in byte
+// order, so "Note.md" before "note.md", keeps the name and syncs, and the others are left alone on this device until
+// they're renamed in the vault. The policy doesn't depend on anything local, so every device picks the same file.
+// s.mu must be held.
+func (s *State) findCaseCollisions() {
+	s.caseShadowed = nil
+	s.caseFolded = nil
+	if !s.caseInsensitive {
+		return
+	}
+	paths := make([]string, 0, len(s.RemoteEntries))
+	s.caseFolded = make(map[string]bool, len(s.RemoteEntries))
+	for encryptedPath := range s.RemoteEntries {
+		path, err := s.remotePath(encryptedPath)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, path)
+		s.caseFolded[strings.ToLower(path)] = true
+	}
+
+	for _, group := range caseCollisions(paths) {
+		for _, path := range group[1:] {
+			if s.caseShadowed == nil {
+				s.caseShadowed = make(map[string]string)
+			}
+			s.caseShadowed[path] = group[0]
+			if !s.caseWarned[path] {
+				api.Log().Warn("⚠️ Not syncing a file that differs only by case from another, which this filesystem can't "+
+					"tell apart. Rename one of them in the vault", "path", path, "kept", group[0])
+				if s.caseWarned == nil {
+					s.caseWarned = make(map[string]bool)
+				}
+				s.caseWarned[path] = true
+			}
+		}
+	}
+}
+
+// withoutCaseCollisions removes the paths findCaseCollisions left alone
+func (s *State) withoutCaseCollisions(paths []string, decrypted func(path string) string) []string {
+	if len(s.caseShadowed) == 0 {
+		return paths
+	}
+	kept := paths[:0]
+	for _, path := range paths {
+		if _, ok := s.caseShadowed[decrypted(path)]; !ok {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+// withoutFoldedDeletes removes deletes of local files whose name, ignoring case, still belongs to a remote file. On a
+// filesystem that folds case that's the same file, so only the local entry is forgotten. s.mu must be held.
+func (s *State) withoutFoldedDeletes(paths []string) []string {
+	if len(s.caseFolded) == 0 {
+		return paths
+	}
+	kept := paths[:0]
+	for _, path := range paths {
+		localFile := s.LocalFiles[path]
+		if _, inRemote := s.RemoteEntries[path]; !inRemote && localFile.Path != "" && s.caseFolded[strings.ToLower(localFile.Path)] {
+			api.Log().Info("ℹ️ Keeping a file another remote file shares a name with, ignoring case", "path", localFile.Path)
+			delete(s.LocalFiles, path)
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
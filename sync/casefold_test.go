@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCaseCollisions(t *testing.T) {
+	tests := []struct {
+		name            string
+		paths           []string
+		caseInsensitive bool
+		wantShadowed    map[string]string // Left alone path to the one that keeps the name
+		wantSynced      []string          // In the order of paths
+	}{
+		{"no collisions", []string{"a.md", "b.md"}, true, nil, []string{"a.md", "b.md"}},
+		{"case sensitive filesystem", []string{"Note.md", "note.md"}, false, nil, []string{"Note.md", "note.md"}},
+		{"first in byte order wins", []string{"note.md", "Note.md"}, true,
+			map[string]string{"note.md": "Note.md"}, []string{"Note.md"}},
+		{"three way", []string{"NOTE.md", "note.md", "Note.md", "other.md"}, true,
+			map[string]string{"Note.md": "NOTE.md", "note.md": "NOTE.md"}, []string{"NOTE.md", "other.md"}},
+		{"folders", []string{"Daily/a.md", "daily/a.md", "daily/b.md"}, true,
+			map[string]string{"daily/a.md": "Daily/a.md"}, []string{"Daily/a.md", "daily/b.md"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &State{RemoteEntries: make(map[string]ObsidianRemoteEntry), caseInsensitive: tt.caseInsensitive}
+			var encryptedPaths []string
+			decrypted := make(map[string]string)
+			for _, path := range tt.paths {
+				s.RemoteEntries["e"+path] = ObsidianRemoteEntry{EncryptedPath: "e" + path, Path: path}
+				encryptedPaths = append(encryptedPaths, "e"+path)
+				decrypted["e"+path] = path
+			}
+
+			s.findCaseCollisions()
+			if !reflect.DeepEqual(s.caseShadowed, tt.wantShadowed) {
+				t.Errorf("caseShadowed = %v, want %v", s.caseShadowed, tt.wantShadowed)
+			}
+			for path := range tt.wantShadowed {
+				if !s.caseWarned[path] {
+					t.Errorf("%s was not reported", path)
+				}
+			}
+
+			var synced []string
+			for _, encryptedPath := range s.withoutCaseCollisions(encryptedPaths, func(p string) string { return decrypted[p] }) {
+				synced = append(synced, decrypted[encryptedPath])
+			}
+			if !reflect.DeepEqual(synced, tt.wantSynced) {
+				t.Errorf("withoutCaseCollisions() = %v, want %v", synced, tt.wantSynced)
+			}
+		})
+	}
+}
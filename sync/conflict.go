@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"os"
+	"time"
+)
+
+// resolveConflict reconciles a file that changed both locally and remotely. JSON files like canvases are merged,
+// anything else keeps both versions: the local file stays in place and the remote version is saved as a copy.
+func (s *State) resolveConflict(ws *api.ObsidianSocketContext, path string, decryptedPath string) error {
+	s.mu.Lock()
+	remoteEntry := s.RemoteEntries[path]
+	s.mu.Unlock()
+	fullPath, err := s.localPath(decryptedPath)
+	if err != nil {
+		return err
+	}
+
+	remoteContent, err := ws.PullFileContext(s.context(), remoteEntry.Uid, remoteEntry.EncryptedHash)
+	if err != nil {
+		return fmt.Errorf("error pulling remote version: %s", err)
+	}
+	localContent, err := os.ReadFile(fullPath)
+	if err != nil {
+		return fmt.Errorf("error reading local version: %s", err)
+	}
+
+	if jsonMergeable(decryptedPath) {
+		merged, err := mergeJSON(decryptedPath, localContent, remoteContent)
+		if err == nil {
+			api.Log().Info("🔀 Merged", "path", decryptedPath)
+			if err := os.WriteFile(fullPath, merged, 0644); err != nil {
+				return fmt.Errorf("error writing merged file: %s", err)
+			}
+			s.changes.record(changeModified, decryptedPath)
+			return s.pushResolved(ws, path, decryptedPath, merged)
+		}
+		api.Log().Warn("⚠️ Could not merge, keeping both versions", "path", decryptedPath, "err", err)
+	}
+
+	// Keep both versions
+	s.mu.Lock()
+	copyPath, err := s.conflictCopyPath(decryptedPath, remoteEntry.Device, time.Now())
+	if err == nil {
+		err = s.checkQuota("", int64(len(remoteContent)))
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	api.Log().Info("📑 Saving remote version as a copy", "path", decryptedPath, "copy", copyPath)
+	fullCopyPath, err := s.localPath(copyPath)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(fullCopyPath, remoteContent, 0644); err != nil {
+		return fmt.Errorf("error writing conflict copy: %s", err)
+	}
+	s.changes.record(changeAdded, copyPath)
+	now := time.Now().UnixMilli()
+	copyEcho, err := ws.PushFileContext(s.context(), copyPath, api.Extension(copyPath), now, now, false, false, remoteContent)
+	if err != nil {
+		return fmt.Errorf("error pushing conflict copy: %s", err)
+	}
+
+	// Track the copy as synced, so the next scan doesn't find it as a new file
+	setModified(fullCopyPath, now)
+	s.mu.Lock()
+	s.recordPush("", int64(len(remoteContent)))
+	s.updateWithPush(copyEcho)
+	s.recordPulled(copyEcho.EncryptedPath, copyPath)
+	s.mu.Unlock()
+	return s.pushResolved(ws, path, decryptedPath, localContent)
+}
+
+// pushResolved pushes the resolved content of a conflicted file and records the new version in both local and remote
+// state, so the next sync doesn't detect the same conflict again
+func (s *State) pushResolved(ws *api.ObsidianSocketContext, path string, decryptedPath string, content []byte) error {
+	s.mu.Lock()
+	err := s.checkQuota(path, int64(len(content)))
+	localEntry := s.LocalFiles[path]
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	echo, err := ws.PushFileContext(s.context(), decryptedPath, api.Extension(decryptedPath), localEntry.Created, now, false, false, content)
+	if err != nil {
+		return fmt.Errorf("error pushing resolved file: %s", err)
+	}
+	if fullPath, err := s.localPath(decryptedPath); err == nil {
+		setModified(fullPath, echo.Mtime)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordPush(path, int64(len(content)))
+	localEntry.Modified = echo.Mtime
+	s.LocalFiles[path] = localEntry
+	remoteEntry := s.RemoteEntries[path]
+	remoteEntry.Uid = echo.Uid
+	remoteEntry.EncryptedHash = echo.EncryptedHash
+	remoteEntry.Modified = echo.Mtime
+	remoteEntry.Size = echo.Size
+	s.RemoteEntries[path] = remoteEntry
+	if echo.Uid > s.RemoteUid {
+		s.RemoteUid = echo.Uid
+	}
+	s.recordSynced(path)
+	return nil
+}
+
+// setModified sets the modification time of a file written for a version whose time the state records, so scanLocal
+// doesn't see a change. A file it fails for is only compared by content.
+func setModified(fullPath string, modified int64) {
+	at := time.UnixMilli(modified)
+	if err := os.Chtimes(fullPath, at, at); err != nil {
+		api.Log().Debug("⚠️ Could not set modification time", "path", fullPath, "err", err)
+	}
+}
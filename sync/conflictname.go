@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultConflictTemplate names conflict copies like "Note (conflicted copy 2006-01-02 150405).md"
+const DefaultConflictTemplate = "{name} (conflicted copy {date} {time}){ext}"
+
+// conflictPlaceholders are the values a conflict template can use
+var conflictPlaceholders = []string{"{name}", "{ext}", "{device}", "{date}", "{time}", "{counter}"}
+
+// maxConflictCopies bounds the search for a free conflict copy name
+const maxConflictCopies = 1000
+
+// ValidateConflictTemplate checks a conflict copy template. Templates must include {name} so copies stay next to and
+// recognizable as their original, and produce a file name rather than a path.
+func ValidateConflictTemplate(template string) error {
+	if !strings.Contains(template, "{name}") {
+		return fmt.Errorf("conflict template must contain {name}")
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return fmt.Errorf("conflict template can't contain path separators")
+	}
+	rest := template
+	for _, placeholder := range conflictPlaceholders {
+		rest = strings.ReplaceAll(rest, placeholder, "")
+	}
+	if i := strings.Index(rest, "{"); i >= 0 && strings.Contains(rest[i:], "}") {
+		return fmt.Errorf("unknown placeholder in conflict template, expected one of %s", strings.Join(conflictPlaceholders, ", "))
+	}
+	return nil
+}
+
+// conflictCopyPath returns a free path, next to the original, for a conflicting remote version written by device.
+// Names that are taken on disk or in the local state get a counter, and long names are shortened to fit the
+// filesystem's name limit. s.mu must be held.
+func (s *State) conflictCopyPath(path string, device string, at time.Time) (string, error) {
+	template := s.ConflictTemplate
+	if template == "" {
+		template = DefaultConflictTemplate
+	}
+	if device == "" {
+		device = "unknown"
+	}
+
+	dir, file := filepath.Split(path)
+	ext := filepath.Ext(file)
+	name := strings.TrimSuffix(file, ext)
+	if name == "" {
+		// A dotfile like ".gitignore" is all name
+		name, ext = file, ""
+	}
+
+	taken := make(map[string]bool, len(s.LocalFiles))
+	for _, localFile := range s.LocalFiles {
+		taken[strings.ToLower(localFile.Path)] = true
+	}
+
+	for counter := 1; counter <= maxConflictCopies; counter++ {
+		copyName := renderConflictName(template, name, ext, device, at, counter)
+		copyPath := filepath.ToSlash(filepath.Join(dir, copyName))
+		if copyPath == path || taken[strings.ToLower(copyPath)] {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(s.TargetPath, copyPath)); !os.IsNotExist(err) {
+			continue
+		}
+		return copyPath, nil
+	}
+	return "", fmt.Errorf("no free conflict copy name for %s after %d attempts", path, maxConflictCopies)
+}
+
+// renderConflictName fills in a conflict template, shortening the original name if the result would be too long.
+// Without {counter} in the template, counters after the first are appended before the extension.
+func renderConflictName(template string, name string, ext string, device string, at time.Time, counter int) string {
+	if counter > 1 && !strings.Contains(template, "{counter}") {
+		template = strings.Replace(template, "{ext}", " {counter}{ext}", 1)
+		if !strings.Contains(template, "{counter}") {
+			template += " {counter}"
+		}
+	}
+
+	render := func(name string) string {
+		return strings.NewReplacer(
+			"{name}", name,
+			"{ext}", ext,
+			"{device}", sanitizeNamePart(device),
+			"{date}", at.Format("2006-01-02"),
+			"{time}", at.Format("150405"),
+			"{counter}", strconv.Itoa(counter),
+		).Replace(template)
+	}
+
+	rendered := render(name)
+	if overflow := len(rendered) - maxNameLength; overflow > 0 {
+		rendered = render(truncateUTF8(name, len(name)-overflow))
+	}
+	return rendered
+}
+
+// sanitizeNamePart replaces characters that aren't portable in file names, for values like device names
+func sanitizeNamePart(value string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
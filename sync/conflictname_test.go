@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConflictCopyPath(t *testing.T) {
+	at := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+	const copySuffix = " (conflicted copy 2024-03-05 140709).md"
+	tests := []struct {
+		name     string
+		template string
+		path     string
+		device   string
+		local    []string // Paths already in the local state
+		onDisk   []string // Paths that exist on disk but aren't tracked
+		want     string
+	}{
+		{"extension", "", "notes/Note.md", "", nil, nil, "notes/Note (conflicted copy 2024-03-05 140709).md"},
+		{"several dots", "", "archive.tar.gz", "", nil, nil, "archive.tar (conflicted copy 2024-03-05 140709).gz"},
+		{"no extension", "", "README", "", nil, nil, "README (conflicted copy 2024-03-05 140709)"},
+		{"dotfile", "", ".gitignore", "", nil, nil, ".gitignore (conflicted copy 2024-03-05 140709)"},
+		{"dotfile with extension", "", ".obsidian/.hotkeys.json", "", nil, nil, ".obsidian/.hotkeys (conflicted copy 2024-03-05 140709).json"},
+		{"taken in the state", "", "Note.md", "",
+			[]string{"Note (conflicted copy 2024-03-05 140709).md"}, nil,
+			"Note (conflicted copy 2024-03-05 140709) 2.md"},
+		{"taken ignoring case", "", "Note.md", "",
+			[]string{"note (CONFLICTED copy 2024-03-05 140709).md"}, nil,
+			"Note (conflicted copy 2024-03-05 140709) 2.md"},
+		{"taken on disk", "", "Note.md", "", nil,
+			[]string{"Note (conflicted copy 2024-03-05 140709).md", "Note (conflicted copy 2024-03-05 140709) 2.md"},
+			"Note (conflicted copy 2024-03-05 140709) 3.md"},
+		{"template with counter", "{name}.{device}.{counter}{ext}", "Note.md", "phone", []string{"Note.phone.1.md"}, nil,
+			"Note.phone.2.md"},
+		{"template same as the original", "{name}{ext}", "Note.md", "", nil, nil, "Note 2.md"},
+		{"unknown device", "{name} ({device}){ext}", "Note.md", "", nil, nil, "Note (unknown).md"},
+		{"device with separators", "{name} ({device}){ext}", "Note.md", `a/b\c`, nil, nil, "Note (a_b_c).md"},
+		{"long name", "", strings.Repeat("n", 250) + ".md", "", nil, nil,
+			strings.Repeat("n", maxNameLength-len(copySuffix)) + copySuffix},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := &State{TargetPath: dir, ConflictTemplate: tt.template, LocalFiles: make(map[string]ObsidianLocalEntry)}
+			for _, path := range tt.local {
+				s.LocalFiles["e"+path] = ObsidianLocalEntry{Path: path}
+			}
+			for _, path := range tt.onDisk {
+				if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(path)), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := s.conflictCopyPath(tt.path, tt.device, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("conflictCopyPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// ErrNoDaemon is returned when no daemon is syncing a folder
+var ErrNoDaemon = errors.New("no daemon is syncing this folder")
+
+// controlTimeout bounds a request on a daemon's control socket
+const controlTimeout = 5 * time.Second
+
+// DaemonReport is what a running daemon answers about itself on its control socket
+type DaemonReport struct {
+	DaemonStatus
+	Failed []string     `json:"failed"`         // Self-checks that are failing, as "name: error"
+	Paused bool         `json:"paused"`         // Changes are received but not applied, see pauseDaemon
+	Sync   *SyncSummary `json:"sync,omitempty"` // Nil until the daemon's first sync pass finished
+}
+
+// currentReport returns the report of the daemon running in this process for targetPath
+func currentReport(targetPath string) (DaemonReport, error) {
+	status, err := ReadDaemonStatus(targetPath)
+	if err != nil {
+		return DaemonReport{}, err
+	}
+	report := DaemonReport{DaemonStatus: status, Paused: daemonPaused(), Sync: currentSummary()}
+	for _, check := range CurrentHealth().Failed() {
+		report.Failed = append(report.Failed, fmt.Sprintf("%s: %s", check.Name, check.Err))
+	}
+	return report, nil
+}
+
+// controlSocketPath returns the control socket of the daemon syncing the vault folder at targetPath
+func controlSocketPath(targetPath string) (string, error) {
+	path, err := StatePath(targetPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, ".json") + ".sock", nil
+}
+
+// DaemonLogPath returns where a daemon started in the background for the vault folder at targetPath logs to
+func DaemonLogPath(targetPath string) (string, error) {
+	path, err := StatePath(targetPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, ".json") + ".daemon.log", nil
+}
+
+// serveControl answers requests on the control socket for targetPath until the returned function is called, calling
+// stop when asked to stop. Fails if another daemon is already syncing the folder.
+func serveControl(targetPath string, stop func()) (func(), error) {
+	path, err := controlSocketPath(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := net.DialTimeout("unix", path, controlTimeout); err == nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("a daemon is already syncing %s", targetPath)
+	}
+	// Left behind by a daemon that died
+	_ = os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening control socket: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			answerControl(conn, targetPath, stop)
+		}
+	}()
+	return func() {
+		_ = listener.Close()
+		<-done
+		daemonEvents.closeAll()
+		_ = os.Remove(path)
+	}, nil
+}
+
+// answerControl answers a single request: "status" or "stop", or "events", which streams events until the follower
+// hangs up
+func answerControl(conn net.Conn, targetPath string, stop func()) {
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	if strings.TrimSpace(request) == "events" {
+		go streamEvents(conn)
+		return
+	}
+	defer conn.Close()
+
+	var reply struct {
+		Report *DaemonReport `json:"report,omitempty"`
+		Error  string        `json:"error,omitempty"`
+	}
+	switch strings.TrimSpace(request) {
+	case "status":
+		report, err := currentReport(targetPath)
+		if err != nil {
+			reply.Error = err.Error()
+			break
+		}
+		reply.Report = &report
+	case "stop":
+		api.Log().Info("👻 Asked to stop")
+		stop()
+	default:
+		reply.Error = fmt.Sprintf("unknown request %q", strings.TrimSpace(request))
+	}
+	_ = json.NewEncoder(conn).Encode(reply)
+}
+
+// controlRequest sends a request to the daemon syncing targetPath and decodes its report, if any
+func controlRequest(targetPath string, request string) (*DaemonReport, error) {
+	path, err := controlSocketPath(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return nil, ErrNoDaemon
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+	if _, err := fmt.Fprintln(conn, request); err != nil {
+		return nil, fmt.Errorf("error sending %s request: %s", request, err)
+	}
+
+	var reply struct {
+		Report *DaemonReport `json:"report"`
+		Error  string        `json:"error"`
+	}
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("error reading %s reply: %s", request, err)
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Report, nil
+}
+
+// QueryDaemon asks the daemon syncing the vault folder at targetPath how it's doing. Returns ErrNoDaemon if there is
+// none.
+func QueryDaemon(targetPath string) (DaemonReport, error) {
+	report, err := controlRequest(targetPath, "status")
+	if err != nil {
+		return DaemonReport{}, err
+	}
+	if report == nil {
+		return DaemonReport{}, fmt.Errorf("the daemon sent no status")
+	}
+	return *report, nil
+}
+
+// StopDaemon asks the daemon syncing the vault folder at targetPath to stop, and waits up to timeout for it to exit.
+// Returns ErrNoDaemon if there is none.
+func StopDaemon(targetPath string, timeout time.Duration) error {
+	if _, err := controlRequest(targetPath, "stop"); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		// The socket goes away last
+		if _, err := controlRequest(targetPath, "status"); errors.Is(err, ErrNoDaemon) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("the daemon didn't stop within %s", timeout)
+}
@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// daemonHeartbeat is how often a running daemon refreshes its status file. A file that wasn't refreshed for a few
+// intervals was left behind by a daemon that died.
+const daemonHeartbeat = 30 * time.Second
+
+// DaemonStatus is what a daemon last reported about itself in its status file
+type DaemonStatus struct {
+	Running   bool      `json:"running"`   // The daemon refreshed its status recently
+	Pid       int       `json:"pid"`       // Process ID of the daemon
+	Started   time.Time `json:"started"`   // When the daemon started
+	Heartbeat time.Time `json:"heartbeat"` // When the daemon last refreshed its status
+	Degraded  bool      `json:"degraded"`  // A self-check was failing, see CurrentHealth
+}
+
+// daemonFilePath returns the status file of the daemon syncing the vault folder at targetPath
+func daemonFilePath(targetPath string) (string, error) {
+	path, err := StatePath(targetPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, ".json") + ".daemon.json", nil
+}
+
+// startHeartbeat writes the daemon status file for targetPath, refreshing it until c is done or the returned function
+// is called, which removes it
+func startHeartbeat(c context.Context, targetPath string) func() {
+	path, err := daemonFilePath(targetPath)
+	if err != nil {
+		return func() {}
+	}
+	status := DaemonStatus{Running: true, Pid: os.Getpid(), Started: time.Now()}
+	write := func() {
+		status.Heartbeat = time.Now()
+		status.Degraded = CurrentHealth().Degraded
+		data, err := json.Marshal(status)
+		if err == nil {
+			err = writeAtomic(path, data)
+		}
+		if err != nil {
+			api.Log().Warn("⚠️ Could not write daemon status", "err", err)
+		}
+	}
+	write()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(daemonHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				write()
+			case <-c.Done():
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		_ = os.Remove(path)
+	}
+}
+
+// ReadDaemonStatus returns the status of the daemon syncing the vault folder at targetPath. Running is false if there
+// is no daemon, or its status is stale.
+func ReadDaemonStatus(targetPath string) (DaemonStatus, error) {
+	path, err := daemonFilePath(targetPath)
+	if err != nil {
+		return DaemonStatus{}, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DaemonStatus{}, nil
+	}
+	if err != nil {
+		return DaemonStatus{}, err
+	}
+	var status DaemonStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return DaemonStatus{}, err
+	}
+	status.Running = time.Since(status.Heartbeat) < 3*daemonHeartbeat
+	return status, nil
+}
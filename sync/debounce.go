@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// DefaultDebounce is how long a daemon waits for the vault to go quiet before syncing. The app saves open notes every
+// few seconds while they're edited, so another device editing one sends a burst of pushes.
+const DefaultDebounce = 2 * time.Second
+
+// DefaultPollInterval is how often a daemon checks the folder for local changes, see State.hasLocalChanges
+const DefaultPollInterval = 5 * time.Second
+
+// maxDebounceRounds is how many quiet periods a daemon waits at most, so a vault that never goes quiet still syncs
+const maxDebounceRounds = 10
+
+// coalescePushes applies the push messages that arrive until none did for Debounce, so a burst of changes is planned
+// in one sync pass instead of one each. Messages already waiting are applied even without Debounce. Returns early if
+// the daemon is asked to stop, and fails like WaitForPushMessage if the connection was lost.
+func (s *State) coalescePushes(ws *api.ObsidianSocketContext) error {
+	coalesced := 0
+	defer func() {
+		if coalesced > 0 {
+			api.Log().Debug("📦 Coalesced push messages", "count", coalesced)
+		}
+	}()
+
+	for round := 0; ; round++ {
+		pushes, err := ws.PendingPushMessages()
+		for _, push := range pushes {
+			s.UpdateWithPush(push)
+		}
+		coalesced += len(pushes)
+		if err != nil {
+			return err
+		}
+		if s.Debounce <= 0 || (round > 0 && len(pushes) == 0) || round == maxDebounceRounds {
+			return nil
+		}
+
+		timer := time.NewTimer(s.Debounce)
+		select {
+		case <-timer.C:
+		case <-s.stopping().Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// settleLocal waits until the folder didn't change for Debounce, so a burst of local edits, like the app saving a note
+// every few seconds while it's typed in, is planned in one sync pass instead of one each. Push messages that arrive
+// meanwhile are applied to be planned in the same pass. Returns early if the daemon is asked to stop, and fails like
+// WaitForPushMessage if the connection was lost.
+func (s *State) settleLocal(ws *api.ObsidianSocketContext) error {
+	if s.Debounce <= 0 {
+		return nil
+	}
+	stamps := s.localStamps()
+	for round := 0; round < maxDebounceRounds; round++ {
+		timer := time.NewTimer(s.Debounce)
+		select {
+		case <-timer.C:
+		case <-s.stopping().Done():
+			timer.Stop()
+			return nil
+		}
+
+		pushes, err := ws.PendingPushMessages()
+		for _, push := range pushes {
+			s.UpdateWithPush(push)
+		}
+		if err != nil {
+			return err
+		}
+		settled := s.localStamps()
+		if reflect.DeepEqual(settled, stamps) {
+			return nil
+		}
+		api.Log().Debug("✏️ Folder still changing")
+		stamps = settled
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package sync
+
+import "fmt"
+
+// freeDiskSpace is not supported on this platform
+func freeDiskSpace(path string) (int64, error) {
+	return 0, fmt.Errorf("free disk space check is not supported on this platform")
+}
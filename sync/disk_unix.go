@@ -0,0 +1,14 @@
+//go:build unix
+
+package sync
+
+import "syscall"
+
+// freeDiskSpace returns the number of bytes available to the current user on the filesystem containing path
+func freeDiskSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
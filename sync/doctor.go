@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// maxClockSkew is how far the local clock may be off before Doctor fails it. Modification times decide which side of a
+// change wins, and are compared with those of other devices.
+const maxClockSkew = time.Minute
+
+// minFreeSpace is the least free disk space Doctor passes, even for an empty vault
+const minFreeSpace = 100 * 1024 * 1024
+
+// Diagnosis is the result of one of Doctor's checks
+type Diagnosis struct {
+	Check
+	Skipped bool   // The check couldn't run, because an earlier one failed or something it needs wasn't given
+	Detail  string // What was found, e.g. the free disk space
+	Hint    string // How to fix a failed check
+}
+
+// Doctor checks what a sync needs, one step at a time so a failure points at its cause: the auth token, access to the
+// vault, the websocket connection to its host, the vault password, the clock, and the vault folder at targetPath. The
+// vault and folder checks are skipped if vaultId or targetPath is empty, and the password check if password is nil and
+// the vault doesn't have a managed one. Nothing is changed.
+func Doctor(c context.Context, targetPath string, authToken *crypto.Secret, vaultId string, password *crypto.Secret) []Diagnosis {
+	var diagnoses []Diagnosis
+	add := func(d Diagnosis) bool {
+		diagnoses = append(diagnoses, d)
+		return d.Err == nil && !d.Skipped
+	}
+	skip := func(names []string, why string) {
+		for _, name := range names {
+			add(Diagnosis{Check: Check{Name: name}, Skipped: true, Detail: why})
+		}
+	}
+
+	// Also tells whether the API is reachable at all, so a failing token check can point at the network instead
+	skew, skewErr := api.ClockSkew()
+
+	vault, why := doctorAccount(add, skip, authToken, vaultId, skewErr == nil)
+	if vault == nil {
+		skip([]string{"websocket", "vault password"}, why)
+	} else {
+		doctorVault(c, add, skip, *vault, authToken, password)
+	}
+
+	clock := Diagnosis{Check: Check{Name: "clock", Err: skewErr}, Hint: apiHint()}
+	if skewErr == nil {
+		clock.Detail = fmt.Sprintf("%s off the server's", skew.Round(time.Second))
+		if skew > maxClockSkew || skew < -maxClockSkew {
+			clock.Err = fmt.Errorf("clock is off by more than %s", maxClockSkew)
+			clock.Hint = "sync the clock with NTP, file modification times decide which side of a change wins"
+		}
+	}
+	add(clock)
+
+	folderChecks := []string{"disk space", "folder writable", "file names"}
+	if targetPath == "" {
+		skip(folderChecks, "no vault folder given")
+	} else {
+		doctorFolder(add, targetPath, vault)
+	}
+	return diagnoses
+}
+
+// doctorAccount checks the auth token and that it can list vaults, returning the vault with vaultId if it's given and
+// accessible, or why the vault can't be checked
+func doctorAccount(add func(Diagnosis) bool, skip func([]string, string), authToken *crypto.Secret, vaultId string, reachable bool) (*api.VaultInfo, string) {
+	user, err := api.GetUserInfo(authToken)
+	token := Diagnosis{Check: Check{Name: "auth token", Err: err}, Hint: "log in again with login, the token may have expired or been revoked"}
+	if !reachable {
+		token.Hint = apiHint()
+	}
+	if err == nil {
+		token.Detail = user.Email
+	}
+	if !add(token) {
+		skip([]string{"vault list", "vault access"}, "needs a working auth token")
+		return nil, "needs a working auth token"
+	}
+
+	vaults, err := api.ListVaults(authToken)
+	list := Diagnosis{Check: Check{Name: "vault list", Err: err}, Hint: "check the account has an active Sync subscription with whoami"}
+	if err == nil {
+		list.Detail = fmt.Sprintf("%d vaults", len(vaults))
+	}
+	if !add(list) {
+		skip([]string{"vault access"}, "needs the vault list")
+		return nil, "needs the vault list"
+	}
+	if vaultId == "" {
+		skip([]string{"vault access"}, "no vault ID given")
+		return nil, "no vault ID given"
+	}
+
+	var vault *api.VaultInfo
+	access := Diagnosis{Check: Check{Name: "vault access"}, Hint: "list the IDs of the vaults this account can access with vaults"}
+	for i := range vaults {
+		if vaults[i].Id == vaultId {
+			vault = &vaults[i]
+			access.Detail = vault.Name
+		}
+	}
+	if vault == nil {
+		access.Err = fmt.Errorf("vault %s is not accessible with this token", vaultId)
+	}
+	if !add(access) {
+		return nil, "needs access to the vault"
+	}
+	return vault, ""
+}
+
+// apiHint suggests what to do when the API can't be reached
+func apiHint() string {
+	return fmt.Sprintf("check %s is reachable, or set the API URL with --apiUrl", api.DefaultEndpoint.BaseURL)
+}
+
+// doctorVault checks the websocket connection to the vault's host and the vault password
+func doctorVault(c context.Context, add func(Diagnosis) bool, skip func([]string, string), vault api.VaultInfo, authToken *crypto.Secret, password *crypto.Secret) {
+	if (password == nil || password.Empty()) && vault.Password != "" {
+		password = crypto.SecretString(vault.Password)
+		defer password.Wipe()
+	}
+
+	// Derive the key first so the connection can check it, the websocket alone doesn't need it
+	var cipher crypto.VaultCipher
+	var keyErr error
+	if password != nil && !password.Empty() {
+		cipher, keyErr = crypto.VerifyPassword(vault.EncryptionVersion, password, []byte(vault.Salt))
+	}
+
+	ctx, err := api.ConnectWithCipherContext(c, vault, cipher, authToken)
+	if !add(Diagnosis{
+		Check:  Check{Name: "websocket", Err: err},
+		Detail: vault.Host,
+		Hint:   fmt.Sprintf("check %s is reachable, a proxy or firewall may block websockets", vault.Host),
+	}) {
+		skip([]string{"vault password"}, "needs a websocket connection")
+		return
+	}
+	defer ctx.Close()
+	ctx.ReadOnly = true
+
+	switch {
+	case password == nil || password.Empty():
+		skip([]string{"vault password"}, "no password given or stored")
+	case keyErr != nil:
+		add(Diagnosis{Check: Check{Name: "vault password", Err: keyErr}, Hint: "the vault's encryption may not be supported"})
+	default:
+		err := ctx.CheckKey()
+		hint := "check the connection to the vault's host"
+		if errors.Is(err, api.ErrKeyMismatch) {
+			hint = "the password is wrong or was changed on another device, pass the current one with --password"
+		}
+		add(Diagnosis{Check: Check{Name: "vault password", Err: err}, Hint: hint})
+	}
+}
+
+// doctorFolder checks the vault folder has room for the vault, can be written to, and has names other platforms accept
+func doctorFolder(add func(Diagnosis) bool, targetPath string, vault *api.VaultInfo) {
+	free, err := freeDiskSpace(targetPath)
+	space := Diagnosis{Check: Check{Name: "disk space"}, Hint: "free up space, or sync to another disk"}
+	switch {
+	case err != nil:
+		space.Skipped = true
+		space.Detail = err.Error()
+	default:
+		space.Detail = fmt.Sprintf("%.1f MB free", float64(free)/1024/1024)
+		// A folder that was synced already holds most of the vault
+		needed := int64(minFreeSpace)
+		if vault != nil && !IsSyncedFolder(targetPath) && vault.Size > needed {
+			needed = vault.Size
+		}
+		if free < needed {
+			space.Err = fmt.Errorf("%.1f MB free, need %.1f MB", float64(free)/1024/1024, float64(needed)/1024/1024)
+		}
+	}
+	add(space)
+
+	add(Diagnosis{
+		Check: Check{Name: "folder writable", Err: checkWritable(targetPath)},
+		Hint:  "check the folder's permissions, and that its disk isn't mounted read-only",
+	})
+
+	issues, err := CheckPortability(targetPath)
+	names := Diagnosis{Check: Check{Name: "file names", Err: err}, Hint: "list them with check-portability and rename them"}
+	if err == nil && len(issues) > 0 {
+		names.Err = fmt.Errorf("%d names may not sync to other platforms", len(issues))
+	}
+	add(names)
+}
@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// StateDump is a snapshot of the planner's inputs, suitable for attaching to bug reports
+type StateDump struct {
+	TargetPath string            `json:"targetPath,omitempty"`
+	VaultId    string            `json:"vaultId"`
+	LastSync   int64             `json:"lastSync"`
+	RemoteUid  int64             `json:"remoteUid"`
+	Size       int64             `json:"size"`
+	Limit      int64             `json:"limit"`
+	Counts     StateDumpCounts   `json:"counts"`
+	Local      []LocalDumpEntry  `json:"local"`
+	Remote     []RemoteDumpEntry `json:"remote"`
+}
+
+type StateDumpCounts struct {
+	Local        int `json:"local"`
+	LocalFolders int `json:"localFolders"`
+	Evicted      int `json:"evicted"`
+	Remote       int `json:"remote"`
+	RemoteOnly   int `json:"remoteOnly"`
+	LocalOnly    int `json:"localOnly"`
+	Quarantined  int `json:"quarantined"`
+}
+
+// LocalDumpEntry describes a local entry. Key matches the Key of the corresponding remote entry.
+type LocalDumpEntry struct {
+	Key      string `json:"key"`
+	Path     string `json:"path,omitempty"`
+	Created  int64  `json:"created"`
+	Modified int64  `json:"modified"`
+	IsFolder bool   `json:"folder"`
+	Evicted  bool   `json:"evicted"`
+}
+
+type RemoteDumpEntry struct {
+	Key      string `json:"key"`
+	Uid      int64  `json:"uid"`
+	Created  int64  `json:"created"`
+	Modified int64  `json:"modified"`
+	Size     int64  `json:"size"`
+	IsFolder bool   `json:"folder"`
+}
+
+// Dump returns a snapshot of the state. When redact is set, paths are replaced by short hashes and the vault folder is
+// omitted, so the dump reveals nothing about note names or content.
+func (s *State) Dump(redact bool) StateDump {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := func(path string) string {
+		if !redact {
+			return path
+		}
+		sum := sha256.Sum256([]byte(path))
+		return hex.EncodeToString(sum[:6])
+	}
+
+	dump := StateDump{
+		VaultId:   s.VaultId,
+		LastSync:  s.LastSync,
+		RemoteUid: s.RemoteUid,
+		Size:      s.Size,
+		Limit:     s.Limit,
+		Local:     []LocalDumpEntry{},
+		Remote:    []RemoteDumpEntry{},
+	}
+	if !redact {
+		dump.TargetPath = s.TargetPath
+	}
+
+	for path, localFile := range s.LocalFiles {
+		entry := LocalDumpEntry{
+			Key:      key(path),
+			Created:  localFile.Created,
+			Modified: localFile.Modified,
+			IsFolder: localFile.IsFolder,
+			Evicted:  localFile.Evicted,
+		}
+		if !redact {
+			entry.Path = localFile.Path
+		}
+		dump.Local = append(dump.Local, entry)
+
+		if localFile.IsFolder {
+			dump.Counts.LocalFolders++
+		}
+		if localFile.Evicted {
+			dump.Counts.Evicted++
+		}
+		if _, inRemote := s.RemoteEntries[path]; !inRemote {
+			dump.Counts.LocalOnly++
+		}
+	}
+	for path, remoteEntry := range s.RemoteEntries {
+		dump.Remote = append(dump.Remote, RemoteDumpEntry{
+			Key:      key(path),
+			Uid:      remoteEntry.Uid,
+			Created:  remoteEntry.Created,
+			Modified: remoteEntry.Modified,
+			Size:     remoteEntry.Size,
+			IsFolder: remoteEntry.IsFolder,
+		})
+		if _, inLocal := s.LocalFiles[path]; !inLocal {
+			dump.Counts.RemoteOnly++
+		}
+	}
+	dump.Counts.Local = len(s.LocalFiles)
+	dump.Counts.Remote = len(s.RemoteEntries)
+	dump.Counts.Quarantined = len(s.Quarantined)
+
+	sort.Slice(dump.Local, func(i, j int) bool { return dump.Local[i].Key < dump.Local[j].Key })
+	sort.Slice(dump.Remote, func(i, j int) bool { return dump.Remote[i].Uid < dump.Remote[j].Uid })
+	return dump
+}
@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	gosync "sync"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// EventKind is what happened in an Event
+type EventKind string
+
+const (
+	EventPulled   EventKind = "pulled"   // A file was pulled from the vault
+	EventPushed   EventKind = "pushed"   // A file was pushed to the vault
+	EventDeleted  EventKind = "deleted"  // A file or folder deleted in the vault was deleted locally
+	EventRemoved  EventKind = "removed"  // A file or folder deleted locally was deleted in the vault
+	EventConflict EventKind = "conflict" // A file changed both locally and remotely, before it's resolved
+	EventError    EventKind = "error"    // A sync pass failed
+)
+
+// Event is something a sync did, for tools following it as a stream of JSON objects
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    EventKind `json:"kind"`
+	VaultId string    `json:"vaultId"`
+	Path    string    `json:"path,omitempty"`  // Decrypted path of the file, if any
+	Bytes   int64     `json:"bytes,omitempty"` // Size of a pulled or pushed file
+	Error   string    `json:"error,omitempty"`
+}
+
+// Events receives the events of a sync as they happen. It may be called from parallel tasks at once.
+type Events interface {
+	OnEvent(event Event)
+}
+
+// EventsFunc adapts a function to the Events interface
+type EventsFunc func(event Event)
+
+func (f EventsFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// emit sends an event to the state's receiver, if any, and to the followers of this process's daemon
+func (s *State) emit(event Event) {
+	event.Time = time.Now()
+	event.VaultId = s.VaultId
+	if s.Events != nil {
+		s.Events.OnEvent(event)
+	}
+	daemonEvents.publish(event)
+}
+
+// eventFollowBuffer is how many events a follower may fall behind by before it misses some
+const eventFollowBuffer = 256
+
+// eventBroadcast sends events to the followers of a daemon on its control socket, see FollowDaemon
+type eventBroadcast struct {
+	mu        gosync.Mutex
+	followers map[chan Event]bool
+}
+
+// daemonEvents are the events of the daemon running in this process
+var daemonEvents = &eventBroadcast{followers: make(map[chan Event]bool)}
+
+// follow returns a channel receiving the events published from now on, and a function to stop
+func (b *eventBroadcast) follow() (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := make(chan Event, eventFollowBuffer)
+	b.followers[events] = true
+	return events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.followers[events] {
+			delete(b.followers, events)
+			close(events)
+		}
+	}
+}
+
+// publish sends an event to every follower. Followers that fell behind miss it rather than holding up the sync.
+func (b *eventBroadcast) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.followers {
+		select {
+		case events <- event:
+		default:
+			api.Log().Debug("⚠️ Event follower fell behind, dropping event", "kind", event.Kind, "path", event.Path)
+		}
+	}
+}
+
+// closeAll ends every follow, when the daemon stops
+func (b *eventBroadcast) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events := range b.followers {
+		delete(b.followers, events)
+		close(events)
+	}
+}
+
+// streamEvents writes the daemon's events to conn as JSON lines until it stops or conn is closed
+func streamEvents(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Time{})
+	events, stop := daemonEvents.follow()
+	defer stop()
+
+	// Reading only returns once the follower hangs up
+	go func() {
+		_, _ = bufio.NewReader(conn).ReadByte()
+		stop()
+	}()
+	encoder := json.NewEncoder(conn)
+	for event := range events {
+		_ = conn.SetWriteDeadline(time.Now().Add(controlTimeout))
+		if err := encoder.Encode(event); err != nil {
+			return
+		}
+	}
+}
+
+// FollowDaemon calls onEvent with each event of the daemon syncing the vault folder at targetPath, until it stops.
+// Returns ErrNoDaemon if there is none.
+func FollowDaemon(targetPath string, onEvent func(event Event)) error {
+	path, err := controlSocketPath(targetPath)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return ErrNoDaemon
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, "events"); err != nil {
+		return fmt.Errorf("error sending events request: %s", err)
+	}
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				// The daemon stopped
+				return nil
+			}
+			return fmt.Errorf("error reading event: %s", err)
+		}
+		onEvent(event)
+	}
+}
@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EvictionPolicy controls when attachments are evicted from the local vault to free disk space
+type EvictionPolicy struct {
+	MinFreeBytes int64 // Evict until at least this many bytes are free on the vault's disk. Zero disables eviction.
+	MinFileSize  int64 // Only attachments at least this large are considered for eviction
+}
+
+type evictionCandidate struct {
+	path       string
+	fullPath   string
+	size       int64
+	accessTime time.Time
+}
+
+// EvictAttachments removes the least-recently-accessed large attachments from disk until the policy's free space
+// target is met. Evicted files are kept remotely and remain in LocalFiles as placeholders, so they are neither
+// pulled again nor deleted by the next sync. Returns the number of bytes freed.
+func (s *State) EvictAttachments(policy EvictionPolicy) (int64, error) {
+	if policy.MinFreeBytes <= 0 {
+		return 0, nil
+	}
+
+	free, err := freeDiskSpace(s.TargetPath)
+	if err != nil {
+		return 0, fmt.Errorf("error checking free disk space: %s", err)
+	}
+	if free >= policy.MinFreeBytes {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Collect attachments that exist on disk and are also available remotely
+	var candidates []evictionCandidate
+	for path, localFile := range s.LocalFiles {
+		if localFile.IsFolder || localFile.Evicted || isNote(localFile.Path) || s.fetched[localFile.Path] {
+			continue
+		}
+		if _, inRemote := s.RemoteEntries[path]; !inRemote {
+			continue
+		}
+
+		fullPath := filepath.Join(s.TargetPath, localFile.Path)
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			continue
+		}
+		if info.Size() < policy.MinFileSize {
+			continue
+		}
+		candidates = append(candidates, evictionCandidate{
+			path:       path,
+			fullPath:   fullPath,
+			size:       info.Size(),
+			accessTime: accessTime(info),
+		})
+	}
+
+	// Evict least recently accessed first
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].accessTime.Before(candidates[j].accessTime)
+	})
+
+	var freed int64
+	for _, candidate := range candidates {
+		if free+freed >= policy.MinFreeBytes {
+			break
+		}
+
+		api.Log().Info("🧹 Evicting", "path", candidate.fullPath)
+		if err := os.Remove(candidate.fullPath); err != nil {
+			return freed, fmt.Errorf("error evicting file: %s", err)
+		}
+		freed += candidate.size
+
+		localFile := s.LocalFiles[candidate.path]
+		localFile.Evicted = true
+		s.LocalFiles[candidate.path] = localFile
+	}
+
+	if free+freed < policy.MinFreeBytes {
+		api.Log().Warn("⚠️ Disk space is still low", "freed", freed)
+	}
+
+	return freed, nil
+}
+
+// EvictedPaths returns the decrypted paths of all placeholder entries, sorted
+func (s *State) EvictedPaths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for _, localFile := range s.LocalFiles {
+		if localFile.Evicted {
+			paths = append(paths, localFile.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// FetchEvicted pulls an evicted placeholder back onto disk on demand, see Options.Fetch. It isn't evicted again for
+// the rest of the run. Files that are already on disk are left alone.
+func (s *State) FetchEvicted(ws *api.ObsidianSocketContext, decryptedPath string) error {
+	s.mu.Lock()
+	path, evicted := "", false
+	for encryptedPath, localFile := range s.LocalFiles {
+		if localFile.Path == decryptedPath {
+			path, evicted = encryptedPath, localFile.Evicted
+			break
+		}
+	}
+	_, inRemote := s.RemoteEntries[path]
+	s.mu.Unlock()
+
+	switch {
+	case path == "":
+		return fmt.Errorf("no placeholder found for %s", decryptedPath)
+	case !evicted:
+		return nil
+	case !inRemote:
+		return fmt.Errorf("%s is no longer in the vault", decryptedPath)
+	}
+	api.Log().Info("📥 Fetching evicted file", "path", decryptedPath)
+	fullPath, err := s.localPath(decryptedPath)
+	if err != nil {
+		return err
+	}
+	// Its folder may be gone if nothing else was in it
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("error creating folder: %s", err)
+	}
+	if err := s.pullEntry(ws, path, decryptedPath, nil); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.fetched == nil {
+		s.fetched = make(map[string]bool)
+	}
+	s.fetched[decryptedPath] = true
+	s.mu.Unlock()
+	return nil
+}
+
+// isNote returns true for markdown notes, which are never evicted
+func isNote(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".md")
+}
@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEvictAttachments(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"ea.png": "a.png", "eb.md": "b.md", "ec.png": "c.png", "ed.png": "d.png"}
+	s := &State{
+		TargetPath:    dir,
+		LocalFiles:    make(map[string]ObsidianLocalEntry),
+		RemoteEntries: make(map[string]ObsidianRemoteEntry),
+		fetched:       map[string]bool{"c.png": true},
+	}
+	for path, decryptedPath := range files {
+		if err := os.WriteFile(filepath.Join(dir, decryptedPath), []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s.LocalFiles[path] = ObsidianLocalEntry{Path: decryptedPath}
+		if path != "ed.png" {
+			s.RemoteEntries[path] = ObsidianRemoteEntry{EncryptedPath: path, Path: decryptedPath}
+		}
+	}
+
+	freed, err := s.EvictAttachments(EvictionPolicy{MinFreeBytes: math.MaxInt64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != int64(len("content")) {
+		t.Errorf("freed %d bytes, want %d", freed, len("content"))
+	}
+	if got := s.EvictedPaths(); len(got) != 1 || got[0] != "a.png" {
+		t.Errorf("EvictedPaths() = %v, want [a.png]", got)
+	}
+	for _, path := range []string{"b.md", "c.png", "d.png"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("%s should be kept: %s", path, err)
+		}
+	}
+}
+
+func TestFetchEvicted(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   ObsidianLocalEntry
+		remote  bool
+		wantErr bool
+	}{
+		{"not tracked", ObsidianLocalEntry{Path: "other.png"}, true, true},
+		{"on disk", ObsidianLocalEntry{Path: "a.png"}, true, false},
+		{"deleted remotely", ObsidianLocalEntry{Path: "a.png", Evicted: true}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &State{
+				TargetPath:    t.TempDir(),
+				LocalFiles:    map[string]ObsidianLocalEntry{"ea.png": tt.local},
+				RemoteEntries: make(map[string]ObsidianRemoteEntry),
+			}
+			if tt.remote {
+				s.RemoteEntries["ea.png"] = ObsidianRemoteEntry{EncryptedPath: "ea.png", Path: tt.local.Path}
+			}
+			// Neither needs the connection
+			if err := s.FetchEvicted(nil, "a.png"); (err != nil) != tt.wantErr {
+				t.Errorf("FetchEvicted() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
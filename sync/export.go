@@ -0,0 +1,187 @@
+package sync
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// ArchiveFormat is the kind of archive Export writes
+type ArchiveFormat string
+
+const (
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+	ArchiveZip   ArchiveFormat = "zip"
+)
+
+// ArchiveFormatOf returns the format an archive's file name asks for: .tar.gz or .tgz, or .zip
+func ArchiveFormatOf(name string) (ArchiveFormat, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip, nil
+	default:
+		return "", fmt.Errorf("can't tell the archive format of %s, name it .tar.gz or .zip", name)
+	}
+}
+
+// ExportResult counts what Export wrote
+type ExportResult struct {
+	Files   int
+	Folders int
+	Bytes   int64 // Decrypted content, before compression
+}
+
+// Export writes every file and folder of the vault, decrypted, to an archive streamed to w, without syncing or
+// touching any sync state. They're under a folder named after the vault, with their modified times. Files are pulled
+// one at a time, so the vault never has to fit in memory or on disk.
+func Export(w io.Writer, format ArchiveFormat, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (ExportResult, error) {
+	return ExportContext(context.Background(), w, format, authToken, vault, password, opts)
+}
+
+// ExportContext is Export, giving up when c is done
+func ExportContext(c context.Context, w io.Writer, format ArchiveFormat, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (ExportResult, error) {
+	var result ExportResult
+	var archive archiveWriter
+	switch format {
+	case ArchiveTarGz:
+		archive = newTarArchive(w)
+	case ArchiveZip:
+		archive = zipArchive{zip.NewWriter(w)}
+	default:
+		return result, fmt.Errorf("unknown archive format %q", format)
+	}
+
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+	ctx, err := connectReadOnly(c, authToken, vault, password, opts)
+	if err != nil {
+		return result, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+	state, err := fetchIndex(c, ctx)
+	if err != nil {
+		return result, err
+	}
+	entries := make([]ObsidianRemoteEntry, 0, len(state.RemoteEntries))
+	for _, entry := range state.RemoteEntries {
+		entries = append(entries, entry)
+	}
+	// Folders come before what's in them
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	root := strings.ReplaceAll(vault.Name, "/", "_")
+	if root == "" || root == "." || root == ".." {
+		root = vault.Id
+	}
+	for _, entry := range entries {
+		// Paths come from the vault, don't let one escape the archive's folder when it's extracted
+		if path.Clean("/" + entry.Path)[1:] != entry.Path {
+			return result, fmt.Errorf("refusing to export %q, it isn't a clean relative path", entry.Path)
+		}
+		name := root + "/" + entry.Path
+		modified := time.UnixMilli(entry.Modified)
+		if entry.IsFolder {
+			if err := archive.folder(name, modified); err != nil {
+				return result, fmt.Errorf("error writing %s: %s", entry.Path, err)
+			}
+			result.Folders++
+			continue
+		}
+
+		api.Log().Info("📄 Pulling", "path", entry.Path, "uid", entry.Uid)
+		data, err := ctx.PullEncryptedContext(c, entry.Uid)
+		if err != nil {
+			return result, fmt.Errorf("error pulling %s: %s", entry.Path, err)
+		}
+		content, err := ctx.DecryptContent(data, entry.EncryptedHash)
+		if err != nil {
+			return result, fmt.Errorf("error decrypting %s: %s", entry.Path, err)
+		}
+		if err := archive.file(name, modified, content); err != nil {
+			return result, fmt.Errorf("error writing %s: %s", entry.Path, err)
+		}
+		result.Files++
+		result.Bytes += int64(len(content))
+	}
+	if err := archive.Close(); err != nil {
+		return result, fmt.Errorf("error finishing archive: %s", err)
+	}
+	return result, nil
+}
+
+// archiveWriter writes the entries of an archive in one format
+type archiveWriter interface {
+	folder(name string, modified time.Time) error
+	file(name string, modified time.Time, content []byte) error
+	Close() error
+}
+
+// tarArchive writes a gzipped tarball
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarArchive(w io.Writer) tarArchive {
+	gz := gzip.NewWriter(w)
+	return tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (a tarArchive) folder(name string, modified time.Time) error {
+	return a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0755, ModTime: modified})
+}
+
+func (a tarArchive) file(name string, modified time.Time, content []byte) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content)), ModTime: modified}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(content)
+	return err
+}
+
+func (a tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// zipArchive writes a zip file
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a zipArchive) folder(name string, modified time.Time) error {
+	_, err := a.zw.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: modified})
+	return err
+}
+
+func (a zipArchive) file(name string, modified time.Time, content []byte) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+func (a zipArchive) Close() error {
+	return a.zw.Close()
+}
@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// FetchResult counts what FetchPath wrote
+type FetchResult struct {
+	Dest    string // Where the file or folder was written
+	Files   int
+	Folders int
+	Bytes   int64
+}
+
+// FetchPath downloads the remote file at remotePath, or every file under it if it's a folder, without syncing or
+// touching any sync state. A file is written to dest, or into dest if it's an existing folder. A folder's contents are
+// written under dest. dest defaults to the name of remotePath in the working directory.
+func FetchPath(remotePath string, dest string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (FetchResult, error) {
+	return FetchPathContext(context.Background(), remotePath, dest, authToken, vault, password, opts)
+}
+
+// FetchPathContext is FetchPath, giving up when c is done
+func FetchPathContext(c context.Context, remotePath string, dest string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (FetchResult, error) {
+	var result FetchResult
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+	remotePath = strings.Trim(path.Clean("/"+filepath.ToSlash(remotePath)), "/")
+
+	ctx, err := connectReadOnly(c, authToken, vault, password, opts)
+	if err != nil {
+		return result, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+	state, err := fetchIndex(c, ctx)
+	if err != nil {
+		return result, err
+	}
+
+	// A single file
+	if encryptedPath, ok := state.EncryptedPath(remotePath); ok && !state.RemoteEntries[encryptedPath].IsFolder {
+		result.Dest = fetchDest(dest, remotePath, vault)
+		if info, err := os.Stat(result.Dest); err == nil && info.IsDir() {
+			result.Dest = filepath.Join(result.Dest, path.Base(remotePath))
+		}
+		err := fetchEntry(c, ctx, state.RemoteEntries[encryptedPath], result.Dest, &result)
+		return result, err
+	}
+
+	// A folder, or the whole vault if remotePath is empty
+	var entries []ObsidianRemoteEntry
+	prefix := remotePath + "/"
+	for _, entry := range state.RemoteEntries {
+		if remotePath == "" || entry.Path == remotePath || strings.HasPrefix(entry.Path, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == 0 {
+		return result, fmt.Errorf("%s not found in the vault", remotePath)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	result.Dest = fetchDest(dest, remotePath, vault)
+	for _, entry := range entries {
+		relPath := strings.TrimPrefix(strings.TrimPrefix(entry.Path, remotePath), "/")
+		fullPath := filepath.Join(result.Dest, filepath.FromSlash(relPath))
+		// Paths come from the vault, don't let one escape the destination
+		if rel, err := filepath.Rel(result.Dest, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return result, fmt.Errorf("refusing to write %s outside of %s", entry.Path, result.Dest)
+		}
+		if err := fetchEntry(c, ctx, entry, fullPath, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// connectReadOnly connects to the vault for a command that only reads it. Callers close the connection.
+func connectReadOnly(c context.Context, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (*api.ObsidianSocketContext, error) {
+	ctx, err := api.ConnectToVaultContext(c, vault, password, authToken)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to vault: %s", err)
+	}
+	ctx.ReadOnly = true
+	ctx.DeviceName = opts.DeviceName
+	return ctx, nil
+}
+
+// fetchIndex receives the vault's whole index over ctx, decrypted into the remote entries of a State without a folder,
+// which callers fill in as needed. Reusing the sync state's bookkeeping decrypts each path once.
+func fetchIndex(c context.Context, ctx *api.ObsidianSocketContext) (*State, error) {
+	api.Log().Info("🔄 Initializing")
+	initResult, err := ctx.SendInitContext(c, 0, true)
+	if err != nil {
+		return nil, fmt.Errorf("error sending init message: %s", err)
+	}
+	state := &State{RemoteEntries: make(map[string]ObsidianRemoteEntry)}
+	if err := state.setCipher(ctx.Cipher); err != nil {
+		return nil, err
+	}
+	state.applyInit(initResult)
+	return state, nil
+}
+
+// applyInit applies the pushes of an init result to the remote entries, and catches up with its UID
+func (s *State) applyInit(initResult *api.InitResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, push := range initResult.PushedFiles {
+		s.updateWithPush(&push)
+	}
+	if initResult.RemoteUid > s.RemoteUid {
+		s.RemoteUid = initResult.RemoteUid
+	}
+}
+
+// fetchDest returns where to write remotePath if dest isn't given
+func fetchDest(dest string, remotePath string, vault api.VaultInfo) string {
+	switch {
+	case dest != "":
+		return dest
+	case remotePath == "":
+		return vault.Name
+	default:
+		return path.Base(remotePath)
+	}
+}
+
+// fetchEntry writes a remote file or folder to fullPath
+func fetchEntry(c context.Context, ctx *api.ObsidianSocketContext, entry ObsidianRemoteEntry, fullPath string, result *FetchResult) error {
+	if entry.IsFolder {
+		if err := os.MkdirAll(fullPath, 0755); err != nil {
+			return fmt.Errorf("error creating folder: %s", err)
+		}
+		result.Folders++
+		return nil
+	}
+
+	api.Log().Info("📄 Pulling", "path", entry.Path, "uid", entry.Uid)
+	data, err := ctx.PullEncryptedContext(c, entry.Uid)
+	if err != nil {
+		return fmt.Errorf("error pulling %s: %s", entry.Path, err)
+	}
+	content, err := ctx.DecryptContent(data, entry.EncryptedHash)
+	if err != nil {
+		return fmt.Errorf("error decrypting %s: %s", entry.Path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("error creating folder: %s", err)
+	}
+	if err := os.WriteFile(fullPath, content, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %s", fullPath, err)
+	}
+	result.Files++
+	result.Bytes += int64(len(content))
+	return nil
+}
@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// FileType is a kind of attachment, which like in the Obsidian app's sync settings can be left out of syncs. Notes,
+// canvases and the vault's config folder are always synced.
+type FileType string
+
+const (
+	FileTypeImage FileType = "image"
+	FileTypeAudio FileType = "audio"
+	FileTypeVideo FileType = "video"
+	FileTypePDF   FileType = "pdf"
+	FileTypeOther FileType = "other" // Anything that isn't a note or one of the types above
+)
+
+// FileTypes lists every FileType
+var FileTypes = []FileType{FileTypeImage, FileTypeAudio, FileTypeVideo, FileTypePDF, FileTypeOther}
+
+// configFolder is where Obsidian keeps a vault's settings, plugins and themes
+const configFolder = ".obsidian"
+
+// fileTypeExtensions are the extensions of each type, as the Obsidian app sorts them. webm goes with video.
+var fileTypeExtensions = map[string]FileType{
+	"bmp": FileTypeImage, "png": FileTypeImage, "jpg": FileTypeImage, "jpeg": FileTypeImage, "gif": FileTypeImage,
+	"svg": FileTypeImage, "webp": FileTypeImage, "avif": FileTypeImage,
+	"mp3": FileTypeAudio, "wav": FileTypeAudio, "m4a": FileTypeAudio, "3gp": FileTypeAudio, "flac": FileTypeAudio,
+	"ogg": FileTypeAudio, "oga": FileTypeAudio, "opus": FileTypeAudio,
+	"mp4": FileTypeVideo, "webm": FileTypeVideo, "ogv": FileTypeVideo, "mov": FileTypeVideo, "mkv": FileTypeVideo,
+	"pdf": FileTypePDF,
+}
+
+// ParseFileTypes parses the names of file types, "all" being every one of them
+func ParseFileTypes(names []string) ([]FileType, error) {
+	var types []FileType
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "all" {
+			return FileTypes, nil
+		}
+		known := false
+		for _, fileType := range FileTypes {
+			if FileType(name) == fileType {
+				types = append(types, fileType)
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown file type %q, expected image, audio, video, pdf, other or all", name)
+		}
+	}
+	return types, nil
+}
+
+// OtherFileTypes returns every FileType that isn't in types
+func OtherFileTypes(types []FileType) []FileType {
+	var others []FileType
+	for _, fileType := range FileTypes {
+		listed := false
+		for _, t := range types {
+			listed = listed || t == fileType
+		}
+		if !listed {
+			others = append(others, fileType)
+		}
+	}
+	return others
+}
+
+// fileTypeOf returns the type of the file at path, and false for notes and config files, which always sync
+func fileTypeOf(path string) (FileType, bool) {
+	if path == configFolder || strings.HasPrefix(path, configFolder+"/") {
+		return "", false
+	}
+	ext := api.Extension(path)
+	if ext == "md" || ext == "canvas" {
+		return "", false
+	}
+	if fileType, ok := fileTypeExtensions[ext]; ok {
+		return fileType, true
+	}
+	return FileTypeOther, true
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// pathFilter decides which paths a sync touches, see Options.Include, Options.Exclude and Options.SkipFileTypes
+type pathFilter struct {
+	include   globList
+	exclude   globList
+	skipTypes map[FileType]bool
+}
+
+// compileFilter compiles include and exclude globs. Like in a .gitignore, a pattern without a slash matches the name
+// at any depth, so "*.md" matches every note.
+func compileFilter(include []string, exclude []string, skipTypes []FileType) (pathFilter, error) {
+	filter := pathFilter{skipTypes: make(map[FileType]bool)}
+	for _, fileType := range skipTypes {
+		filter.skipTypes[fileType] = true
+	}
+	var err error
+	if filter.include, err = compileGlobs(anyDepth(include)); err != nil {
+		return filter, err
+	}
+	if filter.exclude, err = compileGlobs(anyDepth(exclude)); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// anyDepth makes patterns without a slash match at any depth
+func anyDepth(patterns []string) []string {
+	expanded := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		expanded = append(expanded, pattern)
+	}
+	return expanded
+}
+
+// allows returns true if path should be synced. Folders are only ever excluded, since included files below a folder
+// need it.
+func (f pathFilter) allows(path string, folder bool) bool {
+	if f.exclude.firstMatch(path) >= 0 {
+		return false
+	}
+	if fileType, ok := fileTypeOf(path); ok && !folder && f.skipTypes[fileType] {
+		return false
+	}
+	return folder || len(f.include) == 0 || f.include.firstMatch(path) >= 0
+}
+
+// withoutFiltered drops the paths the filter doesn't allow. decrypted returns the decrypted path and whether it's a
+// folder for each path.
+func (s *State) withoutFiltered(paths []string, decrypted func(path string) (string, bool)) []string {
+	if len(s.Filter.include)+len(s.Filter.exclude)+len(s.Filter.skipTypes) == 0 {
+		return paths
+	}
+	kept := paths[:0]
+	skipped := 0
+	for _, path := range paths {
+		if !s.Filter.allows(decrypted(path)) {
+			skipped++
+			continue
+		}
+		kept = append(kept, path)
+	}
+	if skipped > 0 {
+		api.Log().Debug("⏭️ Leaving filtered out paths alone", "count", skipped)
+	}
+	return kept
+}
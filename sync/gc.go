@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// managedDirs are the folders inside a vault whose contents the client may prune
+var managedDirs = []string{".trash"}
+
+// RetentionPolicy caps how long and how much data is kept in the managed folders. Zero values disable a cap.
+type RetentionPolicy struct {
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+// Enabled returns true if the policy has any cap set
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxSize > 0
+}
+
+// GCResult summarizes a garbage collection run
+type GCResult struct {
+	FilesRemoved   int
+	BytesReclaimed int64
+}
+
+type gcFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// CollectGarbage applies the retention policy to every managed folder in the vault at targetPath
+func CollectGarbage(targetPath string, policy RetentionPolicy) (GCResult, error) {
+	var result GCResult
+	if !policy.Enabled() {
+		return result, nil
+	}
+
+	for _, dir := range managedDirs {
+		dirResult, err := pruneDir(filepath.Join(targetPath, dir), policy)
+		if err != nil {
+			return result, fmt.Errorf("error pruning %s: %s", dir, err)
+		}
+		result.FilesRemoved += dirResult.FilesRemoved
+		result.BytesReclaimed += dirResult.BytesReclaimed
+	}
+
+	return result, nil
+}
+
+// pruneDir removes files older than the max age, then the oldest remaining files until the folder fits the size cap
+func pruneDir(dir string, policy RetentionPolicy) (GCResult, error) {
+	var result GCResult
+
+	// Collect files in the folder
+	var files []gcFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, gcFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// Oldest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.size
+	}
+
+	cutoff := time.Now().Add(-policy.MaxAge)
+	for _, file := range files {
+		expired := policy.MaxAge > 0 && file.modTime.Before(cutoff)
+		oversize := policy.MaxSize > 0 && totalSize > policy.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+
+		if err := os.Remove(file.path); err != nil {
+			return result, err
+		}
+		totalSize -= file.size
+		result.FilesRemoved++
+		result.BytesReclaimed += file.size
+	}
+
+	return result, removeEmptyDirs(dir)
+}
+
+// removeEmptyDirs removes empty subfolders below dir, leaving dir itself in place
+func removeEmptyDirs(dir string) error {
+	var subDirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != dir {
+			subDirs = append(subDirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deepest folders first, so parents can become empty
+	for i := len(subDirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(subDirs[i])
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(subDirs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// DefaultGitMessage is the commit message Options.GitCommit uses when no template is set
+const DefaultGitMessage = "Sync from {device} at UID {uid}"
+
+// gitPlaceholders are the values a git commit message template can use
+var gitPlaceholders = []string{"{device}", "{uid}", "{date}", "{time}", "{added}", "{modified}", "{deleted}"}
+
+// ValidateGitMessage checks a commit message template for Options.GitMessage. It may use {device}, the name of this
+// device, {uid}, the vault's latest UID after the pass, {date} and {time}, and {added}, {modified} and {deleted}, the
+// number of files the pass changed locally.
+func ValidateGitMessage(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("git commit message can't be empty")
+	}
+	rest := template
+	for _, placeholder := range gitPlaceholders {
+		rest = strings.ReplaceAll(rest, placeholder, "")
+	}
+	if i := strings.Index(rest, "{"); i >= 0 && strings.Contains(rest[i:], "}") {
+		return fmt.Errorf("unknown placeholder in git commit message, expected one of %s", strings.Join(gitPlaceholders, ", "))
+	}
+	return nil
+}
+
+// checkGit makes sure git commits can be made with a commit message template, before a sync starts
+func checkGit(template string) error {
+	if template != "" {
+		if err := ValidateGitMessage(template); err != nil {
+			return err
+		}
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git commits need git installed: %s", err)
+	}
+	return nil
+}
+
+// commitToGit commits everything in the folder to its git repository after a sync pass, if GitCommit is set, so there's
+// a local history alongside the vault's versions. The repository is created the first time. changes, which may be nil,
+// fill in the message's counts. Like a failing hook, a failed commit is logged but doesn't fail the sync.
+func (s *State) commitToGit(changes *Changeset) {
+	if !s.GitCommit {
+		return
+	}
+	if err := s.gitCommit(changes); err != nil {
+		api.Log().Warn("⚠️ Could not commit to git", "err", err)
+	}
+}
+
+// gitCommit is commitToGit, returning what went wrong
+func (s *State) gitCommit(changes *Changeset) error {
+	if _, err := os.Stat(filepath.Join(s.TargetPath, ".git")); os.IsNotExist(err) {
+		api.Log().Info("🗃️ Creating git repository", "folder", s.TargetPath)
+		if _, err := s.git("init", "--quiet"); err != nil {
+			return err
+		}
+		// The marker belongs to this folder, not to the vault's history
+		if err := appendLine(filepath.Join(s.TargetPath, ".git", "info", "exclude"), "/"+MarkerFile); err != nil {
+			return fmt.Errorf("error excluding %s: %s", MarkerFile, err)
+		}
+	}
+
+	status, err := s.git("status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(status) == "" {
+		return nil
+	}
+	if _, err := s.git("add", "--all"); err != nil {
+		return err
+	}
+
+	// Commit as obsidian-sync if git doesn't know who the user is, rather than failing every pass
+	args := []string{"commit", "--quiet", "-m", s.gitMessage(changes, time.Now())}
+	if _, err := s.git("config", "user.email"); err != nil {
+		args = append([]string{"-c", "user.name=obsidian-sync", "-c", "user.email=obsidian-sync@localhost"}, args...)
+	}
+	if _, err := s.git(args...); err != nil {
+		return err
+	}
+	api.Log().Info("🗃️ Committed to git", "uid", s.remoteUid())
+	return nil
+}
+
+// gitMessage fills in the GitMessage template, or DefaultGitMessage, for a pass that finished at
+func (s *State) gitMessage(changes *Changeset, at time.Time) string {
+	template := s.GitMessage
+	if template == "" {
+		template = DefaultGitMessage
+	}
+	device := s.DeviceName
+	if device == "" {
+		device = "unknown"
+	}
+	var added, modified, deleted int
+	if changes != nil {
+		added, modified, deleted = len(changes.Added), len(changes.Modified), len(changes.Deleted)
+	}
+	return strings.NewReplacer(
+		"{device}", device,
+		"{uid}", strconv.FormatInt(s.remoteUid(), 10),
+		"{date}", at.Format("2006-01-02"),
+		"{time}", at.Format("15:04:05"),
+		"{added}", strconv.Itoa(added),
+		"{modified}", strconv.Itoa(modified),
+		"{deleted}", strconv.Itoa(deleted),
+	).Replace(template)
+}
+
+// git runs a git command in the folder and returns its output
+func (s *State) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.TargetPath
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if output := strings.TrimSpace(string(out)); output != "" {
+			return "", fmt.Errorf("git %s: %s: %s", strings.Join(args, " "), err, output)
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// appendLine appends a line to a file, creating it if needed
+func appendLine(path string, line string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString("\n" + line + "\n"); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
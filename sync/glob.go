@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compileGlob converts a slash-separated glob into a regexp. "*" and "?" don't cross folder boundaries, "**" matches
+// any number of folders, and a pattern ending in "/**" also matches the folder itself.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			i++
+			if i+1 < len(pattern) && pattern[i+1] == '/' {
+				// "**/" matches zero or more folders
+				i++
+				expr.WriteString("(.*/)?")
+			} else {
+				expr.WriteString(".*")
+			}
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if strings.HasSuffix(pattern, "/**") {
+		// Let "folder/**" match "folder" too
+		return regexp.Compile("^" + regexp.QuoteMeta(strings.TrimSuffix(pattern, "/**")) + "$|" + expr.String() + "$")
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// globList is a compiled list of glob patterns
+type globList []*regexp.Regexp
+
+// compileGlobs compiles every pattern, failing on the first invalid one
+func compileGlobs(patterns []string) (globList, error) {
+	globs := make(globList, 0, len(patterns))
+	for _, pattern := range patterns {
+		glob, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+// firstMatch returns the index of the first pattern matching path, or -1 if none match
+func (g globList) firstMatch(path string) int {
+	for i, glob := range g {
+		if glob.MatchString(path) {
+			return i
+		}
+	}
+	return -1
+}
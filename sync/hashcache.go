@@ -0,0 +1,71 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"time"
+)
+
+// HashedFile is the content hash of a local file, valid while its size and modification time stay the same
+type HashedFile struct {
+	Size     int64
+	Modified int64  // Modification time in nanoseconds
+	Hash     string // Hex SHA-256 of the content
+}
+
+// racyHashWindow is how long after a file was modified its hash isn't cached. A change made within the resolution of
+// the filesystem's modification times right after hashing wouldn't change them, filesystems like FAT keep two seconds.
+const racyHashWindow = 2 * time.Second
+
+// localHash returns the hex SHA-256 of the local file at the decrypted path. It's read and hashed only if its size or
+// modification time changed since it was last hashed. scanLocal and settleModified compare content with it on every
+// pass, sweeps included, so rescanning a big vault only reads the files that changed. s.mu must not be held.
+func (s *State) localHash(path string) (string, error) {
+	fullPath, err := s.localPath(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	cached, ok := s.Hashes[path]
+	s.mu.Unlock()
+	if ok && cached.Size == info.Size() && cached.Modified == info.ModTime().UnixNano() {
+		return cached.Hash, nil
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	if int64(len(content)) == info.Size() && time.Since(info.ModTime()) > racyHashWindow {
+		s.mu.Lock()
+		if s.Hashes == nil {
+			s.Hashes = make(map[string]HashedFile)
+		}
+		s.Hashes[path] = HashedFile{Size: info.Size(), Modified: info.ModTime().UnixNano(), Hash: hash}
+		s.mu.Unlock()
+	}
+	return hash, nil
+}
+
+// pruneHashes forgets the hashes of files the state no longer tracks. s.mu must be held.
+func (s *State) pruneHashes() {
+	if len(s.Hashes) == 0 {
+		return
+	}
+	tracked := make(map[string]bool, len(s.LocalFiles))
+	for _, localFile := range s.LocalFiles {
+		tracked[localFile.Path] = true
+	}
+	for path := range s.Hashes {
+		if !tracked[path] {
+			delete(s.Hashes, path)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Check is the result of a single self-check, Err is nil if it passed
+type Check struct {
+	Name string
+	Err  error
+}
+
+// Health is the daemon's latest self-check status
+type Health struct {
+	Degraded  bool
+	Checks    []Check
+	CheckedAt time.Time
+}
+
+// Failed returns the checks that didn't pass
+func (h Health) Failed() []Check {
+	var failed []Check
+	for _, check := range h.Checks {
+		if check.Err != nil {
+			failed = append(failed, check)
+		}
+	}
+	return failed
+}
+
+var (
+	healthMu      sync.Mutex
+	currentHealth Health
+)
+
+// CurrentHealth returns the latest health status reported by a running daemon
+func CurrentHealth() Health {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	return currentHealth
+}
+
+func setHealth(health Health) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	currentHealth = health
+}
+
+func newHealth(checks []Check) Health {
+	health := Health{Checks: checks, CheckedAt: time.Now()}
+	health.Degraded = len(health.Failed()) > 0
+	return health
+}
+
+// startHealthy runs the daemon start-up self-checks, staying in a degraded watch-only mode and retrying with backoff
+// until every check passes. Returns a connected context and initialized state, or c's error if c is done first.
+func startHealthy(c context.Context, targetPath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (*api.ObsidianSocketContext, *State, error) {
+	for attempt := 0; ; attempt++ {
+		checks := []Check{
+			{Name: "vault writable", Err: checkWritable(targetPath)},
+			{Name: "credentials", Err: checkCredentials(authToken, vault.Id)},
+		}
+
+		var ctx *api.ObsidianSocketContext
+		var syncState *State
+		if len(newHealth(checks).Failed()) == 0 {
+			var err error
+			ctx, syncState, err = connectAndInit(c, targetPath, authToken, vault, password, opts)
+			checks = append(checks, Check{Name: "connectivity", Err: err})
+			if err == nil {
+				checks = append(checks, Check{Name: "state integrity", Err: syncState.checkIntegrity()})
+			}
+		}
+
+		health := newHealth(checks)
+		setHealth(health)
+		if !health.Degraded {
+			return ctx, syncState, nil
+		}
+		if ctx != nil {
+			_ = ctx.Close()
+		}
+
+		delay := api.DefaultBackoff.Delay(attempt)
+		for _, check := range health.Failed() {
+			api.Log().Warn("🩺 Self-check failed, running in degraded mode", "check", check.Name, "err", check.Err)
+		}
+		api.Log().Info("🩺 Retrying self-check", "delay", delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-c.Done():
+			return nil, nil, c.Err()
+		}
+	}
+}
+
+// checkWritable verifies that files can be created in the vault folder
+func checkWritable(targetPath string) error {
+	file, err := os.CreateTemp(targetPath, ".obsidian-sync-check-*")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkCredentials verifies that the auth token is accepted and can access the vault
+func checkCredentials(authToken *crypto.Secret, vaultId string) error {
+	vaults, err := api.ListVaults(authToken)
+	if err != nil {
+		return err
+	}
+	for _, vault := range vaults {
+		if vault.Id == vaultId {
+			return nil
+		}
+	}
+	return fmt.Errorf("vault %s is not accessible with this token", vaultId)
+}
+
+// checkIntegrity verifies that the sync state is internally consistent
+func (s *State) checkIntegrity() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, remoteEntry := range s.RemoteEntries {
+		if remoteEntry.EncryptedPath != path {
+			return fmt.Errorf("remote entry %d is stored under the wrong path", remoteEntry.Uid)
+		}
+		if remoteEntry.Uid > s.RemoteUid {
+			return fmt.Errorf("remote entry %d is newer than the remote watermark %d", remoteEntry.Uid, s.RemoteUid)
+		}
+	}
+	for _, localEntry := range s.LocalFiles {
+		if localEntry.Path == "" || filepath.IsAbs(localEntry.Path) {
+			return fmt.Errorf("local entry has invalid path %q", localEntry.Path)
+		}
+	}
+	return nil
+}
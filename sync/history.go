@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// FileHistory lists the versions the server kept of the file at remotePath, newest first
+func FileHistory(remotePath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) ([]api.FileVersion, error) {
+	return FileHistoryContext(context.Background(), remotePath, authToken, vault, password, opts)
+}
+
+// FileHistoryContext is FileHistory, giving up when c is done
+func FileHistoryContext(c context.Context, remotePath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) ([]api.FileVersion, error) {
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+	remotePath, err := cleanRemotePath(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := connectForHistory(c, authToken, vault, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+
+	versions, err := ctx.HistoryContext(c, remotePath)
+	if err != nil {
+		return nil, fmt.Errorf("error getting history: %s", err)
+	}
+	return versions, nil
+}
+
+// connectForHistory connects read-only and initializes, which the server needs before it answers history
+func connectForHistory(c context.Context, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (*api.ObsidianSocketContext, error) {
+	ctx, err := api.ConnectToVaultContext(c, vault, password, authToken)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to vault: %s", err)
+	}
+	ctx.ReadOnly = true
+	ctx.DeviceName = opts.DeviceName
+
+	api.Log().Info("🔄 Initializing")
+	if _, err := ctx.SendInitContext(c, 0, true); err != nil {
+		_ = ctx.Close()
+		return nil, fmt.Errorf("error sending init message: %s", err)
+	}
+	return ctx, nil
+}
+
+// cleanRemotePath normalizes a vault path given on the command line, returning an error if it's the vault root
+func cleanRemotePath(remotePath string) (string, error) {
+	remotePath = strings.Trim(path.Clean("/"+filepath.ToSlash(remotePath)), "/")
+	if remotePath == "" {
+		return "", fmt.Errorf("a file path is needed")
+	}
+	return remotePath, nil
+}
@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// Hooks are shell commands run on sync events, e.g. to rebuild a static site after notes change. Each gets the event
+// in OBSIDIAN_SYNC_EVENT, and its details in OBSIDIAN_SYNC_EVENT_* environment variables: VAULT_ID and FOLDER for
+// every event, and the ones listed for each. Hooks run one at a time and the sync waits for them, so long-running
+// work should be started in the background. A failing hook is logged but doesn't fail the sync.
+type Hooks struct {
+	PreSync       string // Before each sync pass
+	PostSync      string // After each sync pass that succeeded, with ADDED, MODIFIED and DELETED, the number of files
+	OnConflict    string // For each file changed both locally and remotely, with PATH, before it's resolved
+	OnError       string // When a sync pass fails, with ERROR
+	OnFileChanged string // For each file or folder a sync pass changed locally, with PATH and CHANGE: added, modified or deleted
+}
+
+// Events passed to hooks in OBSIDIAN_SYNC_EVENT
+const (
+	hookPreSync     = "pre-sync"
+	hookPostSync    = "post-sync"
+	hookConflict    = "conflict"
+	hookError       = "error"
+	hookFileChanged = "file-changed"
+)
+
+// runHook runs a hook command, if set, for event. details are NAME=value pairs, named without the
+// OBSIDIAN_SYNC_EVENT_ prefix.
+func (s *State) runHook(command string, event string, details ...string) {
+	if command == "" {
+		return
+	}
+	var shell *exec.Cmd
+	if runtime.GOOS == "windows" {
+		shell = exec.Command("cmd", "/C", command)
+	} else {
+		shell = exec.Command("sh", "-c", command)
+	}
+	shell.Dir = s.TargetPath
+	shell.Env = append(os.Environ(), "OBSIDIAN_SYNC_EVENT="+event, "OBSIDIAN_SYNC_EVENT_VAULT_ID="+s.VaultId,
+		"OBSIDIAN_SYNC_EVENT_FOLDER="+s.TargetPath)
+	for _, detail := range details {
+		shell.Env = append(shell.Env, "OBSIDIAN_SYNC_EVENT_"+detail)
+	}
+
+	api.Log().Debug("🪝 Running hook", "event", event, "command", command)
+	out, err := shell.CombinedOutput()
+	if output := strings.TrimSpace(string(out)); output != "" {
+		api.Log().Info("🪝 Hook output", "event", event, "output", output)
+	}
+	if err != nil {
+		api.Log().Warn("⚠️ Hook failed", "event", event, "err", err)
+	}
+}
+
+// runChangeHooks runs the hooks for the end of a sync pass that made changes, and failed with err if it isn't nil
+func (s *State) runChangeHooks(changes *Changeset, err error) {
+	if changes != nil && s.Hooks.OnFileChanged != "" {
+		for _, change := range []struct {
+			name  string
+			paths []string
+		}{{"added", changes.Added}, {"modified", changes.Modified}, {"deleted", changes.Deleted}} {
+			for _, path := range change.paths {
+				s.runHook(s.Hooks.OnFileChanged, hookFileChanged, "PATH="+path, "CHANGE="+change.name)
+			}
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrStopped):
+		// Asked to stop, which isn't an error
+	case err != nil:
+		s.runHook(s.Hooks.OnError, hookError, "ERROR="+err.Error())
+	case changes != nil:
+		// Changes are recorded whenever there's a post-sync hook
+		s.runHook(s.Hooks.PostSync, hookPostSync, "ADDED="+strconv.Itoa(len(changes.Added)),
+			"MODIFIED="+strconv.Itoa(len(changes.Modified)), "DELETED="+strconv.Itoa(len(changes.Deleted)))
+	}
+}
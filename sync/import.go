@@ -0,0 +1,302 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// ErrVaultNotEmpty is returned by Import when the vault has files that an earlier import of the folder didn't push
+var ErrVaultNotEmpty = errors.New("the vault isn't empty")
+
+// ImportResult counts what Import pushed
+type ImportResult struct {
+	Files     int   // Files pushed
+	Unchanged int   // Files the vault already had with the same content, e.g. from an interrupted import
+	OverQuota int   // Files skipped because they didn't fit in the vault, see Options.SkipOverQuota
+	Bytes     int64 // Content pushed, before encryption
+}
+
+// importFile is a file of the folder being imported
+type importFile struct {
+	path     string // Slash-separated path relative to the folder, which is also its vault path
+	size     int64
+	modified int64
+}
+
+// Import pushes every file of the folder at sourcePath into the vault, without syncing or touching any sync state, for
+// moving notes from another sync solution into an empty vault. Up to opts.Parallelism files are pushed at once, each
+// over its own connection. Files the vault already has with the same content are skipped, so an interrupted import
+// picks up where it stopped when run again. Import refuses to start if the vault has other files, or other content at
+// a path, unless merge is set, which keeps the other files and replaces the differing ones. Options.Include, Exclude
+// and SkipFileTypes pick the files to import, and empty folders aren't.
+func Import(sourcePath string, merge bool, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (ImportResult, error) {
+	return ImportContext(context.Background(), sourcePath, merge, authToken, vault, password, opts)
+}
+
+// ImportContext is Import, giving up when c is done. The files pushed before then are counted in the result.
+func ImportContext(c context.Context, sourcePath string, merge bool, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (ImportResult, error) {
+	var result ImportResult
+	if opts.ReadOnly {
+		return result, api.ErrReadOnly
+	}
+	priorities, err := compileGlobs(opts.Priorities)
+	if err != nil {
+		return result, fmt.Errorf("invalid priority pattern: %s", err)
+	}
+	filter, err := compileFilter(opts.Include, opts.Exclude, opts.SkipFileTypes)
+	if err != nil {
+		return result, fmt.Errorf("invalid include or exclude pattern: %s", err)
+	}
+	files, err := scanImport(sourcePath, filter)
+	if err != nil {
+		return result, fmt.Errorf("error scanning %s: %s", sourcePath, err)
+	}
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.path)
+	}
+	for _, issue := range CheckPathPortability(paths) {
+		api.Log().Warn("⚠️ Path may not sync to every platform", "path", issue.Path, "problem", issue.Problem)
+	}
+
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+	ctx, err := api.ConnectToVaultContext(c, vault, password, authToken)
+	if err != nil {
+		return result, fmt.Errorf("error connecting to vault: %s", err)
+	}
+	defer ctx.Close()
+	ctx.DeviceName = opts.DeviceName
+	defer closeWhenDone(c, ctx)()
+
+	state, err := fetchIndex(c, ctx)
+	if err != nil {
+		return result, err
+	}
+	size, limit, err := ctx.GetSizeConfig()
+	if err != nil {
+		return result, fmt.Errorf("error getting size info: %s", err)
+	}
+
+	// The sync state's bookkeeping also checks the quota and reports progress
+	state.TargetPath = sourcePath
+	state.Size = size
+	state.Limit = limit
+	state.SkipOverQuota = opts.SkipOverQuota
+	state.Progress = opts.Progress
+	pending, unchanged, err := state.planImport(ctx, files, merge)
+	if err != nil {
+		return result, err
+	}
+	result.Unchanged = unchanged
+	if unchanged > 0 {
+		api.Log().Info("⏩ Resuming import", "unchanged", unchanged, "remaining", len(pending))
+	}
+	if len(pending) == 0 {
+		return result, nil
+	}
+
+	// Notes and priority paths first, so they're usable soonest
+	byPath := make(map[string]importFile, len(pending))
+	decrypted := make(map[string]string, len(pending))
+	paths = paths[:0]
+	for _, file := range pending {
+		byPath[file.path] = file
+		decrypted[file.path] = file.path
+		paths = append(paths, file.path)
+	}
+	sortByPriority(paths, decrypted, priorities)
+
+	// Pushes on one connection run one at a time, so each parallel push gets its own
+	parallelism := opts.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if parallelism > len(pending) {
+		parallelism = len(pending)
+	}
+	conns := make(chan *api.ObsidianSocketContext, parallelism)
+	conns <- ctx
+	for i := 1; i < parallelism; i++ {
+		conn, err := api.ConnectToVaultContext(c, vault, password, authToken)
+		if err == nil {
+			defer conn.Close()
+			conn.DeviceName = opts.DeviceName
+			defer closeWhenDone(c, conn)()
+			_, err = conn.SendInitContext(c, state.RemoteUid, false)
+		}
+		if err != nil {
+			api.Log().Warn("⚠️ Could not open another connection, pushing over fewer", "connections", i, "err", err)
+			break
+		}
+		conns <- conn
+	}
+	parallelism = len(conns)
+
+	var pushBytes int64
+	for _, file := range pending {
+		pushBytes += crypto.EncryptedSize(file.size)
+	}
+	pushDone := state.reportPhaseCountdown(PhasePush, len(paths), pushBytes)
+	tasks := make([]*task, 0, len(paths))
+	for i, path := range paths {
+		i, file := i, byPath[path]
+		tasks = append(tasks, &task{path: file.path, run: func() error {
+			defer pushDone()
+			conn := <-conns
+			defer func() {
+				conns <- conn
+			}()
+			return state.importEntry(c, conn, file, i, len(paths), &result)
+		}})
+	}
+	err = runTasks(c, tasks, parallelism)
+	return result, err
+}
+
+// scanImport lists the files below sourcePath that filter allows, leaving out what sync leaves alone
+func scanImport(sourcePath string, filter pathFilter) ([]importFile, error) {
+	var files []importFile
+	err := filepath.WalkDir(sourcePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == sourcePath {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".trash") {
+			return filepath.SkipDir
+		}
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if d.IsDir() {
+			if !filter.allows(relPath, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == MarkerFile || !filter.allows(relPath, false) {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			api.Log().Warn("⏭️ Skipping, not a regular file", "path", relPath)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, importFile{path: relPath, size: info.Size(), modified: info.ModTime().UnixMilli()})
+		return nil
+	})
+	return files, err
+}
+
+// planImport returns the files the vault doesn't have yet, or has with other content, and how many it already has.
+// Fails with ErrVaultNotEmpty if the vault has other files, or other content, unless merge is set.
+func (s *State) planImport(ws *api.ObsidianSocketContext, files []importFile, merge bool) ([]importFile, int, error) {
+	remoteFiles := make(map[string]ObsidianRemoteEntry)
+	for _, entry := range s.RemoteEntries {
+		if !entry.IsFolder {
+			remoteFiles[entry.Path] = entry
+		}
+	}
+
+	var pending []importFile
+	unchanged := 0
+	differing := 0
+	for _, file := range files {
+		remoteFile, exists := remoteFiles[file.path]
+		delete(remoteFiles, file.path)
+		if exists {
+			same, err := s.matchesRemote(ws, file.path, remoteFile)
+			if err != nil {
+				return nil, 0, fmt.Errorf("error comparing %s with the vault: %s", file.path, err)
+			}
+			if same {
+				unchanged++
+				continue
+			}
+			differing++
+		}
+		pending = append(pending, file)
+	}
+	if !merge && (differing > 0 || len(remoteFiles) > 0) {
+		return nil, 0, fmt.Errorf("%w: it has %d files that aren't in %s and %d with other content", ErrVaultNotEmpty, len(remoteFiles), s.TargetPath, differing)
+	}
+	return pending, unchanged, nil
+}
+
+// importEntry pushes a file of the folder being imported over ws, which it has to itself, and counts it in result
+func (s *State) importEntry(c context.Context, ws *api.ObsidianSocketContext, file importFile, index int, count int, result *ImportResult) error {
+	api.Log().Info("📄 Pushing", "path", file.path)
+	content, err := os.ReadFile(filepath.Join(s.TargetPath, filepath.FromSlash(file.path)))
+	if err != nil {
+		return fmt.Errorf("error reading %s from disk: %s", file.path, err)
+	}
+
+	// Make sure the push fits in the vault, reserving the space so parallel pushes can't overcommit it
+	existing, _ := s.EncryptedPath(file.path)
+	s.mu.Lock()
+	err = s.checkQuota(existing, int64(len(content)))
+	if err == nil {
+		s.recordPush(existing, int64(len(content)))
+	} else if s.SkipOverQuota {
+		result.OverQuota++
+	}
+	s.mu.Unlock()
+	if err != nil {
+		if s.SkipOverQuota {
+			api.Log().Warn("⏭️ Skipping", "path", file.path, "err", err)
+			return nil
+		}
+		return err
+	}
+
+	err = s.trackTransfer(PhasePush, file.path, index, count, func(onTransfer api.TransferFunc) error {
+		ws.OnTransfer = onTransfer
+		defer func() {
+			ws.OnTransfer = nil
+		}()
+		push := func() error {
+			_, err := ws.PushFileContext(c, file.path, api.Extension(file.path), file.modified, file.modified, false, false, content)
+			return err
+		}
+		err := push()
+		for attempt := 0; retryable(err) && attempt < transferRetries; attempt++ {
+			api.Log().Warn("⚠️ Push failed, reconnecting to retry", "path", file.path, "err", err)
+			metrics.reconnects.Add(1)
+			if _, reconnectErr := ws.ReconnectContext(c, api.DefaultBackoff, s.RemoteUid); reconnectErr != nil {
+				return fmt.Errorf("error reconnecting to retry: %s", reconnectErr)
+			}
+			err = push()
+		}
+		return err
+	})
+	// Pushes over the other connections may be echoed on this one too, drop them before they pile up
+	_, _ = ws.PendingPushMessages()
+	if err != nil {
+		s.mu.Lock()
+		s.Size -= s.quotaDelta(existing, int64(len(content)))
+		s.mu.Unlock()
+		return fmt.Errorf("error pushing %s: %s", file.path, err)
+	}
+
+	metrics.filesPushed.Add(1)
+	metrics.bytesPushed.Add(int64(len(content)))
+	s.mu.Lock()
+	result.Files++
+	result.Bytes += int64(len(content))
+	s.mu.Unlock()
+	return nil
+}
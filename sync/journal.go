@@ -0,0 +1,89 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	gosync "sync"
+	"time"
+)
+
+// Changeset lists the local paths one sync pass changed. With a journal directory set, each pass that changes anything
+// writes one, so backup tools can back up only when the vault changed instead of scanning it. Consumers should remove
+// the files they've processed.
+type Changeset struct {
+	VaultId    string    `json:"vaultId"`
+	TargetPath string    `json:"targetPath"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	Added      []string  `json:"added"`    // Files and folders that didn't exist locally
+	Modified   []string  `json:"modified"` // Files whose content was replaced
+	Deleted    []string  `json:"deleted"`  // Files and folders that were removed
+
+	mu gosync.Mutex
+}
+
+// changeKind is which list of a Changeset a path goes in
+type changeKind int
+
+const (
+	changeAdded changeKind = iota
+	changeModified
+	changeDeleted
+)
+
+// record adds a decrypted path to the changeset. Safe to call on a nil changeset, and from parallel tasks.
+func (c *Changeset) record(kind changeKind, path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch kind {
+	case changeAdded:
+		c.Added = append(c.Added, path)
+	case changeModified:
+		c.Modified = append(c.Modified, path)
+	case changeDeleted:
+		c.Deleted = append(c.Deleted, path)
+	}
+}
+
+// Empty returns true if nothing changed
+func (c *Changeset) Empty() bool {
+	return len(c.Added)+len(c.Modified)+len(c.Deleted) == 0
+}
+
+// startJournal starts recording the changes of a sync pass, if a journal directory, a hook or a git commit needs them
+func (s *State) startJournal() {
+	s.changes = nil
+	if s.JournalDir != "" || s.Hooks.OnFileChanged != "" || s.Hooks.PostSync != "" || s.GitCommit {
+		s.changes = &Changeset{VaultId: s.VaultId, TargetPath: s.TargetPath, Started: time.Now()}
+	}
+}
+
+// finishJournal returns the changes of the sync pass, if they were recorded, and writes them to the journal directory
+// as <unix millis>.json if there were any. Called even when the pass failed partway, since the changes it made are
+// still on disk.
+func (s *State) finishJournal() (*Changeset, error) {
+	c := s.changes
+	s.changes = nil
+	if c == nil {
+		return nil, nil
+	}
+	c.Finished = time.Now()
+	sort.Strings(c.Added)
+	sort.Strings(c.Modified)
+	sort.Strings(c.Deleted)
+	if s.JournalDir == "" || c.Empty() {
+		return c, nil
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return c, err
+	}
+	path := filepath.Join(s.JournalDir, fmt.Sprintf("%d.json", c.Finished.UnixMilli()))
+	return c, writeAtomic(path, data)
+}
@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// RemoteFile is a file or folder in the vault's index
+type RemoteFile struct {
+	Path     string    `json:"path"`
+	Folder   bool      `json:"folder"`
+	Size     int64     `json:"size"` // Decrypted, zero for folders
+	Modified time.Time `json:"modified"`
+	Device   string    `json:"device"` // Device that pushed the current version
+	Uid      int64     `json:"uid"`
+}
+
+// ListRemote lists the files and folders under remotePath in the vault, or the file at it, sorted by path. An empty
+// remotePath lists the whole vault. Nothing is pulled, everything comes from the vault's index.
+func ListRemote(remotePath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) ([]RemoteFile, error) {
+	return ListRemoteContext(context.Background(), remotePath, authToken, vault, password, opts)
+}
+
+// ListRemoteContext is ListRemote, giving up when c is done
+func ListRemoteContext(c context.Context, remotePath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) ([]RemoteFile, error) {
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+	remotePath = strings.Trim(path.Clean("/"+filepath.ToSlash(remotePath)), "/")
+
+	ctx, err := connectReadOnly(c, authToken, vault, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+	state, err := fetchIndex(c, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []RemoteFile{}
+	prefix := remotePath + "/"
+	for _, entry := range state.RemoteEntries {
+		if entry.Path == "" {
+			continue
+		}
+		if remotePath != "" && entry.Path != remotePath && !strings.HasPrefix(entry.Path, prefix) {
+			continue
+		}
+		file := RemoteFile{
+			Path:     entry.Path,
+			Folder:   entry.IsFolder,
+			Modified: time.UnixMilli(entry.Modified),
+			Device:   entry.Device,
+			Uid:      entry.Uid,
+		}
+		if !entry.IsFolder {
+			file.Size = crypto.PlaintextSize(entry.Size)
+		}
+		files = append(files, file)
+	}
+	if remotePath != "" && len(files) == 0 {
+		return nil, fmt.Errorf("%s not found in the vault", remotePath)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
@@ -0,0 +1,151 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// pushLocalChanges pushes the local changes the planner doesn't handle: folders created locally, found by scanLocal,
+// as folder entries, and files and folders deleted locally, as deletions. A deletion is only pushed while the vault
+// still has the version that last synced, a newer remote version is pulled back instead. Read-only syncs leave both
+// alone.
+func (s *State) pushLocalChanges(ws *api.ObsidianSocketContext, folders []string) error {
+	if s.ReadOnly {
+		return nil
+	}
+	deleted := s.locallyDeleted()
+	if len(deleted)+len(folders) == 0 {
+		return nil
+	}
+	api.Log().Info("📋 Local changes", "deleted", len(deleted), "folders", len(folders))
+
+	for _, path := range deleted {
+		if err := s.pushDelete(ws, path); err != nil {
+			return err
+		}
+	}
+	for _, path := range folders {
+		if err := s.pushFolder(ws, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// locallyDeleted returns the encrypted paths of synced files and folders that are gone from disk, and whose deletion
+// can be pushed, the contents of folders before the folders
+func (s *State) locallyDeleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted []string
+	gone := make(map[string]bool)
+	for path, localFile := range s.LocalFiles {
+		if localFile.Evicted || localFile.Path == "" || !s.Filter.allows(localFile.Path, localFile.IsFolder) {
+			continue
+		}
+		fullPath, err := s.localPath(localFile.Path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Lstat(fullPath); !os.IsNotExist(err) {
+			continue
+		}
+		remoteFile, inRemote := s.RemoteEntries[path]
+		baseEntry, inBase := s.Synced[localFile.Path]
+		if !inRemote || !inBase || remoteFile.IsFolder != localFile.IsFolder || remoteChanged(baseEntry, remoteFile) {
+			continue
+		}
+		deleted = append(deleted, path)
+		gone[localFile.Path] = true
+	}
+
+	// A folder stays while the vault has anything in it that isn't being deleted, which will be pulled back into it
+	kept := deleted[:0]
+	for _, path := range deleted {
+		localFile := s.LocalFiles[path]
+		if localFile.IsFolder && s.hasRemoteContents(localFile.Path, gone) {
+			continue
+		}
+		kept = append(kept, path)
+	}
+	sortByDepth(kept, func(path string) string {
+		return s.LocalFiles[path].Path
+	}, true)
+	return kept
+}
+
+// hasRemoteContents returns true if the vault has a file or folder under the folder at path other than those in
+// except. s.mu must be held.
+func (s *State) hasRemoteContents(path string, except map[string]bool) bool {
+	prefix := path + "/"
+	for _, remoteFile := range s.RemoteEntries {
+		if strings.HasPrefix(remoteFile.Path, prefix) && !except[remoteFile.Path] {
+			return true
+		}
+	}
+	return false
+}
+
+// pushDelete deletes a file or folder that was deleted locally from the vault, and forgets it
+func (s *State) pushDelete(ws *api.ObsidianSocketContext, path string) error {
+	s.mu.Lock()
+	localFile := s.LocalFiles[path]
+	s.mu.Unlock()
+	api.Log().Info("🗑️ Deleting from the vault", "path", localFile.Path)
+	now := time.Now().UnixMilli()
+	var echo *api.IncomingPushMessage
+	err := s.useSocket(ws, localFile.Path, nil, func() error {
+		var err error
+		echo, err = ws.PushFileContext(s.context(), localFile.Path, api.Extension(localFile.Path), localFile.Created, now, localFile.IsFolder, true, nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting %s from the vault: %s", localFile.Path, err)
+	}
+
+	s.mu.Lock()
+	s.updateWithPush(echo)
+	delete(s.RemoteEntries, path)
+	delete(s.LocalFiles, path)
+	delete(s.Synced, localFile.Path)
+	s.mu.Unlock()
+	s.emit(Event{Kind: EventRemoved, Path: localFile.Path})
+	return nil
+}
+
+// pushFolder creates a folder that was created locally in the vault, and records it as synced
+func (s *State) pushFolder(ws *api.ObsidianSocketContext, path string) error {
+	api.Log().Info("📁 Creating folder in the vault", "path", path)
+	info, err := os.Stat(filepath.Join(s.TargetPath, filepath.FromSlash(path)))
+	if err != nil {
+		return fmt.Errorf("error reading folder: %s", err)
+	}
+	modified := info.ModTime().UnixMilli()
+	var echo *api.IncomingPushMessage
+	err = s.useSocket(ws, path, nil, func() error {
+		var err error
+		echo, err = ws.PushFileContext(s.context(), path, "", modified, modified, true, false, nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error creating folder %s in the vault: %s", path, err)
+	}
+
+	s.mu.Lock()
+	s.updateWithPush(echo)
+	s.LocalFiles[echo.EncryptedPath] = ObsidianLocalEntry{
+		Path:     path,
+		Created:  modified,
+		Modified: modified,
+		IsFolder: true,
+	}
+	s.recordSynced(echo.EncryptedPath)
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,158 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// lockRefresh is how often a held lock's timestamp is refreshed. A lock that wasn't refreshed for a few intervals was
+// left behind by a sync that died, even if another process reused its PID.
+const lockRefresh = 30 * time.Second
+
+// folderLock is the content of a vault folder's lock file
+type folderLock struct {
+	Pid       int       `json:"pid"`
+	Acquired  time.Time `json:"acquired"`
+	Refreshed time.Time `json:"refreshed"`
+}
+
+// stale returns true if the sync holding the lock is gone
+func (l folderLock) stale() bool {
+	if time.Since(l.Refreshed) > 3*lockRefresh {
+		return true
+	}
+	return l.Pid > 0 && !processAlive(l.Pid)
+}
+
+// LockedError is returned when another sync holds the lock of a vault folder
+type LockedError struct {
+	Pid      int
+	Acquired time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("the folder is being synced by PID %d since %s, pass --forceUnlock if it isn't", e.Pid,
+		e.Acquired.Format("2006-01-02 15:04:05"))
+}
+
+// lockPath returns the lock file of the vault folder at targetPath
+func lockPath(targetPath string) (string, error) {
+	path, err := StatePath(targetPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, ".json") + ".lock", nil
+}
+
+// acquireLock locks the vault folder at targetPath, so two syncs don't apply changes to it at once and overwrite each
+// other's state. Stale locks are taken over, and force takes over any lock. The returned function releases it.
+func acquireLock(targetPath string, force bool) (func(), error) {
+	path, err := lockPath(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	lock := folderLock{Pid: os.Getpid(), Acquired: time.Now(), Refreshed: time.Now()}
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt == 3 {
+			return nil, fmt.Errorf("error locking the folder: other syncs keep taking the lock")
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = file.Write(data)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, fmt.Errorf("error writing lock file: %s", err)
+			}
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("error creating lock file: %s", err)
+		}
+
+		held, err := readLock(path)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case force:
+			api.Log().Warn("⚠️ Forcing the folder unlocked", "pid", held.Pid)
+		case held.stale():
+			api.Log().Info("🔓 Taking over a stale lock", "pid", held.Pid)
+		default:
+			return nil, &LockedError{Pid: held.Pid, Acquired: held.Acquired}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error removing lock file: %s", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lock.Refreshed = time.Now()
+				data, err := json.Marshal(lock)
+				if err == nil {
+					err = writeAtomic(path, data)
+				}
+				if err != nil {
+					api.Log().Warn("⚠️ Could not refresh folder lock", "err", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		// A forced unlock may have handed the lock to another sync
+		if held, err := readLock(path); err == nil && held.Pid == lock.Pid && held.Acquired.Equal(lock.Acquired) {
+			_ = os.Remove(path)
+		}
+	}, nil
+}
+
+// readLock reads a lock file. One that's gone reads as stale, and one that can't be parsed, e.g. because it's being
+// written, as fresh until it's old.
+func readLock(path string) (folderLock, error) {
+	var lock folderLock
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return lock, nil
+	}
+	if err != nil {
+		return lock, fmt.Errorf("error reading lock file: %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return lock, fmt.Errorf("error reading lock file: %s", err)
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return folderLock{Acquired: info.ModTime(), Refreshed: info.ModTime()}, nil
+	}
+	return lock, nil
+}
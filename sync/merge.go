@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// jsonMergeable returns true for files that can be merged at the JSON object level instead of line by line
+func jsonMergeable(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".canvas", ".json":
+		return true
+	}
+	return false
+}
+
+// mergeJSON merges two versions of a JSON document. Objects are merged key by key, arrays of objects with an "id"
+// (like canvas nodes and edges) are merged by id, and the local version wins any remaining conflicts.
+func mergeJSON(path string, local []byte, remote []byte) ([]byte, error) {
+	localValue, err := decodeJSON(local)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse local version: %s", err)
+	}
+	remoteValue, err := decodeJSON(remote)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse remote version: %s", err)
+	}
+
+	localObject, localOk := localValue.(map[string]interface{})
+	remoteObject, remoteOk := remoteValue.(map[string]interface{})
+	if !localOk || !remoteOk {
+		return nil, fmt.Errorf("top level value is not an object")
+	}
+
+	// Obsidian writes canvas files with tabs, and settings with two spaces
+	indent := "  "
+	if strings.EqualFold(filepath.Ext(path), ".canvas") {
+		indent = "\t"
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(mergeValues(localObject, remoteObject)); err != nil {
+		return nil, fmt.Errorf("could not encode merged version: %s", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// decodeJSON decodes a document, keeping numbers as written
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// mergeValues merges a local and remote JSON value, preferring local where they can't be combined
+func mergeValues(local interface{}, remote interface{}) interface{} {
+	switch localValue := local.(type) {
+	case map[string]interface{}:
+		remoteValue, ok := remote.(map[string]interface{})
+		if !ok {
+			return local
+		}
+		merged := make(map[string]interface{}, len(remoteValue))
+		for key, value := range remoteValue {
+			merged[key] = value
+		}
+		for key, value := range localValue {
+			if remoteItem, inRemote := remoteValue[key]; inRemote {
+				merged[key] = mergeValues(value, remoteItem)
+			} else {
+				merged[key] = value
+			}
+		}
+		return merged
+	case []interface{}:
+		remoteValue, ok := remote.([]interface{})
+		if !ok {
+			return local
+		}
+		if merged, ok := mergeById(localValue, remoteValue); ok {
+			return merged
+		}
+		return local
+	default:
+		return local
+	}
+}
+
+// mergeById merges arrays whose items are all objects with a string "id", keeping local order and appending items
+// only found remotely. Returns false if the arrays aren't keyed by id.
+func mergeById(local []interface{}, remote []interface{}) ([]interface{}, bool) {
+	localIds, ok := itemIds(local)
+	if !ok {
+		return nil, false
+	}
+	remoteIds, ok := itemIds(remote)
+	if !ok {
+		return nil, false
+	}
+
+	remoteById := make(map[string]interface{}, len(remote))
+	for i, id := range remoteIds {
+		remoteById[id] = remote[i]
+	}
+
+	merged := make([]interface{}, 0, len(local)+len(remote))
+	seen := make(map[string]bool, len(local))
+	for i, id := range localIds {
+		seen[id] = true
+		if remoteItem, inRemote := remoteById[id]; inRemote {
+			merged = append(merged, mergeValues(local[i], remoteItem))
+		} else {
+			merged = append(merged, local[i])
+		}
+	}
+	for i, id := range remoteIds {
+		if !seen[id] {
+			merged = append(merged, remote[i])
+		}
+	}
+	return merged, true
+}
+
+// itemIds returns the "id" of each item, or false if any item isn't an object with a string id
+func itemIds(items []interface{}) ([]string, bool) {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id, ok := object["id"].(string)
+		if !ok {
+			return nil, false
+		}
+		ids[i] = id
+	}
+	return ids, true
+}
@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMergeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   string
+		remote  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "clean merge",
+			local:  `{"a": 1, "b": 2}`,
+			remote: `{"a": 1, "c": 3}`,
+			want:   `{"a":1,"b":2,"c":3}`,
+		},
+		{
+			name:   "overlapping edits keep local",
+			local:  `{"theme": "dark", "size": 12}`,
+			remote: `{"theme": "light", "size": 12}`,
+			want:   `{"size":12,"theme":"dark"}`,
+		},
+		{
+			name:   "nested objects merge by key",
+			local:  `{"hotkeys": {"a": "x"}}`,
+			remote: `{"hotkeys": {"b": "y"}}`,
+			want:   `{"hotkeys":{"a":"x","b":"y"}}`,
+		},
+		{
+			name:   "nodes merge by id in local order",
+			local:  `{"nodes": [{"id": "2", "x": 5}, {"id": "1", "x": 1}]}`,
+			remote: `{"nodes": [{"id": "1", "x": 9, "color": "red"}, {"id": "3"}]}`,
+			want:   `{"nodes":[{"id":"2","x":5},{"color":"red","id":"1","x":1},{"id":"3"}]}`,
+		},
+		{
+			// Without the base version a deletion on one side looks like an addition on the other
+			name:   "node deleted on one side is kept",
+			local:  `{"nodes": [{"id": "1"}]}`,
+			remote: `{"nodes": [{"id": "1"}, {"id": "2"}]}`,
+			want:   `{"nodes":[{"id":"1"},{"id":"2"}]}`,
+		},
+		{
+			name:   "key deleted on one side is kept",
+			local:  `{"a": 1}`,
+			remote: `{"a": 1, "b": 2}`,
+			want:   `{"a":1,"b":2}`,
+		},
+		{
+			name:   "arrays without ids keep local",
+			local:  `{"tags": ["a", "b"]}`,
+			remote: `{"tags": ["c"]}`,
+			want:   `{"tags":["a","b"]}`,
+		},
+		{
+			name:   "numbers keep their form",
+			local:  `{"x": 1.50}`,
+			remote: `{"y": 10000000000000000001}`,
+			want:   `{"x":1.50,"y":10000000000000000001}`,
+		},
+		{name: "invalid local", local: `{`, remote: `{}`, wantErr: true},
+		{name: "invalid remote", local: `{}`, remote: `nope`, wantErr: true},
+		{name: "top level array", local: `[]`, remote: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeJSON("settings.json", []byte(tt.local), []byte(tt.remote))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mergeJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, got); err != nil {
+				t.Fatalf("mergeJSON() returned invalid JSON %s: %s", got, err)
+			}
+			if compact.String() != tt.want {
+				t.Errorf("mergeJSON() = %s, want %s", compact.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeJSONIndent(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"board.canvas", "{\n\t\"a\": 1\n}"},
+		{".obsidian/app.json", "{\n  \"a\": 1\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := mergeJSON(tt.path, []byte(`{"a": 1}`), []byte(`{"a": 2}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mergeJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
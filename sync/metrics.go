@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// passBuckets are the upper bounds in seconds of the sync pass duration histogram
+var passBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900}
+
+// metrics are counted by the syncs running in this process, and served by a daemon on /metrics, see writeMetrics
+var metrics struct {
+	filesPushed atomic.Int64
+	filesPulled atomic.Int64
+	bytesPushed atomic.Int64
+	bytesPulled atomic.Int64
+	conflicts   atomic.Int64
+	reconnects  atomic.Int64 // After losing the connection, not the ones sweeps make on purpose
+	queueDepth  atomic.Int64 // Messages waiting to be read when the last push message arrived
+	passes      histogram
+}
+
+// histogram counts observations into cumulative buckets, like a Prometheus histogram
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // Per bucket of passBuckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// observeSince observes the seconds since start
+func (h *histogram) observeSince(start time.Time) {
+	seconds := time.Since(start).Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make([]uint64, len(passBuckets))
+	}
+	for i, bound := range passBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// writeMetrics writes the metrics in the Prometheus text format
+func writeMetrics(w io.Writer) {
+	metric := func(name string, kind string, help string, value float64) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+	}
+	metric("obsidian_sync_files_pushed_total", "counter", "Files pushed to the vault.", float64(metrics.filesPushed.Load()))
+	metric("obsidian_sync_files_pulled_total", "counter", "Files pulled from the vault.", float64(metrics.filesPulled.Load()))
+	metric("obsidian_sync_pushed_bytes_total", "counter", "Bytes of files pushed to the vault.", float64(metrics.bytesPushed.Load()))
+	metric("obsidian_sync_pulled_bytes_total", "counter", "Bytes of files pulled from the vault.", float64(metrics.bytesPulled.Load()))
+	metric("obsidian_sync_conflicts_total", "counter", "Files changed both locally and remotely.", float64(metrics.conflicts.Load()))
+	metric("obsidian_sync_reconnects_total", "counter", "Reconnects after losing the connection.", float64(metrics.reconnects.Load()))
+	metric("obsidian_sync_queue_depth", "gauge", "Messages waiting to be read when the last change arrived.", float64(metrics.queueDepth.Load()))
+	metric("obsidian_sync_degraded", "gauge", "1 if a self-check is failing.", boolMetric(CurrentHealth().Degraded))
+	metric("obsidian_sync_paused", "gauge", "1 if the daemon is paused.", boolMetric(daemonPaused()))
+	if summary := currentSummary(); summary != nil {
+		metric("obsidian_sync_vault_size_bytes", "gauge", "Bytes the vault uses.", float64(summary.Size))
+		metric("obsidian_sync_vault_limit_bytes", "gauge", "Size limit of the vault.", float64(summary.Limit))
+		metric("obsidian_sync_files", "gauge", "Files in the folder.", float64(summary.Files))
+		if !summary.LastSync.IsZero() {
+			metric("obsidian_sync_last_sync_timestamp_seconds", "gauge", "When the last sync pass finished.", float64(summary.LastSync.Unix()))
+		}
+	}
+
+	h := &metrics.passes
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	const name = "obsidian_sync_pass_duration_seconds"
+	_, _ = fmt.Fprintf(w, "# HELP %s How long sync passes took.\n# TYPE %s histogram\n", name, name)
+	var cumulative uint64
+	for i, bound := range passBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), cumulative)
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", name, h.count, name, formatFloat(h.sum), name, h.count)
+}
+
+// formatFloat formats a sample value
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// boolMetric is 1 for true and 0 for false
+func boolMetric(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// Mirror is a secondary destination that receives a copy of every file a sync pulls or pushes, so a daemon doubles as
+// an off-site backup agent. Deletes aren't mirrored, a mirror keeps the latest version of every file it was sent.
+type Mirror interface {
+	// Put stores content at a slash-separated vault path, replacing what was there
+	Put(path string, content []byte, modified time.Time) error
+}
+
+// DirMirror mirrors files into a local directory, e.g. on a backup drive
+type DirMirror struct {
+	Dir string
+}
+
+func (m DirMirror) Put(path string, content []byte, modified time.Time) error {
+	fullPath := filepath.Join(m.Dir, filepath.FromSlash(path))
+	if err := writeAtomic(fullPath, content); err != nil {
+		return err
+	}
+	return os.Chtimes(fullPath, modified, modified)
+}
+
+// MirrorInfoFile is written at the root of an encrypted mirror with what's needed to decrypt it besides the password
+const MirrorInfoFile = ".obsidian-sync-mirror.json"
+
+// encryptedSuffix is added to the names of files in an encrypted mirror
+const encryptedSuffix = ".enc"
+
+// mirrorInfo is the content of MirrorInfoFile
+type mirrorInfo struct {
+	Salt string `json:"salt"`
+}
+
+// EncryptedMirror re-encrypts content with its own password before passing it to another mirror, for destinations
+// that shouldn't see notes in the clear. Paths stay readable, with encryptedSuffix added. See RestoreMirror.
+type EncryptedMirror struct {
+	next   Mirror
+	cipher *crypto.Cipher
+}
+
+// NewEncryptedMirror derives the key for password and salt, usually the vault's, and records the salt in next
+func NewEncryptedMirror(next Mirror, password *crypto.Secret, salt string) (*EncryptedMirror, error) {
+	cipher, err := crypto.NewCipher(password, []byte(salt))
+	if err != nil {
+		return nil, fmt.Errorf("error deriving mirror key: %s", err)
+	}
+	info, err := json.Marshal(mirrorInfo{Salt: salt})
+	if err != nil {
+		return nil, err
+	}
+	if err := next.Put(MirrorInfoFile, info, time.Now()); err != nil {
+		return nil, fmt.Errorf("error writing %s: %s", MirrorInfoFile, err)
+	}
+	return &EncryptedMirror{next: next, cipher: cipher}, nil
+}
+
+func (m *EncryptedMirror) Put(path string, content []byte, modified time.Time) error {
+	encrypted, err := m.cipher.Encrypt(content)
+	if err != nil {
+		return fmt.Errorf("error encrypting: %s", err)
+	}
+	return m.next.Put(path+encryptedSuffix, encrypted, modified)
+}
+
+// mirrorFile sends the local file at the decrypted path, which was just pulled or pushed, to the mirror, if there is
+// one. The file is only read when mirroring. Like a failing hook, a failed mirror is logged but doesn't fail the sync.
+func (s *State) mirrorFile(path string, modified int64) {
+	if s.Mirror == nil {
+		return
+	}
+	content, err := os.ReadFile(filepath.Join(s.TargetPath, filepath.FromSlash(path)))
+	if err == nil {
+		err = s.Mirror.Put(path, content, time.UnixMilli(modified))
+	}
+	if err != nil {
+		api.Log().Warn("⚠️ Could not mirror file", "path", path, "err", err)
+		return
+	}
+	api.Log().Debug("🪞 Mirrored", "path", path)
+}
+
+// MirrorRestoreResult counts what RestoreMirror decrypted
+type MirrorRestoreResult struct {
+	Files int
+	Bytes int64
+}
+
+// RestoreMirror decrypts an encrypted mirror at mirrorDir, a DirMirror or a download of a bucket, into targetPath
+func RestoreMirror(mirrorDir string, targetPath string, password *crypto.Secret) (MirrorRestoreResult, error) {
+	var result MirrorRestoreResult
+	data, err := os.ReadFile(filepath.Join(mirrorDir, MirrorInfoFile))
+	if err != nil {
+		return result, fmt.Errorf("error reading %s, is this an encrypted mirror? %s", MirrorInfoFile, err)
+	}
+	var info mirrorInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return result, fmt.Errorf("error parsing %s: %s", MirrorInfoFile, err)
+	}
+	cipher, err := crypto.NewCipher(password, []byte(info.Salt))
+	if err != nil {
+		return result, fmt.Errorf("error deriving mirror key: %s", err)
+	}
+
+	err = filepath.WalkDir(mirrorDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), encryptedSuffix) {
+			return nil
+		}
+		relPath, err := filepath.Rel(mirrorDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = strings.TrimSuffix(relPath, encryptedSuffix)
+		encrypted, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		content, err := cipher.Decrypt(encrypted)
+		if err != nil {
+			return fmt.Errorf("error decrypting %s, is the password right? %s", filepath.ToSlash(relPath), err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		restored := filepath.Join(targetPath, relPath)
+		if err := os.MkdirAll(filepath.Dir(restored), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(restored, content, 0644); err != nil {
+			return err
+		}
+		if err := os.Chtimes(restored, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+		result.Files++
+		result.Bytes += int64(len(content))
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// MarkerFile is written to the root of every synced folder so the folder can be recognized after it's moved. It is
+// local to this machine and must never be pushed.
+const MarkerFile = ".obsidian-sync"
+
+// vaultMarker is the content of MarkerFile, tying the folder to its state file
+type vaultMarker struct {
+	MarkerId string `json:"markerId"`
+	VaultId  string `json:"vaultId"`
+}
+
+// newMarkerId returns a random ID for a newly synced folder
+func newMarkerId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CanonicalPath resolves symlinks in an absolute path, so the same folder always maps to the same state
+func CanonicalPath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return resolved
+}
+
+// readMarker returns the marker in a folder, or nil if there is none
+func readMarker(targetPath string) (*vaultMarker, error) {
+	data, err := os.ReadFile(filepath.Join(targetPath, MarkerFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var marker vaultMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", MarkerFile, err)
+	}
+	return &marker, nil
+}
+
+// writeMarker writes the state's marker to its folder if it isn't already there
+func (s *State) writeMarker() error {
+	marker, err := readMarker(s.TargetPath)
+	if err == nil && marker != nil && marker.MarkerId == s.MarkerId {
+		return nil
+	}
+	data, err := json.Marshal(vaultMarker{MarkerId: s.MarkerId, VaultId: s.VaultId})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.TargetPath, MarkerFile), data, 0644)
+}
+
+// IsSyncedFolder returns true if the folder has been synced before, here or at a previous location
+func IsSyncedFolder(targetPath string) bool {
+	marker, err := readMarker(targetPath)
+	return err == nil && marker != nil
+}
+
+// findMovedState looks for the state of a folder that was synced somewhere else and has since moved to targetPath.
+// Returns nil if the folder wasn't moved, including when it was copied and the original is still in place.
+func findMovedState(targetPath string, vaultId string) (*State, error) {
+	marker, err := readMarker(targetPath)
+	if err != nil || marker == nil || marker.VaultId != vaultId {
+		return nil, err
+	}
+
+	dir, err := StateDir()
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		state, err := readStateFile(filepath.Join(dir, file.Name()))
+		if err != nil || state.MarkerId != marker.MarkerId || state.VaultId != vaultId {
+			continue
+		}
+		if CanonicalPath(state.TargetPath) == CanonicalPath(targetPath) {
+			continue
+		}
+
+		// A copy rather than a move, the original still owns this state
+		if original, err := readMarker(state.TargetPath); err == nil && original != nil && original.MarkerId == marker.MarkerId {
+			api.Log().Info("ℹ️ Folder looks like a copy, syncing it as a new folder", "path", targetPath, "original", state.TargetPath)
+			return nil, nil
+		}
+		return state, nil
+	}
+	return nil, nil
+}
+
+// rebindMoved returns the state of a folder that was moved to targetPath, if the move is confirmed
+func rebindMoved(targetPath string, vaultId string, confirm func(oldPath string, newPath string) bool) (*State, error) {
+	moved, err := findMovedState(targetPath, vaultId)
+	if err != nil || moved == nil {
+		return nil, err
+	}
+	if confirm == nil || !confirm(moved.TargetPath, targetPath) {
+		api.Log().Info("ℹ️ Not reusing the state of a moved folder, syncing from scratch", "path", targetPath, "original", moved.TargetPath)
+		return nil, nil
+	}
+	oldPath := moved.TargetPath
+	if err := moved.rebind(targetPath); err != nil {
+		return nil, err
+	}
+	api.Log().Info("📦 Rebound sync state", "from", oldPath, "to", targetPath)
+	return moved, nil
+}
+
+// rebind moves a state found by findMovedState to its new folder, removing the state file for the old location
+func (s *State) rebind(targetPath string) error {
+	oldPath, err := StatePath(s.TargetPath)
+	if err != nil {
+		return err
+	}
+	s.TargetPath = targetPath
+	if err := s.Save(); err != nil {
+		return err
+	}
+	return os.Remove(oldPath)
+}
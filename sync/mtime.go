@@ -0,0 +1,146 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// DefaultModifiedTolerance is how far apart two modification times of the same version may be. Filesystems like FAT
+// keep them to two seconds, and other devices may round them.
+const DefaultModifiedTolerance = 2 * time.Second
+
+// settleModified compares content where modification times alone would make the planner see a change that may not be
+// one, so clock skew and rounding between devices don't cause false conflicts or pulls of files that are already
+// there. It runs before planChanges, and updates the base of files whose content turns out to match.
+//
+// A file without a remote version in its base is compared with the remote file whenever their times differ. A file
+// whose time is within ModifiedTolerance of its base is compared with the base's version, and counts as unchanged if
+// that has no hash. Times further apart are changes, as before.
+func (s *State) settleModified() {
+	tolerance := s.ModifiedTolerance.Milliseconds()
+	var checks []contentCheck
+	s.mu.Lock()
+	for path, localFile := range s.LocalFiles {
+		remoteFile, inRemote := s.RemoteEntries[path]
+		if !inRemote || localFile.IsFolder || remoteFile.IsFolder || localFile.Evicted || localFile.Path == "" {
+			continue
+		}
+		localFile := localFile
+		baseEntry, inBase := s.Synced[localFile.Path]
+
+		switch {
+		case !inBase || baseEntry.Uid == 0:
+			if localFile.Modified == remoteFile.Modified {
+				continue
+			}
+			checks = append(checks, contentCheck{path: localFile.Path, hash: remoteFile.EncryptedHash, apply: func(same bool, err error) {
+				if os.IsNotExist(err) {
+					return
+				}
+				if err != nil {
+					api.Log().Debug("⚠️ Could not compare content", "path", localFile.Path, "err", err)
+					same = withinTolerance(localFile.Modified, remoteFile.Modified, tolerance)
+				}
+				if same {
+					api.Log().Debug("🕰️ Same content despite modification times", "path", localFile.Path, "local", localFile.Modified, "remote", remoteFile.Modified)
+					baseEntry := syncedFromRemote(remoteFile)
+					baseEntry.Modified = localFile.Modified
+					s.Synced[localFile.Path] = baseEntry
+				}
+			}})
+		case localFile.Modified != baseEntry.Modified && withinTolerance(localFile.Modified, baseEntry.Modified, tolerance):
+			if baseEntry.Hash == "" {
+				baseEntry.Modified = localFile.Modified
+				s.Synced[localFile.Path] = baseEntry
+				continue
+			}
+			checks = append(checks, contentCheck{path: localFile.Path, hash: baseEntry.Hash, apply: func(same bool, err error) {
+				if os.IsNotExist(err) {
+					return
+				}
+				if err != nil {
+					api.Log().Debug("⚠️ Could not compare content", "path", localFile.Path, "err", err)
+					same = true
+				}
+				if same {
+					baseEntry.Modified = localFile.Modified
+					s.Synced[localFile.Path] = baseEntry
+				}
+			}})
+		}
+	}
+	s.mu.Unlock()
+	s.runContentChecks(checks)
+}
+
+// contentCheck compares a local file with the content of an encrypted hash. Reading the file can take a while, so
+// checks are collected under s.mu, run without it, and applied with it again, see runContentChecks.
+type contentCheck struct {
+	path  string                     // Decrypted path of the local file
+	hash  string                     // Encrypted hash to compare with
+	apply func(same bool, err error) // Called with the result of sameContent, s.mu held
+}
+
+// runContentChecks runs the checks and applies their results. s.mu must not be held.
+func (s *State) runContentChecks(checks []contentCheck) {
+	if len(checks) == 0 {
+		return
+	}
+	same := make([]bool, len(checks))
+	errs := make([]error, len(checks))
+	for i, check := range checks {
+		same[i], errs[i] = s.sameContent(check.path, check.hash)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, check := range checks {
+		check.apply(same[i], errs[i])
+	}
+}
+
+// withinTolerance returns true if two modification times in milliseconds are at most tolerance apart
+func withinTolerance(a int64, b int64, tolerance int64) bool {
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= tolerance
+}
+
+// sameContent returns true if the local file at the decrypted path has the content an encrypted hash is of. s.mu must
+// not be held.
+func (s *State) sameContent(path string, encryptedHash string) (bool, error) {
+	s.mu.Lock()
+	cipher := s.cipher
+	s.mu.Unlock()
+	if cipher == nil || encryptedHash == "" {
+		return false, fmt.Errorf("no hash to compare with")
+	}
+	localHash, err := s.localHash(path)
+	if err != nil {
+		return false, err
+	}
+	hash, err := cipher.DecryptString(encryptedHash)
+	if err != nil {
+		return false, fmt.Errorf("error decrypting hash: %s", err)
+	}
+	return localHash == hash, nil
+}
+
+// checkClockSkew warns if the local clock is far off the server's. Modification times are compared with those of
+// other devices, so a wrong clock can make a sync pick the wrong side of a change.
+func checkClockSkew() {
+	skew, err := api.ClockSkew()
+	if err != nil {
+		api.Log().Debug("⚠️ Could not check the clock", "err", err)
+		return
+	}
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		api.Log().Warn("⚠️ The clock is off from the server's, changes may be resolved the wrong way. Check the system time",
+			"skew", skew.Round(time.Second))
+	}
+}
@@ -0,0 +1,140 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// ErrUnsafePath is returned for a decrypted remote path that would resolve outside of the vault folder
+var ErrUnsafePath = errors.New("path escapes the vault folder")
+
+// checkRemotePath makes sure a decrypted remote path stays inside the vault folder wherever it's used. Paths come from
+// the server and other devices, so a corrupted or malicious entry could otherwise write or delete anywhere.
+func checkRemotePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+	if strings.ContainsRune(path, 0) {
+		return fmt.Errorf("%w: %q", ErrUnsafePath, path)
+	}
+	if strings.HasPrefix(path, "/") || strings.HasPrefix(path, "\\") || filepath.IsAbs(path) || filepath.VolumeName(filepath.FromSlash(path)) != "" {
+		return fmt.Errorf("%w: %q is absolute", ErrUnsafePath, path)
+	}
+	// Backslashes separate folders on Windows
+	for _, name := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if name == ".." {
+			return fmt.Errorf("%w: %q", ErrUnsafePath, path)
+		}
+	}
+	return nil
+}
+
+// localPath returns where a decrypted vault path is in the folder, making sure it's inside it
+func (s *State) localPath(path string) (string, error) {
+	if err := checkRemotePath(path); err != nil {
+		return "", err
+	}
+	fullPath := filepath.Join(s.TargetPath, filepath.FromSlash(path))
+	rel, err := filepath.Rel(s.TargetPath, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, path)
+	}
+	return fullPath, nil
+}
+
+// verifySamples is how many encrypted paths of an index are decrypted to check the vault key before using the index
+const verifySamples = 3
+
+// sampleEncryptedPaths returns up to n encrypted paths of the entries
+func sampleEncryptedPaths(entries map[string]ObsidianRemoteEntry, n int) []string {
+	var samples []string
+	for path := range entries {
+		if len(samples) == n {
+			break
+		}
+		samples = append(samples, path)
+	}
+	return samples
+}
+
+// setCipher sets the cipher used to decrypt remote paths and rebuilds the path index with it. Entries with unsafe paths
+// are dropped, see checkRemotePath.
+func (s *State) setCipher(cipher crypto.VaultCipher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cipher = cipher
+	s.paths = make(map[string]string, len(s.RemoteEntries))
+	for path, remoteEntry := range s.RemoteEntries {
+		remoteEntry.Path = ""
+		s.RemoteEntries[path] = remoteEntry
+		if _, err := s.remotePath(path); errors.Is(err, ErrUnsafePath) {
+			api.Log().Warn("⚠️ Ignoring remote file with an unsafe path", "err", err)
+			delete(s.RemoteEntries, path)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remotePath returns the decrypted path of a remote entry, decrypting it only the first time. s.mu must be held.
+func (s *State) remotePath(encryptedPath string) (string, error) {
+	remoteEntry, ok := s.RemoteEntries[encryptedPath]
+	if ok && remoteEntry.Path != "" {
+		return remoteEntry.Path, nil
+	}
+	if s.cipher == nil {
+		return "", fmt.Errorf("no cipher to decrypt paths with")
+	}
+	path, err := s.cipher.DecryptString(encryptedPath)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting path: %s", err)
+	}
+	if err := checkRemotePath(path); err != nil {
+		return "", err
+	}
+	if ok {
+		remoteEntry.Path = path
+		s.RemoteEntries[encryptedPath] = remoteEntry
+		s.indexPath(path, encryptedPath)
+	}
+	return path, nil
+}
+
+// EncryptedPath returns the key of the remote entry for a decrypted path
+func (s *State) EncryptedPath(path string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	encryptedPath, ok := s.paths[path]
+	return encryptedPath, ok
+}
+
+// indexPath records the encrypted path a decrypted path is stored under
+func (s *State) indexPath(path string, encryptedPath string) {
+	if s.paths == nil {
+		s.paths = make(map[string]string)
+	}
+	s.paths[path] = encryptedPath
+}
+
+// unindexPath removes a decrypted path from the index, if it still points at encryptedPath
+func (s *State) unindexPath(path string, encryptedPath string) {
+	if s.paths[path] == encryptedPath {
+		delete(s.paths, path)
+	}
+}
+
+// rekeyEntry moves the state of a file to a new encrypted path. Paths are encrypted with a random nonce, so another
+// device pushing a file we already know can use a different encrypted path for it.
+func (s *State) rekeyEntry(oldPath string, newPath string) {
+	delete(s.RemoteEntries, oldPath)
+	if localFile, ok := s.LocalFiles[oldPath]; ok {
+		delete(s.LocalFiles, oldPath)
+		s.LocalFiles[newPath] = localFile
+	}
+}
@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string // relative to the vault folder, empty when the path is rejected
+	}{
+		{"plain", "notes/a.md", "notes/a.md"},
+		{"current folder", "./notes/./a.md", "notes/a.md"},
+		{"doubled slashes", "notes//a.md", "notes/a.md"},
+		{"trailing slash", "notes/", "notes"},
+		{"backslash separator", "notes\\a.md", "notes\\a.md"},
+		{"dots in a name", "notes/...md", "notes/...md"},
+		{"dotdot prefix in a name", "..notes/a.md", "..notes/a.md"},
+		{"empty", "", ""},
+		{"parent", "..", ""},
+		{"escapes", "../a.md", ""},
+		{"escapes from a folder", "notes/../../a.md", ""},
+		{"parent inside the vault", "notes/../a.md", ""},
+		{"trailing parent", "notes/..", ""},
+		{"absolute", "/etc/passwd", ""},
+		{"backslash absolute", "\\etc\\passwd", ""},
+		{"UNC", "\\\\server\\share\\a.md", ""},
+		{"backslash escapes", "..\\a.md", ""},
+		{"mixed separators escape", "notes\\..\\../a.md", ""},
+		{"NUL", "a\x00.md", ""},
+	}
+	dir := t.TempDir()
+	s := &State{TargetPath: dir}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.localPath(tt.path)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Errorf("localPath(%q) = %q, %v, want ErrUnsafePath", tt.path, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("localPath(%q) error = %v", tt.path, err)
+			}
+			if want := filepath.Join(dir, filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("localPath(%q) = %q, want %q", tt.path, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// SyncSummary is what a running daemon knew about its vault folder after its last sync pass
+type SyncSummary struct {
+	VaultId   string    `json:"vaultId"`
+	LastSync  time.Time `json:"lastSync"`
+	RemoteUid int64     `json:"remoteUid"` // Latest remote change applied
+	Files     int       `json:"files"`     // Files in the folder, not counting folders
+	Size      int64     `json:"size"`      // Bytes the vault uses
+	Limit     int64     `json:"limit"`     // Size limit of the vault
+}
+
+var (
+	pauseMu sync.Mutex
+	paused  bool
+	resumed = make(chan struct{}) // Closed when the daemon is resumed, then replaced
+
+	summaryMu sync.Mutex
+	summary   *SyncSummary
+)
+
+// pauseDaemon stops the daemon running in this process applying changes until resumeDaemon. It keeps receiving
+// them, so it catches up when resumed.
+func pauseDaemon() {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if !paused {
+		api.Log().Info("⏸️ Paused")
+	}
+	paused = true
+}
+
+// resumeDaemon lets the daemon apply changes again, waking it if it's waiting for one
+func resumeDaemon() {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if paused {
+		paused = false
+		close(resumed)
+		resumed = make(chan struct{})
+	}
+}
+
+// daemonPaused returns true if the daemon was paused
+func daemonPaused() bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	return paused
+}
+
+// currentSummary returns the summary published by the daemon running in this process, or nil before its first pass
+func currentSummary() *SyncSummary {
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	if summary == nil {
+		return nil
+	}
+	copied := *summary
+	return &copied
+}
+
+// publishSummary publishes the state for currentSummary
+func (s *State) publishSummary() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	published := &SyncSummary{VaultId: s.VaultId, RemoteUid: s.RemoteUid, Size: s.Size, Limit: s.Limit}
+	if s.LastSync > 0 {
+		published.LastSync = time.UnixMilli(s.LastSync)
+	}
+	for _, localFile := range s.LocalFiles {
+		if !localFile.IsFolder {
+			published.Files++
+		}
+	}
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	summary = published
+}
+
+// syncUnlessPaused syncs the files unless the daemon is paused, in which case the changes received so far are applied
+// once it's resumed
+func (s *State) syncUnlessPaused(ws *api.ObsidianSocketContext) error {
+	if daemonPaused() {
+		api.Log().Debug("⏸️ Paused, not applying changes")
+		return nil
+	}
+	if err := s.SyncFiles(ws); err != nil {
+		return err
+	}
+	s.publishSummary()
+	return nil
+}
+
+// daemonWait returns the context a daemon waits for push messages with. It's done when the daemon is asked to stop,
+// when the next sweep is due unless paused, and when it's resumed.
+func (s *State) daemonWait(nextSweep time.Time) (context.Context, context.CancelFunc) {
+	pauseMu.Lock()
+	wake, isPaused := resumed, paused
+	pauseMu.Unlock()
+
+	if !isPaused && s.SweepInterval > 0 {
+		return context.WithDeadline(s.stopping(), nextSweep)
+	}
+	wait, cancel := context.WithCancel(s.stopping())
+	if isPaused {
+		go func() {
+			select {
+			case <-wake:
+				cancel()
+			case <-wait.Done():
+			}
+		}()
+	}
+	return wait, cancel
+}
@@ -0,0 +1,179 @@
+package sync
+
+import "sort"
+
+// SyncedEntry is the version of a file both sides agreed on when it last synced. It's the base the planner compares
+// the current local and remote versions against, so it can tell which side changed instead of guessing from
+// timestamps.
+type SyncedEntry struct {
+	Uid      int64  // Remote version, zero if unknown
+	Hash     string // Encrypted hash of the remote version
+	Modified int64  // Local modification time
+	IsFolder bool
+}
+
+// Plan is what a sync pass does. Paths are encrypted, sorted so the same inputs always give the same plan.
+type Plan struct {
+	Deletes    []string // Local entries to remove, before anything else
+	NewFolders []string
+	Pulls      []string
+	Pushes     []string
+	Conflicts  []string // Changed on both sides
+	Refreshes  []string // Evicted placeholders whose remote version changed, tracked without pulling
+	Rebases    []string // Unchanged on both sides, but the base's remote version is stale
+}
+
+// syncedFromRemote returns the base for a remote entry that's now in sync
+func syncedFromRemote(remoteFile ObsidianRemoteEntry) SyncedEntry {
+	return SyncedEntry{
+		Uid:      remoteFile.Uid,
+		Hash:     remoteFile.EncryptedHash,
+		Modified: remoteFile.Modified,
+		IsFolder: remoteFile.IsFolder,
+	}
+}
+
+// remoteChanged returns true if the remote entry isn't the version in the base. A new UID with the same content
+// doesn't count.
+func remoteChanged(base SyncedEntry, remoteFile ObsidianRemoteEntry) bool {
+	if base.IsFolder != remoteFile.IsFolder {
+		return true
+	}
+	if base.IsFolder {
+		return false
+	}
+	if base.Uid == remoteFile.Uid {
+		return false
+	}
+	return base.Hash == "" || base.Hash != remoteFile.EncryptedHash
+}
+
+// localChanged returns true if the local entry was modified since the base. Local files have no hash in the state, so
+// their modification time on disk, which scanLocal keeps up to date, stands in for one.
+func localChanged(base SyncedEntry, localFile ObsidianLocalEntry) bool {
+	if base.IsFolder != localFile.IsFolder {
+		return true
+	}
+	return !localFile.IsFolder && localFile.Modified != base.Modified
+}
+
+// planChanges compares each path's last synced version with its current local and remote versions. Local and remote
+// are keyed by encrypted path, base by decrypted path, which doesn't change when the vault key does. The local versions
+// are the state's local entries as State.scanLocal found them on disk.
+//
+// A side changed if it differs from the base, and a path without a base changed on every side it exists on. Changes on
+// one side are applied to the other, changes on both are conflicts. The planner never deletes remotely: files deleted
+// from disk are pushed as deletions before planning, by State.pushLocalChanges, and a file the state still has but
+// that's unchanged remotely is pulled again.
+func planChanges(base map[string]SyncedEntry, local map[string]ObsidianLocalEntry, remote map[string]ObsidianRemoteEntry) Plan {
+	var plan Plan
+
+	for path, remoteFile := range remote {
+		localFile, inLocal := local[path]
+		baseEntry, inBase := base[remoteFile.Path]
+		if remoteFile.Path == "" {
+			inBase = false
+		}
+
+		if !inLocal {
+			if remoteFile.IsFolder {
+				plan.NewFolders = append(plan.NewFolders, path)
+			} else {
+				plan.Pulls = append(plan.Pulls, path)
+			}
+			continue
+		}
+
+		// Folders have no content to compare
+		if localFile.IsFolder && remoteFile.IsFolder {
+			if !inBase {
+				plan.Rebases = append(plan.Rebases, path)
+			}
+			continue
+		}
+
+		// A path that changed type takes the vault's type
+		if localFile.IsFolder != remoteFile.IsFolder {
+			plan.Deletes = append(plan.Deletes, path)
+			if remoteFile.IsFolder {
+				plan.NewFolders = append(plan.NewFolders, path)
+			} else {
+				plan.Pulls = append(plan.Pulls, path)
+			}
+			continue
+		}
+
+		remoteDiffers := !inBase || remoteChanged(baseEntry, remoteFile)
+		localDiffers := !inBase || localChanged(baseEntry, localFile)
+		if !inBase && localFile.Modified == remoteFile.Modified {
+			// Both sides have the same version, the base was just never recorded
+			remoteDiffers, localDiffers = false, false
+		}
+
+		switch {
+		case localFile.Evicted:
+			// Placeholders have no local content to change, only track the newer version
+			if remoteDiffers {
+				plan.Refreshes = append(plan.Refreshes, path)
+			}
+		case remoteDiffers && localDiffers:
+			plan.Conflicts = append(plan.Conflicts, path)
+		case remoteDiffers:
+			plan.Pulls = append(plan.Pulls, path)
+		case localDiffers:
+			plan.Pushes = append(plan.Pushes, path)
+		case !inBase || baseEntry.Uid != remoteFile.Uid:
+			plan.Rebases = append(plan.Rebases, path)
+		}
+	}
+
+	for path, localFile := range local {
+		if _, inRemote := remote[path]; inRemote {
+			continue // Handled above
+		}
+		baseEntry, inBase := base[localFile.Path]
+		switch {
+		case localFile.Evicted || (inBase && !localChanged(baseEntry, localFile)):
+			// Deleted remotely, and there's no local change to lose
+			plan.Deletes = append(plan.Deletes, path)
+		case !localFile.IsFolder:
+			// New locally, or changed since the remote copy was deleted
+			plan.Pushes = append(plan.Pushes, path)
+		}
+	}
+
+	for _, paths := range [][]string{plan.Deletes, plan.NewFolders, plan.Pulls, plan.Pushes, plan.Conflicts, plan.Refreshes, plan.Rebases} {
+		sort.Strings(paths)
+	}
+	return plan
+}
+
+// seedSynced builds a base for a state saved before bases were recorded. Every local file is assumed to be the
+// version that last synced, which is what the timestamps it was planned with used to assume.
+func seedSynced(local map[string]ObsidianLocalEntry, remote map[string]ObsidianRemoteEntry) map[string]SyncedEntry {
+	base := make(map[string]SyncedEntry, len(local))
+	for path, localFile := range local {
+		if localFile.Path == "" {
+			continue
+		}
+		baseEntry := SyncedEntry{Modified: localFile.Modified, IsFolder: localFile.IsFolder}
+		if remoteFile, ok := remote[path]; ok && remoteFile.Modified == localFile.Modified {
+			baseEntry.Uid = remoteFile.Uid
+			baseEntry.Hash = remoteFile.EncryptedHash
+		}
+		base[localFile.Path] = baseEntry
+	}
+	return base
+}
+
+// recordSynced records the remote entry at the encrypted path as the version both sides now have
+func (s *State) recordSynced(path string) {
+	remoteFile, ok := s.RemoteEntries[path]
+	if !ok || remoteFile.Path == "" {
+		return
+	}
+	if s.Synced == nil {
+		s.Synced = make(map[string]SyncedEntry)
+	}
+	s.Synced[remoteFile.Path] = syncedFromRemote(remoteFile)
+}
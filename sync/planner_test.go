@@ -0,0 +1,165 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRemoteChanged(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   SyncedEntry
+		remote ObsidianRemoteEntry
+		want   bool
+	}{
+		{"same version", SyncedEntry{Uid: 1, Hash: "a"}, ObsidianRemoteEntry{Uid: 1, EncryptedHash: "a"}, false},
+		{"new version", SyncedEntry{Uid: 1, Hash: "a"}, ObsidianRemoteEntry{Uid: 2, EncryptedHash: "b"}, true},
+		{"new uid with the same content", SyncedEntry{Uid: 1, Hash: "a"}, ObsidianRemoteEntry{Uid: 2, EncryptedHash: "a"}, false},
+		{"new uid without a base hash", SyncedEntry{Uid: 1}, ObsidianRemoteEntry{Uid: 2, EncryptedHash: "a"}, true},
+		{"folder", SyncedEntry{Uid: 1, IsFolder: true}, ObsidianRemoteEntry{Uid: 2, IsFolder: true}, false},
+		{"file became a folder", SyncedEntry{Uid: 1, Hash: "a"}, ObsidianRemoteEntry{Uid: 1, IsFolder: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remoteChanged(tt.base, tt.remote); got != tt.want {
+				t.Errorf("remoteChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalChanged(t *testing.T) {
+	tests := []struct {
+		name  string
+		base  SyncedEntry
+		local ObsidianLocalEntry
+		want  bool
+	}{
+		{"same time", SyncedEntry{Modified: 10}, ObsidianLocalEntry{Path: "a.md", Modified: 10}, false},
+		{"modified", SyncedEntry{Modified: 10}, ObsidianLocalEntry{Path: "a.md", Modified: 20}, true},
+		{"folder", SyncedEntry{Modified: 10, IsFolder: true}, ObsidianLocalEntry{Path: "a", Modified: 20, IsFolder: true}, false},
+		{"file became a folder", SyncedEntry{Modified: 10}, ObsidianLocalEntry{Path: "a", Modified: 10, IsFolder: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := localChanged(tt.base, tt.local); got != tt.want {
+				t.Errorf("localChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanChanges(t *testing.T) {
+	file := func(path string, uid int64, hash string, modified int64) ObsidianRemoteEntry {
+		return ObsidianRemoteEntry{EncryptedPath: "e" + path, Path: path, Uid: uid, EncryptedHash: hash, Modified: modified}
+	}
+	folder := func(path string, uid int64) ObsidianRemoteEntry {
+		return ObsidianRemoteEntry{EncryptedPath: "e" + path, Path: path, Uid: uid, IsFolder: true}
+	}
+
+	tests := []struct {
+		name   string
+		base   map[string]SyncedEntry
+		local  map[string]ObsidianLocalEntry
+		remote map[string]ObsidianRemoteEntry
+		want   Plan
+	}{
+		{
+			name:   "new remote file",
+			remote: map[string]ObsidianRemoteEntry{"ea.md": file("a.md", 1, "h1", 10)},
+			want:   Plan{Pulls: []string{"ea.md"}},
+		},
+		{
+			name:   "new remote folder",
+			remote: map[string]ObsidianRemoteEntry{"ea": folder("a", 1)},
+			want:   Plan{NewFolders: []string{"ea"}},
+		},
+		{
+			name:  "new local file",
+			local: map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 10}},
+			want:  Plan{Pushes: []string{"ea.md"}},
+		},
+		{
+			name:   "unchanged",
+			base:   map[string]SyncedEntry{"a.md": {Uid: 1, Hash: "h1", Modified: 10}},
+			local:  map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 10}},
+			remote: map[string]ObsidianRemoteEntry{"ea.md": file("a.md", 1, "h1", 10)},
+		},
+		{
+			name:   "changed remotely",
+			base:   map[string]SyncedEntry{"a.md": {Uid: 1, Hash: "h1", Modified: 10}},
+			local:  map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 10}},
+			remote: map[string]ObsidianRemoteEntry{"ea.md": file("a.md", 2, "h2", 20)},
+			want:   Plan{Pulls: []string{"ea.md"}},
+		},
+		{
+			name:   "changed locally",
+			base:   map[string]SyncedEntry{"a.md": {Uid: 1, Hash: "h1", Modified: 10}},
+			local:  map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 30}},
+			remote: map[string]ObsidianRemoteEntry{"ea.md": file("a.md", 1, "h1", 10)},
+			want:   Plan{Pushes: []string{"ea.md"}},
+		},
+		{
+			name:   "changed on both sides",
+			base:   map[string]SyncedEntry{"a.md": {Uid: 1, Hash: "h1", Modified: 10}},
+			local:  map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 30}},
+			remote: map[string]ObsidianRemoteEntry{"ea.md": file("a.md", 2, "h2", 20)},
+			want:   Plan{Conflicts: []string{"ea.md"}},
+		},
+		{
+			name:  "deleted remotely",
+			base:  map[string]SyncedEntry{"a.md": {Uid: 1, Hash: "h1", Modified: 10}},
+			local: map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 10}},
+			want:  Plan{Deletes: []string{"ea.md"}},
+		},
+		{
+			name:  "deleted remotely and changed locally",
+			base:  map[string]SyncedEntry{"a.md": {Uid: 1, Hash: "h1", Modified: 10}},
+			local: map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 30}},
+			want:  Plan{Pushes: []string{"ea.md"}},
+		},
+		{
+			name:   "same version without a base",
+			local:  map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 10}},
+			remote: map[string]ObsidianRemoteEntry{"ea.md": file("a.md", 1, "h1", 10)},
+			want:   Plan{Rebases: []string{"ea.md"}},
+		},
+		{
+			name:   "new uid with the same content",
+			base:   map[string]SyncedEntry{"a.md": {Uid: 1, Hash: "h1", Modified: 10}},
+			local:  map[string]ObsidianLocalEntry{"ea.md": {Path: "a.md", Modified: 10}},
+			remote: map[string]ObsidianRemoteEntry{"ea.md": file("a.md", 2, "h1", 10)},
+			want:   Plan{Rebases: []string{"ea.md"}},
+		},
+		{
+			name:   "evicted and changed remotely",
+			base:   map[string]SyncedEntry{"a.png": {Uid: 1, Hash: "h1", Modified: 10}},
+			local:  map[string]ObsidianLocalEntry{"ea.png": {Path: "a.png", Modified: 10, Evicted: true}},
+			remote: map[string]ObsidianRemoteEntry{"ea.png": file("a.png", 2, "h2", 20)},
+			want:   Plan{Refreshes: []string{"ea.png"}},
+		},
+		{
+			name:   "local folder became a remote file",
+			base:   map[string]SyncedEntry{"a": {Uid: 1, IsFolder: true}},
+			local:  map[string]ObsidianLocalEntry{"ea": {Path: "a", IsFolder: true}},
+			remote: map[string]ObsidianRemoteEntry{"ea": file("a", 2, "h2", 20)},
+			want:   Plan{Deletes: []string{"ea"}, Pulls: []string{"ea"}},
+		},
+		{
+			name: "sorted",
+			remote: map[string]ObsidianRemoteEntry{
+				"ec.md": file("c.md", 3, "h3", 10),
+				"ea.md": file("a.md", 1, "h1", 10),
+				"eb.md": file("b.md", 2, "h2", 10),
+			},
+			want: Plan{Pulls: []string{"ea.md", "eb.md", "ec.md"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planChanges(tt.base, tt.local, tt.remote); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planChanges() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
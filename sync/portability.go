@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PortabilityIssue is a vault path that will break on some platform another device may sync with
+type PortabilityIssue struct {
+	Path    string
+	Problem string
+}
+
+// windowsInvalidChars can't appear in file names on Windows
+const windowsInvalidChars = `<>:"\|?*`
+
+// windowsReservedNames can't be used as file names on Windows, with or without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// maxNameLength is the longest file name, in bytes, most filesystems accept
+const maxNameLength = 255
+
+// CheckPortability scans the vault folder at targetPath for names that break on Windows, macOS or Linux
+func CheckPortability(targetPath string) ([]PortabilityIssue, error) {
+	var paths []string
+	err := filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == targetPath {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".trash") {
+			return filepath.SkipDir
+		}
+		relPath, err := filepath.Rel(targetPath, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return CheckPathPortability(paths), nil
+}
+
+// CheckPathPortability checks a list of slash-separated vault paths for portability problems
+func CheckPathPortability(paths []string) []PortabilityIssue {
+	var issues []PortabilityIssue
+	for _, path := range paths {
+		name := path[strings.LastIndex(path, "/")+1:]
+		if problem := namePortabilityProblem(name); problem != "" {
+			issues = append(issues, PortabilityIssue{Path: path, Problem: problem})
+		}
+	}
+
+	// Paths that only differ by case clobber each other on case-insensitive filesystems
+	for _, collision := range caseCollisions(paths) {
+		issues = append(issues, PortabilityIssue{
+			Path:    collision[0],
+			Problem: fmt.Sprintf("differs only by case from %s", strings.Join(collision[1:], ", ")),
+		})
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Path < issues[j].Path
+	})
+	return issues
+}
+
+// namePortabilityProblem describes why a single file or folder name isn't portable, or returns an empty string
+func namePortabilityProblem(name string) string {
+	if i := strings.IndexAny(name, windowsInvalidChars); i >= 0 {
+		return fmt.Sprintf("contains %q, which is invalid on Windows", name[i])
+	}
+	for _, r := range name {
+		if r < 0x20 {
+			return "contains a control character, which is invalid on Windows"
+		}
+	}
+	if strings.HasSuffix(name, " ") || strings.HasSuffix(name, ".") {
+		return "ends with a space or dot, which Windows strips"
+	}
+	if strings.HasPrefix(name, " ") {
+		return "starts with a space, which some apps strip"
+	}
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	if windowsReservedNames[base] {
+		return fmt.Sprintf("uses the reserved Windows name %s", base)
+	}
+	if len(name) > maxNameLength {
+		return fmt.Sprintf("is %d bytes long, more than the %d most filesystems allow", len(name), maxNameLength)
+	}
+	return ""
+}
+
+// caseCollisions groups paths that are equal when compared case-insensitively. Each group is sorted.
+func caseCollisions(paths []string) [][]string {
+	groups := make(map[string][]string)
+	for _, path := range paths {
+		key := strings.ToLower(path)
+		groups[key] = append(groups[key], path)
+	}
+
+	var collisions [][]string
+	for _, group := range groups {
+		if len(group) > 1 {
+			sort.Strings(group)
+			collisions = append(collisions, group)
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i][0] < collisions[j][0]
+	})
+	return collisions
+}
@@ -0,0 +1,29 @@
+package sync
+
+import (
+	"sort"
+)
+
+// sortByPriority orders paths so that files matching earlier priority patterns are applied first. Files matching no
+// pattern come after all prioritized files, and within the same priority notes come before attachments.
+// decryptedPaths maps each path to its decrypted form, which is what patterns match against.
+func sortByPriority(paths []string, decryptedPaths map[string]string, priorities globList) {
+	rank := func(path string) int {
+		if i := priorities.firstMatch(decryptedPaths[path]); i >= 0 {
+			return i
+		}
+		return len(priorities)
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		rankI, rankJ := rank(paths[i]), rank(paths[j])
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		noteI, noteJ := isNote(decryptedPaths[paths[i]]), isNote(decryptedPaths[paths[j]])
+		if noteI != noteJ {
+			return noteI
+		}
+		return decryptedPaths[paths[i]] < decryptedPaths[paths[j]]
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package sync
+
+import "os"
+
+// processAlive returns true if a process with the PID is running. Finding a process fails if there is none.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = process.Release()
+	return true
+}
@@ -0,0 +1,12 @@
+//go:build unix
+
+package sync
+
+import "syscall"
+
+// processAlive returns true if a process with the PID is running
+func processAlive(pid int) bool {
+	// Signal 0 only checks the process exists, EPERM means it's someone else's
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,84 @@
+package sync
+
+import "sync/atomic"
+
+// Phase is a stage of a sync pass
+type Phase string
+
+const (
+	PhaseScan   Phase = "scan"
+	PhaseDelete Phase = "delete"
+	PhaseFolder Phase = "folder"
+	PhasePull   Phase = "pull"
+	PhasePush   Phase = "push"
+)
+
+// ProgressKind identifies what a ProgressEvent reports
+type ProgressKind int
+
+const (
+	PhaseStarted  ProgressKind = iota // A phase started, FileCount is the number of files in it and PhaseBytes their size
+	FileStarted                       // A file in the current phase started
+	FileBytes                         // Bytes of the current file were transferred
+	FileFinished                      // A file in the current phase finished, Err is set if it failed
+	PhaseFinished                     // A phase finished
+)
+
+// ProgressEvent describes a step of a sync pass
+type ProgressEvent struct {
+	Kind       ProgressKind
+	Phase      Phase
+	Path       string // Decrypted path of the current file, if any
+	FileIndex  int    // Zero-based index of the current file within the phase
+	FileCount  int    // Number of files in the phase
+	Bytes      int64  // Bytes transferred so far for the current file
+	TotalBytes int64  // Total bytes to transfer for the current file, if known
+	PhaseBytes int64  // Total bytes to transfer in the phase, for pulls and pushes. Like Bytes, counts encrypted content.
+	Err        error
+}
+
+// Progress receives events as a sync pass runs, so callers can drive their own UI
+type Progress interface {
+	OnProgress(event ProgressEvent)
+}
+
+// ProgressFunc adapts a function to the Progress interface
+type ProgressFunc func(event ProgressEvent)
+
+func (f ProgressFunc) OnProgress(event ProgressEvent) {
+	f(event)
+}
+
+// report sends an event to the state's progress receiver, if any
+func (s *State) report(event ProgressEvent) {
+	if s.Progress != nil {
+		s.progressMu.Lock()
+		defer s.progressMu.Unlock()
+		s.Progress.OnProgress(event)
+	}
+}
+
+// reportPhase reports the start of a phase of files totalling byteCount bytes, and returns a function that reports its
+// end
+func (s *State) reportPhase(phase Phase, fileCount int, byteCount int64) func() {
+	s.report(ProgressEvent{Kind: PhaseStarted, Phase: phase, FileCount: fileCount, PhaseBytes: byteCount})
+	return func() {
+		s.report(ProgressEvent{Kind: PhaseFinished, Phase: phase, FileCount: fileCount, PhaseBytes: byteCount})
+	}
+}
+
+// reportPhaseCountdown reports the start of a phase and returns a function to call as each of its files is done. The
+// end of the phase is reported once all of them are, which with parallel tasks can be after later phases started.
+func (s *State) reportPhaseCountdown(phase Phase, fileCount int, byteCount int64) func() {
+	end := s.reportPhase(phase, fileCount, byteCount)
+	if fileCount == 0 {
+		end()
+		return func() {}
+	}
+	remaining := int32(fileCount)
+	return func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			end()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package sync
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// QuarantinedFile is a file whose transfer was skipped. It isn't pulled or pushed again until it's released.
+type QuarantinedFile struct {
+	Phase Phase     // PhasePull or PhasePush
+	Uid   int64     // Remote version being pulled, if any
+	At    time.Time // When the transfer was skipped
+}
+
+// errTransferSkipped is returned by useSocket when the transfer was skipped with SkipTransfer
+var errTransferSkipped = errors.New("transfer skipped")
+
+// SkipTransfer stops the file transfer in progress and quarantines the file, without ending the sync. Returns the
+// decrypted path of the file, or false if nothing is being transferred.
+func (s *State) SkipTransfer() (string, bool) {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	if s.transferSocket == nil || s.skipRequested {
+		return "", false
+	}
+	s.skipRequested = true
+	s.transferSocket.CancelTransfer()
+	return s.transferPath, true
+}
+
+// beginTransfer records the transfer using the connection, so SkipTransfer can find it
+func (s *State) beginTransfer(ws *api.ObsidianSocketContext, path string) {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	s.transferSocket = ws
+	s.transferPath = path
+	s.skipRequested = false
+}
+
+// endTransfer clears the transfer in progress, returning true if it was skipped
+func (s *State) endTransfer() bool {
+	s.transferMu.Lock()
+	defer s.transferMu.Unlock()
+	skipped := s.skipRequested
+	s.transferSocket = nil
+	s.transferPath = ""
+	s.skipRequested = false
+	return skipped
+}
+
+// quarantine records a skipped file so later syncs leave it alone
+func (s *State) quarantine(path string, file QuarantinedFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Quarantined == nil {
+		s.Quarantined = make(map[string]QuarantinedFile)
+	}
+	s.Quarantined[path] = file
+	api.Log().Warn("⏸️ Skipped, it won't sync until released with quarantine release", "path", path)
+}
+
+// ReleaseQuarantined lets a quarantined file sync again. Returns false if it wasn't quarantined.
+func (s *State) ReleaseQuarantined(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Quarantined[path]; !ok {
+		return false
+	}
+	delete(s.Quarantined, path)
+	return true
+}
+
+// QuarantinedPaths returns the decrypted paths of quarantined files, sorted
+func (s *State) QuarantinedPaths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.Quarantined))
+	for path := range s.Quarantined {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// withoutQuarantined removes the encrypted paths whose decrypted path is quarantined
+func (s *State) withoutQuarantined(paths []string, decrypted func(path string) string) []string {
+	if len(s.Quarantined) == 0 {
+		return paths
+	}
+	kept := paths[:0]
+	skipped := 0
+	for _, path := range paths {
+		if _, ok := s.Quarantined[decrypted(path)]; ok {
+			skipped++
+			continue
+		}
+		kept = append(kept, path)
+	}
+	if skipped > 0 {
+		api.Log().Info("⏸️ Leaving quarantined files alone", "count", skipped)
+	}
+	return kept
+}
+
+// forwardSkips skips the transfer in progress for each request, until requests is closed
+func forwardSkips(requests <-chan struct{}, s *State) {
+	for range requests {
+		if _, ok := s.SkipTransfer(); !ok {
+			api.Log().Info("Nothing is being transferred, nothing to skip")
+		}
+	}
+}
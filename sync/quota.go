@@ -0,0 +1,35 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// checkQuota returns an error if pushing content of the given size to path would exceed the vault's size limit.
+// path is the encrypted path of the remote entry being replaced, or empty for a new file. s.mu must be held.
+func (s *State) checkQuota(path string, size int64) error {
+	// Nothing to enforce if the server didn't tell us the limit
+	if s.Limit <= 0 {
+		return nil
+	}
+
+	projected := s.Size + s.quotaDelta(path, size)
+	if projected > s.Limit {
+		return fmt.Errorf("quota exceeded: push needs %d bytes but only %d of %d are free", crypto.EncryptedSize(size), s.Limit-s.Size, s.Limit)
+	}
+	return nil
+}
+
+// recordPush updates the known vault size after a successful push. s.mu must be held.
+func (s *State) recordPush(path string, size int64) {
+	s.Size += s.quotaDelta(path, size)
+}
+
+// quotaDelta returns how much the vault size changes when content of the given size replaces the entry at path
+func (s *State) quotaDelta(path string, size int64) int64 {
+	delta := crypto.EncryptedSize(size)
+	if remoteEntry, ok := s.RemoteEntries[path]; ok && path != "" {
+		delta -= remoteEntry.Size
+	}
+	return delta
+}
@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// RestoreResult describes the version Restore wrote
+type RestoreResult struct {
+	Dest    string // Where the file was written
+	Version api.FileVersion
+	Bytes   int64
+}
+
+// Restore downloads an older version of the file at remotePath from the server's history, without syncing or touching
+// any sync state. uid picks the version as listed by FileHistory, zero picks the newest version that isn't a deletion.
+// If deleted is set the file has to be deleted in the vault, and the version from before the deletion is restored.
+// The file is written like FetchPath writes a single file.
+func Restore(remotePath string, dest string, uid int64, deleted bool, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (RestoreResult, error) {
+	return RestoreContext(context.Background(), remotePath, dest, uid, deleted, authToken, vault, password, opts)
+}
+
+// RestoreContext is Restore, giving up when c is done
+func RestoreContext(c context.Context, remotePath string, dest string, uid int64, deleted bool, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (RestoreResult, error) {
+	var result RestoreResult
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+	remotePath, err := cleanRemotePath(remotePath)
+	if err != nil {
+		return result, err
+	}
+
+	ctx, err := connectForHistory(c, authToken, vault, password, opts)
+	if err != nil {
+		return result, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+
+	versions, err := ctx.HistoryContext(c, remotePath)
+	if err != nil {
+		return result, fmt.Errorf("error getting history: %s", err)
+	}
+	result.Version, err = pickVersion(versions, remotePath, uid, deleted)
+	if err != nil {
+		return result, err
+	}
+
+	result.Dest = fetchDest(dest, remotePath, vault)
+	if info, err := os.Stat(result.Dest); err == nil && info.IsDir() {
+		result.Dest = filepath.Join(result.Dest, path.Base(remotePath))
+	}
+	api.Log().Info("📄 Restoring", "path", remotePath, "uid", result.Version.Uid)
+	data, err := ctx.PullEncryptedContext(c, result.Version.Uid)
+	if err != nil {
+		return result, fmt.Errorf("error pulling version %d: %s", result.Version.Uid, err)
+	}
+	content, err := ctx.DecryptContent(data, result.Version.EncryptedHash)
+	if err != nil {
+		return result, fmt.Errorf("error decrypting version %d: %s", result.Version.Uid, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(result.Dest), 0755); err != nil {
+		return result, fmt.Errorf("error creating folder: %s", err)
+	}
+	if err := os.WriteFile(result.Dest, content, 0644); err != nil {
+		return result, fmt.Errorf("error writing %s: %s", result.Dest, err)
+	}
+	result.Bytes = int64(len(content))
+	return result, nil
+}
+
+// pickVersion picks the version to restore from a file's history, newest first
+func pickVersion(versions []api.FileVersion, remotePath string, uid int64, deleted bool) (api.FileVersion, error) {
+	if len(versions) == 0 {
+		return api.FileVersion{}, fmt.Errorf("no versions of %s found", remotePath)
+	}
+	if versions[0].Folder {
+		return api.FileVersion{}, fmt.Errorf("%s is a folder", remotePath)
+	}
+	if deleted && !versions[0].Deleted {
+		return api.FileVersion{}, fmt.Errorf("%s isn't deleted, restore a version instead", remotePath)
+	}
+
+	for _, version := range versions {
+		if uid != 0 && version.Uid != uid {
+			continue
+		}
+		if version.Deleted {
+			if uid != 0 {
+				return api.FileVersion{}, fmt.Errorf("version %d of %s is a deletion", uid, remotePath)
+			}
+			continue
+		}
+		return version, nil
+	}
+	if uid != 0 {
+		return api.FileVersion{}, fmt.Errorf("%s has no version %d, see history", remotePath, uid)
+	}
+	return api.FileVersion{}, fmt.Errorf("%s has no versions with content", remotePath)
+}
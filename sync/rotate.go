@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// maxPasswordAttempts bounds how often we ask for a new vault password before giving up
+const maxPasswordAttempts = 3
+
+// initWithRotation runs init, and if the server rejects our key because the vault password was changed, rotates to
+// the new password. Returns whether the key was rotated, in which case the result is a full index under the new key.
+func initWithRotation(c context.Context, ctx *api.ObsidianSocketContext, authToken *crypto.Secret, version int64, prompt PasswordPrompt) (*api.InitResult, bool, error) {
+	initResult, err := ctx.SendInitContext(c, version, version == 0)
+	if errors.Is(err, api.ErrKeyMismatch) {
+		initResult, err = rotateKey(c, ctx, authToken, prompt, err)
+		return initResult, err == nil, err
+	}
+	return initResult, false, err
+}
+
+// rotateKey asks for the new vault password, re-derives keys and re-runs init from scratch, since every encrypted
+// path changes with the key
+func rotateKey(c context.Context, ctx *api.ObsidianSocketContext, authToken *crypto.Secret, prompt PasswordPrompt, cause error) (*api.InitResult, error) {
+	for attempt := 0; attempt < maxPasswordAttempts; attempt++ {
+		if prompt == nil {
+			return nil, fmt.Errorf("%w, sync again with the new vault password", cause)
+		}
+		api.Log().Info("🔑 The vault password was changed")
+
+		// The salt can change along with the password, so get fresh vault info
+		vault, err := findVault(authToken, ctx.Vault.Id)
+		if err != nil {
+			return nil, err
+		}
+		password, err := prompt(vault)
+		if err != nil {
+			return nil, err
+		}
+		err = ctx.Rekey(vault, password)
+		password.Wipe()
+		if err != nil {
+			return nil, err
+		}
+
+		initResult, err := ctx.SendInitContext(c, 0, true)
+		if err == nil {
+			api.Log().Info("🔑 Vault key updated")
+			return initResult, nil
+		}
+		if !errors.Is(err, api.ErrKeyMismatch) {
+			return nil, err
+		}
+		cause = err
+	}
+	return nil, cause
+}
+
+// rotateKey rotates a running daemon to the new vault password, rebuilding the remote index under the new key and
+// re-verifying local files against it
+func (s *State) rotateKey(ctx *api.ObsidianSocketContext, cause error) error {
+	initResult, err := rotateKey(s.context(), ctx, s.authToken, s.promptPassword, cause)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.RemoteEntries = make(map[string]ObsidianRemoteEntry)
+	s.RemoteUid = initResult.RemoteUid
+	s.KeyHash = ctx.Cipher.KeyHash()
+	saved := s.LocalFiles
+	s.mu.Unlock()
+	if err := s.setCipher(ctx.Cipher); err != nil {
+		return err
+	}
+	s.applyInit(initResult)
+	if err := s.rekeyLocalFiles(ctx, saved); err != nil {
+		return err
+	}
+	return s.SyncFiles(ctx)
+}
+
+// findVault lists the account's vaults and returns the one with the given ID
+func findVault(authToken *crypto.Secret, vaultId string) (api.VaultInfo, error) {
+	vaults, err := api.ListVaults(authToken)
+	if err != nil {
+		return api.VaultInfo{}, fmt.Errorf("error listing vaults: %s", err)
+	}
+	for _, vault := range vaults {
+		if vault.Id == vaultId {
+			return vault, nil
+		}
+	}
+	return api.VaultInfo{}, fmt.Errorf("vault %s not found", vaultId)
+}
+
+// rekeyLocalFiles maps local entries saved under a previous vault key onto the current remote entries. State is
+// keyed by encrypted path, which changes with the key, so entries are matched by decrypted path instead. Each file is
+// verified against the remote hash, so only files that really differ are pulled or reported as conflicts.
+func (s *State) rekeyLocalFiles(ws *api.ObsidianSocketContext, saved map[string]ObsidianLocalEntry) error {
+	api.Log().Info("🔑 Vault key changed since the last sync, re-verifying local files")
+
+	// Match under the lock, and verify content without it
+	type match struct {
+		path       string
+		localFile  ObsidianLocalEntry
+		remoteFile ObsidianRemoteEntry
+	}
+	var matches []match
+	dropped := 0
+	s.mu.Lock()
+	for _, localFile := range saved {
+		path, ok := s.paths[localFile.Path]
+		if !ok {
+			// Without a remote entry we can't know the new encrypted path, leave the file alone
+			dropped++
+			continue
+		}
+		matches = append(matches, match{path: path, localFile: localFile, remoteFile: s.RemoteEntries[path]})
+	}
+	s.mu.Unlock()
+
+	verified, changed := 0, 0
+	rekeyed := make(map[string]ObsidianLocalEntry, len(matches))
+	synced := make(map[string]SyncedEntry)
+	for _, m := range matches {
+		localFile := m.localFile
+		if !localFile.IsFolder && !localFile.Evicted {
+			same, err := s.matchesRemote(ws, localFile.Path, m.remoteFile)
+			if err != nil {
+				return fmt.Errorf("error verifying %s: %s", localFile.Path, err)
+			}
+			if same {
+				localFile.Modified = m.remoteFile.Modified
+				synced[localFile.Path] = syncedFromRemote(m.remoteFile)
+				verified++
+			} else {
+				changed++
+			}
+		}
+		rekeyed[m.path] = localFile
+	}
+
+	s.mu.Lock()
+	s.LocalFiles = rekeyed
+	if s.Synced != nil {
+		for path, baseEntry := range synced {
+			s.Synced[path] = baseEntry
+		}
+	}
+	s.mu.Unlock()
+
+	api.Log().Info("🔑 Local files re-verified", "verified", verified, "differ", changed, "dropped", dropped)
+	return nil
+}
+
+// matchesRemote returns true if the file on disk has the content of the remote entry. s.mu must not be held.
+func (s *State) matchesRemote(ws *api.ObsidianSocketContext, path string, remoteFile ObsidianRemoteEntry) (bool, error) {
+	localHash, err := s.localHash(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	remoteHash, err := ws.Cipher.DecryptString(remoteFile.EncryptedHash)
+	if err != nil {
+		return false, fmt.Errorf("error decrypting hash: %s", err)
+	}
+	return localHash == remoteHash, nil
+}
@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// s3Timeout is how long an S3 request may take
+const s3Timeout = 5 * time.Minute
+
+// S3Mirror mirrors files into an S3 bucket, or a bucket of an S3-compatible service like MinIO, R2 or B2. Requests are
+// signed with AWS Signature Version 4 and use path-style URLs.
+type S3Mirror struct {
+	Endpoint     string // Base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com. Defaults to AWS in Region
+	Region       string
+	Bucket       string
+	Prefix       string // Prepended to each path, e.g. "backups/vault/"
+	AccessKey    string
+	SecretKey    *crypto.Secret
+	SessionToken string // Only for temporary credentials
+}
+
+func (m *S3Mirror) Put(path string, content []byte, modified time.Time) error {
+	endpoint := m.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + m.Region + ".amazonaws.com"
+	}
+	base, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return fmt.Errorf("invalid S3 endpoint: %s", err)
+	}
+	objectPath := base.Path + "/" + m.Bucket + "/" + m.Prefix + path
+
+	c, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(c, http.MethodPut, base.Scheme+"://"+base.Host+s3Escape(objectPath), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-amz-meta-mtime", modified.UTC().Format(time.RFC3339))
+	m.sign(req, s3Escape(objectPath), content, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req, whose escaped path is canonicalPath
+func (m *S3Mirror) sign(req *http.Request, canonicalPath string, payload []byte, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("x-amz-date", amzDate)
+	if m.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", m.SessionToken)
+	}
+
+	// Every header set so far is signed, along with the host
+	headers := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		names = append(names, lower)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, canonicalPath, "", canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := day + "/" + m.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+m.SecretKey.Reveal()), day)
+	key = hmacSHA256(key, m.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", m.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape escapes a path the way S3 signs it: everything but unreserved characters and slashes is percent-encoded
+func s3Escape(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,239 @@
+package sync
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// scanLocal updates the local entries from the files on disk, so the planner sees what changed locally since the last
+// sync: files whose modification time changed are changes, and files the state doesn't track are new. A file that was
+// only touched keeps its base, which is checked with localHash, so rescanning an unchanged vault doesn't read it.
+// Entries of files that are gone from disk and never synced are dropped, synced ones are left for
+// State.pushLocalChanges to push as deletions.
+//
+// Returns the decrypted paths of folders on disk that neither the vault nor the state know of, parents before their
+// subfolders.
+func (s *State) scanLocal() ([]string, error) {
+	s.mu.Lock()
+	tracked := s.trackedPaths()
+
+	var folders []string
+	var checks []contentCheck
+	seen := make(map[string]bool)
+	err := s.walkLocal(func(relPath string, d fs.DirEntry) error {
+		if d.IsDir() {
+			if _, inRemote := s.paths[relPath]; !inRemote && tracked[relPath] == "" {
+				folders = append(folders, relPath)
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			api.Log().Debug("⏭️ Skipping, not a regular file", "path", relPath)
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil // Deleted while scanning
+		}
+		if err != nil {
+			return err
+		}
+		seen[relPath] = true
+		if check, ok := s.scanFile(relPath, tracked[relPath], info.ModTime().UnixMilli()); ok {
+			checks = append(checks, check)
+		}
+		return nil
+	})
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	for path, localFile := range s.LocalFiles {
+		if localFile.IsFolder || localFile.Evicted || seen[localFile.Path] || !s.Filter.allows(localFile.Path, false) {
+			continue
+		}
+		if _, inBase := s.Synced[localFile.Path]; !inBase {
+			delete(s.LocalFiles, path)
+		}
+	}
+	s.mu.Unlock()
+
+	s.runContentChecks(checks)
+	return folders, nil
+}
+
+// scanFile updates the local entry at the encrypted path for the file at the decrypted path, modified at the given
+// time. An empty encrypted path adds an entry for a file the state doesn't track. Returns the check that keeps the base
+// of a file that was only touched, if it may have been. s.mu must be held.
+func (s *State) scanFile(relPath string, path string, modified int64) (contentCheck, bool) {
+	if path == "" {
+		// Keyed like the remote entry if the vault has one, so the planner compares them. Paths are encrypted with a
+		// random nonce, so a new file gets the key of its push once it's pushed, see pushEntry.
+		path = s.paths[relPath]
+		if path == "" {
+			var err error
+			if path, err = s.cipher.EncryptString(relPath); err != nil {
+				api.Log().Warn("⚠️ Could not encrypt local path", "path", relPath, "err", err)
+				return contentCheck{}, false
+			}
+		}
+		api.Log().Debug("🔍 New local file", "path", relPath)
+		s.LocalFiles[path] = ObsidianLocalEntry{Path: relPath, Created: modified, Modified: modified}
+		return contentCheck{}, false
+	}
+
+	localFile := s.LocalFiles[path]
+	if localFile.IsFolder || localFile.Evicted || localFile.Modified == modified {
+		return contentCheck{}, false
+	}
+	localFile.Modified = modified
+	s.LocalFiles[path] = localFile
+
+	// Only touched, e.g. by a copy that didn't keep modification times, if it still has the synced content
+	baseEntry, ok := s.Synced[relPath]
+	if !ok || baseEntry.Hash == "" {
+		return contentCheck{}, false
+	}
+	return contentCheck{path: relPath, hash: baseEntry.Hash, apply: func(same bool, err error) {
+		if err == nil && same {
+			baseEntry.Modified = modified
+			s.Synced[relPath] = baseEntry
+		}
+	}}, true
+}
+
+// trackedPaths returns the encrypted path of each local entry by its decrypted path. s.mu must be held.
+func (s *State) trackedPaths() map[string]string {
+	tracked := make(map[string]string, len(s.LocalFiles))
+	for path, localFile := range s.LocalFiles {
+		tracked[localFile.Path] = path
+	}
+	return tracked
+}
+
+// walkLocal calls fn with the decrypted path of each folder and file on disk the filter allows, leaving out the
+// files of obsidian-sync itself. Files may not be regular files.
+func (s *State) walkLocal(fn func(relPath string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(s.TargetPath, func(fullPath string, d fs.DirEntry, err error) error {
+		if fullPath == s.TargetPath {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir // Nothing synced into it yet
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".trash") {
+			return filepath.SkipDir
+		}
+		relPath, err := filepath.Rel(s.TargetPath, fullPath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if d.IsDir() {
+			if !s.Filter.allows(relPath, true) {
+				return filepath.SkipDir
+			}
+			return fn(relPath, d)
+		}
+		if d.Name() == MarkerFile || strings.HasPrefix(d.Name(), pullTempPrefix) || !s.Filter.allows(relPath, false) {
+			return nil
+		}
+		return fn(relPath, d)
+	})
+}
+
+// errLocalChange stops walking the folder at the first local change
+var errLocalChange = errors.New("local change")
+
+// hasLocalChanges returns true if scanLocal would find anything to sync in the folder: a new file or folder, a file
+// whose modification time changed, or a file or folder that's gone. Like scanLocal it doesn't read any file, so a
+// daemon can check cheaply while it waits.
+func (s *State) hasLocalChanges() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tracked := s.trackedPaths()
+	seen := make(map[string]bool)
+	err := s.walkLocal(func(relPath string, d fs.DirEntry) error {
+		path := tracked[relPath]
+		seen[relPath] = true
+		if d.IsDir() {
+			if _, inRemote := s.paths[relPath]; !inRemote && path == "" {
+				return errLocalChange
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if path == "" {
+			return errLocalChange
+		}
+		localFile := s.LocalFiles[path]
+		if localFile.IsFolder || localFile.Evicted {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().UnixMilli() != localFile.Modified {
+			return errLocalChange
+		}
+		return nil
+	})
+	if err == errLocalChange {
+		return true
+	}
+	if err != nil {
+		api.Log().Debug("⚠️ Could not check the folder for changes", "err", err)
+		return false
+	}
+
+	for _, localFile := range s.LocalFiles {
+		if localFile.Evicted || localFile.Path == "" || seen[localFile.Path] || !s.Filter.allows(localFile.Path, localFile.IsFolder) {
+			continue
+		}
+		fullPath, err := s.localPath(localFile.Path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Lstat(fullPath); os.IsNotExist(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileStamp is what changes about a file on disk when it's written
+type fileStamp struct {
+	size     int64
+	modified int64
+}
+
+// localStamps returns the stamp of each file and folder on disk by decrypted path, to tell whether the folder changed
+// between two calls
+func (s *State) localStamps() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	err := s.walkLocal(func(relPath string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stamps[relPath] = fileStamp{size: info.Size(), modified: info.ModTime().UnixMilli()}
+		return nil
+	})
+	if err != nil {
+		api.Log().Debug("⚠️ Could not check the folder for changes", "err", err)
+	}
+	return stamps
+}
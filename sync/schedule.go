@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// task is one operation of a sync pass's apply phase, run by runTasks once the tasks it depends on have finished
+type task struct {
+	path string // Decrypted path the task changes
+	run  func() error
+	deps []*task
+
+	order      int
+	waiting    int
+	dependents []*task
+}
+
+// after makes t wait for each of deps to finish
+func (t *task) after(deps ...*task) {
+	t.deps = append(t.deps, deps...)
+}
+
+// taskResult is a finished task
+type taskResult struct {
+	task *task
+	err  error
+}
+
+// runTasks runs tasks with at most parallelism running at once, starting each once all of its dependencies have
+// finished. Ready tasks start in the order given, so with a parallelism of one the tasks run in order. After a task
+// fails or c is done no new tasks start, and the first error, or c's, is returned once the running tasks have finished.
+func runTasks(c context.Context, tasks []*task, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var ready []*task
+	for i, t := range tasks {
+		t.order = i
+		t.waiting = len(t.deps)
+		t.dependents = nil
+	}
+	for _, t := range tasks {
+		for _, dep := range t.deps {
+			dep.dependents = append(dep.dependents, t)
+		}
+		if t.waiting == 0 {
+			ready = append(ready, t)
+		}
+	}
+
+	results := make(chan taskResult)
+	running := 0
+	finished := 0
+	var firstErr error
+	for {
+		for firstErr == nil && running < parallelism && len(ready) > 0 {
+			if err := c.Err(); err != nil {
+				firstErr = err
+				break
+			}
+			t := ready[0]
+			ready = ready[1:]
+			running++
+			go func() {
+				results <- taskResult{task: t, err: t.run()}
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		finished++
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			continue
+		}
+		for _, dependent := range result.task.dependents {
+			dependent.waiting--
+			if dependent.waiting == 0 {
+				ready = insertByOrder(ready, dependent)
+			}
+		}
+	}
+
+	if firstErr == nil && finished < len(tasks) {
+		return fmt.Errorf("%d sync operations have circular dependencies", len(tasks)-finished)
+	}
+	return firstErr
+}
+
+// insertByOrder inserts t into ready, which is sorted by the order the tasks were given in
+func insertByOrder(ready []*task, t *task) []*task {
+	i := sort.Search(len(ready), func(i int) bool {
+		return ready[i].order > t.order
+	})
+	ready = append(ready, nil)
+	copy(ready[i+1:], ready[i:])
+	ready[i] = t
+	return ready
+}
+
+// linkPathDependencies makes tasks wait for deletes of their path or a parent folder, so a path is deleted before it's
+// recreated, and for the creation of their parent folders. Deleting a folder waits for deletes inside it, which would
+// otherwise race with it.
+func linkPathDependencies(deletes []*task, folders []*task, others []*task) {
+	deletesByPath := make(map[string]*task, len(deletes))
+	for _, t := range deletes {
+		deletesByPath[t.path] = t
+	}
+	for _, t := range deletes {
+		for _, parent := range parentPaths(t.path) {
+			if dep, ok := deletesByPath[parent]; ok {
+				dep.after(t)
+			}
+		}
+	}
+	foldersByPath := make(map[string]*task, len(folders))
+	for _, t := range folders {
+		foldersByPath[t.path] = t
+	}
+
+	link := func(t *task) {
+		if dep, ok := deletesByPath[t.path]; ok {
+			t.after(dep)
+		}
+		for _, parent := range parentPaths(t.path) {
+			if dep, ok := deletesByPath[parent]; ok {
+				t.after(dep)
+			}
+			if dep, ok := foldersByPath[parent]; ok {
+				t.after(dep)
+			}
+		}
+	}
+	for _, t := range folders {
+		link(t)
+	}
+	for _, t := range others {
+		link(t)
+	}
+}
+
+// sortByDepth sorts paths by how many folders deep their decrypted path is, deepest first if deepestFirst is set, and
+// then by decrypted path
+func sortByDepth(paths []string, decrypted func(path string) string, deepestFirst bool) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		pathI, pathJ := decrypted(paths[i]), decrypted(paths[j])
+		depthI, depthJ := strings.Count(pathI, "/"), strings.Count(pathJ, "/")
+		if depthI != depthJ {
+			return (depthI > depthJ) == deepestFirst
+		}
+		return pathI < pathJ
+	})
+}
+
+// parentPaths returns the folders containing a vault path, e.g. "a" and "a/b" for "a/b/c.md"
+func parentPaths(path string) []string {
+	var parents []string
+	for i := strings.Index(path, "/"); i >= 0; {
+		parents = append(parents, path[:i])
+		next := strings.Index(path[i+1:], "/")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return parents
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// ErrStopped is returned when a sync is asked to stop before it finished. What it applied is saved, and the rest is
+// synced next time.
+var ErrStopped = errors.New("stopped before the sync finished")
+
+// shutdownGrace is how long the transfers in flight get to finish once a sync is asked to stop, before their connection
+// is closed
+const shutdownGrace = 30 * time.Second
+
+// withGrace returns a context that's done grace after c is, or when canceled
+func withGrace(c context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	run, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-c.Done():
+		case <-run.Done():
+			return
+		}
+		select {
+		case <-time.After(grace):
+			cancel()
+		case <-run.Done():
+		}
+	}()
+	return run, cancel
+}
+
+// stopping returns the context that asks the sync run to stop starting new work, see SyncContext
+func (s *State) stopping() context.Context {
+	if s.stopCtx == nil {
+		return s.context()
+	}
+	return s.stopCtx
+}
+
+// saveStopped saves what a sync pass that was asked to stop applied, and returns ErrStopped
+func (s *State) saveStopped() error {
+	api.Log().Info("💾 Stopped, saving what was synced")
+	if err := s.Save(); err != nil {
+		return fmt.Errorf("error saving sync state: %s", err)
+	}
+	return ErrStopped
+}
+
+// daemonError returns what a daemon returns for err. Errors from being asked to stop while reconnecting aren't errors,
+// but a sync pass that was cut short is.
+func (s *State) daemonError(what string, err error) error {
+	if errors.Is(err, ErrStopped) {
+		return err
+	}
+	if s.stopping().Err() != nil {
+		api.Log().Info("👻 Stopping daemon")
+		return nil
+	}
+	return fmt.Errorf("%s: %s", what, err)
+}
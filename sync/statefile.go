@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// StateDir returns the folder where sync state files are kept
+func StateDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "obsidian-sync", "state"), nil
+}
+
+// StatePath returns the state file used for the vault folder at targetPath
+func StatePath(targetPath string) (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(targetPath))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// LoadState reads the saved state for the vault folder at targetPath. Returns nil if the folder was never synced.
+func LoadState(targetPath string) (*State, error) {
+	path, err := StatePath(targetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := readStateFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return state, err
+}
+
+// readStateFile reads and parses a state file
+func readStateFile(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("could not parse state file %s: %s", path, err)
+	}
+	if state.LocalFiles == nil {
+		state.LocalFiles = make(map[string]ObsidianLocalEntry)
+	}
+	if state.RemoteEntries == nil {
+		state.RemoteEntries = make(map[string]ObsidianRemoteEntry)
+	}
+	return &state, nil
+}
+
+// Save writes the state to its state file, replacing it atomically
+func (s *State) Save() error {
+	path, err := StatePath(s.TargetPath)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	data, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(path, data); err != nil {
+		return err
+	}
+
+	// Mark the folder so the state can be found again if it's moved
+	if s.MarkerId == "" {
+		return nil
+	}
+	return s.writeMarker()
+}
+
+// writeAtomic writes a private file through a temporary file, so readers never see it half written
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// ExtensionStats counts the files with one extension
+type ExtensionStats struct {
+	Extension string `json:"extension"` // Lowercase, without the dot, empty for files without one
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// FileStats is the size of a file in the vault
+type FileStats struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// VaultStats breaks down what a vault stores. Sizes are as stored on the server, so encrypted, which is what the
+// quota counts.
+type VaultStats struct {
+	Files      int              `json:"files"`
+	Folders    int              `json:"folders"`
+	Bytes      int64            `json:"bytes"`      // Of the current version of every file
+	Used       int64            `json:"used"`       // Counted against the quota by the server
+	Limit      int64            `json:"limit"`      // Quota of the vault
+	Extensions []ExtensionStats `json:"extensions"` // Largest first
+	Largest    []FileStats      `json:"largest"`    // Largest first
+}
+
+// Stats counts the files in the vault by extension and lists the top largest, or all of them if top is negative, along
+// with the vault's quota usage. Nothing is pulled, the sizes come from the vault's index.
+func Stats(top int, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (VaultStats, error) {
+	return StatsContext(context.Background(), top, authToken, vault, password, opts)
+}
+
+// StatsContext is Stats, giving up when c is done
+func StatsContext(c context.Context, top int, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (VaultStats, error) {
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+
+	ctx, err := connectReadOnly(c, authToken, vault, password, opts)
+	if err != nil {
+		return VaultStats{}, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+	state, err := fetchIndex(c, ctx)
+	if err != nil {
+		return VaultStats{}, err
+	}
+	used, limit, err := ctx.GetSizeConfig()
+	if err != nil {
+		return VaultStats{}, fmt.Errorf("error getting size info: %s", err)
+	}
+
+	stats := countEntries(state.RemoteEntries, top)
+	stats.Used = used
+	stats.Limit = limit
+	return stats, nil
+}
+
+// countEntries breaks down the remote entries like Stats, without the quota usage
+func countEntries(entries map[string]ObsidianRemoteEntry, top int) VaultStats {
+	var stats VaultStats
+	extensions := make(map[string]*ExtensionStats)
+	for _, entry := range entries {
+		if entry.IsFolder {
+			stats.Folders++
+			continue
+		}
+		stats.Files++
+		stats.Bytes += entry.Size
+		ext := api.Extension(entry.Path)
+		if extensions[ext] == nil {
+			extensions[ext] = &ExtensionStats{Extension: ext}
+		}
+		extensions[ext].Files++
+		extensions[ext].Bytes += entry.Size
+		stats.Largest = append(stats.Largest, FileStats{Path: entry.Path, Bytes: entry.Size})
+	}
+
+	for _, ext := range extensions {
+		stats.Extensions = append(stats.Extensions, *ext)
+	}
+	sort.Slice(stats.Extensions, func(i, j int) bool {
+		if stats.Extensions[i].Bytes != stats.Extensions[j].Bytes {
+			return stats.Extensions[i].Bytes > stats.Extensions[j].Bytes
+		}
+		return stats.Extensions[i].Extension < stats.Extensions[j].Extension
+	})
+	sort.Slice(stats.Largest, func(i, j int) bool {
+		if stats.Largest[i].Bytes != stats.Largest[j].Bytes {
+			return stats.Largest[i].Bytes > stats.Largest[j].Bytes
+		}
+		return stats.Largest[i].Path < stats.Largest[j].Path
+	})
+	if top >= 0 && len(stats.Largest) > top {
+		stats.Largest = stats.Largest[:top]
+	}
+	return stats
+}
@@ -0,0 +1,43 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCountEntries(t *testing.T) {
+	entries := map[string]ObsidianRemoteEntry{
+		"ea":       {Path: "a", IsFolder: true},
+		"ea/b.md":  {Path: "a/b.md", Size: 10},
+		"ec.MD":    {Path: "c.MD", Size: 30},
+		"ed.png":   {Path: "d.png", Size: 50},
+		"eREADME":  {Path: "README", Size: 5},
+		"ea/e.png": {Path: "a/e.png", Size: 50},
+	}
+	extensions := []ExtensionStats{
+		{Extension: "png", Files: 2, Bytes: 100},
+		{Extension: "md", Files: 2, Bytes: 40},
+		{Extension: "", Files: 1, Bytes: 5},
+	}
+	all := []FileStats{{"a/e.png", 50}, {"d.png", 50}, {"c.MD", 30}, {"a/b.md", 10}, {"README", 5}}
+
+	tests := []struct {
+		name    string
+		entries map[string]ObsidianRemoteEntry
+		top     int
+		want    VaultStats
+	}{
+		{"empty", nil, 10, VaultStats{}},
+		{"top", entries, 2, VaultStats{Files: 5, Folders: 1, Bytes: 145, Extensions: extensions, Largest: all[:2]}},
+		{"top above the count", entries, 10, VaultStats{Files: 5, Folders: 1, Bytes: 145, Extensions: extensions, Largest: all}},
+		{"zero top", entries, 0, VaultStats{Files: 5, Folders: 1, Bytes: 145, Extensions: extensions, Largest: all[:0]}},
+		{"negative top lists all", entries, -1, VaultStats{Files: 5, Folders: 1, Bytes: 145, Extensions: extensions, Largest: all}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countEntries(tt.entries, tt.top); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("countEntries() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
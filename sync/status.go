@@ -0,0 +1,163 @@
+package sync
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status summarizes what would happen if a vault folder synced now, like git status. It's computed from the saved state
+// and the files on disk, without connecting, so remote changes are only those received but not applied yet.
+type Status struct {
+	TargetPath    string       `json:"targetPath"`
+	VaultId       string       `json:"vaultId"`
+	LastSync      time.Time    `json:"lastSync"`      // Zero if the folder never finished a sync
+	LocalAdded    []string     `json:"localAdded"`    // Files on disk that aren't in the vault
+	LocalModified []string     `json:"localModified"` // Files changed on disk since the last sync
+	LocalDeleted  []string     `json:"localDeleted"`  // Synced files that are gone from disk
+	RemotePending []string     `json:"remotePending"` // Remote changes not applied yet, by decrypted path where it's known
+	RemoteHidden  int          `json:"remoteHidden"`  // Remote changes not applied yet whose path can't be decrypted without the vault password
+	Conflicts     []string     `json:"conflicts"`     // Files changed on both sides
+	Quarantined   []string     `json:"quarantined"`
+	Size          int64        `json:"size"`
+	Limit         int64        `json:"limit"`
+	Daemon        DaemonStatus `json:"daemon"`
+}
+
+// Clean returns true if there is nothing to sync
+func (s Status) Clean() bool {
+	return len(s.LocalAdded)+len(s.LocalModified)+len(s.LocalDeleted)+len(s.RemotePending)+s.RemoteHidden+len(s.Conflicts) == 0
+}
+
+// GetStatus returns the status of the vault folder at targetPath, or nil if it was never synced
+func GetStatus(targetPath string) (*Status, error) {
+	state, err := LoadState(targetPath)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	daemon, err := ReadDaemonStatus(targetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		TargetPath:  targetPath,
+		VaultId:     state.VaultId,
+		Size:        state.Size,
+		Limit:       state.Limit,
+		Daemon:      daemon,
+		Quarantined: state.QuarantinedPaths(),
+	}
+	if state.LastSync > 0 {
+		status.LastSync = time.UnixMilli(state.LastSync)
+	}
+	if err := state.localStatus(status); err != nil {
+		return nil, err
+	}
+	state.remoteStatus(status)
+	return status, nil
+}
+
+// localStatus compares the files on disk with the last sync like scanLocal does, without reading them, so a file that
+// was only touched counts as modified.
+func (s *State) localStatus(status *Status) error {
+	tracked := make(map[string]ObsidianLocalEntry, len(s.LocalFiles))
+	for _, localFile := range s.LocalFiles {
+		tracked[localFile.Path] = localFile
+	}
+
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(s.TargetPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.TargetPath {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".trash") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || d.Name() == MarkerFile || strings.HasPrefix(d.Name(), pullTempPrefix) {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.TargetPath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		seen[relPath] = true
+
+		localFile, ok := tracked[relPath]
+		switch {
+		case !ok:
+			status.LocalAdded = append(status.LocalAdded, relPath)
+		case localFile.Evicted:
+			// Placeholders are rewritten when evicted, their content isn't ours to push
+		default:
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().UnixMilli() != localFile.Modified {
+				status.LocalModified = append(status.LocalModified, relPath)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for path, localFile := range tracked {
+		if !localFile.IsFolder && !localFile.Evicted && !seen[path] {
+			status.LocalDeleted = append(status.LocalDeleted, path)
+		}
+	}
+	sort.Strings(status.LocalAdded)
+	sort.Strings(status.LocalModified)
+	sort.Strings(status.LocalDeleted)
+	return nil
+}
+
+// remoteStatus plans the saved state to find remote changes that weren't applied, e.g. because the last sync failed or
+// was read-only. Decrypted remote paths aren't saved, so they're taken from the local entries.
+func (s *State) remoteStatus(status *Status) {
+	remote := make(map[string]ObsidianRemoteEntry, len(s.RemoteEntries))
+	for path, remoteFile := range s.RemoteEntries {
+		if remoteFile.Path == "" {
+			remoteFile.Path = s.LocalFiles[path].Path
+		}
+		remote[path] = remoteFile
+	}
+	plan := planChanges(s.Synced, s.LocalFiles, remote)
+
+	decrypted := func(path string) string {
+		if remoteFile, ok := remote[path]; ok && remoteFile.Path != "" {
+			return remoteFile.Path
+		}
+		return s.LocalFiles[path].Path
+	}
+	// Deletes are of local entries the vault no longer has. A path that changed type is both deleted and pulled.
+	pending := make(map[string]bool)
+	for _, paths := range [][]string{plan.Deletes, plan.NewFolders, plan.Pulls} {
+		for _, path := range paths {
+			name := decrypted(path)
+			if _, ok := s.Quarantined[name]; ok {
+				continue // Listed as quarantined instead
+			}
+			if name == "" {
+				status.RemoteHidden++
+			} else if !pending[name] {
+				pending[name] = true
+				status.RemotePending = append(status.RemotePending, name)
+			}
+		}
+	}
+	for _, path := range plan.Conflicts {
+		status.Conflicts = append(status.Conflicts, decrypted(path))
+	}
+	sort.Strings(status.RemotePending)
+	sort.Strings(status.Conflicts)
+}
@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// serveStatus serves the HTTP status endpoint of the daemon syncing targetPath on 127.0.0.1 at port, for monitoring
+// and container health checks, until the returned function is called:
+//
+//	GET /healthz  200 if every self-check passes, otherwise 503 with the failing ones
+//	GET /status   The daemon's DaemonReport as JSON
+//	GET /metrics  Counters and gauges in the Prometheus text format, see writeMetrics
+//	POST /pause   Stop applying changes, see pauseDaemon
+//	POST /resume  Apply changes again
+func serveStatus(port int, targetPath string) (func(), error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("error opening status endpoint: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		failed := CurrentHealth().Failed()
+		if len(failed) == 0 {
+			_, _ = fmt.Fprintln(w, "ok")
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, check := range failed {
+			_, _ = fmt.Fprintf(w, "%s: %s\n", check.Name, check.Err)
+		}
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		report, err := currentReport(targetPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodPost) {
+			pauseDaemon()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodPost) {
+			resumeDaemon()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Serve(listener)
+	}()
+	api.Log().Info("🩺 Serving status", "addr", "http://"+listener.Addr().String())
+	return func() {
+		_ = server.Close()
+		<-done
+	}, nil
+}
+
+// allowMethod answers 405 and returns false unless r uses method, or HEAD for GET
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method || (method == http.MethodGet && r.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
@@ -1,21 +1,28 @@
 package sync
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/nbadal/obsidian-sync/api"
 	"github.com/nbadal/obsidian-sync/crypto"
+	"io"
 	"os"
 	"path/filepath"
+	gosync "sync"
 	"time"
 )
 
 type ObsidianRemoteEntry struct {
 	Uid           int64
 	EncryptedPath string
+	Path          string `json:"-"` // Decrypted path, kept in memory only
 	EncryptedHash string
 	Created       int64
 	Modified      int64
+	Size          int64
 	IsFolder      bool
+	Device        string // Device that pushed this version
 }
 
 type ObsidianLocalEntry struct {
@@ -23,63 +30,226 @@ type ObsidianLocalEntry struct {
 	Created  int64
 	Modified int64
 	IsFolder bool
+	Evicted  bool // Placeholder for a file that only exists remotely, see EvictAttachments
 }
 
+// PasswordPrompt asks the user for the current password of a vault
+type PasswordPrompt func(vault api.VaultInfo) (*crypto.Secret, error)
+
+// Options configures optional sync behavior
+type Options struct {
+	Daemon        bool
+	ReadOnly      bool       // Only pull, never modify the vault. Local changes are kept but not pushed
+	DeviceName    string     // Name other devices see for our changes
+	CacheDir      string     // Optional blob cache to pull content from, see Prime
+	SkipOverQuota bool       // Skip pushes that would exceed the vault's size limit instead of failing
+	Priorities    []string   // Glob patterns of paths to pull and push first, in order of priority
+	Include       []string   // Glob patterns of the only paths to sync, all if empty. See compileFilter
+	Exclude       []string   // Glob patterns of paths to leave alone on both sides, even if included
+	SkipFileTypes []FileType // Attachment types to leave alone on both sides, like unticked types in the app's sync settings
+	Eviction      EvictionPolicy
+	Fetch         []string // Decrypted paths of evicted placeholders to pull back onto disk before syncing, see State.FetchEvicted
+	Retention     RetentionPolicy
+	Progress      Progress      // Optional receiver for progress events
+	Events        Events        // Optional receiver for what each sync pass did, see Event
+	FullInit      bool          // Receive the whole remote index instead of resuming from the last sync
+	Parallelism   int           // How many files to apply at once, at least one
+	JournalDir    string        // Optional directory to write a Changeset to after each sync pass that changes files
+	Hooks         Hooks         // Commands to run on sync events
+	GitCommit     bool          // Commit the folder to a git repository in it after each sync pass, see State.commitToGit
+	GitMessage    string        // Commit message template for GitCommit, see ValidateGitMessage. Defaults to DefaultGitMessage
+	Mirror        Mirror        // Optional secondary destination for a copy of every file pulled or pushed, e.g. a DirMirror or S3Mirror
+	Timeout       time.Duration // Give up on a one-off sync or prime that takes longer, zero waits forever. Ignored by daemons
+	SweepInterval time.Duration // How often a daemon reconciles the whole vault, see State.sweep. Zero never does
+	StatusPort    int           // Port for a daemon to serve its HTTP status endpoint on at 127.0.0.1, see serveStatus. Zero doesn't
+	ForceUnlock   bool          // Take over the folder's lock even if another sync seems to hold it, see acquireLock
+
+	// ModifiedTolerance is how far apart modification times of the same version may be, see State.settleModified.
+	// Zero compares them exactly.
+	ModifiedTolerance time.Duration
+
+	// Debounce is how long a daemon waits for the vault or the folder to go quiet after a change before syncing, see
+	// State.coalescePushes and State.settleLocal. Zero syncs right away.
+	Debounce time.Duration
+
+	// PollInterval is how often a daemon checks the folder for local changes while it waits for remote ones, see
+	// State.hasLocalChanges. Zero only pushes local changes along with remote ones and sweeps.
+	PollInterval time.Duration
+
+	// MirrorPassword re-encrypts the files sent to Mirror with a key derived from it and the vault's salt, see
+	// EncryptedMirror. Files are mirrored decrypted if nil.
+	MirrorPassword *crypto.Secret
+
+	// SkipRequests skips the file transfer in progress each time it receives, see State.SkipTransfer. Optional.
+	SkipRequests <-chan struct{}
+
+	// PromptNewPassword is asked for the new password when the vault password was changed on another device. Syncs
+	// fail with api.ErrKeyMismatch if nil.
+	PromptNewPassword PasswordPrompt
+
+	// ConflictTemplate names the copies kept when a file conflicts, see DefaultConflictTemplate for the placeholders
+	ConflictTemplate string
+
+	// ConfirmRebind is asked whether to reuse the state of a folder that was moved from oldPath to newPath, instead of
+	// syncing it from scratch. Moved folders aren't rebound if nil.
+	ConfirmRebind func(oldPath string, newPath string) bool
+}
+
+// State is what a sync knows about its folder and vault. Its methods can be called from any goroutine: mu guards
+// everything a sync changes, and work that takes a while, like hashing files or transfers, runs without holding it.
 type State struct {
 	TargetPath    string
+	VaultId       string
+	MarkerId      string // Identifies the folder through its MarkerFile, even after it's moved
 	LocalFiles    map[string]ObsidianLocalEntry
 	RemoteEntries map[string]ObsidianRemoteEntry
 	LastSync      int64
+	RemoteUid     int64 // Latest remote UID we're aware of, used to resume after reconnecting
 	Size          int64
 	Limit         int64
+	KeyHash       string                     // Hash of the vault key the encrypted paths above were made with
+	Quarantined   map[string]QuarantinedFile // Skipped files by decrypted path, left alone until released
+	Synced        map[string]SyncedEntry     // Version of each file at its last sync by decrypted path, see planChanges
+	Hashes        map[string]HashedFile      // Content hash of local files by decrypted path, see localHash
+
+	// Options for this run, which aren't persisted
+	ReadOnly         bool            `json:"-"`
+	SkipOverQuota    bool            `json:"-"`
+	Priorities       globList        `json:"-"`
+	Filter           pathFilter      `json:"-"`
+	Eviction         EvictionPolicy  `json:"-"`
+	Retention        RetentionPolicy `json:"-"`
+	Progress         Progress        `json:"-"`
+	Events           Events          `json:"-"`
+	Cache            *BlobCache      `json:"-"`
+	ConflictTemplate string          `json:"-"`
+	Parallelism      int             `json:"-"`
+	JournalDir       string          `json:"-"`
+	Hooks            Hooks           `json:"-"`
+	GitCommit        bool            `json:"-"`
+	GitMessage       string          `json:"-"`
+	DeviceName       string          `json:"-"`
+	Mirror           Mirror          `json:"-"`
+	SweepInterval    time.Duration   `json:"-"`
+
+	ModifiedTolerance time.Duration `json:"-"` // See Options.ModifiedTolerance
+	Debounce          time.Duration `json:"-"` // See Options.Debounce
+	PollInterval      time.Duration `json:"-"` // See Options.PollInterval
+
+	// Needed to rotate to a new vault password while running as a daemon
+	authToken      *crypto.Secret
+	promptPassword PasswordPrompt
+
+	fetched map[string]bool // Decrypted paths of placeholders fetched on demand, which aren't evicted again this run
+
+	cipher crypto.VaultCipher // Decrypts remote paths as they arrive
+	paths  map[string]string  // Decrypted path to encrypted path of every remote entry
+
+	mu         gosync.Mutex // Guards the maps, counters and cipher above, and the case fields below
+	socketMu   gosync.Mutex // Held while using the connection
+	progressMu gosync.Mutex // Serializes progress events
+
+	changes *Changeset // Changes of the current sync pass, if journaling
+
+	caseInsensitive bool              // Whether the folder's filesystem folds case, see findCaseCollisions
+	caseShadowed    map[string]string // Remote paths left alone this pass to the path that kept their name
+	caseFolded      map[string]bool   // Lowercase remote paths this pass
+	caseWarned      map[string]bool   // Shadowed paths already warned about
+
+	transferMu     gosync.Mutex // Guards the transfer in progress, for SkipTransfer
+	transferSocket *api.ObsidianSocketContext
+	transferPath   string
+	skipRequested  bool
+
+	runCtx  context.Context // Cancels the network operations of the run, see SyncContext
+	stopCtx context.Context // Asks the run to stop starting new work, see SyncContext
 }
 
-func Sync(targetPath string, authToken string, vault api.VaultInfo, password string, daemon bool) error {
-	// Create websocket API connection
-	ctx, err := api.ConnectToVault(vault, password, authToken)
-	if err != nil {
-		return fmt.Errorf("error connecting to vault: %s", err)
-	}
-	defer ctx.Close()
+func Sync(targetPath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) error {
+	return SyncContext(context.Background(), targetPath, authToken, vault, password, opts)
+}
 
-	// send initial sync message
-	fmt.Println("🔄 Initializing...")
-	initResult, err := ctx.SendInit()
+// SyncContext is Sync, giving up when c is done. Daemons run until c is done.
+func SyncContext(c context.Context, targetPath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) error {
+	timeout := opts.Timeout
+	if opts.Daemon {
+		timeout = 0
+	}
+	c, cancel := withTimeout(c, timeout)
+	defer cancel()
+	// Once c is done no new work starts, but the run's transfers get a grace period to finish
+	run, cancelRun := withGrace(c, shutdownGrace)
+	defer cancelRun()
+	if opts.Daemon {
+		// The control socket goes last, so a stop request can wait for it to go away
+		stopControl, err := serveControl(targetPath, cancel)
+		if err != nil {
+			return err
+		}
+		defer stopControl()
+		defer startHeartbeat(c, targetPath)()
+		if opts.StatusPort > 0 {
+			stopStatus, err := serveStatus(opts.StatusPort, targetPath)
+			if err != nil {
+				return err
+			}
+			defer stopStatus()
+		}
+	}
+	unlock, err := acquireLock(targetPath, opts.ForceUnlock)
 	if err != nil {
-		return fmt.Errorf("error sending init message: %s", err)
+		return err
 	}
-	fmt.Println("✅ Initialized")
-	fmt.Printf("Got %d files from server\n", len(initResult.PushedFiles))
+	defer unlock()
 
-	// Get size info
-	fmt.Println("📊 Getting size info...")
-	size, limit, err := ctx.GetSizeConfig()
+	var ctx *api.ObsidianSocketContext
+	var syncState *State
+	if opts.Daemon {
+		// Daemons wait out failed checks in degraded mode rather than exiting
+		ctx, syncState, err = startHealthy(c, targetPath, authToken, vault, password, opts)
+	} else {
+		ctx, syncState, err = connectAndInit(c, targetPath, authToken, vault, password, opts)
+	}
+	if timedOut(c) && err != nil {
+		return fmt.Errorf("sync timed out after %s: %s", opts.Timeout, err)
+	}
 	if err != nil {
-		return fmt.Errorf("error getting size info: %s", err)
+		return err
 	}
+	defer ctx.Close()
+	syncState.runCtx = run
+	syncState.stopCtx = c
+	defer closeWhenDone(run, ctx)()
 
-	// Create sync state
-	syncState := State{
-		TargetPath:    targetPath,
-		LocalFiles:    make(map[string]ObsidianLocalEntry),
-		RemoteEntries: make(map[string]ObsidianRemoteEntry),
-		Size:          size,
-		Limit:         limit,
+	if opts.SkipRequests != nil {
+		go forwardSkips(opts.SkipRequests, syncState)
 	}
-	for _, push := range initResult.PushedFiles {
-		syncState.UpdateWithPush(&push)
+
+	for _, path := range opts.Fetch {
+		if err := syncState.FetchEvicted(ctx, path); err != nil {
+			return fmt.Errorf("error fetching %s: %s", path, err)
+		}
 	}
 
 	// Do initial sync
 	err = syncState.SyncFiles(ctx)
+	if timedOut(c) && err != nil {
+		return fmt.Errorf("sync timed out after %s: %s", opts.Timeout, err)
+	}
+	if errors.Is(err, ErrStopped) {
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("error syncing files: %s", err)
 	}
 
 	// Start daemon if needed
-	if daemon {
-		fmt.Println("👻 Starting daemon...")
+	if opts.Daemon {
+		api.Log().Info("👻 Starting daemon")
 		err := syncState.StartDaemon(ctx)
+		if errors.Is(err, ErrStopped) {
+			return err
+		}
 		if err != nil {
 			return fmt.Errorf("error starting daemon: %s", err)
 		}
@@ -88,222 +258,952 @@ func Sync(targetPath string, authToken string, vault api.VaultInfo, password str
 	return nil
 }
 
-// TODO: Maybe batch syncs? Maybe debounce?
-// TODO: Cache file hashes for moves so we don't redownload
-
-// SyncFiles starts the sync process by pulling all files that are newer than the local version
-func (s *State) SyncFiles(ws *api.ObsidianSocketContext) error {
-	var pullPaths []string
-	var pushPaths []string
-	var newFolderPaths []string
-	var deletePaths []string
-	var conflictPaths []string
-
-	// Pull any files that are newer on the server
-	for path, remoteFile := range s.RemoteEntries {
-		localFile, inLocal := s.LocalFiles[path]
-
-		if inLocal {
-			// If type changed, delete and pull or create folder
-			if localFile.IsFolder != remoteFile.IsFolder {
-				deletePaths = append(deletePaths, path)
-				if localFile.IsFolder {
-					pullPaths = append(pullPaths, path)
-				} else {
-					newFolderPaths = append(newFolderPaths, path)
-				}
-			}
+// connectAndInit connects to the vault, receives the remote index and builds the sync state from it
+func connectAndInit(c context.Context, targetPath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (*api.ObsidianSocketContext, *State, error) {
+	priorities, err := compileGlobs(opts.Priorities)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid priority pattern: %s", err)
+	}
+	filter, err := compileFilter(opts.Include, opts.Exclude, opts.SkipFileTypes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid include or exclude pattern: %s", err)
+	}
+	if opts.ConflictTemplate != "" {
+		if err := ValidateConflictTemplate(opts.ConflictTemplate); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.GitCommit {
+		if err := checkGit(opts.GitMessage); err != nil {
+			return nil, nil, err
+		}
+	}
+	mirror := opts.Mirror
+	if mirror != nil && opts.MirrorPassword != nil {
+		if mirror, err = NewEncryptedMirror(mirror, opts.MirrorPassword, vault.Salt); err != nil {
+			return nil, nil, err
+		}
+	}
 
-			if !localFile.IsFolder {
-				// Pull if remote file is newer
-				if localFile.Modified < remoteFile.Modified {
-					pullPaths = append(pullPaths, path)
-				}
+	// Start from a primed cache's index if there is one, so only newer changes are sent
+	var cache *BlobCache
+	var index *CacheIndex
+	if opts.CacheDir != "" {
+		cache = &BlobCache{Dir: opts.CacheDir}
+		index, err = cache.LoadIndex()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error loading cache index: %s", err)
+		}
+		if index != nil && index.VaultId != vault.Id {
+			index = nil
+		}
+	}
+	if index == nil {
+		index = &CacheIndex{RemoteEntries: make(map[string]ObsidianRemoteEntry)}
+	}
 
-				// Conflict if local file is newer
-				if localFile.Modified > remoteFile.Modified {
-					conflictPaths = append(conflictPaths, path)
-				}
-			} // We don't care about mismatches for folders
-		} else {
-			// Pull the file or create a folder
-			if remoteFile.IsFolder {
-				newFolderPaths = append(newFolderPaths, path)
-			} else {
-				pullPaths = append(pullPaths, path)
-			}
+	// Restore what we knew from the last sync of this folder
+	saved, err := LoadState(targetPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading sync state: %s", err)
+	}
+	if saved == nil {
+		saved, err = rebindMoved(targetPath, vault.Id, opts.ConfirmRebind)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error checking for a moved folder: %s", err)
 		}
 	}
+	if saved != nil && saved.VaultId != vault.Id {
+		saved = nil
+	}
 
-	// Push any files that are newer on the client
-	for path, localFile := range s.LocalFiles {
-		remoteFile, inRemote := s.RemoteEntries[path]
-		if !inRemote {
-			if localFile.Created < s.LastSync {
-				// Delete if file was created before last sync
-				deletePaths = append(deletePaths, path)
-			} else if localFile.Modified > remoteFile.Modified {
-				// Push if file is modified newer
-				pushPaths = append(pushPaths, path)
-			}
-		} // else cases handled above
+	// Derive the vault key once, for checking the indexes and for the connection
+	cipher, err := crypto.NewVaultCipher(vault.EncryptionVersion, password, []byte(vault.Salt))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error deriving vault key: %s", err)
 	}
 
-	// Print out summary
-	fmt.Printf("%d files to delete\n", len(deletePaths))
-	fmt.Printf("%d conflicts\n", len(conflictPaths))
-	fmt.Printf("%d files to push\n", len(pushPaths))
-	fmt.Printf("%d files to pull\n", len(pullPaths))
-	fmt.Printf("%d new folders\n", len(newFolderPaths))
+	// Resume from the last sync's remote index, so the server only sends what changed since. Its paths are encrypted
+	// with the key it was saved with, so it can only be reused with the same key.
+	if opts.FullInit {
+		index = &CacheIndex{RemoteEntries: make(map[string]ObsidianRemoteEntry)}
+	} else if saved != nil && saved.KeyHash == cipher.KeyHash() && saved.RemoteUid > index.RemoteUid && saved.RemoteEntries != nil {
+		api.Log().Info("⏩ Resuming", "uid", saved.RemoteUid)
+		index = &CacheIndex{RemoteUid: saved.RemoteUid, RemoteEntries: saved.RemoteEntries}
+	}
 
-	// Pull conflicting data to compare
-	for _, path := range conflictPaths {
-		// Decrypt path
-		decryptedPath, err := crypto.DecryptString(path, []byte(ws.Vault.Password), []byte(ws.Vault.Salt))
-		if err != nil {
-			return fmt.Errorf("error decrypting path: %s", err)
-		}
+	// A cache primed before the vault password changed can't be decrypted with the new key, so check it now rather
+	// than failing partway through the sync
+	err = crypto.VerifyCipher(cipher, sampleEncryptedPaths(index.RemoteEntries, verifySamples)...)
+	if errors.Is(err, crypto.ErrWrongPassword) {
+		api.Log().Info("ℹ️ The cached index was encrypted with a different vault password, receiving the full index")
+		index = &CacheIndex{RemoteEntries: make(map[string]ObsidianRemoteEntry)}
+	} else if err != nil {
+		return nil, nil, err
+	}
 
-		fmt.Printf("⚠️ Conflict detected for %s\n", decryptedPath)
-		// TODO: Prompt user to choose which version to keep. Pulling if necessary
+	// Create websocket API connection
+	ctx, err := api.ConnectWithCipherContext(c, vault, cipher, authToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to vault: %s", err)
 	}
+	ctx.ReadOnly = opts.ReadOnly
+	ctx.DeviceName = opts.DeviceName
 
-	// Delete any paths indicated first
-	for _, path := range deletePaths {
-		// Decrypt path
-		decryptedPath, err := crypto.DecryptString(path, []byte(ws.Vault.Password), []byte(ws.Vault.Salt))
-		if err != nil {
-			return fmt.Errorf("error decrypting path: %s", err)
-		}
+	// send initial sync message
+	api.Log().Info("🔄 Initializing")
+	initResult, rotated, err := initWithRotation(c, ctx, authToken, index.RemoteUid, opts.PromptNewPassword)
+	if err != nil {
+		_ = ctx.Close()
+		return nil, nil, fmt.Errorf("error sending init message: %s", err)
+	}
+	if rotated {
+		// The cached index is keyed by paths encrypted with the old key
+		index = &CacheIndex{RemoteEntries: make(map[string]ObsidianRemoteEntry)}
+	}
+	api.Log().Info("✅ Initialized", "files", len(initResult.PushedFiles))
+	checkClockSkew()
 
-		fullPath := filepath.Join(s.TargetPath, decryptedPath)
-		fmt.Printf("🗑️ Deleting %s\n", fullPath)
+	// Get size info, which doesn't take a context
+	api.Log().Debug("📊 Getting size info")
+	stopClosing := closeWhenDone(c, ctx)
+	size, limit, err := ctx.GetSizeConfig()
+	stopClosing()
+	if err != nil {
+		_ = ctx.Close()
+		return nil, nil, fmt.Errorf("error getting size info: %s", err)
+	}
 
-		// Delete from os
-		err = os.RemoveAll(fullPath)
+	// Create sync state
+	syncState := &State{
+		TargetPath:        targetPath,
+		VaultId:           vault.Id,
+		LocalFiles:        make(map[string]ObsidianLocalEntry),
+		RemoteEntries:     index.RemoteEntries,
+		Size:              size,
+		Limit:             limit,
+		ReadOnly:          opts.ReadOnly,
+		SkipOverQuota:     opts.SkipOverQuota,
+		Priorities:        priorities,
+		Filter:            filter,
+		Eviction:          opts.Eviction,
+		Retention:         opts.Retention,
+		Progress:          opts.Progress,
+		Events:            opts.Events,
+		Cache:             cache,
+		ConflictTemplate:  opts.ConflictTemplate,
+		Parallelism:       opts.Parallelism,
+		JournalDir:        opts.JournalDir,
+		Hooks:             opts.Hooks,
+		GitCommit:         opts.GitCommit,
+		GitMessage:        opts.GitMessage,
+		DeviceName:        opts.DeviceName,
+		Mirror:            mirror,
+		SweepInterval:     opts.SweepInterval,
+		ModifiedTolerance: opts.ModifiedTolerance,
+		Debounce:          opts.Debounce,
+		PollInterval:      opts.PollInterval,
+		RemoteUid:         index.RemoteUid,
+		KeyHash:           ctx.Cipher.KeyHash(),
+		authToken:         authToken,
+		promptPassword:    opts.PromptNewPassword,
+	}
+	if err := syncState.setCipher(ctx.Cipher); err != nil {
+		_ = ctx.Close()
+		return nil, nil, err
+	}
+	syncState.applyInit(initResult)
+
+	// Restore what we knew about local files
+	if saved != nil {
+		syncState.LocalFiles = saved.LocalFiles
+		syncState.Quarantined = saved.Quarantined
+		syncState.Synced = saved.Synced
+		syncState.Hashes = saved.Hashes
+		syncState.LastSync = saved.LastSync
+		syncState.MarkerId = saved.MarkerId
+		if saved.KeyHash != "" && saved.KeyHash != syncState.KeyHash {
+			if err := syncState.rekeyLocalFiles(ctx, saved.LocalFiles); err != nil {
+				_ = ctx.Close()
+				return nil, nil, err
+			}
+		}
+	}
+	syncState.caseInsensitive = foldsCase(targetPath)
+	if syncState.MarkerId == "" {
+		syncState.MarkerId, err = newMarkerId()
 		if err != nil {
-			return fmt.Errorf("error deleting file: %s", err)
+			_ = ctx.Close()
+			return nil, nil, err
 		}
+	}
 
-		// Delete from local entries
-		delete(s.LocalFiles, path)
+	return ctx, syncState, nil
+}
+
+// TODO: Cache file hashes for moves so we don't redownload
+
+// SyncFiles plans the changes with planChanges and applies them, running the hooks around them and committing them to
+// git
+func (s *State) SyncFiles(ws *api.ObsidianSocketContext) error {
+	defer metrics.passes.observeSince(time.Now())
+	s.runHook(s.Hooks.PreSync, hookPreSync)
+	s.startJournal()
+	err := s.syncFiles(ws)
+	changes, journalErr := s.finishJournal()
+	if journalErr != nil {
+		api.Log().Warn("⚠️ Could not write change journal", "err", journalErr)
 	}
+	s.runChangeHooks(changes, err)
+	if err != nil && !errors.Is(err, ErrStopped) {
+		s.emit(Event{Kind: EventError, Error: err.Error()})
+	}
+	if err == nil {
+		s.commitToGit(changes)
+	}
+	return err
+}
 
-	// Create any needed folders
-	for _, path := range newFolderPaths {
-		// Decrypt path
-		decryptedPath, err := crypto.DecryptString(path, []byte(ws.Vault.Password), []byte(ws.Vault.Salt))
-		if err != nil {
-			return fmt.Errorf("error decrypting path: %s", err)
+// syncFiles is SyncFiles without the hooks
+func (s *State) syncFiles(ws *api.ObsidianSocketContext) error {
+	s.mu.Lock()
+	entries := len(s.RemoteEntries) + len(s.LocalFiles)
+
+	// States saved before bases were recorded start from what they knew about local files
+	if s.Synced == nil {
+		s.Synced = seedSynced(s.LocalFiles, s.RemoteEntries)
+	}
+	s.mu.Unlock()
+	endScan := s.reportPhase(PhaseScan, entries, 0)
+	folders, err := s.scanLocal()
+	if err != nil {
+		return fmt.Errorf("error scanning the folder: %s", err)
+	}
+	if err := s.pushLocalChanges(ws, folders); err != nil {
+		return err
+	}
+	s.settleModified()
+
+	plan, err := s.planPass()
+	endScan()
+	if err != nil {
+		return err
+	}
+
+	// Pull conflicting data to compare
+	for _, path := range plan.conflicts {
+		decryptedPath := plan.decrypted[path]
+		api.Log().Warn("⚠️ Conflict detected", "path", decryptedPath)
+		metrics.conflicts.Add(1)
+		s.runHook(s.Hooks.OnConflict, hookConflict, "PATH="+decryptedPath)
+		s.emit(Event{Kind: EventConflict, Path: decryptedPath})
+		if err := s.resolveConflict(ws, path, decryptedPath); err != nil {
+			return fmt.Errorf("error resolving conflict for %s: %s", decryptedPath, err)
 		}
+	}
 
-		fullPath := filepath.Join(s.TargetPath, decryptedPath)
-		fmt.Printf("📁 Creating folder %s\n", fullPath)
+	// Plan the changes as tasks, so independent files can be applied in parallel
+	deletePaths := plan.deletes
+	var deleteTasks []*task
+	deleteDone := s.reportPhaseCountdown(PhaseDelete, len(deletePaths), 0)
+	for i, path := range deletePaths {
+		i, path := i, path
+		decryptedPath := plan.decrypted[path]
+		deleteTasks = append(deleteTasks, &task{path: decryptedPath, run: func() error {
+			defer deleteDone()
+			fullPath, err := s.localPath(decryptedPath)
+			if err != nil {
+				return err
+			}
+			api.Log().Info("🗑️ Deleting", "path", fullPath)
+			s.report(ProgressEvent{Kind: FileStarted, Phase: PhaseDelete, Path: decryptedPath, FileIndex: i, FileCount: len(deletePaths)})
 
-		// Create folder
-		err = os.MkdirAll(fullPath, 0755)
-		if err != nil {
-			return fmt.Errorf("error creating folder: %s", err)
+			// Delete from os
+			err = os.RemoveAll(fullPath)
+			s.report(ProgressEvent{Kind: FileFinished, Phase: PhaseDelete, Path: decryptedPath, FileIndex: i, FileCount: len(deletePaths), Err: err})
+			if err != nil {
+				return fmt.Errorf("error deleting file: %s", err)
+			}
+
+			// Delete from local entries
+			s.mu.Lock()
+			delete(s.LocalFiles, path)
+			delete(s.Synced, decryptedPath)
+			s.mu.Unlock()
+			s.changes.record(changeDeleted, decryptedPath)
+			s.emit(Event{Kind: EventDeleted, Path: decryptedPath})
+			return nil
+		}})
+	}
+
+	newFolderPaths := plan.newFolders
+	var folderTasks []*task
+	folderDone := s.reportPhaseCountdown(PhaseFolder, len(newFolderPaths), 0)
+	for i, path := range newFolderPaths {
+		i, path := i, path
+		decryptedPath := plan.decrypted[path]
+		folderTasks = append(folderTasks, &task{path: decryptedPath, run: func() error {
+			defer folderDone()
+			fullPath, err := s.localPath(decryptedPath)
+			if err != nil {
+				return err
+			}
+			api.Log().Info("📁 Creating folder", "path", fullPath)
+			s.report(ProgressEvent{Kind: FileStarted, Phase: PhaseFolder, Path: decryptedPath, FileIndex: i, FileCount: len(newFolderPaths)})
+
+			// Create folder
+			err = os.MkdirAll(fullPath, 0755)
+			s.report(ProgressEvent{Kind: FileFinished, Phase: PhaseFolder, Path: decryptedPath, FileIndex: i, FileCount: len(newFolderPaths), Err: err})
+			if err != nil {
+				return fmt.Errorf("error creating folder: %s", err)
+			}
+
+			// Add folder to local entries
+			s.mu.Lock()
+			s.LocalFiles[path] = ObsidianLocalEntry{
+				Path:     decryptedPath,
+				IsFolder: true,
+			}
+			s.recordSynced(path)
+			s.mu.Unlock()
+			s.changes.record(changeAdded, decryptedPath)
+			return nil
+		}})
+	}
+
+	// Order pulls by priority
+	pullPaths := plan.pulls
+	sortByPriority(pullPaths, plan.decrypted, s.Priorities)
+
+	var transferTasks []*task
+	pullDone := s.reportPhaseCountdown(PhasePull, len(pullPaths), plan.pullBytes)
+	for i, path := range pullPaths {
+		i, path := i, path
+		decryptedPath := plan.decrypted[path]
+		transferTasks = append(transferTasks, &task{path: decryptedPath, run: func() error {
+			defer pullDone()
+			return s.trackTransfer(PhasePull, decryptedPath, i, len(pullPaths), func(onTransfer api.TransferFunc) error {
+				return s.pullEntry(ws, path, decryptedPath, onTransfer)
+			})
+		}})
+	}
+
+	// Order pushes by priority too
+	pushPaths := plan.pushes
+	sortByPriority(pushPaths, plan.decrypted, s.Priorities)
+
+	var pushBytes int64
+	for _, path := range pushPaths {
+		if info, err := os.Stat(filepath.Join(s.TargetPath, plan.decrypted[path])); err == nil && !info.IsDir() {
+			pushBytes += crypto.EncryptedSize(info.Size())
 		}
+	}
+	pushDone := s.reportPhaseCountdown(PhasePush, len(pushPaths), pushBytes)
+	for i, path := range pushPaths {
+		i, path := i, path
+		pushEntry := plan.pushEntries[path]
+		transferTasks = append(transferTasks, &task{path: pushEntry.Path, run: func() error {
+			defer pushDone()
+			return s.pushEntry(ws, path, pushEntry, i, len(pushPaths))
+		}})
+	}
 
-		// Add folder to local entries
-		s.LocalFiles[path] = ObsidianLocalEntry{
-			Path:     decryptedPath,
-			IsFolder: true,
+	// Deletes go first, contents before their folder, and folders before their contents. Everything else is independent
+	linkPathDependencies(deleteTasks, folderTasks, transferTasks)
+	tasks := append(append(deleteTasks, folderTasks...), transferTasks...)
+	if err := runTasks(s.stopping(), tasks, s.Parallelism); err != nil {
+		if s.stopping().Err() != nil {
+			return s.saveStopped()
 		}
+		return err
 	}
 
-	// Pull files
-	for _, path := range pullPaths {
-		// Decrypt path
-		decryptedPath, err := crypto.DecryptString(path, []byte(ws.Vault.Password), []byte(ws.Vault.Salt))
-		if err != nil {
-			return fmt.Errorf("error decrypting path: %s", err)
+	// Free up disk space if needed
+	freed, err := s.EvictAttachments(s.Eviction)
+	if err != nil {
+		return fmt.Errorf("error evicting attachments: %s", err)
+	}
+	if freed > 0 {
+		api.Log().Info("🧹 Evicted", "bytes", freed, "placeholders", len(s.EvictedPaths()))
+	}
+
+	// Apply retention policy to client managed folders
+	gcResult, err := CollectGarbage(s.TargetPath, s.Retention)
+	if err != nil {
+		return fmt.Errorf("error collecting garbage: %s", err)
+	}
+	if gcResult.FilesRemoved > 0 {
+		api.Log().Info("🧹 Pruned", "files", gcResult.FilesRemoved, "bytes", gcResult.BytesReclaimed)
+	}
+
+	// Set last sync to now in milliseconds
+	lastSync := time.Now().UnixNano() / 1000000
+	s.mu.Lock()
+	s.LastSync = lastSync
+	s.mu.Unlock()
+
+	api.Log().Info("🔄 Sync complete", "at", lastSync)
+
+	// Persist state for the next run
+	if err := s.Save(); err != nil {
+		return fmt.Errorf("error saving sync state: %s", err)
+	}
+
+	return nil
+}
+
+// passPlan is what a sync pass applies, by encrypted path
+type passPlan struct {
+	conflicts   []string
+	deletes     []string
+	newFolders  []string
+	pulls       []string
+	pushes      []string
+	decrypted   map[string]string             // Decrypted path of each path above
+	pushEntries map[string]ObsidianLocalEntry // Local entry of each push
+	pullBytes   int64
+}
+
+// planPass plans the changes of a sync pass with planChanges, leaving out what the pass must not touch. It plans under
+// s.mu, and takes what the tasks need from the state with it, so changes applied meanwhile wait for the next pass.
+func (s *State) planPass() (*passPlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneHashes()
+	plan := planChanges(s.Synced, s.LocalFiles, s.RemoteEntries)
+	pullPaths := plan.Pulls
+	pushPaths := plan.Pushes
+	newFolderPaths := plan.NewFolders
+	deletePaths := plan.Deletes
+	conflictPaths := plan.Conflicts
+
+	// Keep placeholders remote-only, just track the newer version
+	for _, path := range plan.Refreshes {
+		localFile := s.LocalFiles[path]
+		localFile.Created = s.RemoteEntries[path].Created
+		localFile.Modified = s.RemoteEntries[path].Modified
+		s.LocalFiles[path] = localFile
+		s.recordSynced(path)
+	}
+	for _, path := range plan.Rebases {
+		s.recordSynced(path)
+	}
+
+	// Files that would clobber each other on this filesystem are left alone
+	s.findCaseCollisions()
+	remoteDecrypted := func(path string) string {
+		decryptedPath, _ := s.remotePath(path)
+		return decryptedPath
+	}
+	localDecrypted := func(path string) string {
+		return s.LocalFiles[path].Path
+	}
+	pullPaths = s.withoutCaseCollisions(pullPaths, remoteDecrypted)
+	conflictPaths = s.withoutCaseCollisions(conflictPaths, remoteDecrypted)
+	newFolderPaths = s.withoutCaseCollisions(newFolderPaths, remoteDecrypted)
+	pushPaths = s.withoutCaseCollisions(pushPaths, localDecrypted)
+	deletePaths = s.withoutFoldedDeletes(deletePaths)
+
+	// Quarantined files wait for the user to release them
+	pullPaths = s.withoutQuarantined(pullPaths, remoteDecrypted)
+	pushPaths = s.withoutQuarantined(pushPaths, localDecrypted)
+
+	// Paths outside the include and exclude patterns are left alone on both sides
+	remote := func(path string) (string, bool) {
+		decryptedPath, _ := s.remotePath(path)
+		return decryptedPath, s.RemoteEntries[path].IsFolder
+	}
+	local := func(path string) (string, bool) {
+		return s.LocalFiles[path].Path, s.LocalFiles[path].IsFolder
+	}
+	pullPaths = s.withoutFiltered(pullPaths, remote)
+	conflictPaths = s.withoutFiltered(conflictPaths, remote)
+	newFolderPaths = s.withoutFiltered(newFolderPaths, remote)
+	pushPaths = s.withoutFiltered(pushPaths, local)
+	deletePaths = s.withoutFiltered(deletePaths, local)
+
+	// Read-only syncs leave local changes alone rather than pushing them
+	if s.ReadOnly && len(pushPaths)+len(conflictPaths) > 0 {
+		api.Log().Info("🔒 Read-only, keeping local changes without pushing", "count", len(pushPaths)+len(conflictPaths))
+		pushPaths = nil
+		conflictPaths = nil
+	}
+
+	// Print out summary
+	api.Log().Info("📋 Planned changes", "delete", len(deletePaths), "conflicts", len(conflictPaths), "push", len(pushPaths),
+		"pull", len(pullPaths), "folders", len(newFolderPaths))
+
+	pass := &passPlan{
+		pushes:      pushPaths,
+		decrypted:   make(map[string]string),
+		pushEntries: make(map[string]ObsidianLocalEntry, len(pushPaths)),
+	}
+	for _, paths := range [][]string{conflictPaths, newFolderPaths, pullPaths} {
+		for _, path := range paths {
+			decryptedPath, err := s.remotePath(path)
+			if err != nil {
+				return nil, err
+			}
+			pass.decrypted[path] = decryptedPath
 		}
+	}
+	pass.conflicts = conflictPaths
+	pass.pulls = pullPaths
+	for _, path := range pullPaths {
+		pass.pullBytes += s.RemoteEntries[path].Size
+	}
+	for _, path := range pushPaths {
+		pass.pushEntries[path] = s.LocalFiles[path]
+		pass.decrypted[path] = s.LocalFiles[path].Path
+	}
 
-		pullEntry := s.RemoteEntries[path]
-		fullPath := filepath.Join(s.TargetPath, decryptedPath)
-		fmt.Printf("📄 Pulling file %s version %d\n", fullPath, pullEntry.Uid)
-		content, err := ws.PullFile(pullEntry.Uid, pullEntry.EncryptedHash)
-		if err != nil {
-			return fmt.Errorf("error pulling file: %s", err)
+	// The remote entry of a delete is gone, so it uses the path we wrote the file to
+	for _, path := range deletePaths {
+		decryptedPath := s.LocalFiles[path].Path
+		if decryptedPath == "" {
+			api.Log().Warn("⚠️ Skipping delete of unknown local path", "path", path)
+			delete(s.LocalFiles, path)
+			continue
 		}
+		if err := checkRemotePath(decryptedPath); err != nil {
+			api.Log().Warn("⚠️ Skipping delete of unsafe local path", "err", err)
+			delete(s.LocalFiles, path)
+			continue
+		}
+		pass.deletes = append(pass.deletes, path)
+		pass.decrypted[path] = decryptedPath
+	}
 
-		// Print file contents
-		fmt.Printf("📄 %s contents:\n", decryptedPath)
-		fmt.Printf("%s\n", string(content))
+	// Delete the contents of folders before the folders, and create folders before their subfolders
+	sortByDepth(pass.deletes, localDecrypted, true)
+	sortByDepth(newFolderPaths, remoteDecrypted, false)
+	pass.newFolders = newFolderPaths
+	return pass, nil
+}
 
-		// Write file to disk
-		err = os.WriteFile(fullPath, content, 0644)
-		if err != nil {
-			return fmt.Errorf("error writing file to disk: %s", err)
+// trackTransfer runs a file transfer, reporting its start, byte progress and result
+func (s *State) trackTransfer(phase Phase, path string, index int, count int, transfer func(onTransfer api.TransferFunc) error) error {
+	s.report(ProgressEvent{Kind: FileStarted, Phase: phase, Path: path, FileIndex: index, FileCount: count})
+	err := transfer(func(transferred int64, total int64) {
+		s.report(ProgressEvent{Kind: FileBytes, Phase: phase, Path: path, FileIndex: index, FileCount: count, Bytes: transferred, TotalBytes: total})
+	})
+	s.report(ProgressEvent{Kind: FileFinished, Phase: phase, Path: path, FileIndex: index, FileCount: count, Err: err})
+	return err
+}
+
+// transferRetries is how many times a transfer that timed out is retried on a new connection
+const transferRetries = 2
+
+// useSocket runs op, the transfer of the file at the decrypted path, with exclusive use of the connection, which
+// carries one operation at a time. Bytes op transfers are passed to onTransfer, which may be nil. Returns
+// errTransferSkipped if the transfer was stopped with SkipTransfer, once the connection is usable again. Transfers that
+// time out are retried.
+func (s *State) useSocket(ws *api.ObsidianSocketContext, path string, onTransfer api.TransferFunc, op func() error) error {
+	s.socketMu.Lock()
+	defer s.socketMu.Unlock()
+	ws.OnTransfer = onTransfer
+	defer func() {
+		ws.OnTransfer = nil
+	}()
+
+	// A push acknowledged without holding the connection may have closed it, see pushPipelined
+	if !ws.Connected() {
+		metrics.reconnects.Add(1)
+		if _, err := ws.ReconnectContext(s.context(), api.DefaultBackoff, s.remoteUid()); err != nil {
+			return fmt.Errorf("error reconnecting: %s", err)
 		}
+	}
 
-		// Update local state
-		s.LocalFiles[path] = ObsidianLocalEntry{
-			Path:     decryptedPath,
-			Created:  pullEntry.Created,
-			Modified: pullEntry.Modified,
-			IsFolder: pullEntry.IsFolder,
+	s.beginTransfer(ws, path)
+	err := op()
+	for attempt := 0; retryable(err) && attempt < transferRetries; attempt++ {
+		// The connection is unusable after a timeout, losing sync or a crossed echo, but a fresh one may get through
+		api.Log().Warn("⚠️ Transfer failed, reconnecting to retry", "path", path, "err", err)
+		metrics.reconnects.Add(1)
+		if _, reconnectErr := ws.ReconnectContext(s.context(), api.DefaultBackoff, s.remoteUid()); reconnectErr != nil {
+			s.endTransfer()
+			return fmt.Errorf("error reconnecting to retry: %s", reconnectErr)
 		}
+		err = op()
+	}
+	if !s.endTransfer() {
+		return err
 	}
 
-	// Push files
-	for _, path := range pushPaths {
-		pushEntry := s.LocalFiles[path]
-		fmt.Printf("📄 Pushing file %s\n", path)
+	// Skipping closed the connection, even if the transfer managed to finish
+	if _, reconnectErr := ws.ReconnectContext(s.context(), api.DefaultBackoff, s.remoteUid()); reconnectErr != nil {
+		return fmt.Errorf("error reconnecting after skipping %s: %s", path, reconnectErr)
+	}
+	if errors.Is(err, api.ErrTransferCanceled) {
+		return errTransferSkipped
+	}
+	return err
+}
 
-		// Read file from disk
-		contents, err := os.ReadFile(path)
+// pushPipelined pushes a file with send, only holding the connection while its content is sent, so parallel tasks can
+// send theirs while the server acknowledges it. A push that isn't acknowledged in a way a fresh connection may fix is
+// sent again.
+func (s *State) pushPipelined(ws *api.ObsidianSocketContext, path string, onTransfer api.TransferFunc, send func() (*api.PendingPush, error)) (*api.IncomingPushMessage, error) {
+	for attempt := 0; ; attempt++ {
+		var pending *api.PendingPush
+		err := s.useSocket(ws, path, onTransfer, func() error {
+			var err error
+			pending, err = send()
+			return err
+		})
 		if err != nil {
-			return fmt.Errorf("error reading file from disk: %s", err)
+			return nil, err
+		}
+		echo, err := pending.AckContext(s.context())
+		if err == nil || !retryable(err) || attempt == transferRetries {
+			return echo, err
 		}
+		api.Log().Warn("⚠️ Push wasn't acknowledged, sending it again", "path", path, "err", err)
+	}
+}
 
-		// Push file
-		err = ws.PushFile(pushEntry.Path, "md", pushEntry.Created, pushEntry.Modified, false, false, contents)
-		if err != nil {
-			return fmt.Errorf("error pushing file: %s", err)
+// retryable returns true if a transfer failed in a way a fresh connection may fix
+func retryable(err error) bool {
+	return errors.Is(err, api.ErrTimeout) || errors.Is(err, api.ErrLostSync) || errors.Is(err, api.ErrEchoMismatch) ||
+		errors.Is(err, api.ErrPushUnacknowledged)
+}
+
+// pushEntry pushes a local file, skipping it if it would exceed the vault's size limit and SkipOverQuota is set
+func (s *State) pushEntry(ws *api.ObsidianSocketContext, path string, pushEntry ObsidianLocalEntry, index int, count int) error {
+	api.Log().Info("📄 Pushing", "path", pushEntry.Path)
+
+	// Open file from disk, its content is streamed rather than read into memory
+	file, err := os.Open(filepath.Join(s.TargetPath, pushEntry.Path))
+	if err != nil {
+		return fmt.Errorf("error reading file from disk: %s", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading file from disk: %s", err)
+	}
+	size := info.Size()
+
+	// Make sure the push fits in the vault, reserving the space so parallel pushes can't overcommit it
+	s.mu.Lock()
+	err = s.checkQuota(path, size)
+	if err == nil {
+		s.recordPush(path, size)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		if s.SkipOverQuota {
+			api.Log().Warn("⏭️ Skipping", "path", pushEntry.Path, "err", err)
+			return nil
 		}
+		return err
 	}
 
-	// Set last sync to now in milliseconds
-	s.LastSync = time.Now().UnixNano() / 1000000
+	// Push file
+	var echo *api.IncomingPushMessage
+	err = s.trackTransfer(PhasePush, pushEntry.Path, index, count, func(onTransfer api.TransferFunc) error {
+		var err error
+		echo, err = s.pushPipelined(ws, pushEntry.Path, onTransfer, func() (*api.PendingPush, error) {
+			// A retry sends the content again from the start
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("error reading file from disk: %s", err)
+			}
+			return ws.SendPushFromContext(s.context(), pushEntry.Path, api.Extension(pushEntry.Path), pushEntry.Created, pushEntry.Modified, file, size)
+		})
+		return err
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.Size -= s.quotaDelta(path, size)
+		s.mu.Unlock()
+	}
+	if errors.Is(err, errTransferSkipped) {
+		s.quarantine(pushEntry.Path, QuarantinedFile{Phase: PhasePush, At: time.Now()})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error pushing file: %s", err)
+	}
 
-	fmt.Printf("🔄 Sync complete at %d\n", s.LastSync)
+	metrics.filesPushed.Add(1)
+	metrics.bytesPushed.Add(size)
+	s.mirrorFile(pushEntry.Path, pushEntry.Modified)
+	s.emit(Event{Kind: EventPushed, Path: pushEntry.Path, Bytes: size})
 
+	// Both sides have the pushed version now, under the encrypted path of the push, which a new file didn't have yet
+	s.mu.Lock()
+	s.updateWithPush(echo)
+	if path != echo.EncryptedPath {
+		delete(s.LocalFiles, path)
+		s.LocalFiles[echo.EncryptedPath] = pushEntry
+	}
+	if s.Synced == nil {
+		s.Synced = make(map[string]SyncedEntry)
+	}
+	s.Synced[pushEntry.Path] = SyncedEntry{Uid: echo.Uid, Hash: echo.EncryptedHash, Modified: pushEntry.Modified}
+	s.mu.Unlock()
 	return nil
 }
 
+// pullEntry downloads a remote entry to disk and records it in the local state
+func (s *State) pullEntry(ws *api.ObsidianSocketContext, path string, decryptedPath string, onTransfer api.TransferFunc) error {
+	s.mu.Lock()
+	pullEntry, ok := s.RemoteEntries[path]
+	s.mu.Unlock()
+	if !ok {
+		// Deleted from the vault since the pass was planned, which the next pass takes care of
+		return nil
+	}
+	fullPath, err := s.localPath(decryptedPath)
+	if err != nil {
+		return err
+	}
+
+	// A local copy that already has the content, e.g. in a folder the vault was copied into, needs no download
+	if same, err := s.sameContent(decryptedPath, pullEntry.EncryptedHash); err == nil && same {
+		api.Log().Info("✅ Already has the remote content, not pulling", "path", decryptedPath, "uid", pullEntry.Uid)
+		s.mu.Lock()
+		s.recordPulled(path, decryptedPath)
+		s.mu.Unlock()
+		return nil
+	}
+
+	api.Log().Info("📄 Pulling", "path", fullPath, "uid", pullEntry.Uid)
+	size, err := s.pullToFile(ws, pullEntry, fullPath, onTransfer)
+	if errors.Is(err, errTransferSkipped) {
+		s.quarantine(decryptedPath, QuarantinedFile{Phase: PhasePull, Uid: pullEntry.Uid, At: time.Now()})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error pulling file: %s", err)
+	}
+	api.Log().Debug("📄 Pulled", "path", decryptedPath, "bytes", size)
+	metrics.filesPulled.Add(1)
+	metrics.bytesPulled.Add(size)
+	s.mirrorFile(decryptedPath, pullEntry.Modified)
+	s.emit(Event{Kind: EventPulled, Path: decryptedPath, Bytes: size})
+
+	// Update local state
+	s.mu.Lock()
+	existed := s.recordPulled(path, decryptedPath)
+	s.mu.Unlock()
+	if existed {
+		s.changes.record(changeModified, decryptedPath)
+	} else {
+		s.changes.record(changeAdded, decryptedPath)
+	}
+	return nil
+}
+
+// recordPulled records that the local file at the decrypted path has the remote entry's version, returning true if
+// the state already tracked it. s.mu must be held.
+func (s *State) recordPulled(path string, decryptedPath string) bool {
+	pullEntry := s.RemoteEntries[path]
+	_, existed := s.LocalFiles[path]
+	s.LocalFiles[path] = ObsidianLocalEntry{
+		Path:     decryptedPath,
+		Created:  pullEntry.Created,
+		Modified: pullEntry.Modified,
+		IsFolder: pullEntry.IsFolder,
+	}
+	s.recordSynced(path)
+	return existed
+}
+
 func (s *State) StartDaemon(ctx *api.ObsidianSocketContext) error {
+	s.publishSummary()
+	nextSweep := time.Now().Add(s.SweepInterval)
 	for {
-		fmt.Println("👻 Waiting for push message...")
-		pushMsg, err := ctx.WaitForPushMessage()
+		api.Log().Debug("👻 Waiting for push message")
+		wait, stopWaiting := s.daemonWait(nextSweep)
+		pushMsg, err := ctx.WaitForPushMessageWithin(wait, s.pollInterval())
+		interrupted := wait.Err() != nil
+		stopWaiting()
+		if s.stopping().Err() != nil {
+			api.Log().Info("👻 Stopping daemon")
+			return nil
+		}
+		if interrupted && !daemonPaused() && !time.Now().Before(nextSweep) {
+			if err := s.sweep(ctx); err != nil {
+				return s.daemonError("error reconciling", err)
+			}
+			nextSweep = time.Now().Add(s.SweepInterval)
+			continue
+		}
+		if interrupted {
+			// Resumed, which closed the connection, so catch up on a new one
+			api.Log().Info("▶️ Resuming")
+			if err := s.reconnect(ctx); err != nil {
+				return s.daemonError("error reconnecting", err)
+			}
+			continue
+		}
 		if err != nil {
-			return fmt.Errorf("error getting push message: %s", err)
+			if err := s.recoverConnection(ctx, err); err != nil {
+				return err
+			}
+			continue
 		}
-		fmt.Printf("📄 Got push message for UID %d\n", pushMsg.Uid)
+		if pushMsg == nil {
+			// Nothing from the vault for a poll interval, check the folder
+			if daemonPaused() || !s.hasLocalChanges() {
+				continue
+			}
+			api.Log().Debug("✏️ Local changes, waiting for the folder to go quiet")
+			if err := s.settleLocal(ctx); err != nil {
+				if err := s.recoverConnection(ctx, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.syncUnlessPaused(ctx); err != nil {
+				return s.daemonError("error syncing files", err)
+			}
+			continue
+		}
+		stats := ctx.QueueStats()
+		metrics.queueDepth.Store(int64(stats.Queued))
+		api.Log().Debug("📄 Got push message", "uid", pushMsg.Uid, "queued", stats.Queued, "peak", stats.Peak)
 
-		// Update remote files
+		// Update remote files, with the rest of a burst of changes
 		s.UpdateWithPush(pushMsg)
+		if err := s.coalescePushes(ctx); err != nil {
+			if err := s.recoverConnection(ctx, err); err != nil {
+				return err
+			}
+			continue
+		}
 
-		err = s.SyncFiles(ctx)
+		err = s.syncUnlessPaused(ctx)
 		if err != nil {
-			return fmt.Errorf("error syncing files: %s", err)
+			return s.daemonError("error syncing files", err)
 		}
 	}
 }
 
+// pollInterval returns how often the daemon checks the folder for local changes, zero if it doesn't because it
+// doesn't push them
+func (s *State) pollInterval() time.Duration {
+	if s.ReadOnly {
+		return 0
+	}
+	return s.PollInterval
+}
+
+// recoverConnection reconnects a daemon after it lost the connection with err
+func (s *State) recoverConnection(ctx *api.ObsidianSocketContext, err error) error {
+	api.Log().Warn("⚠️ Connection lost", "err", err)
+	setHealth(newHealth([]Check{{Name: "connectivity", Err: err}}))
+	if err := s.reconnect(ctx); err != nil {
+		return s.daemonError("error reconnecting", err)
+	}
+	setHealth(newHealth([]Check{{Name: "connectivity"}}))
+	return nil
+}
+
+// reconnect re-establishes the connection, resuming from our UID watermark, and syncs anything we missed unless paused
+func (s *State) reconnect(ctx *api.ObsidianSocketContext) error {
+	metrics.reconnects.Add(1)
+	initResult, err := ctx.ReconnectContext(s.stopping(), api.DefaultBackoff, s.remoteUid())
+	if errors.Is(err, api.ErrKeyMismatch) {
+		return s.rotateKey(ctx, err)
+	}
+	if err != nil {
+		return err
+	}
+	s.applyInit(initResult)
+	return s.syncUnlessPaused(ctx)
+}
+
+// sweep reconciles the whole vault, in case changes were missed on either side. It receives the full remote index
+// again, which drops entries whose deletes never arrived, and syncs it with the folder, which scanLocal scans at the
+// start of every pass: local edits, new files and deletions made while the daemon wasn't looking are pushed.
+func (s *State) sweep(ctx *api.ObsidianSocketContext) error {
+	api.Log().Info("🧹 Reconciling the whole vault")
+	initResult, err := ctx.ReconnectContext(s.stopping(), api.DefaultBackoff, 0)
+	if errors.Is(err, api.ErrKeyMismatch) {
+		return s.rotateKey(ctx, err)
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.RemoteEntries = make(map[string]ObsidianRemoteEntry)
+	s.mu.Unlock()
+	if err := s.setCipher(ctx.Cipher); err != nil {
+		return err
+	}
+	s.applyInit(initResult)
+	return s.syncUnlessPaused(ctx)
+}
+
+// UpdateWithPush applies a pushed change to the remote entries, decrypting its path once so planning can look paths
+// up without decrypting them again. Like the other methods of State, it's safe to call from any goroutine, also while a
+// sync pass runs: a change applied after the pass planned waits for the next one.
 func (s *State) UpdateWithPush(push *api.IncomingPushMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateWithPush(push)
+}
+
+// remoteUid returns the latest remote UID we're aware of
+func (s *State) remoteUid() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.RemoteUid
+}
+
+// updateWithPush is UpdateWithPush, s.mu must be held
+func (s *State) updateWithPush(push *api.IncomingPushMessage) {
+	if push.Uid > s.RemoteUid {
+		s.RemoteUid = push.Uid
+	}
+
+	// Find the entry we know for this file, even if its path was encrypted differently
+	path := ""
+	knownPath := push.EncryptedPath
+	if s.cipher != nil {
+		if remoteEntry, ok := s.RemoteEntries[push.EncryptedPath]; ok && remoteEntry.Path != "" {
+			path = remoteEntry.Path
+		} else if decrypted, err := s.cipher.DecryptString(push.EncryptedPath); err == nil {
+			path = decrypted
+			if existing, ok := s.paths[path]; ok {
+				knownPath = existing
+			}
+		} else {
+			api.Log().Warn("⚠️ Could not decrypt pushed path", "err", err)
+		}
+	}
+	if path != "" {
+		if err := checkRemotePath(path); err != nil {
+			api.Log().Warn("⚠️ Ignoring pushed file with an unsafe path", "uid", push.Uid, "err", err)
+			return
+		}
+	}
+
 	if push.Deleted {
+		delete(s.RemoteEntries, knownPath)
 		delete(s.RemoteEntries, push.EncryptedPath)
-	} else {
-		s.RemoteEntries[push.EncryptedPath] = ObsidianRemoteEntry{
-			EncryptedPath: push.EncryptedPath,
-			IsFolder:      push.Folder,
-			EncryptedHash: push.EncryptedHash,
-			Uid:           push.Uid,
-			Created:       push.Ctime,
-			Modified:      push.Mtime,
-		}
+		s.unindexPath(path, knownPath)
+		return
+	}
+
+	if knownPath != push.EncryptedPath {
+		s.rekeyEntry(knownPath, push.EncryptedPath)
+	}
+	s.RemoteEntries[push.EncryptedPath] = ObsidianRemoteEntry{
+		EncryptedPath: push.EncryptedPath,
+		Path:          path,
+		IsFolder:      push.Folder,
+		EncryptedHash: push.EncryptedHash,
+		Uid:           push.Uid,
+		Created:       push.Ctime,
+		Modified:      push.Mtime,
+		Size:          push.Size,
+		Device:        push.Device,
+	}
+	if path != "" {
+		s.indexPath(path, push.EncryptedPath)
 	}
 }
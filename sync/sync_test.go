@@ -0,0 +1,200 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// fakeVault is a sync server keeping the latest version of each file in memory
+type fakeVault struct {
+	mu      gosync.Mutex
+	uid     int64
+	entries map[string]api.IncomingPushMessage // Latest push by encrypted path
+	blobs   map[int64][]byte                   // Encrypted content by UID
+}
+
+// newFakeVault starts a fake sync server that new connections use
+func newFakeVault(t *testing.T) *fakeVault {
+	t.Helper()
+	v := &fakeVault{entries: make(map[string]api.IncomingPushMessage), blobs: make(map[int64][]byte)}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		v.serve(ws)
+	}))
+	t.Cleanup(srv.Close)
+
+	endpoint := api.DefaultEndpoint
+	api.DefaultEndpoint = api.Endpoint{WSScheme: "ws", SyncHost: strings.TrimPrefix(srv.URL, "http://")}
+	t.Cleanup(func() { api.DefaultEndpoint = endpoint })
+	return v
+}
+
+func (v *fakeVault) serve(ws *websocket.Conn) {
+	for {
+		var msg struct {
+			Op      string `json:"op"`
+			Version int64  `json:"version"`
+			Uid     int64  `json:"uid"`
+			api.OutgoingPushMessage
+		}
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Op {
+		case "init":
+			_ = ws.WriteJSON(map[string]string{"res": "ok"})
+			v.mu.Lock()
+			for _, entry := range v.entries {
+				if entry.Uid > msg.Version {
+					_ = ws.WriteJSON(entry)
+				}
+			}
+			_ = ws.WriteJSON(map[string]interface{}{"op": "ready", "version": v.uid})
+			v.mu.Unlock()
+		case "pull":
+			v.mu.Lock()
+			blob := v.blobs[msg.Uid]
+			v.mu.Unlock()
+			pieces := 0
+			if len(blob) > 0 {
+				pieces = 1
+			}
+			_ = ws.WriteJSON(map[string]interface{}{"hash": "", "size": len(blob), "pieces": pieces})
+			if pieces > 0 {
+				_ = ws.WriteMessage(websocket.BinaryMessage, blob)
+			}
+		case "push":
+			var blob []byte
+			for i := 0; i < msg.Pieces; i++ {
+				_ = ws.WriteJSON(map[string]string{"res": "next"})
+				_, piece, err := ws.ReadMessage()
+				if err != nil {
+					return
+				}
+				blob = append(blob, piece...)
+			}
+			v.mu.Lock()
+			v.uid++
+			echo := api.IncomingPushMessage{Op: "push", EncryptedPath: msg.Path, EncryptedHash: msg.Hash, Size: int64(len(blob)),
+				Ctime: msg.Ctime, Mtime: msg.Mtime, Folder: msg.Folder, Deleted: msg.Deleted, Device: "fake", Uid: v.uid}
+			v.blobs[v.uid] = blob
+			if msg.Deleted {
+				delete(v.entries, msg.Path)
+			} else {
+				v.entries[msg.Path] = echo
+			}
+			v.mu.Unlock()
+			_ = ws.WriteJSON(echo)
+			_ = ws.WriteJSON(map[string]string{"op": "ok"})
+		default:
+			_ = ws.WriteJSON(map[string]string{"res": "ok"})
+		}
+	}
+}
+
+// Pushes from other devices may be applied while a sweep reconciles the vault, run with -race to catch unguarded state
+func TestSweepWhilePushesArrive(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("HOME", config)
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("AppData", config)
+	logger := api.Log()
+	api.SetLogger(api.NewTextLogger(io.Discard, api.LevelWarn))
+	t.Cleanup(func() { api.SetLogger(logger) })
+	backoff := api.DefaultBackoff
+	api.DefaultBackoff = api.BackoffPolicy{Initial: time.Millisecond, Max: time.Millisecond}
+	t.Cleanup(func() { api.DefaultBackoff = backoff })
+
+	newFakeVault(t)
+	cipher, err := crypto.NewVaultCipher(0, crypto.SecretString("password"), []byte("salt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := api.ConnectWithCipherContext(context.Background(), api.VaultInfo{Host: "vault"}, cipher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	s := &State{
+		TargetPath:    t.TempDir(),
+		LocalFiles:    make(map[string]ObsidianLocalEntry),
+		RemoteEntries: make(map[string]ObsidianRemoteEntry),
+		Parallelism:   4,
+	}
+	if err := s.setCipher(cipher); err != nil {
+		t.Fatal(err)
+	}
+	var notes []string
+	for i := 0; i < 20; i++ {
+		note := fmt.Sprintf("notes/%d.md", i)
+		notes = append(notes, note)
+		if err := os.MkdirAll(filepath.Join(s.TargetPath, "notes"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(s.TargetPath, filepath.FromSlash(note)), []byte(note), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SyncFiles(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another device creates and deletes folders, each push encrypting the path anew
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			path, err := cipher.EncryptString(fmt.Sprintf("incoming/%d", i%5))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			s.UpdateWithPush(&api.IncomingPushMessage{Op: "push", EncryptedPath: path, Folder: true, Deleted: i%3 == 2, Uid: int64(1000 + i)})
+			s.EncryptedPath(notes[i%len(notes)])
+			s.QuarantinedPaths()
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		if err := s.sweep(ctx); err != nil {
+			t.Fatalf("sweep %d: %s", i, err)
+		}
+	}
+	close(stop)
+	<-done
+
+	for _, note := range notes {
+		if _, ok := s.EncryptedPath(note); !ok {
+			t.Errorf("%s is no longer in the vault", note)
+		}
+	}
+	if err := s.checkIntegrity(); err != nil {
+		t.Errorf("checkIntegrity() = %s", err)
+	}
+}
@@ -0,0 +1,46 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nbadal/obsidian-sync/api"
+)
+
+// withTimeout bounds c by timeout, a zero timeout doesn't
+func withTimeout(c context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(c)
+	}
+	return context.WithTimeout(c, timeout)
+}
+
+// closeWhenDone closes the connection once c is done, which fails whatever operation is in progress, even ones that
+// don't take a context. The returned function stops watching.
+func closeWhenDone(c context.Context, ctx *api.ObsidianSocketContext) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-c.Done():
+			_ = ctx.Close()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
+
+// timedOut returns true if c is done because its deadline passed
+func timedOut(c context.Context) bool {
+	return errors.Is(c.Err(), context.DeadlineExceeded)
+}
+
+// context returns the context the sync run was started with, see SyncContext
+func (s *State) context() context.Context {
+	if s.runCtx == nil {
+		return context.Background()
+	}
+	return s.runCtx
+}
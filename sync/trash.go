@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// DeletedFile is a file the server still tracks as deleted, and can be restored from its history
+type DeletedFile struct {
+	Path string `json:"path"` // Decrypted path, empty if it couldn't be decrypted
+	api.FileVersion
+}
+
+// ListDeleted lists the files the server still tracks as deleted, most recently deleted first
+func ListDeleted(authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) ([]DeletedFile, error) {
+	return ListDeletedContext(context.Background(), authToken, vault, password, opts)
+}
+
+// ListDeletedContext is ListDeleted, giving up when c is done
+func ListDeletedContext(c context.Context, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) ([]DeletedFile, error) {
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+
+	ctx, err := connectForHistory(c, authToken, vault, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+
+	versions, err := ctx.DeletedFilesContext(c)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deleted files: %s", err)
+	}
+	deleted := make([]DeletedFile, 0, len(versions))
+	for _, version := range versions {
+		if version.Folder {
+			continue
+		}
+		path, err := ctx.Cipher.DecryptString(version.EncryptedPath)
+		if err != nil {
+			api.Log().Warn("⚠️ Could not decrypt path of deleted file", "uid", version.Uid, "err", err)
+		}
+		deleted = append(deleted, DeletedFile{Path: path, FileVersion: version})
+	}
+	sort.SliceStable(deleted, func(i, j int) bool {
+		return deleted[i].Ts > deleted[j].Ts
+	})
+	return deleted, nil
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// ErrRemoteExists is returned by PushPath when the vault already has a file at the remote path
+var ErrRemoteExists = errors.New("the vault already has a file at this path")
+
+// PushResult describes what PushPath uploaded
+type PushResult struct {
+	Path     string // Path in the vault the file was pushed to
+	Bytes    int64
+	Replaced bool // An existing remote file was overwritten
+}
+
+// PushPath uploads the file at localPath to remotePath in the vault without syncing or touching any sync state.
+// remotePath defaults to the file's name at the root of the vault, and a remotePath ending in a slash is a folder to
+// upload into. An existing remote file is only replaced if overwrite is set.
+func PushPath(localPath string, remotePath string, overwrite bool, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (PushResult, error) {
+	return PushPathContext(context.Background(), localPath, remotePath, overwrite, authToken, vault, password, opts)
+}
+
+// PushPathContext is PushPath, giving up when c is done
+func PushPathContext(c context.Context, localPath string, remotePath string, overwrite bool, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (PushResult, error) {
+	var result PushResult
+	if opts.ReadOnly {
+		return result, api.ErrReadOnly
+	}
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return result, err
+	}
+	if info.IsDir() {
+		return result, fmt.Errorf("%s is a folder, only single files can be pushed", localPath)
+	}
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return result, fmt.Errorf("error reading file from disk: %s", err)
+	}
+	remotePath = pushDest(localPath, remotePath)
+	result.Path = remotePath
+	if issues := CheckPathPortability([]string{remotePath}); len(issues) > 0 {
+		api.Log().Warn("⚠️ Path may not sync to every platform", "path", remotePath, "problem", issues[0].Problem)
+	}
+
+	ctx, err := api.ConnectToVaultContext(c, vault, password, authToken)
+	if err != nil {
+		return result, fmt.Errorf("error connecting to vault: %s", err)
+	}
+	defer ctx.Close()
+	ctx.DeviceName = opts.DeviceName
+	defer closeWhenDone(c, ctx)()
+
+	state, err := fetchIndex(c, ctx)
+	if err != nil {
+		return result, err
+	}
+	// The sync state's bookkeeping also checks the quota
+	state.Size, state.Limit, err = ctx.GetSizeConfig()
+	if err != nil {
+		return result, fmt.Errorf("error getting size info: %s", err)
+	}
+	existing, exists := state.EncryptedPath(remotePath)
+	if exists && state.RemoteEntries[existing].IsFolder {
+		return result, fmt.Errorf("the vault has a folder at %s", remotePath)
+	}
+	if exists && !overwrite {
+		return result, fmt.Errorf("%w: %s", ErrRemoteExists, remotePath)
+	}
+	if err := state.checkQuota(existing, int64(len(content))); err != nil {
+		return result, err
+	}
+
+	api.Log().Info("📄 Pushing", "path", remotePath)
+	mtime := info.ModTime().UnixMilli()
+	if _, err := ctx.PushFileContext(c, remotePath, api.Extension(remotePath), mtime, mtime, false, false, content); err != nil {
+		return result, fmt.Errorf("error pushing file: %s", err)
+	}
+	result.Bytes = int64(len(content))
+	result.Replaced = exists
+	return result, nil
+}
+
+// pushDest returns the vault path to push localPath to
+func pushDest(localPath string, remotePath string) string {
+	name := filepath.Base(localPath)
+	remotePath = filepath.ToSlash(remotePath)
+	if remotePath == "" || strings.HasSuffix(remotePath, "/") {
+		remotePath += name
+	}
+	return strings.TrimPrefix(path.Clean("/"+remotePath), "/")
+}
@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/nbadal/obsidian-sync/api"
+	"github.com/nbadal/obsidian-sync/crypto"
+)
+
+// VerifyReport lists the differences Verify found between a vault folder and the vault
+type VerifyReport struct {
+	TargetPath string   `json:"targetPath"`
+	Checked    int      `json:"checked"`    // Files on both sides whose content was compared
+	Mismatched []string `json:"mismatched"` // Files whose content differs from the vault's
+	Missing    []string `json:"missing"`    // Files and folders in the vault that aren't on disk
+	Extra      []string `json:"extra"`      // Files on disk that aren't in the vault
+	Skipped    []string `json:"skipped"`    // Evicted placeholders and quarantined files, which aren't expected to match
+}
+
+// OK returns true if the folder matches the vault
+func (r *VerifyReport) OK() bool {
+	return len(r.Mismatched)+len(r.Missing)+len(r.Extra) == 0
+}
+
+// Verify hashes every file in the vault folder at targetPath and compares it with the hash the vault has for it,
+// reporting mismatched, missing and extra files. It only reads, neither the folder, its sync state nor the vault are
+// changed.
+func Verify(targetPath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (*VerifyReport, error) {
+	return VerifyContext(context.Background(), targetPath, authToken, vault, password, opts)
+}
+
+// VerifyContext is Verify, giving up when c is done
+func VerifyContext(c context.Context, targetPath string, authToken *crypto.Secret, vault api.VaultInfo, password *crypto.Secret, opts Options) (*VerifyReport, error) {
+	c, cancel := withTimeout(c, opts.Timeout)
+	defer cancel()
+
+	// The saved state, if any, only tells us which files aren't expected to match
+	saved, err := LoadState(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool)
+	if saved != nil {
+		for _, localFile := range saved.LocalFiles {
+			if localFile.Evicted {
+				skip[localFile.Path] = true
+			}
+		}
+		for _, path := range saved.QuarantinedPaths() {
+			skip[path] = true
+		}
+	}
+
+	ctx, err := connectReadOnly(c, authToken, vault, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer ctx.Close()
+	defer closeWhenDone(c, ctx)()
+	state, err := fetchIndex(c, ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Compares hashes with the files in the folder
+	state.TargetPath = targetPath
+
+	report := &VerifyReport{TargetPath: targetPath}
+	remote := make(map[string]ObsidianRemoteEntry, len(state.RemoteEntries))
+	for _, remoteFile := range state.RemoteEntries {
+		remote[remoteFile.Path] = remoteFile
+	}
+	for path, remoteFile := range remote {
+		if skip[path] {
+			report.Skipped = append(report.Skipped, path)
+			continue
+		}
+		info, err := os.Stat(filepath.Join(targetPath, filepath.FromSlash(path)))
+		if os.IsNotExist(err) || (err == nil && info.IsDir() != remoteFile.IsFolder) {
+			report.Missing = append(report.Missing, path)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if remoteFile.IsFolder {
+			continue
+		}
+		matches, err := state.matchesRemote(ctx, path, remoteFile)
+		if err != nil {
+			return nil, fmt.Errorf("error checking %s: %s", path, err)
+		}
+		report.Checked++
+		if !matches {
+			report.Mismatched = append(report.Mismatched, path)
+		}
+	}
+
+	err = filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == targetPath {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".trash") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || d.Name() == MarkerFile {
+			return nil
+		}
+		relPath, err := filepath.Rel(targetPath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if _, ok := remote[relPath]; !ok && !skip[relPath] {
+			report.Extra = append(report.Extra, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(report.Mismatched)
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Strings(report.Skipped)
+	return report, nil
+}